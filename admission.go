@@ -0,0 +1,105 @@
+package cache
+
+// AdmissionPolicy decides whether a new key is admitted when a capacity bound is reached,
+// see WithAdmissionPolicy.
+type AdmissionPolicy uint8
+
+const (
+	// AdmitAll admits every new key, evicting the items in the order of the EvictionPolicy, the default.
+	AdmitAll AdmissionPolicy = iota
+
+	// TinyLFU admits a new key over the capacity only if it has been accessed more often
+	// than the item it would evict, so the keys read once do not evict the hot items.
+	// The accesses, hits and misses, are counted by a count-min sketch halved periodically.
+	TinyLFU
+)
+
+func (p AdmissionPolicy) String() string {
+	switch p {
+	case AdmitAll:
+		return "AdmitAll"
+	case TinyLFU:
+		return "TinyLFU"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// sketchDepth the number of rows of a count-min sketch.
+	sketchDepth = 4
+
+	// sketchMinWidth the minimum number of counters of a row.
+	sketchMinWidth = 1024
+
+	// sketchResetFactor the counters are halved every width*sketchResetFactor additions,
+	// so the keys frequent in the past give way to the keys frequent now.
+	sketchResetFactor = 10
+)
+
+// countMinSketch estimates the number of occurrences of the keys by their hash,
+// never below the actual number since the last reset. It is not safe for concurrent use.
+type countMinSketch struct {
+	rows [sketchDepth][]uint32
+	mask uint64
+	adds int
+}
+
+// newCountMinSketch creates a sketch of width counters per row, rounded up to a power of 2.
+func newCountMinSketch(width int) *countMinSketch {
+	w := sketchMinWidth
+	for w < width {
+		w <<= 1
+	}
+	s := &countMinSketch{mask: uint64(w - 1)}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, w)
+	}
+	return s
+}
+
+// add counts an occurrence of the key with hash h, and returns its estimated number of occurrences,
+// and whether the counters have been halved since.
+func (s *countMinSketch) add(h uint64) (count uint32, reset bool) {
+	count = ^uint32(0)
+	for i := range s.rows {
+		c := &s.rows[i][s.index(h, i)]
+		if *c < ^uint32(0) {
+			*c++
+		}
+		if *c < count {
+			count = *c
+		}
+	}
+	if s.adds++; s.adds >= len(s.rows[0])*sketchResetFactor {
+		s.reset()
+		return count >> 1, true
+	}
+	return count, false
+}
+
+// estimate returns the estimated number of occurrences of the key with hash h.
+func (s *countMinSketch) estimate(h uint64) uint32 {
+	count := ^uint32(0)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < count {
+			count = c
+		}
+	}
+	return count
+}
+
+// index returns the position of the key with hash h in the row i, by double hashing.
+func (s *countMinSketch) index(h uint64, i int) uint64 {
+	return (h + uint64(i)*(h>>32|h<<32|1)) & s.mask
+}
+
+// reset halves the counters.
+func (s *countMinSketch) reset() {
+	s.adds = 0
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package redis implements the Backend of a tiered cache over go-redis, see cache.NewTiered.
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/fufuok/cache"
+)
+
+var (
+	_ cache.Backend                   = (*Backend)(nil)
+	_ cache.BackendOf[string, string] = (*BackendOf[string])(nil)
+)
+
+// Codec serializes the values stored in Redis, the codec of the cache can be shared, see cache.WithCodec.
+type Codec = cache.Codec
+
+// JSONCodec the default codec, encoding the values with encoding/json.
+var JSONCodec = cache.JSONCodec
+
+type Config struct {
+	// Prefix prepended to the keys in Redis.
+	Prefix string
+
+	// Codec serializes the values, JSONCodec by default.
+	Codec Codec
+
+	// Timeout of each Redis command, none by default.
+	Timeout time.Duration
+}
+
+type Option func(config *Config)
+
+func WithPrefix(prefix string) Option {
+	return func(config *Config) {
+		config.Prefix = prefix
+	}
+}
+
+func WithCodec(codec Codec) Option {
+	return func(config *Config) {
+		config.Codec = codec
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(config *Config) {
+		config.Timeout = timeout
+	}
+}
+
+func configDefault(opts ...Option) Config {
+	cfg := Config{
+		Codec: JSONCodec,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.Codec == nil {
+		cfg.Codec = JSONCodec
+	}
+	return cfg
+}
+
+// client the commands shared by Backend and BackendOf.
+type client struct {
+	rdb goredis.UniversalClient
+	cfg Config
+}
+
+func (c *client) context() (context.Context, context.CancelFunc) {
+	if c.cfg.Timeout > 0 {
+		return context.WithTimeout(context.Background(), c.cfg.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// get returns the value of the key, decoded into v, and its remaining time to live.
+func (c *client) get(k string, v interface{}) (time.Duration, bool, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	pipe := c.rdb.Pipeline()
+	get := pipe.Get(ctx, c.cfg.Prefix+k)
+	pttl := pipe.PTTL(ctx, c.cfg.Prefix+k)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, goredis.Nil) {
+		return 0, false, err
+	}
+	data, err := get.Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if err := c.cfg.Codec.Unmarshal(data, v); err != nil {
+		return 0, false, err
+	}
+	// PTTL is -1 for the keys that never expire
+	ttl := pttl.Val()
+	if ttl < 0 {
+		ttl = cache.NoExpiration
+	}
+	return ttl, true, nil
+}
+
+func (c *client) set(k string, v interface{}, d time.Duration) error {
+	data, err := c.cfg.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		// 0 means no expiration to go-redis, while -1 keeps the current TTL
+		d = 0
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.rdb.Set(ctx, c.cfg.Prefix+k, data, d).Err()
+}
+
+func (c *client) delete(k string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.rdb.Del(ctx, c.cfg.Prefix+k).Err()
+}
+
+// Backend stores the values of a cache.Tiered in Redis.
+// The values are decoded into interface{}, e.g. map[string]interface{} for structs with JSONCodec,
+// use BackendOf to decode them into their type.
+type Backend struct {
+	client
+}
+
+// New returns a Backend over the go-redis client rdb.
+func New(rdb goredis.UniversalClient, opts ...Option) *Backend {
+	return &Backend{client{rdb: rdb, cfg: configDefault(opts...)}}
+}
+
+func (b *Backend) Get(k string) (interface{}, time.Duration, bool, error) {
+	var v interface{}
+	ttl, ok, err := b.get(k, &v)
+	return v, ttl, ok, err
+}
+
+func (b *Backend) Set(k string, v interface{}, d time.Duration) error {
+	return b.set(k, v, d)
+}
+
+func (b *Backend) Delete(k string) error {
+	return b.delete(k)
+}
+
+// BackendOf stores the values of a cache.TieredOf in Redis.
+type BackendOf[V any] struct {
+	client
+}
+
+// NewOf returns a BackendOf over the go-redis client rdb.
+func NewOf[V any](rdb goredis.UniversalClient, opts ...Option) *BackendOf[V] {
+	return &BackendOf[V]{client{rdb: rdb, cfg: configDefault(opts...)}}
+}
+
+func (b *BackendOf[V]) Get(k string) (V, time.Duration, bool, error) {
+	var v V
+	ttl, ok, err := b.get(k, &v)
+	if !ok {
+		var zeroedV V
+		return zeroedV, ttl, ok, err
+	}
+	return v, ttl, ok, err
+}
+
+func (b *BackendOf[V]) Set(k string, v V, d time.Duration) error {
+	return b.set(k, v, d)
+}
+
+func (b *BackendOf[V]) Delete(k string) error {
+	return b.delete(k)
+}
@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/fufuok/cache"
+)
+
+func newClient(t *testing.T) (*miniredis.Miniredis, goredis.UniversalClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, rdb
+}
+
+func TestBackend(t *testing.T) {
+	mr, rdb := newClient(t)
+	b := New(rdb, WithPrefix("p:"), WithTimeout(time.Second))
+
+	if v, _, ok, err := b.Get("a"); err != nil || ok || v != nil {
+		t.Fatalf("expected a miss, got %v, %v, %v", v, ok, err)
+	}
+	if err := b.Set("a", map[string]interface{}{"x": 1}, cache.NoExpiration); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !mr.Exists("p:a") {
+		t.Fatal("expected the key to be prefixed")
+	}
+	v, ttl, ok, err := b.Get("a")
+	if err != nil || !ok || ttl != cache.NoExpiration {
+		t.Fatalf("unexpected result: %v, %v, %v, %v", v, ttl, ok, err)
+	}
+	if m, _ := v.(map[string]interface{}); m["x"] != float64(1) {
+		t.Fatalf("expected the value decoded by JSONCodec, got %#v", v)
+	}
+
+	if err := b.Set("b", "2", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ttl, ok, _ := b.Get("b"); !ok || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected the remaining time to live, got %v, %v", ttl, ok)
+	}
+	mr.FastForward(time.Minute)
+	if _, _, ok, _ := b.Get("b"); ok {
+		t.Fatal("expected the key to expire")
+	}
+
+	if err := b.Delete("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Delete("a"); err != nil {
+		t.Fatalf("expected no error deleting a missing key, got %v", err)
+	}
+	if _, _, ok, _ := b.Get("a"); ok {
+		t.Fatal("expected the key to be deleted")
+	}
+
+	mr.Close()
+	if _, _, _, err := b.Get("a"); err == nil {
+		t.Fatal("expected the error of the connection")
+	}
+}
+
+func TestBackendOf(t *testing.T) {
+	type point struct{ X, Y int }
+	_, rdb := newClient(t)
+	b := NewOf[point](rdb, WithCodec(cache.GobCodec))
+
+	if v, _, ok, err := b.Get("a"); err != nil || ok || v != (point{}) {
+		t.Fatalf("expected a miss, got %v, %v, %v", v, ok, err)
+	}
+	if err := b.Set("a", point{1, 2}, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, ttl, ok, err := b.Get("a"); err != nil || !ok || v != (point{1, 2}) || ttl <= 0 {
+		t.Fatalf("unexpected result: %v, %v, %v, %v", v, ttl, ok, err)
+	}
+}
+
+func TestBackend_Tiered(t *testing.T) {
+	_, rdb := newClient(t)
+	l2 := NewOf[int](rdb)
+	tc := cache.NewTieredOf[string, int](cache.NewOf[string, int](), l2)
+	defer tc.Close()
+
+	if err := tc.Set("a", 1, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tc.L1().Delete("a")
+	if v, ok, err := tc.Get("a"); err != nil || !ok || v != 1 {
+		t.Fatalf("expected the value read from Redis, got %v, %v, %v", v, ok, err)
+	}
+	if _, ok := tc.L1().Get("a"); !ok {
+		t.Fatal("expected the value promoted to the first level")
+	}
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// DefaultFalsePositiveRate the false positive rate used by KeyFilter if the given rate is not in (0, 1).
+const DefaultFalsePositiveRate = 0.01
+
+// bloomMagic identifies a serialized BloomFilter.
+var bloomMagic = [4]byte{'F', 'C', 'B', 'F'}
+
+// ErrBloomFilterFormat the data is not a serialized BloomFilter, or it is truncated.
+var ErrBloomFilterFormat = errors.New("cache: invalid bloom filter format")
+
+// BloomFilter a compact probabilistic set of keys, see Cache.KeyFilter.
+// MayContain never returns false for an added key, and returns true for a key
+// that was not added with about the false positive rate the filter was sized for.
+// Keys are hashed with FNV-1a, so a filter can be serialized with MarshalBinary
+// and checked by another process. It is not safe for concurrent Add.
+type BloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint32
+}
+
+// NewBloomFilter creates a filter sized for n keys with the false positive rate p.
+// If p is not in (0, 1), DefaultFalsePositiveRate is used.
+func NewBloomFilter(n int, p float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = DefaultFalsePositiveRate
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &BloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add adds the key to the filter.
+func (f *BloomFilter) Add(key string) {
+	h1, h2 := bloomHash(key)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		b := (h1 + i*h2) % f.m
+		f.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+// MayContain reports whether the key was probably added to the filter.
+// False means the key was definitely not added.
+func (f *BloomFilter) MayContain(key string) bool {
+	h1, h2 := bloomHash(key)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		b := (h1 + i*h2) % f.m
+		if f.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (f *BloomFilter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, 16+8*len(f.bits))
+	data = append(data, bloomMagic[:]...)
+	data = binary.BigEndian.AppendUint32(data, f.k)
+	data = binary.BigEndian.AppendUint64(data, f.m)
+	for _, w := range f.bits {
+		data = binary.BigEndian.AppendUint64(data, w)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (f *BloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 || !bytes.Equal(data[:4], bloomMagic[:]) {
+		return ErrBloomFilterFormat
+	}
+	k := binary.BigEndian.Uint32(data[4:])
+	m := binary.BigEndian.Uint64(data[8:])
+	data = data[16:]
+	if k < 1 || m < 1 || uint64(len(data)) != (m+63)/64*8 {
+		return ErrBloomFilterFormat
+	}
+	bits := make([]uint64, len(data)/8)
+	for i := range bits {
+		bits[i] = binary.BigEndian.Uint64(data[i*8:])
+	}
+	f.bits, f.m, f.k = bits, m, k
+	return nil
+}
+
+// bloomHash returns the two hashes of the key combined to derive the k bit positions.
+func bloomHash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	x := h.Sum64()
+	return x & math.MaxUint32, x>>32 | 1
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	f := NewBloomFilter(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(strconv.Itoa(i))
+	}
+	for i := 0; i < n; i++ {
+		if !f.MayContain(strconv.Itoa(i)) {
+			t.Fatalf("added key %d should be found", i)
+		}
+	}
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if f.MayContain(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / n; rate > 0.02 {
+		t.Fatalf("false positive rate too high: %.4f", rate)
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var g BloomFilter
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2*n; i++ {
+		if f.MayContain(strconv.Itoa(i)) != g.MayContain(strconv.Itoa(i)) {
+			t.Fatalf("decoded filter differs for key %d", i)
+		}
+	}
+	if err = g.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrBloomFilterFormat) {
+		t.Fatalf("expected ErrBloomFilterFormat, got: %v", err)
+	}
+	if err = g.UnmarshalBinary([]byte("invalid data")); !errors.Is(err, ErrBloomFilterFormat) {
+		t.Fatalf("expected ErrBloomFilterFormat, got: %v", err)
+	}
+}
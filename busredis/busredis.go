@@ -0,0 +1,229 @@
+// Package busredis keeps the local caches of several instances coherent by broadcasting
+// the deleted keys over Redis pub/sub: each instance deletes locally the keys invalidated by the others.
+package busredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/fufuok/cache"
+)
+
+// DefaultChannel the default Redis channel of the invalidations.
+const DefaultChannel = "cache:invalidate"
+
+// Deleter the local cache of the bus, e.g. a cache.Cache or a cache.CacheOf[string, V].
+type Deleter interface {
+	Delete(k string)
+}
+
+type Config struct {
+	// Channel the Redis channel of the invalidations, DefaultChannel by default.
+	Channel string
+
+	// NodeID the ID of this instance, unique among the instances, random by default.
+	NodeID string
+
+	// Timeout of each Redis command, none by default.
+	Timeout time.Duration
+
+	// ErrorHandler called with the errors of the invalidations published by OnDeleted
+	// and of the malformed messages received.
+	ErrorHandler func(err error)
+}
+
+type Option func(config *Config)
+
+func WithChannel(channel string) Option {
+	return func(config *Config) {
+		config.Channel = channel
+	}
+}
+
+func WithNodeID(id string) Option {
+	return func(config *Config) {
+		config.NodeID = id
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(config *Config) {
+		config.Timeout = timeout
+	}
+}
+
+func WithErrorHandler(fn func(err error)) Option {
+	return func(config *Config) {
+		config.ErrorHandler = fn
+	}
+}
+
+func configDefault(opts ...Option) Config {
+	cfg := Config{
+		Channel: DefaultChannel,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = DefaultChannel
+	}
+	if cfg.NodeID == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		cfg.NodeID = hex.EncodeToString(b)
+	}
+	return cfg
+}
+
+// message the payload published on the channel.
+type message struct {
+	Node string   `json:"node"`
+	Keys []string `json:"keys"`
+}
+
+// Bus an invalidation bus over Redis pub/sub.
+type Bus struct {
+	rdb    goredis.UniversalClient
+	c      Deleter
+	cfg    Config
+	pubsub *goredis.PubSub
+	wg     sync.WaitGroup
+
+	// remote the versions of the keys being deleted for another instance, not to publish them back
+	// from OnDeleted, which drops the version so that only the deletion of the instance is skipped.
+	remoteMu sync.Mutex
+	remote   map[string]uint64
+	version  uint64
+}
+
+// New subscribes to the invalidation channel over the go-redis client rdb,
+// and deletes from c the keys invalidated by the other instances until Close.
+func New(rdb goredis.UniversalClient, c Deleter, opts ...Option) (*Bus, error) {
+	b := &Bus{
+		rdb:    rdb,
+		c:      c,
+		cfg:    configDefault(opts...),
+		remote: make(map[string]uint64),
+	}
+	ctx, cancel := b.context()
+	defer cancel()
+	b.pubsub = rdb.Subscribe(ctx, b.cfg.Channel)
+	// wait for the subscription, not to miss the invalidations published right after New
+	if _, err := b.pubsub.Receive(ctx); err != nil {
+		_ = b.pubsub.Close()
+		return nil, fmt.Errorf("busredis: subscribe %s: %w", b.cfg.Channel, err)
+	}
+	b.wg.Add(1)
+	go b.receive(b.pubsub.Channel())
+	return b, nil
+}
+
+// Invalidate deletes the keys from the local cache and publishes them to the other instances.
+func (b *Bus) Invalidate(keys ...string) error {
+	for _, k := range keys {
+		b.c.Delete(k)
+	}
+	return b.Publish(keys...)
+}
+
+// Publish publishes the keys to the other instances, without deleting them locally.
+func (b *Bus) Publish(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(message{Node: b.cfg.NodeID, Keys: keys})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := b.context()
+	defer cancel()
+	if err := b.rdb.Publish(ctx, b.cfg.Channel, data).Err(); err != nil {
+		return fmt.Errorf("busredis: publish %v: %w", keys, err)
+	}
+	return nil
+}
+
+// OnDeleted publishes the keys deleted from the local cache, to be set as its evicted callback,
+// see cache.WithEvictedCallbackWithReason. The keys deleted for the other instances are not
+// published back, unless the callbacks are asynchronous, see cache.WithAsyncCallbacks.
+func (b *Bus) OnDeleted(k string, _ interface{}, reason cache.EvictionReason) {
+	if reason != cache.ReasonDeleted {
+		return
+	}
+	b.remoteMu.Lock()
+	_, ok := b.remote[k]
+	delete(b.remote, k)
+	b.remoteMu.Unlock()
+	if ok {
+		return
+	}
+	if err := b.Publish(k); err != nil {
+		b.error(err)
+	}
+}
+
+// NodeID returns the ID of this instance.
+func (b *Bus) NodeID() string {
+	return b.cfg.NodeID
+}
+
+// Close unsubscribes from the invalidation channel, it does not close the client or the cache.
+func (b *Bus) Close() error {
+	err := b.pubsub.Close()
+	b.wg.Wait()
+	return err
+}
+
+func (b *Bus) receive(ch <-chan *goredis.Message) {
+	defer b.wg.Done()
+	for m := range ch {
+		var msg message
+		if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+			b.error(fmt.Errorf("busredis: invalid message %q: %w", m.Payload, err))
+			continue
+		}
+		if msg.Node == b.cfg.NodeID {
+			continue
+		}
+		for _, k := range msg.Keys {
+			b.deleteRemote(k)
+		}
+	}
+}
+
+// deleteRemote deletes the key k for another instance, its version is dropped afterwards
+// unless OnDeleted dropped it, or another deletion replaced it.
+func (b *Bus) deleteRemote(k string) {
+	b.remoteMu.Lock()
+	b.version++
+	v := b.version
+	b.remote[k] = v
+	b.remoteMu.Unlock()
+	b.c.Delete(k)
+	b.remoteMu.Lock()
+	if b.remote[k] == v {
+		delete(b.remote, k)
+	}
+	b.remoteMu.Unlock()
+}
+
+func (b *Bus) context() (context.Context, context.CancelFunc) {
+	if b.cfg.Timeout > 0 {
+		return context.WithTimeout(context.Background(), b.cfg.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (b *Bus) error(err error) {
+	if b.cfg.ErrorHandler != nil {
+		b.cfg.ErrorHandler(err)
+	}
+}
@@ -0,0 +1,150 @@
+package busredis
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/fufuok/cache"
+)
+
+// node an instance sharing the bus, its local cache publishes the deleted keys.
+type node struct {
+	c   cache.Cache
+	bus *Bus
+}
+
+func newNode(t *testing.T, mr *miniredis.Miniredis, opts ...Option) *node {
+	t.Helper()
+	rdb := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	n := &node{}
+	n.c = cache.New(cache.WithEvictedCallbackWithReason(func(k string, v interface{}, reason cache.EvictionReason) {
+		n.bus.OnDeleted(k, v, reason)
+	}))
+	bus, err := New(rdb, n.c, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n.bus = bus
+	t.Cleanup(func() {
+		_ = bus.Close()
+		n.c.Close()
+		_ = rdb.Close()
+	})
+	return n
+}
+
+// waitFor waits for the invalidations delivered asynchronously by the subscription.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the invalidation")
+		}
+		runtime.Gosched()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBus(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newNode(t, mr, WithNodeID("a"))
+	b := newNode(t, mr, WithNodeID("b"))
+	if a.bus.NodeID() != "a" {
+		t.Fatalf("expected the node ID a, got %s", a.bus.NodeID())
+	}
+	for _, n := range []*node{a, b} {
+		n.c.SetForever("x", 1)
+		n.c.SetForever("y", 2)
+		n.c.SetForever("z", 3)
+	}
+
+	// Invalidate deletes locally and on the other nodes
+	if err := a.bus.Invalidate("x"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := a.c.Get("x"); ok {
+		t.Fatal("expected x to be deleted locally")
+	}
+	waitFor(t, func() bool { _, ok := b.c.Get("x"); return !ok })
+
+	// a local Delete is published by OnDeleted
+	b.c.Delete("y")
+	waitFor(t, func() bool { _, ok := a.c.Get("y"); return !ok })
+
+	// Publish does not delete locally
+	if err := a.bus.Publish("z"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { _, ok := b.c.Get("z"); return !ok })
+	if _, ok := a.c.Get("z"); !ok {
+		t.Fatal("expected z to be kept locally")
+	}
+}
+
+func TestBus_NoEcho(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newNode(t, mr, WithNodeID("a"))
+	b := newNode(t, mr, WithNodeID("b"))
+	rdb := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	sub := rdb.Subscribe(context.Background(), DefaultChannel)
+	defer sub.Close()
+	if _, err := sub.Receive(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.c.SetForever("x", 1)
+	b.c.SetForever("y", 2)
+
+	if err := a.bus.Invalidate("x"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { _, ok := b.c.Get("x"); return !ok })
+	b.c.Delete("y")
+
+	// the messages are received in order, the deletion of x by b is not published before y
+	var got []message
+	for len(got) < 2 {
+		m, err := sub.ReceiveMessage(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var msg message
+		if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, msg)
+	}
+	want := []message{{Node: "a", Keys: []string{"x"}}, {Node: "b", Keys: []string{"y"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	b.bus.remoteMu.Lock()
+	n := len(b.bus.remote)
+	b.bus.remoteMu.Unlock()
+	if n != 0 {
+		t.Fatalf("expected no remote version left, got %d", n)
+	}
+}
+
+func TestBus_InvalidMessage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var errs int32
+	n := newNode(t, mr, WithChannel("ch"), WithErrorHandler(func(err error) {
+		atomic.AddInt32(&errs, 1)
+	}))
+	n.c.SetForever("x", 1)
+	mr.Publish("ch", "invalid")
+	waitFor(t, func() bool { return atomic.LoadInt32(&errs) == 1 })
+	if _, ok := n.c.Get("x"); !ok {
+		t.Fatal("expected the cache to be untouched")
+	}
+}
@@ -0,0 +1,11 @@
+package cache
+
+import (
+	"unsafe"
+)
+
+// bytesToString returns the bytes as a string without copying them,
+// the string must not be retained past the use of the bytes, see GetBytes.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"time"
+)
+
+// GetBytesOf returns the value of the key given as bytes, e.g. read from a network buffer,
+// without allocating a string for the key, see Cache.GetBytes.
+func GetBytesOf[V any](c CacheOf[string, V], k []byte) (V, bool) {
+	if w, ok := c.(*xsyncMapOfWrapper[string, V]); ok && w.noCopyReads {
+		return getBytesOf(w.xsyncMapOf, k)
+	}
+	return c.Get(string(k))
+}
+
+// getBytesOf returns the value of the unexpired item or the missing key without copying the key,
+// and falls back to Get for the other reads, which may retain the key.
+func getBytesOf[V any](c *xsyncMapOf[string, V], k []byte) (V, bool) {
+	if c.profiler != nil {
+		defer c.profile(ProfileGet, time.Now())
+	}
+	s := bytesToString(k)
+	i, ok := c.items.Load(s)
+	if !ok && c.loader == nil && c.overflow == nil {
+		c.record(EventGet, s, false)
+		return i.v, false
+	}
+	if ok && i.ext().t == 0 && !c.expired(s, i) {
+		c.record(EventGet, s, true)
+		return c.copied(i.v), true
+	}
+	return c.Get(string(k))
+}
+
+// SetBytesOf stores the value for the key given as bytes, see Cache.SetBytes.
+func SetBytesOf[V any](c CacheOf[string, V], k []byte, v V, d time.Duration) {
+	c.Set(string(k), v, d)
+}
@@ -4,8 +4,10 @@
 package cache
 
 import (
+	"fmt"
 	"strconv"
 	"testing"
+	"time"
 	_ "unsafe"
 )
 
@@ -117,6 +119,54 @@ func BenchmarkCache_Integer_WarmUp(b *testing.B) {
 	}
 }
 
+func BenchmarkCache_CoarseClock_WarmUp(b *testing.B) {
+	for _, bc := range benchmarkCases {
+		b.Run(bc.name, func(b *testing.B) {
+			m := NewOf[string, int](WithClockOf[string, int](CoarseClock))
+			for i := 0; i < benchmarkNumEntries; i++ {
+				m.Set(benchmarkKeyPrefix+strconv.Itoa(i), i, time.Hour)
+			}
+			benchmarkCache(b, func(k string) (int, bool) {
+				return m.Get(k)
+			}, func(k string, v int) {
+				m.Set(k, v, time.Hour)
+			}, func(k string) {
+				m.Delete(k)
+			}, bc.readPercentage)
+		})
+	}
+}
+
+func BenchmarkCache_Key2(b *testing.B) {
+	const n = 1000
+	b.Run("Sprintf", func(b *testing.B) {
+		m := NewOf[string, int]()
+		for i := 0; i < n; i++ {
+			m.SetForever(fmt.Sprintf("%d:%s", i, benchmarkKeyPrefix), i)
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				m.Get(fmt.Sprintf("%d:%s", i%n, benchmarkKeyPrefix))
+			}
+		})
+	})
+	b.Run("Key2", func(b *testing.B) {
+		m := NewOf[Key2[int, string], int](
+			WithHasherOf[Key2[int, string], int](Key2Hasher[int, string]()),
+		)
+		for i := 0; i < n; i++ {
+			m.SetForever(NewKey2(i, benchmarkKeyPrefix), i)
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				m.Get(NewKey2(i%n, benchmarkKeyPrefix))
+			}
+		})
+	})
+}
+
 func benchmarkCache(
 	b *testing.B,
 	loadFn func(k string) (int, bool),
@@ -187,6 +237,17 @@ func BenchmarkCache_Range(b *testing.B) {
 	})
 }
 
+func BenchmarkCache_DeleteExpired(b *testing.B) {
+	m := NewOf[string, int](WithCleanupIntervalOf[string, int](0))
+	for i := 0; i < benchmarkNumEntries; i++ {
+		m.SetForever(benchmarkKeys[i], i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.DeleteExpired()
+	}
+}
+
 //go:noescape
 //go:linkname runtimeFastrand runtime.fastrand
 func runtimeFastrand() uint32
@@ -1,8 +1,17 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -27,7 +36,7 @@ var (
 		num: 2,
 		sub: &t1,
 	}
-	testKV = []kv{
+	testKV = []kvOf[string, interface{}]{
 		{"string", "s"},
 		{"int", 1},
 		{"int32", int32(32)},
@@ -267,6 +276,206 @@ func TestCache_GetAndRefresh(t *testing.T) {
 	if !ok || v == nil || v.(int) != 1 || tm.Before(time.Now()) {
 		t.Fatal("failed to get the value and expiration time of key x")
 	}
+
+	if !c.RefreshOnly("x", 2*time.Second) {
+		t.Fatal("key x should be refreshed")
+	}
+	if _, ttl, _ = c.GetWithTTL("x"); ttl < 1500*time.Millisecond {
+		t.Fatalf("key x lifetime is incorrect, expected >= 1.5s, got %d", ttl)
+	}
+	if c.RefreshOnly("y", time.Second) {
+		t.Fatal("missing key y should not be refreshed")
+	}
+}
+
+func TestCache_GetWithExpirationNano(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	c.Set("a", 1, time.Hour)
+	c.SetForever("b", 2)
+	_, e, _ := c.GetWithExpiration("a")
+	if v, n, ok := c.GetWithExpirationNano("a"); !ok || v != 1 || n != e.UnixNano() {
+		t.Fatalf("expected 1 expiring at %d, got %v at %d", e.UnixNano(), v, n)
+	}
+	if v, n, ok := c.GetWithExpirationNano("b"); !ok || v != 2 || n != 0 {
+		t.Fatalf("expected 2 never expiring, got %v at %d", v, n)
+	}
+	if _, _, ok := c.GetWithExpirationNano("c"); ok {
+		t.Fatal("expected c to be missing")
+	}
+}
+
+func TestCache_GetBytes(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := New(WithClock(clock))
+	defer c.Close()
+	c.SetBytes([]byte("a"), 1, time.Second)
+	buf := []byte("a")
+	if v, ok := c.GetBytes(buf); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	if v, ok := c.GetBytes([]byte("b")); ok || v != nil {
+		t.Fatalf("expected a miss, got %v, %v", v, ok)
+	}
+	miss := []byte("b")
+	if n := testing.AllocsPerRun(100, func() {
+		c.GetBytes(buf)
+		c.GetBytes(miss)
+	}); n != 0 {
+		t.Fatalf("expected no allocation, got %v", n)
+	}
+	clock.Advance(2 * time.Second)
+	if _, ok := c.GetBytes(buf); ok {
+		t.Fatal("expected the item to expire")
+	}
+	if n := c.Count(); n != 0 {
+		t.Fatalf("expected the expired item to be deleted, got %d items", n)
+	}
+
+	// the features retaining the keys read copy them
+	c = New(WithMaxEntries(1), WithEventHistory(10))
+	defer c.Close()
+	c.SetBytes(buf, 1, NoExpiration)
+	if v, ok := c.GetBytes(buf); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	buf[0] = 'x'
+	if events := c.RecentEvents(); events[len(events)-1].Key != "a" {
+		t.Fatalf("expected the key to be copied, got %v", events)
+	}
+	if v, ok := c.Namespace("ns:").GetBytes([]byte("b")); ok {
+		t.Fatalf("expected a miss, got %v", v)
+	}
+}
+
+func TestCache_WithKeyNormalizer(t *testing.T) {
+	c := New(WithKeyNormalizer(strings.ToLower))
+	defer c.Close()
+	c.SetForever("Content-Type", "json")
+	if v, ok := c.Get("content-type"); !ok || v != "json" {
+		t.Fatalf("expected json, got %v, %v", v, ok)
+	}
+	c.SetForever("CONTENT-TYPE", "xml")
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"content-type"}) {
+		t.Fatalf("expected the normalized key, got %v", keys)
+	}
+	if items := c.GetMultiple([]string{"Content-Type", "Missing"}); !reflect.DeepEqual(items, map[string]interface{}{"Content-Type": "xml"}) {
+		t.Fatalf("expected the items by the keys given, got %v", items)
+	}
+	if v, err := Increment(c, "N", 2); err != nil || v != 2 {
+		t.Fatalf("expected 2, got %v, %v", v, err)
+	}
+	if v, ok := c.GetBytes([]byte("n")); !ok || v != 2 {
+		t.Fatalf("expected 2, got %v, %v", v, ok)
+	}
+	ns := c.Namespace("Hosts:")
+	ns.SetForever("Example.COM", 1)
+	if v, ok := c.Get("hosts:example.com"); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	if keys := ns.KeysSorted(); !reflect.DeepEqual(keys, []string{"example.com"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	c.Delete("HOSTS:EXAMPLE.COM")
+	if n := c.Count(); n != 2 {
+		t.Fatalf("expected 2 items, got %d", n)
+	}
+
+	s := NewSharded(4, WithKeyNormalizer(strings.ToLower))
+	defer s.Close()
+	s.SetForever("A", 1)
+	if v, ok := s.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+}
+
+func TestCache_WithValueCopier(t *testing.T) {
+	c := New(WithValueCopier(func(v interface{}) interface{} {
+		return append([]int(nil), v.([]int)...)
+	}))
+	defer c.Close()
+
+	c.SetForever("a", []int{1, 2})
+	v, _ := c.Get("a")
+	v.([]int)[0] = 100
+	items := c.Items()
+	items["a"].([]int)[1] = 100
+	if v, _ := c.Get("a"); !reflect.DeepEqual(v, []int{1, 2}) {
+		t.Fatalf("the cached value should not be mutated: %v", v)
+	}
+	if v, _ := c.GetE("a"); !reflect.DeepEqual(v, []int{1, 2}) {
+		t.Fatalf("unexpected value: %v", v)
+	}
+
+	// the reads of the Cache interface return copies like those of CacheOf
+	reads := map[string]func() interface{}{
+		"ItemsSorted": func() interface{} { return c.ItemsSorted()[0].Value },
+		"RangeSorted": func() (v interface{}) {
+			c.RangeSorted(func(_ string, x interface{}) bool { v = x; return false })
+			return
+		},
+		"ItemsWithExpiration": func() interface{} { return c.ItemsWithExpiration()["a"].Value },
+		"ItemsWithPrefix":     func() interface{} { return c.ItemsWithPrefix("a")["a"] },
+	}
+	for name, read := range reads {
+		read().([]int)[0] = 100
+		if v, _ := c.Get("a"); !reflect.DeepEqual(v, []int{1, 2}) {
+			t.Fatalf("%s: the cached value should not be mutated: %v", name, v)
+		}
+	}
+}
+
+// testPool a ValuePool recording the values put.
+type testPool struct {
+	mu   sync.Mutex
+	vals []interface{}
+}
+
+func (p *testPool) Put(v interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.vals = append(p.vals, v)
+}
+
+func (p *testPool) values() []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]interface{}(nil), p.vals...)
+}
+
+func TestCache_WithValuePool(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	pool := &testPool{}
+	c := New(WithClock(clock), WithCleanupInterval(0), WithMaxEntries(2), WithValuePool(pool))
+	defer c.Close()
+
+	a1, a2, b := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	c.SetForever("a", a1)
+	c.SetForever("a", a1) // stored again, kept
+	c.SetForever("a", a2) // overwritten, pooled
+	if got := pool.values(); len(got) != 1 || got[0] != a1 {
+		t.Fatalf("expected the overwritten value to be pooled, got: %v", got)
+	}
+	c.Set("b", b, time.Second)
+	clock.Advance(time.Minute)
+	c.DeleteExpired()
+	if got := pool.values(); len(got) != 2 || got[1] != b {
+		t.Fatalf("expected the expired value to be pooled, got: %v", got)
+	}
+
+	c.Delete("a")
+	c.SetForever("c", &bytes.Buffer{})
+	if old, _ := c.GetAndSet("c", &bytes.Buffer{}, NoExpiration); old == nil {
+		t.Fatal("expected the previous value")
+	}
+	if got := pool.values(); len(got) != 2 {
+		t.Fatalf("expected the deleted values and those returned to be kept, got: %v", got)
+	}
+	c.Clear()
+	if got := pool.values(); len(got) != 3 {
+		t.Fatalf("expected the cleared value to be pooled, got: %v", got)
+	}
 }
 
 func TestCache_GetOrCompute(t *testing.T) {
@@ -355,6 +564,47 @@ func TestCache_GetOrCompute_FunctionCalledOnce(t *testing.T) {
 	})
 }
 
+func TestCache_GetOrComputeUnlocked(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	if v, loaded := c.GetOrComputeUnlocked("a", func() interface{} { return 1 }, NoExpiration); loaded || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, loaded)
+	}
+	if v, loaded := c.GetOrComputeUnlocked("a", func() interface{} { return 2 }, NoExpiration); !loaded || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, loaded)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _ := c.GetOrComputeUnlocked("b", func() interface{} {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					close(started)
+				}
+				<-release
+				return 1
+			}, NoExpiration)
+			if v != 2 {
+				t.Errorf("expected the value set meanwhile, got %v", v)
+			}
+		}()
+	}
+	<-started
+	// not blocked by the computation
+	c.Set("b", 2, NoExpiration)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected valueFn to be called once, got %d", n)
+	}
+}
+
 func TestCache_Compute(t *testing.T) {
 	var zeroedV interface{}
 	c := New()
@@ -472,6 +722,43 @@ func TestCache_Compute(t *testing.T) {
 	}
 }
 
+func TestCache_ComputeWithOp(t *testing.T) {
+	evicted := 0
+	c := New(WithEvictedCallback(func(k string, v interface{}) { evicted++ }))
+	c.Set("a", 1, time.Hour)
+	_, e, _ := c.GetWithExpirationNano("a")
+	v, ok := c.ComputeWithOp("a", func(oldValue interface{}, loaded bool) (interface{}, ComputeOp) {
+		if oldValue != 1 || !loaded {
+			t.Fatalf("unexpected old value: %v, %v", oldValue, loaded)
+		}
+		return 2, CancelOp
+	}, NoExpiration)
+	if v != 1 || !ok {
+		t.Fatalf("an existing value should be kept by CancelOp: %v, %v", v, ok)
+	}
+	if v, e2, _ := c.GetWithExpirationNano("a"); v != 1 || e2 != e {
+		t.Fatalf("the item should be unchanged: %v, %d, expected 1, %d", v, e2, e)
+	}
+	v, ok = c.ComputeWithOp("b", func(oldValue interface{}, loaded bool) (interface{}, ComputeOp) {
+		return 2, CancelOp
+	}, NoExpiration)
+	if v != nil || ok {
+		t.Fatalf("a missing key should stay missing on CancelOp: %v, %v", v, ok)
+	}
+	if evicted != 0 {
+		t.Fatalf("no callback should be called on CancelOp: %d", evicted)
+	}
+	v, ok = c.Namespace("ns:").ComputeWithOp("c", func(interface{}, bool) (interface{}, ComputeOp) {
+		return 3, UpdateOp
+	}, NoExpiration)
+	if v != 3 || !ok {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+	if v, _ := c.Get("ns:c"); v != 3 {
+		t.Fatalf("expected the value of the namespace, got %v", v)
+	}
+}
+
 func TestCache_GetAndDelete(t *testing.T) {
 	c := New()
 	v, ok := c.GetAndDelete("x")
@@ -490,6 +777,22 @@ func TestCache_GetAndDelete(t *testing.T) {
 	if ok || v != nil {
 		t.Fatal("key x should be deleted")
 	}
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	var reason EvictionReason
+	c = New(WithClock(clock), WithNoCleanupLoop(), WithEvictedCallbackWithReason(func(_ string, _ interface{}, r EvictionReason) {
+		reason = r
+	}))
+	c.Set("x", 1, time.Second)
+	clock.Advance(2 * time.Second)
+	v, ok = c.GetAndDelete("x")
+	if ok || v != nil {
+		t.Fatalf("expired key x should not be returned, got %v", v)
+	}
+	_ = c.Close()
+	if reason != ReasonExpired || c.Count() != 0 {
+		t.Fatalf("expected the expired key x deleted as expired, got %v", reason)
+	}
 }
 
 func TestCache_Delete(t *testing.T) {
@@ -616,3 +919,2514 @@ func TestCache_Range(t *testing.T) {
 		t.Fatalf("incorrect number of items in cache, expected %d, got %d", 10, c.Count())
 	}
 }
+
+func TestCache_Scan(t *testing.T) {
+	c := New()
+	for i := 0; i < 100; i++ {
+		c.SetDefault("session:user:"+strconv.Itoa(i), i)
+		c.SetDefault("other:"+strconv.Itoa(i), i)
+	}
+	c.Set("session:user:expired", 1, 1*time.Millisecond)
+	<-time.After(2 * time.Millisecond)
+
+	seen := make(map[string]int)
+	var cursor uint64
+	calls := 0
+	for {
+		var keys []string
+		keys, cursor = c.Scan("session:user:*", cursor, 7)
+		for _, k := range keys {
+			seen[k]++
+		}
+		calls++
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 100 {
+		t.Fatalf("expected %d keys, got: %d", 100, len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("key %s was returned %d times", k, n)
+		}
+		if !strings.HasPrefix(k, "session:user:") || k == "session:user:expired" {
+			t.Fatalf("unexpected key: %s", k)
+		}
+	}
+	if calls < 200/7 {
+		t.Fatalf("expected at least %d calls, got: %d", 200/7, calls)
+	}
+
+	keys, cursor := c.Scan("", 0, 1000)
+	if len(keys) != 200 || cursor != 0 {
+		t.Fatalf("expected all keys in one call, got: %d, cursor: %d", len(keys), cursor)
+	}
+
+	keys, cursor = NewDefault(0, 0).Scan("*", 0, 0)
+	if len(keys) != 0 || cursor != 0 {
+		t.Fatalf("expected empty scan, got: %v, cursor: %d", keys, cursor)
+	}
+}
+
+func TestCache_Scan_WithConcurrentWrites(t *testing.T) {
+	c := New()
+	for i := 0; i < 1000; i++ {
+		c.SetDefault(strconv.Itoa(i), i)
+	}
+
+	seen := make(map[string]int)
+	var cursor uint64
+	for j := 0; ; j++ {
+		var keys []string
+		keys, cursor = c.Scan("", cursor, 50)
+		for _, k := range keys {
+			seen[k]++
+		}
+		if cursor == 0 {
+			break
+		}
+		if j > 5 {
+			continue
+		}
+		// force the underlying table to grow while scanning
+		for i := 0; i < 500; i++ {
+			c.SetDefault("new:"+strconv.Itoa(j)+":"+strconv.Itoa(i), i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if n := seen[strconv.Itoa(i)]; n != 1 {
+			t.Fatalf("key %d was returned %d times", i, n)
+		}
+	}
+}
+
+func TestCache_RangeCursor(t *testing.T) {
+	const numEntries = 1000
+	c := New()
+	for i := 0; i < numEntries; i++ {
+		c.SetDefault(strconv.Itoa(i), i)
+	}
+
+	seen := make(map[string]int)
+	deleted := make(map[string]bool)
+	var cursor uint64
+	for j := 0; ; j++ {
+		n := 0
+		cursor = c.RangeCursor(cursor, 100, func(k string, v interface{}) {
+			if i := v.(int); i < numEntries && k != strconv.Itoa(i) {
+				t.Fatalf("key %s does not match value %v", k, v)
+			}
+			seen[k]++
+			n++
+		})
+		if n > 100 {
+			t.Fatalf("expected at most %d entries per call, got: %d", 100, n)
+		}
+		if cursor == 0 {
+			break
+		}
+		// deleted keys may or may not be visited, new keys may be skipped
+		c.Delete(strconv.Itoa(j))
+		deleted[strconv.Itoa(j)] = true
+		c.SetDefault("new:"+strconv.Itoa(j), numEntries+j)
+	}
+	for i := 0; i < numEntries; i++ {
+		k := strconv.Itoa(i)
+		if n := seen[k]; n > 1 || (n == 0 && !deleted[k]) {
+			t.Fatalf("key %s was visited %d times", k, n)
+		}
+	}
+}
+
+func TestCache_ScanItems(t *testing.T) {
+	c := New()
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+
+	seen := make(map[string]interface{})
+	var (
+		items  []KeyValue
+		cursor uint64
+	)
+	for {
+		items, cursor = c.ScanItems(cursor, 30)
+		if len(items) > 30 {
+			t.Fatalf("expected up to 30 items, got %d", len(items))
+		}
+		for _, x := range items {
+			seen[x.Key] = x.Value
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 100 || seen["42"] != 42 {
+		t.Fatalf("expected 100 items, got %d", len(seen))
+	}
+}
+
+func TestCache_SaveToAndLoadFrom(t *testing.T) {
+	c := New()
+	c.Set("a", "1", testDefaultExpiration)
+	c.SetForever("b", 2)
+	c.Set("expired", 3, 1*time.Millisecond)
+	<-time.After(2 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h, err := ReadSnapshotHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Version != SnapshotVersion || h.KeyType != "string" || h.ValueType != "interface {}" || h.Count != 2 {
+		t.Fatalf("unexpected snapshot header: %+v", h)
+	}
+
+	c2 := New()
+	c2.Set("a", "old", NoExpiration)
+	c2.Set("c", 4, NoExpiration)
+	if err = c2.LoadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v, e, ok := c2.GetWithExpiration("a")
+	_, e1, _ := c.GetWithExpiration("a")
+	if !ok || v.(string) != "1" || !e.Equal(e1) {
+		t.Fatalf("key a, expected %v (%v), got %v (%v)", "1", e1, v, e)
+	}
+	v, ttl, ok := c2.GetWithTTL("b")
+	if !ok || v.(float64) != 2 || ttl != NoExpiration {
+		t.Fatalf("key b, expected %v, got %v (%v)", 2, v, ttl)
+	}
+	if _, ok = c2.Get("expired"); ok {
+		t.Fatal("expired key should not be loaded")
+	}
+	if c2.Count() != 3 {
+		t.Fatalf("expected number of items in cache to be 3, got: %d", c2.Count())
+	}
+}
+
+func TestCache_LoadItemsWithExpiration(t *testing.T) {
+	c := New()
+	c.Set("a", 1, 100*time.Millisecond)
+	c.SetForever("b", 2)
+	c.Set("c", 3, 1*time.Millisecond)
+	items := c.ItemsWithExpiration()
+	if len(items) != 3 || !items["b"].Expiration.IsZero() || items["a"].Expiration.IsZero() {
+		t.Fatalf("unexpected items: %v", items)
+	}
+	<-time.After(2 * time.Millisecond)
+	if items = c.ItemsWithExpiration(); len(items) != 2 {
+		t.Fatalf("expected %d items, got: %v", 2, items)
+	}
+
+	soon := time.Now().Add(50 * time.Millisecond)
+	later := time.Now().Add(1 * time.Second)
+	load := map[string]ItemWithExpiration{
+		"a":       {Value: 10, Expiration: later},
+		"b":       {Value: 20, Expiration: soon},
+		"c":       {Value: 30, Expiration: soon},
+		"d":       {Value: 40},
+		"expired": {Value: 50, Expiration: time.Now().Add(-1 * time.Second)},
+	}
+	tests := []struct {
+		strategy LoadStrategy
+		want     map[string]interface{}
+	}{
+		{Overwrite, map[string]interface{}{"a": 10, "b": 20, "c": 30, "d": 40}},
+		{KeepExisting, map[string]interface{}{"a": 1, "b": 2, "c": 30, "d": 40}},
+		{KeepNewestByExpiration, map[string]interface{}{"a": 10, "b": 2, "c": 30, "d": 40}},
+	}
+	for _, tt := range tests {
+		c2 := New()
+		c2.LoadItemsWithExpiration(c.ItemsWithExpiration())
+		c2.LoadItemsWithExpiration(load, tt.strategy)
+		if got := c2.Items(); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("strategy %d, expected %v, got: %v", tt.strategy, tt.want, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	c3 := New()
+	c3.LoadItemsWithExpiration(load)
+	if err := c3.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c4 := New()
+	c4.Set("a", 1, NoExpiration)
+	c4.Set("b", 2, NoExpiration)
+	if err := c4.LoadFrom(&buf, KeepNewestByExpiration); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"a": 1, "b": 2, "c": float64(30), "d": float64(40)}
+	if got := c4.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+}
+
+func TestCache_RecentEvents(t *testing.T) {
+	if New().RecentEvents() != nil {
+		t.Fatal("event history should be disabled by default")
+	}
+
+	c := New(WithEventHistory(4), WithCleanupInterval(0))
+	c.Set("a", 1, 1*time.Millisecond)
+	c.Get("a")
+	c.Get("b")
+	<-time.After(2 * time.Millisecond)
+	c.DeleteExpired()
+	c.Delete("a")
+	c.Clear()
+	events := c.RecentEvents()
+	want := []Event{
+		{Op: EventGet, Key: "b", OK: false},
+		{Op: EventExpire, Key: "a", OK: true},
+		{Op: EventDelete, Key: "a", OK: false},
+		{Op: EventClear, Key: "", OK: true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got: %v", len(want), events)
+	}
+	for i, e := range events {
+		if e.Op != want[i].Op || e.Key != want[i].Key || e.OK != want[i].OK || e.Time.IsZero() {
+			t.Fatalf("event %d, expected %v, got: %v", i, want[i], e)
+		}
+		if i > 0 && e.Time.Before(events[i-1].Time) {
+			t.Fatalf("events are not ordered: %v", events)
+		}
+	}
+	if EventExpire.String() != "expire" || EventOp(0).String() != "unknown" {
+		t.Fatal("unexpected event op name")
+	}
+}
+
+func TestCache_RecentEvents_Concurrent(t *testing.T) {
+	const numEntries = 1000
+	c := New(WithEventHistory(100))
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numEntries; i++ {
+				c.SetDefault(strconv.Itoa(i), i)
+				c.RecentEvents()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(c.RecentEvents()); n != 100 {
+		t.Fatalf("expected %d events, got: %d", 100, n)
+	}
+}
+
+func TestCache_ShadowStats(t *testing.T) {
+	if New().ShadowStats() != nil {
+		t.Fatal("shadow stats should be disabled by default")
+	}
+
+	c := New(WithShadow(Shadow{Policy: LRU}, Shadow{Policy: LRU, Size: 10}, Shadow{Policy: LFU, Size: 10}))
+	// 10 hot keys read 10 times, then 100 cold keys read once after being set
+	for i := 0; i < 10; i++ {
+		c.SetDefault("hot"+strconv.Itoa(i), i)
+	}
+	for n := 0; n < 10; n++ {
+		for i := 0; i < 10; i++ {
+			c.Get("hot" + strconv.Itoa(i))
+		}
+	}
+	for i := 0; i < 100; i++ {
+		k := "cold" + strconv.Itoa(i)
+		if _, ok := c.GetOrSet(k, i, DefaultExpiration); ok {
+			t.Fatalf("key %s should not exist", k)
+		}
+		c.Get(k)
+	}
+	for i := 0; i < 10; i++ {
+		c.Get("hot" + strconv.Itoa(i))
+	}
+
+	stats := c.ShadowStats()
+	if len(stats) != 3 {
+		t.Fatalf("expected %d stats, got: %v", 3, stats)
+	}
+	// reads: 100 hot + 100 cold misses + 100 cold hits + 10 hot
+	if s := stats[0]; s.Hits != 210 || s.Misses != 100 {
+		t.Fatalf("unbounded LRU, unexpected stats: %+v", s)
+	}
+	if s := stats[1]; s.Hits != 200 || s.Misses != 110 {
+		t.Fatalf("LRU@10, unexpected stats: %+v", s)
+	}
+	if s := stats[2]; s.Hits <= stats[1].Hits {
+		t.Fatalf("LFU@10 should keep the hot keys, got: %v", s)
+	}
+
+	c.Clear()
+	c.Get("hot0")
+	if s := c.ShadowStats()[0]; s.Misses != 101 {
+		t.Fatalf("clear should be replayed, got: %+v", s)
+	}
+}
+
+func TestCache_Diagnostics(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := New(WithClock(clock), WithCleanupInterval(time.Minute))
+	defer c.Close()
+	c.SetForever("forever", 1)
+	c.Set("soon", 1, 30*time.Second)
+	c.Set("later", 1, 90*time.Second)
+	c.Set("hour", 1, 50*time.Minute)
+	c.Set("gone", 1, time.Second)
+	clock.Advance(2 * time.Second)
+
+	d := c.Diagnostics(3)
+	if d.Items != 4 || d.Expired != 1 || d.NoExpiration != 1 {
+		t.Fatalf("unexpected counts: %+v", d)
+	}
+	if d.CleanupInterval != time.Minute {
+		t.Fatalf("expected the cleanup interval, got %v", d.CleanupInterval)
+	}
+	// 28s and 88s, ~50m
+	counts := make([]int, len(d.TTLHistogram))
+	for i, b := range d.TTLHistogram {
+		counts[i] = b.Count
+	}
+	if want := []int{0, 0, 1, 1, 1, 0, 0}; !reflect.DeepEqual(counts, want) {
+		t.Fatalf("expected histogram %v, got %v", want, counts)
+	}
+	if want := []int{2, 1, 0}; !reflect.DeepEqual(d.Forecast, want) {
+		t.Fatalf("expected forecast %v, got %v", want, d.Forecast)
+	}
+	if d := c.Namespace("ns:").Diagnostics(0); d.Items != 4 || len(d.Forecast) != 0 {
+		t.Fatalf("expected the diagnostics of the whole cache, got %+v", d)
+	}
+}
+
+func TestCache_HotKeys(t *testing.T) {
+	c := New(WithHotKeys(3))
+	defer c.Close()
+	if c.Namespace("ns:").HotKeys(0) != nil || New().HotKeys(1) != nil {
+		t.Fatal("expected no hot keys")
+	}
+	for i := 0; i < 100; i++ {
+		c.Get(strconv.Itoa(i))
+		for j := 0; j < 10; j++ {
+			c.Get("ns:hot")
+		}
+		for j := 0; j < 5; j++ {
+			c.Get("warm")
+		}
+	}
+	stats := c.HotKeys(2)
+	if len(stats) != 2 || stats[0].Key != "ns:hot" || stats[1].Key != "warm" {
+		t.Fatalf("unexpected hot keys: %v", stats)
+	}
+	if stats[0].Count < 1000 || stats[1].Count < 500 {
+		t.Fatalf("expected the counts to be overestimated at most, got %v", stats)
+	}
+	if n := len(c.HotKeys(0)); n != 3 {
+		t.Fatalf("expected 3 hot keys, got %d", n)
+	}
+	if stats := c.Namespace("ns:").HotKeys(1); len(stats) != 1 || stats[0].Key != "hot" {
+		t.Fatalf("expected the hot key of the namespace, got %v", stats)
+	}
+}
+
+func TestCache_WithPersistencePath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	c := New(WithPersistencePath(path))
+	if c.Count() != 0 {
+		t.Fatalf("expected an empty cache, got: %d", c.Count())
+	}
+	c.SetForever("a", "1")
+	c.Set("b", "2", testDefaultExpiration)
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Close(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed on second close, got: %v", err)
+	}
+
+	c2 := New(WithPersistencePath(path))
+	defer c2.Close()
+	want := map[string]interface{}{"a": "1", "b": "2"}
+	if got := c2.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+
+	// an invalid snapshot is reported, and not saved over
+	if err := os.WriteFile(path, []byte("invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	c3 := New(WithPersistencePath(path), WithPersistenceErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	if c3.Count() != 0 {
+		t.Fatalf("expected an empty cache, got: %d", c3.Count())
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected the error of the snapshot, got: %v", errs)
+	}
+	c3.SetForever("c", "3")
+	if err := c3.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "invalid" {
+		t.Fatalf("expected the invalid snapshot to be kept, got: %q", data)
+	}
+
+	// a missing snapshot is not reported
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	c4 := New(WithPersistencePath(path), WithPersistenceErrorHandler(func(err error) {
+		t.Fatalf("unexpected error: %v", err)
+	}))
+	c4.SetForever("c", "3")
+	if err := c4.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files, _ := os.ReadDir(filepath.Dir(path))
+	if len(files) != 1 {
+		t.Fatalf("temporary files should be removed, got: %v", files)
+	}
+	if got := New(WithPersistencePath(path)).Items(); !reflect.DeepEqual(got, map[string]interface{}{"c": "3"}) {
+		t.Fatalf("unexpected items: %v", got)
+	}
+}
+
+func TestCache_GetOrLoad(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	var calls int32
+	loader := func(k string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return k + "!", nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, _, err := c.GetOrLoad("a", loader, NoExpiration); err != nil || v != "a!" {
+				t.Errorf("unexpected result: %v, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected the loader to be called once, got: %d", n)
+	}
+	v, loaded, err := c.GetOrLoad("a", loader, NoExpiration)
+	if err != nil || !loaded || v != "a!" {
+		t.Fatalf("expected the cached value, got: %v, %v, %v", v, loaded, err)
+	}
+
+	errLoad := errors.New("load failed")
+	v, loaded, err = c.GetOrLoad("b", func(string) (interface{}, error) {
+		return nil, errLoad
+	}, NoExpiration)
+	if err != errLoad || loaded || v != nil {
+		t.Fatalf("expected the loader error, got: %v, %v, %v", v, loaded, err)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("nothing should be stored on error")
+	}
+}
+
+func TestCache_Warmup(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	errLoad := errors.New("load failed")
+	var running, peak int32
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	err := c.Warmup(context.Background(), keys, func(_ context.Context, k string) (interface{}, time.Duration, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		switch k {
+		case "c":
+			return nil, 0, errLoad
+		case "e":
+			panic("boom")
+		}
+		return k + "!", NoExpiration, nil
+	}, 2)
+	var werr *WarmupError
+	if !errors.As(err, &werr) {
+		t.Fatalf("expected a *WarmupError, got: %v", err)
+	}
+	if !reflect.DeepEqual(werr.Keys, []string{"c", "e"}) {
+		t.Fatalf("unexpected failed keys: %v", werr.Keys)
+	}
+	if !errors.Is(err, errLoad) || !errors.Is(err, ErrLoaderPanicked) {
+		t.Fatalf("unexpected errors: %v", werr.Errs)
+	}
+	if p := atomic.LoadInt32(&peak); p > 2 {
+		t.Fatalf("expected at most 2 loads at a time, got: %d", p)
+	}
+	for _, k := range []string{"a", "b", "d", "f"} {
+		if v, ok := c.Get(k); !ok || v != k+"!" {
+			t.Fatalf("expected %s to be loaded, got: %v, %v", k, v, ok)
+		}
+	}
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("nothing should be stored for the failed keys")
+	}
+	if _, ok := c.Get("e"); ok {
+		t.Fatal("nothing should be stored for the failed keys")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = c.Warmup(ctx, []string{"x", "y"}, func(context.Context, string) (interface{}, time.Duration, error) {
+		return 1, NoExpiration, nil
+	}, 4)
+	if !errors.As(err, &werr) || len(werr.Keys) != 2 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the keys to fail with the context error, got: %v", err)
+	}
+}
+
+func TestCache_GetOrLoad_DistributedLocker(t *testing.T) {
+	locker := newTestLocker()
+	c := New(WithDistributedLocker(locker, 0, -1), WithLockPrefix("test:"), WithCleanupInterval(0))
+	defer c.Close()
+	loader := func(k string) (interface{}, error) {
+		return "new", nil
+	}
+
+	// another process holds the lease, the expired value is served
+	c.Set("a", "old", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	_, _ = locker.TryLock(context.Background(), "test:a", DefaultLockLease)
+	v, loaded, err := c.GetOrLoad("a", loader, NoExpiration)
+	if err != nil || !loaded || v != "old" {
+		t.Fatalf("expected the stale value, got: %v, %v, %v", v, loaded, err)
+	}
+
+	// no stale value, loads without waiting
+	v, loaded, err = c.GetOrLoad("b", loader, NoExpiration)
+	if err != nil || loaded || v != "new" {
+		t.Fatalf("expected the loaded value, got: %v, %v, %v", v, loaded, err)
+	}
+
+	_ = locker.Unlock(context.Background(), "test:a")
+	v, loaded, err = c.GetOrLoad("a", loader, NoExpiration)
+	if err != nil || loaded || v != "new" {
+		t.Fatalf("expected the loaded value, got: %v, %v, %v", v, loaded, err)
+	}
+	if locker.held("test:a") {
+		t.Fatal("expected the lease to be released")
+	}
+
+	// the leases of another cache sharing the locker do not collide
+	_, _ = locker.TryLock(context.Background(), "test:c", DefaultLockLease)
+	other := New(WithDistributedLocker(locker, 0, time.Second), WithLockPrefix("other:"), WithCleanupInterval(0))
+	defer other.Close()
+	start := time.Now()
+	v, loaded, err = other.GetOrLoad("c", loader, NoExpiration)
+	if err != nil || loaded || v != "new" || time.Since(start) >= time.Second {
+		t.Fatalf("expected the loaded value without waiting, got: %v, %v, %v", v, loaded, err)
+	}
+
+	// the registry name is the default prefix
+	reg := NewRegistry()
+	named := New(WithDistributedLocker(locker, 0, -1), WithRegistry(reg, "named:"), WithCleanupInterval(0))
+	defer named.Close()
+	_, _ = locker.TryLock(context.Background(), "named:a", DefaultLockLease)
+	named.Set("a", "old", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if v, loaded, _ = named.GetOrLoad("a", loader, NoExpiration); !loaded || v != "old" {
+		t.Fatalf("expected the stale value, got: %v, %v", v, loaded)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic without a lease prefix")
+		}
+	}()
+	New(WithDistributedLocker(locker, 0, -1))
+}
+
+func TestCache_RangeSorted(t *testing.T) {
+	c := New()
+	defer c.Close()
+	for _, k := range []string{"c", "a", "d", "b"} {
+		c.SetForever(k, k+k)
+	}
+	c.Set("e", "ee", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	var got []string
+	c.RangeSorted(func(k string, v interface{}) bool {
+		got = append(got, v.(string))
+		return k < "c"
+	})
+	if !reflect.DeepEqual(got, []string{"aa", "bb", "cc"}) {
+		t.Fatalf("unexpected values: %v", got)
+	}
+	c.RangeSorted(nil)
+
+	got = got[:0]
+	c.RangeSortedFunc(func(a, b string) bool { return a > b }, func(k string, _ interface{}) bool {
+		got = append(got, k)
+		return true
+	})
+	if !reflect.DeepEqual(got, []string{"d", "c", "b", "a"}) {
+		t.Fatalf("unexpected keys: %v", got)
+	}
+	items := c.Namespace("").ItemsSorted()
+	if len(items) != 4 || items[0] != (KeyValue{"a", "aa"}) || items[3] != (KeyValue{"d", "dd"}) {
+		t.Fatalf("unexpected items: %v", items)
+	}
+}
+
+func TestCache_KeyFilter(t *testing.T) {
+	c := New()
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	f := c.KeyFilter(0)
+	for i := 0; i < 100; i++ {
+		if !f.MayContain(strconv.Itoa(i)) {
+			t.Fatalf("key %d should be in the filter", i)
+		}
+	}
+	if New().KeyFilter(0.01).MayContain("a") {
+		t.Fatal("an empty filter should not contain any key")
+	}
+}
+
+func TestCache_WithNoFinalizer(t *testing.T) {
+	c := New(WithNoFinalizer(), WithCleanupInterval(time.Millisecond))
+	c.Set("a", 1, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if c.Count() != 0 {
+		t.Fatal("expired items should be cleaned up")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Close(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got: %v", err)
+	}
+	c.Set("b", 2, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if c.Count() != 1 {
+		t.Fatal("expired items should not be cleaned up after Close")
+	}
+}
+
+func TestCache_SetWithMeta(t *testing.T) {
+	c := New()
+	defer c.Close()
+	c.SetWithMeta("a", 1, NoExpiration, "trace-1")
+	v, meta, ok := c.GetWithMeta("a")
+	if !ok || v != 1 || meta != "trace-1" {
+		t.Fatalf("unexpected result: %v, %v, %v", v, meta, ok)
+	}
+	c.GetAndRefresh("a", testDefaultExpiration)
+	if _, meta, _ = c.GetWithMeta("a"); meta != "trace-1" {
+		t.Fatalf("the metadata should be kept on refresh, got: %v", meta)
+	}
+	c.Set("a", 2, NoExpiration)
+	if v, meta, ok = c.GetWithMeta("a"); !ok || v != 2 || meta != nil {
+		t.Fatalf("the metadata should be dropped on set, got: %v, %v, %v", v, meta, ok)
+	}
+	if v, meta, ok = c.GetWithMeta("b"); ok || v != nil || meta != nil {
+		t.Fatalf("unexpected result: %v, %v, %v", v, meta, ok)
+	}
+}
+
+func TestCache_WithProfiler(t *testing.T) {
+	var mu sync.Mutex
+	ops := make(map[ProfileOp]int)
+	c := New(WithProfiler(ProfilerFunc(func(op ProfileOp, elapsed time.Duration) {
+		if elapsed < 0 {
+			t.Errorf("unexpected elapsed time: %v", elapsed)
+		}
+		mu.Lock()
+		ops[op]++
+		mu.Unlock()
+	})))
+	defer c.Close()
+
+	c.Set("a", 1, NoExpiration)
+	c.GetOrSet("b", 2, NoExpiration)
+	c.Get("a")
+	c.GetWithTTL("a")
+	c.GetOrCompute("c", func() interface{} { return 3 }, NoExpiration)
+	c.Delete("a")
+	c.DeleteExpired()
+
+	want := map[ProfileOp]int{ProfileSet: 2, ProfileGet: 2, ProfileCompute: 1, ProfileDelete: 1, ProfileCleanup: 1}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("expected %v, got: %v", want, ops)
+	}
+	if ProfileCleanup.String() != "cleanup" || ProfileOp(0).String() != "unknown" {
+		t.Fatal("unexpected profile op names")
+	}
+}
+
+func TestCache_WithMaxEntries(t *testing.T) {
+	var evicted []string
+	c := New(WithMaxEntries(3), WithEvictedCallback(func(k string, _ interface{}) {
+		evicted = append(evicted, k)
+	}))
+	defer c.Close()
+
+	c.SetForever("a", 1)
+	c.SetForever("b", 2)
+	c.SetForever("c", 3)
+	c.Get("a")
+	c.SetForever("d", 4) // evicts b
+	if c.Count() != 3 {
+		t.Fatalf("expected 3 items, got: %d", c.Count())
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should be evicted")
+	}
+	c.Delete("c")
+	c.SetForever("e", 5)
+	c.SetForever("f", 6) // evicts a
+	if !reflect.DeepEqual(evicted, []string{"b", "c", "a"}) {
+		t.Fatalf("unexpected evicted keys: %v", evicted)
+	}
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"d", "e", "f"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	c.Clear()
+	for i := 0; i < 100; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	if c.Count() != 3 {
+		t.Fatalf("expected 3 items, got: %d", c.Count())
+	}
+}
+
+func TestCache_WithMemoryLimit(t *testing.T) {
+	if HeapSampler() == 0 {
+		t.Fatal("expected the heap in use to be sampled")
+	}
+
+	var used uint64
+	clock := NewFakeClock(time.Now())
+	c := New(
+		WithClock(clock),
+		WithMemoryLimit(1000, func() uint64 {
+			return atomic.LoadUint64(&used)
+		}),
+	)
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	// fills the buffer of the reads, applied to the eviction order once full
+	for i := 0; i < readBufferSize; i++ {
+		c.Get("0")
+	}
+	clock.Advance(DefaultMemoryCheckInterval)
+	if n := c.Count(); n != 100 {
+		t.Fatalf("expected no eviction under the limit, got %d items", n)
+	}
+
+	atomic.StoreUint64(&used, 1001)
+	clock.Advance(DefaultMemoryCheckInterval)
+	for i := 0; c.Count() != 90; i++ {
+		if i == 100 {
+			t.Fatalf("expected 10 items to be evicted over the limit, got %d items", c.Count())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := c.Get("0"); !ok {
+		t.Fatal("expected the recently used key to be kept")
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Fatal("expected the least recently used key to be evicted")
+	}
+}
+
+func TestCache_WithEvictionPolicy(t *testing.T) {
+	c := New(WithMaxEntries(2), WithEvictionPolicy(LFU))
+	defer c.Close()
+	c.SetForever("a", 1)
+	c.Get("a")
+	c.Get("a")
+	c.SetForever("b", 2)
+	c.SetForever("c", 3) // evicts b, the least frequently used
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"a", "c"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	c.SetForever("d", 4) // evicts c, the least recently used among the least frequently used
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"a", "d"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	c.Clear()
+	c.SetForever("e", 5)
+	if c.Count() != 1 {
+		t.Fatalf("expected 1 item, got: %d", c.Count())
+	}
+}
+
+func TestCache_WithAdmissionPolicy(t *testing.T) {
+	var evicted []string
+	c := New(
+		WithMaxEntries(2),
+		WithAdmissionPolicy(TinyLFU),
+		WithEvictedCallback(func(k string, _ interface{}) {
+			evicted = append(evicted, k)
+		}),
+	)
+	defer c.Close()
+	c.SetForever("a", 1)
+	c.SetForever("b", 2)
+	for i := 0; i < 3; i++ {
+		c.Get("a")
+		c.Get("b")
+	}
+	// a scan of keys read once does not flush the hot items
+	for i := 0; i < 10; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if len(evicted) != 10 || evicted[0] != "0" {
+		t.Fatalf("expected the new keys to be evicted, got %v", evicted)
+	}
+
+	// a key accessed more often than the victim is admitted
+	for i := 0; i < 5; i++ {
+		c.Get("x")
+	}
+	c.SetForever("x", 0)
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"b", "x"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if AdmitAll.String() != "AdmitAll" || TinyLFU.String() != "TinyLFU" {
+		t.Fatal("unexpected names")
+	}
+}
+
+func TestCache_WithHasher(t *testing.T) {
+	var calls int64
+	// a constant hash puts all the keys in the same bucket chain
+	c := New(WithHasher(func(k string, seed uint64) uint64 {
+		atomic.AddInt64(&calls, 1)
+		return 42
+	}))
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := c.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("expected %d, got %v, %v", i, v, ok)
+		}
+	}
+	if atomic.LoadInt64(&calls) < 200 {
+		t.Fatalf("expected the hasher to be used, got %d calls", calls)
+	}
+
+	s := NewSharded(4, WithHasher(func(k string, seed uint64) uint64 {
+		return uint64(len(k))
+	}))
+	defer s.Close()
+	s.SetForever("ab", 1)
+	if s.Shard("ab") != s.Shards()[2] {
+		t.Fatal("expected the hasher to pick the shard")
+	}
+}
+
+func TestCache_WithMaxCost(t *testing.T) {
+	c := New(WithMaxCost(100))
+	defer c.Close()
+	c.SetWithCost("a", "a", NoExpiration, 40)
+	c.SetWithCost("b", "b", NoExpiration, 40)
+	c.Get("a")
+	c.SetWithCost("c", "c", NoExpiration, 30) // evicts b
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"a", "c"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	// overwriting replaces the cost
+	c.SetWithCost("a", "a", NoExpiration, 10)
+	c.SetWithCost("d", "d", NoExpiration, 60)
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"a", "c", "d"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	c.Set("e", "e", NoExpiration) // costs 1, evicts c
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"a", "d", "e"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	c.SetWithCost("f", "f", NoExpiration, 101)
+	if c.Count() != 0 {
+		t.Fatalf("an item over the maximum cost should evict everything, got: %v", c.Items())
+	}
+}
+
+func TestCache_GetOrLoad_Panic(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		defer func() {
+			_ = recover()
+		}()
+		_, _, _ = c.GetOrLoad("a", func(string) (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		}, NoExpiration)
+	}()
+	<-started
+	go func() {
+		_, _, err := c.GetOrLoad("a", func(string) (interface{}, error) {
+			return 1, nil
+		}, NoExpiration)
+		done <- err
+	}()
+
+	// other keys are not blocked by the running loader
+	c.GetOrCompute("b", func() interface{} { return 2 }, NoExpiration)
+	if v, _, err := c.GetOrLoad("c", func(string) (interface{}, error) { return 3, nil }, NoExpiration); err != nil || v != 3 {
+		t.Fatalf("unexpected result: %v, %v", v, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-done; err != ErrLoaderPanicked {
+		t.Fatalf("expected ErrLoaderPanicked, got: %v", err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("nothing should be stored when the loader panics")
+	}
+}
+
+func TestCache_SaveToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	c := New()
+	defer c.Close()
+	c.SetForever("a", "1")
+	c.Set("b", "2", testDefaultExpiration)
+	if err := c.SaveToFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c2 := New()
+	defer c2.Close()
+	c2.SetForever("a", "0")
+	if err := c2.LoadFromFile(path, KeepExisting); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"a": "0", "b": "2"}
+	if got := c2.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+	if err := c2.LoadFromFile(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got: %v", err)
+	}
+}
+
+type testSnapshotWriter struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *testSnapshotWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestCache_WithSnapshot(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		writers []*testSnapshotWriter
+	)
+	newWriter := func() (io.WriteCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		w := &testSnapshotWriter{}
+		writers = append(writers, w)
+		return w, nil
+	}
+	c := New(WithSnapshot(10*time.Millisecond, newWriter))
+	c.SetForever("a", "1")
+	time.Sleep(35 * time.Millisecond)
+	c.SetForever("b", "2")
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(writers) < 2 {
+		t.Fatalf("expected periodic snapshots, got: %d", len(writers))
+	}
+	last := writers[len(writers)-1]
+	if !last.closed {
+		t.Fatal("the writer should be closed")
+	}
+	c2 := New()
+	if err := c2.LoadFrom(&last.Buffer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"a": "1", "b": "2"}
+	if got := c2.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+
+	errOpen := errors.New("open failed")
+	c3 := New(WithSnapshot(0, func() (io.WriteCloser, error) { return nil, errOpen }))
+	if err := c3.Close(); err != errOpen {
+		t.Fatalf("expected the writer error, got: %v", err)
+	}
+}
+
+func TestCache_WithSlidingExpiration(t *testing.T) {
+	c := New(WithSlidingExpiration(), WithCleanupInterval(0))
+	defer c.Close()
+	c.Set("a", 1, 50*time.Millisecond)
+	c.Set("b", 2, 50*time.Millisecond)
+	c.SetForever("c", 3)
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, ok := c.Get("a"); !ok {
+			t.Fatal("a should not expire while read")
+		}
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should expire when idle")
+	}
+	if _, ttl, ok := c.GetWithTTL("a"); !ok || ttl <= 40*time.Millisecond {
+		t.Fatalf("the lifetime of a should be extended, got: %v, %v", ttl, ok)
+	}
+	if _, ttl, _ := c.GetWithTTL("c"); ttl != NoExpiration {
+		t.Fatalf("c should never expire, got: %v", ttl)
+	}
+
+	c2 := New(WithCleanupInterval(0))
+	defer c2.Close()
+	c2.Set("a", 1, 30*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	c2.Get("a")
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c2.Get("a"); ok {
+		t.Fatal("a should expire without sliding expiration")
+	}
+}
+
+func TestCache_SetWithCallback(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	fn := func(k string, v interface{}) {
+		mu.Lock()
+		calls = append(calls, k+"="+v.(string))
+		mu.Unlock()
+	}
+	c := New(WithCleanupInterval(0), WithMaxEntries(10))
+	defer c.Close()
+
+	c.SetWithCallback("expired", "1", time.Millisecond, fn)
+	c.SetWithCallback("lazy", "2", time.Millisecond, fn)
+	c.SetWithCallback("deleted", "3", NoExpiration, fn)
+	c.SetWithCallback("replaced", "4", NoExpiration, fn)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.Get("lazy"); ok {
+		t.Fatal("lazy should be expired")
+	}
+	c.DeleteExpired()
+	c.Delete("deleted")
+	c.SetForever("replaced", "5")
+	c.Delete("replaced")
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(calls)
+	if want := []string{"deleted=3", "expired=1", "lazy=2"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected %v, got: %v", want, calls)
+	}
+}
+
+func TestCache_EvictedCallbackWithReason(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	fn := func(k string, v interface{}, reason EvictionReason) {
+		mu.Lock()
+		calls = append(calls, k+"="+v.(string)+":"+reason.String())
+		mu.Unlock()
+	}
+	c := New(WithCleanupInterval(0), WithMaxEntries(4), WithEvictedCallbackWithReason(fn))
+	defer c.Close()
+
+	c.Set("expired", "1", time.Millisecond)
+	c.Set("lazy", "2", time.Millisecond)
+	c.SetForever("deleted", "3")
+	c.SetForever("replaced", "4")
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.Get("lazy"); ok {
+		t.Fatal("lazy should be expired")
+	}
+	c.DeleteExpired()
+	c.Delete("deleted")
+	c.SetForever("replaced", "5")
+	c.Compute("replaced", func(interface{}, bool) (interface{}, bool) {
+		return "6", false
+	}, NoExpiration)
+	c.GetAndSet("replaced", "7", NoExpiration)
+	for i := 0; i < 4; i++ {
+		c.SetForever(strconv.Itoa(i), "x")
+	}
+	c.Clear()
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(calls)
+	want := []string{
+		"0=x:cleared", "1=x:cleared", "2=x:cleared", "3=x:cleared",
+		"deleted=3:deleted", "expired=1:expired", "lazy=2:expired",
+		"replaced=4:replaced", "replaced=5:replaced", "replaced=6:replaced",
+		"replaced=7:capacity",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected %v, got: %v", want, calls)
+	}
+	if EvictionReason(0).String() != "unknown" {
+		t.Fatal("the zero reason should be unknown")
+	}
+}
+
+func TestCache_AsyncCallbacks(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		called  int32
+	)
+	c := New(
+		WithCleanupInterval(0),
+		WithAsyncCallbacks(1, 10),
+		WithEvictedCallback(func(k string, v interface{}) {
+			<-release
+			atomic.AddInt32(&called, 1)
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 3; i++ {
+		// does not wait for the blocked callback
+		c.Delete(strconv.Itoa(i))
+	}
+	if n := atomic.LoadInt32(&called); n != 0 {
+		t.Fatalf("expected the callbacks to be blocked, got %d calls", n)
+	}
+	close(release)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&called); n != 3 {
+		t.Fatalf("expected Close to wait for 3 callbacks, got %d", n)
+	}
+
+	// inline once closed
+	c.SetForever("k", 1)
+	c.Delete("k")
+	if n := atomic.LoadInt32(&called); n != 4 {
+		t.Fatalf("expected 4 callbacks, got %d", n)
+	}
+}
+
+func TestCache_CloseContext(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		called  int32
+	)
+	c := New(
+		WithCleanupInterval(0),
+		WithCleanupOnClose(),
+		WithAsyncCallbacks(1, 10),
+		WithEvictedCallback(func(k string, v interface{}) {
+			if k == "blocked" {
+				<-release
+			}
+			atomic.AddInt32(&called, 1)
+		}),
+	)
+	c.SetForever("blocked", 1)
+	c.Delete("blocked")
+	c.Set("expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if err := c.CloseContext(context.Background()); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	close(release)
+	for i := 0; atomic.LoadInt32(&called) != 2; i++ {
+		if i == 100 {
+			t.Fatalf("expected the callbacks of the deleted and expired items, got %d", atomic.LoadInt32(&called))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCache_ClosedMode(t *testing.T) {
+	c := New(WithClosedMode(ClosedIgnore))
+	c.SetForever("a", 1)
+	c.Close()
+	c.SetForever("b", 2)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected the closed cache to miss")
+	}
+	if n := c.Count(); n != 0 {
+		t.Fatalf("expected no items, got %d", n)
+	}
+	if err := c.SaveTo(io.Discard); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	c = New(WithClosedMode(ClosedPanic))
+	c.SetForever("a", 1)
+	c.Close()
+	func() {
+		defer func() {
+			if r := recover(); r != ErrClosed {
+				t.Fatalf("expected a panic with ErrClosed, got %v", r)
+			}
+		}()
+		c.Get("a")
+	}()
+
+	c = New()
+	c.Close()
+	c.SetForever("a", 1)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected the closed cache to keep working, got %v", v)
+	}
+}
+
+func TestCache_GetE(t *testing.T) {
+	errLoad := errors.New("load failed")
+	c := New(WithLoader(func(_ context.Context, k string) (interface{}, time.Duration, error) {
+		if k == "missing" {
+			return nil, 0, errLoad
+		}
+		return k, NoExpiration, nil
+	}))
+	defer c.Close()
+
+	if v, err := c.GetE("a"); err != nil || v != "a" {
+		t.Fatalf("unexpected result: %v, %v", v, err)
+	}
+	if _, err := c.GetE("missing"); !errors.Is(err, errLoad) {
+		t.Fatalf("expected the error of the loader, got %v", err)
+	}
+
+	c = New(WithClosedMode(ClosedIgnore))
+	if _, err := c.GetE("a"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := c.SetE("a", 1, NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetE("a"); err != nil || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, err)
+	}
+	c.Close()
+	if err := c.SetE("a", 2, NoExpiration); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, err := c.GetE("a"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	c = New(WithMaxEntries(1), WithAdmissionPolicy(TinyLFU))
+	defer c.Close()
+	if err := c.SetE("a", 1, NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		c.Get("a")
+	}
+	if err := c.SetE("b", 2, NoExpiration); err != ErrCapacityExceeded {
+		t.Fatalf("expected ErrCapacityExceeded, got %v", err)
+	}
+}
+
+func TestCache_ErrSerialization(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	c.SetForever("a", make(chan int))
+	if err := c.SaveTo(io.Discard); !errors.Is(err, ErrSerialization) {
+		t.Fatalf("expected ErrSerialization, got %v", err)
+	}
+}
+
+func TestCache_WithPanicHandler(t *testing.T) {
+	c := New(WithCleanupInterval(0))
+	defer c.Close()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "valueFn" {
+				t.Fatalf("expected the panic of valueFn, got %v", r)
+			}
+		}()
+		c.GetOrCompute("a", func() interface{} { panic("valueFn") }, NoExpiration)
+	}()
+	// the bucket of a is not left locked
+	c.Set("a", 1, NoExpiration)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+
+	var panics int32
+	c = New(
+		WithCleanupInterval(0),
+		WithEvictedCallback(func(k string, v interface{}) { panic(k) }),
+		WithPanicHandler(func(r interface{}) { atomic.AddInt32(&panics, 1) }),
+	)
+	defer c.Close()
+	if v, ok := c.Compute("a", func(interface{}, bool) (interface{}, bool) { panic("valueFn") }, NoExpiration); ok || v != nil {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should not be stored")
+	}
+	c.Set("b", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	if n := atomic.LoadInt32(&panics); n != 2 {
+		t.Fatalf("expected 2 panics, got %d", n)
+	}
+}
+
+func TestCache_WithClock(t *testing.T) {
+	evicted := make(chan string, 1)
+	clock := NewFakeClock(time.Now())
+	c := New(
+		WithClock(clock),
+		WithCleanupInterval(time.Second),
+		WithEvictedCallback(func(k string, v interface{}) {
+			evicted <- k
+		}),
+	)
+	defer c.Close()
+
+	c.Set("a", 1, time.Minute)
+	clock.Advance(30 * time.Second)
+	if _, ttl, ok := c.GetWithTTL("a"); !ok || ttl != 30*time.Second {
+		t.Fatalf("expected 30s left, got %v", ttl)
+	}
+	clock.Advance(30*time.Second + 1)
+	select {
+	case k := <-evicted:
+		if k != "a" {
+			t.Fatalf("expected a to be evicted, got %s", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the cleanup ticker of the clock to evict the expired item")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected the item to have expired")
+	}
+}
+
+func TestCache_ItemsWhere(t *testing.T) {
+	c := New()
+	defer c.Close()
+	c.SetForever("user:1", 1)
+	c.SetForever("user:2", 2)
+	c.SetForever("order:1", 3)
+	c.Set("user:3", 4, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	items := c.ItemsWhere(func(k string, v interface{}) bool {
+		return v.(int)%2 == 1
+	})
+	if want := map[string]interface{}{"user:1": 1, "order:1": 3}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+	items = c.ItemsWithPrefix("user:")
+	if want := map[string]interface{}{"user:1": 1, "user:2": 2}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+
+	ns := c.Namespace("user:")
+	items = ns.ItemsWhere(func(k string, _ interface{}) bool {
+		return k != "2"
+	})
+	if want := map[string]interface{}{"1": 1}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+	if items = ns.ItemsWithPrefix("2"); !reflect.DeepEqual(items, map[string]interface{}{"2": 2}) {
+		t.Fatalf("unexpected items: %v", items)
+	}
+}
+
+func TestCache_ItemsAtomic(t *testing.T) {
+	const n = 8
+	c := New(WithConsistentSnapshots())
+	defer c.Close()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// each round writes the keys in order, so any point in time sees
+		// the keys before some k at round r and the others at round r-1
+		for r := 1; ; r++ {
+			for k := 0; k < n; k++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c.SetForever(strconv.Itoa(k), r)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		items := c.ItemsAtomic()
+		for k := 1; k < len(items); k++ {
+			prev, cur := items[strconv.Itoa(k-1)].(int), items[strconv.Itoa(k)].(int)
+			if cur > prev || prev > cur+1 {
+				t.Fatalf("torn copy: %v", items)
+			}
+		}
+	}
+	close(stop)
+	<-done
+
+	c.SetForever("x", 1)
+	if items := c.ItemsAtomic(); !reflect.DeepEqual(items, c.Items()) {
+		t.Fatalf("expected the items, got %v", items)
+	}
+	if v, ok := c.Get("x"); !ok || v != 1 {
+		t.Fatalf("expected the writes to resume, got %v, %v", v, ok)
+	}
+	if items := New().ItemsAtomic(); len(items) != 0 {
+		t.Fatalf("expected no items, got %v", items)
+	}
+}
+
+func TestCache_CoarseClock(t *testing.T) {
+	c := New(WithClock(CoarseClock), WithCleanupInterval(0))
+	defer c.Close()
+
+	last := CoarseClock.Now()
+	if d := time.Since(last); d < -10*time.Millisecond || d > 10*time.Millisecond {
+		t.Fatalf("expected the coarse time to be close to the time, %v apart", d)
+	}
+	for i := 0; i < 10; i++ {
+		time.Sleep(time.Millisecond)
+		now := CoarseClock.Now()
+		if now.Before(last) {
+			t.Fatalf("expected the coarse time to never go backwards, %v < %v", now, last)
+		}
+		last = now
+	}
+
+	c.Set("a", 1, 5*time.Millisecond)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected the item to be found")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected the item to have expired")
+	}
+}
+
+// countingClock a SystemClock counting its reads.
+type countingClock struct {
+	reads int64
+}
+
+func (c *countingClock) Now() time.Time {
+	atomic.AddInt64(&c.reads, 1)
+	return time.Now()
+}
+
+func (c *countingClock) NewTicker(d time.Duration) Ticker {
+	return SystemClock.NewTicker(d)
+}
+
+func TestCache_ClockReads(t *testing.T) {
+	clock := &countingClock{}
+	c := New(WithClock(clock), WithCleanupInterval(0))
+	defer c.Close()
+
+	c.SetForever("a", 1)
+	c.SetForever("b", 2)
+	c.Get("a")
+	c.Get("missing")
+	c.GetMultiple([]string{"a", "b", "missing"})
+	c.Delete("b")
+	if n := atomic.LoadInt64(&clock.reads); n != 0 {
+		t.Fatalf("expected no clock read for the items which never expire, got %d", n)
+	}
+
+	c.Set("c", 3, time.Minute)
+	c.GetMultiple([]string{"a", "c", "c"})
+	if n := atomic.LoadInt64(&clock.reads); n != 2 {
+		t.Fatalf("expected a clock read to store c and one to read the items, got %d", n)
+	}
+}
+
+func TestCache_DeleteExpiredIndex(t *testing.T) {
+	c := New(WithCleanupInterval(0))
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		c.SetForever("forever"+strconv.Itoa(i), i)
+	}
+	c.Set("expired", 1, time.Millisecond)
+	c.Set("rewritten", 2, time.Millisecond)
+	c.Set("refreshed", 3, time.Millisecond)
+	c.Set("deleted", 4, time.Millisecond)
+	c.Set("forever", 5, time.Millisecond)
+	c.Set("rewritten", 2, time.Hour)
+	c.GetAndRefresh("refreshed", 50*time.Millisecond)
+	c.Delete("deleted")
+	c.SetForever("forever", 5)
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	if n := c.Count(); n != 103 {
+		t.Fatalf("expected 103 items, got %d", n)
+	}
+	for _, k := range []string{"rewritten", "refreshed", "forever"} {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("%s should not be deleted", k)
+		}
+	}
+
+	// rescheduled when found unexpired
+	time.Sleep(60 * time.Millisecond)
+	c.DeleteExpired()
+	if n := c.Count(); n != 102 {
+		t.Fatalf("expected 102 items, got %d", n)
+	}
+
+	// loaded items are scheduled too
+	c.LoadItemsWithExpiration(map[string]ItemWithExpiration{
+		"loaded": {Value: 6, Expiration: time.Now().Add(time.Millisecond)},
+	})
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	if n := c.Count(); n != 102 {
+		t.Fatalf("expected 102 items, got %d", n)
+	}
+}
+
+func TestCache_ExpiredCount(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := New(WithClock(clock), WithCleanupInterval(time.Hour))
+	defer c.Close()
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+	c.SetForever("c", 3)
+	c.SetForever("d", 4)
+	if n := c.ExpiredCount(); n != 0 {
+		t.Fatalf("expected no expired items, got: %d", n)
+	}
+	clock.Advance(2 * time.Minute)
+	if n, total := c.ExpiredCount(), c.Count(); n != 2 || total != 4 {
+		t.Fatalf("expected 2 expired items out of 4, got: %d, %d", n, total)
+	}
+	if n := c.CountWhere(func(_ string, v interface{}) bool { return v.(int) > 1 }); n != 2 {
+		t.Fatalf("expected 2 unexpired items above 1, got: %d", n)
+	}
+	if n := c.Namespace("x").CountWhere(func(string, interface{}) bool { return true }); n != 0 {
+		t.Fatalf("expected an empty namespace, got: %d", n)
+	}
+	c.DeleteExpired()
+	if n := c.ExpiredCount(); n != 0 {
+		t.Fatalf("expected no expired items after the cleanup, got: %d", n)
+	}
+}
+
+func TestCache_DeleteExpiredN(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := New(WithClock(clock), WithCleanupInterval(time.Hour))
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.Set(strconv.Itoa(i), i, time.Duration(i+1)*time.Second)
+	}
+	c.SetForever("x", 0)
+	clock.Advance(time.Minute)
+	if n := c.DeleteExpiredFor(0); n != 0 {
+		t.Fatalf("expected no items deleted without time, got: %d", n)
+	}
+	if n := c.DeleteExpiredN(3); n != 3 {
+		t.Fatalf("expected 3 items deleted, got: %d", n)
+	}
+	if n := c.ExpiredCount(); n != 7 {
+		t.Fatalf("expected 7 expired items left, got: %d", n)
+	}
+	if n := c.DeleteExpiredN(100); n != 7 {
+		t.Fatalf("expected the 7 items left deleted, got: %d", n)
+	}
+	if n := c.DeleteExpiredFor(time.Hour); n != 0 || c.Count() != 1 {
+		t.Fatalf("expected nothing left to delete, got: %d, %d", n, c.Count())
+	}
+}
+
+func TestCache_PauseCleanup(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := New(WithClock(clock), WithCleanupInterval(time.Minute))
+	defer c.Close()
+
+	// cleaned reports whether the cleanup loop deletes the expired item within a second
+	cleaned := func() bool {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			clock.Advance(time.Minute)
+			if c.ExpiredCount() == 0 {
+				return true
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return false
+	}
+
+	c.PauseCleanup()
+	c.Set("a", 1, time.Second)
+	clock.Advance(time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	if n := c.ExpiredCount(); n != 1 {
+		t.Fatalf("expected the paused loop to leave the expired item, got: %d", n)
+	}
+	c.ResumeCleanup()
+	if !cleaned() {
+		t.Fatal("expected the resumed loop to delete the expired item")
+	}
+
+	c.SetCleanupInterval(0)
+	time.Sleep(20 * time.Millisecond)
+	c.Set("b", 1, time.Second)
+	clock.Advance(time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	if n := c.ExpiredCount(); n != 1 {
+		t.Fatalf("expected the stopped loop to leave the expired item, got: %d", n)
+	}
+	c.SetCleanupInterval(time.Second)
+	if !cleaned() {
+		t.Fatal("expected the restarted loop to delete the expired item")
+	}
+}
+
+func TestCache_WithCleanupHook(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	var reports []CleanupReport
+	c := New(
+		WithClock(clock),
+		WithCleanupInterval(0),
+		WithCleanupHook(func(report CleanupReport) {
+			reports = append(reports, report)
+		}),
+	)
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		c.Set(strconv.Itoa(i), i, time.Second)
+	}
+	c.Set("x", 0, time.Hour)
+	clock.Advance(time.Minute)
+	c.DeleteExpired()
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got: %v", reports)
+	}
+	if r := reports[0]; r.Scanned != 5 || r.Deleted != 5 || r.Duration < r.CallbackDuration {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+	c.DeleteExpiredN(1)
+	if len(reports) != 2 || reports[1].Deleted != 0 {
+		t.Fatalf("expected an empty report, got: %v", reports)
+	}
+}
+
+func TestCache_WithNoCleanupLoop(t *testing.T) {
+	var expired int32
+	c := New(
+		WithNoCleanupLoop(),
+		WithCleanupInterval(time.Millisecond),
+		WithEvictedCallback(func(k string, v interface{}) {
+			atomic.AddInt32(&expired, 1)
+		}),
+	)
+	defer c.Close()
+
+	c.Set("a", 1, time.Millisecond)
+	c.Set("b", 2, time.Millisecond)
+	c.SetForever("c", 3)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&expired); n != 0 {
+		t.Fatalf("expected no cleanup loop, got %d expired items", n)
+	}
+	if n := len(c.Items()); n != 1 {
+		t.Fatalf("expected 1 item, got %d", n)
+	}
+	c.Range(func(k string, v interface{}) bool {
+		if k != "c" {
+			t.Fatalf("unexpected expired item: %s", k)
+		}
+		return true
+	})
+	if n := c.Count(); n != 1 {
+		t.Fatalf("expected 1 item, got %d", n)
+	}
+	if n := atomic.LoadInt32(&expired); n != 2 {
+		t.Fatalf("expected Count to delete 2 expired items, got %d", n)
+	}
+}
+
+func TestCache_GetMultiple(t *testing.T) {
+	c := New(WithCleanupInterval(0))
+	defer c.Close()
+	c.SetForever("a", 1)
+	c.SetForever("b", 2)
+	c.Set("expired", 3, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	items := c.GetMultiple([]string{"a", "b", "expired", "missing"})
+	if want := map[string]interface{}{"a": 1, "b": 2}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+	if c.Count() != 2 {
+		t.Fatal("the expired item should be deleted")
+	}
+	if items = c.GetMultiple(nil); len(items) != 0 {
+		t.Fatalf("expected no items, got: %v", items)
+	}
+}
+
+func TestCache_DeleteFunc(t *testing.T) {
+	var deleted []string
+	c := New(WithCleanupInterval(0), WithEvictedCallback(func(k string, v interface{}) {
+		deleted = append(deleted, k)
+	}))
+	defer c.Close()
+	for _, k := range []string{"t1:a", "t1:b", "t2:a", "t2:b", "t3:a"} {
+		c.SetForever(k, k)
+	}
+	c.Set("t1:expired", 0, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	c.DeleteMultiple([]string{"t3:a", "missing"})
+	n := c.DeleteFunc(func(k string, v interface{}) bool {
+		return strings.HasPrefix(k, "t1:")
+	})
+	if n != 2 {
+		t.Fatalf("expected 2 deleted items, got %d", n)
+	}
+	sort.Strings(deleted)
+	if want := []string{"t1:a", "t1:b", "t3:a"}; !reflect.DeepEqual(deleted, want) {
+		t.Fatalf("expected %v, got: %v", want, deleted)
+	}
+	if keys := c.KeysSorted(); !reflect.DeepEqual(keys, []string{"t2:a", "t2:b"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestCache_Update(t *testing.T) {
+	c := New(WithCleanupInterval(0))
+	defer c.Close()
+
+	c.SetForever("from", 10)
+	err := c.Update(func(tx Txn) error {
+		v, _ := tx.Get("from")
+		tx.Set("from", v.(int)-3, NoExpiration)
+		tx.Set("to", 3, NoExpiration)
+		if v, ok := tx.Get("from"); !ok || v != 7 {
+			t.Fatalf("expected the write of the transaction, got %v", v)
+		}
+		if _, ok := c.Get("to"); ok {
+			t.Fatal("the writes should be buffered")
+		}
+		tx.Delete("missing")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := c.Items(); !reflect.DeepEqual(items, map[string]interface{}{"from": 7, "to": 3}) {
+		t.Fatalf("unexpected items: %v", items)
+	}
+
+	errAbort := errors.New("abort")
+	err = c.Update(func(tx Txn) error {
+		tx.Delete("from")
+		if _, ok := tx.Get("from"); ok {
+			t.Fatal("from should be deleted by the transaction")
+		}
+		tx.Set("to", 10, NoExpiration)
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("expected the error of the transaction, got %v", err)
+	}
+	if items := c.Items(); !reflect.DeepEqual(items, map[string]interface{}{"from": 7, "to": 3}) {
+		t.Fatalf("the aborted transaction should not be applied: %v", items)
+	}
+
+	users := c.Namespace("users:")
+	_ = users.Update(func(tx Txn) error {
+		tx.Set("1", "alice", NoExpiration)
+		return nil
+	})
+	if v, ok := c.Get("users:1"); !ok || v != "alice" {
+		t.Fatalf("expected the prefixed key in the cache, got %v", v)
+	}
+}
+
+func TestCache_SetWithTags(t *testing.T) {
+	var deleted []string
+	c := New(WithCleanupInterval(0), WithEvictedCallback(func(k string, v interface{}) {
+		deleted = append(deleted, k)
+	}))
+	defer c.Close()
+
+	c.SetWithTags("profile:42", 1, NoExpiration, "user:42")
+	c.SetWithTags("orders:42", 2, NoExpiration, "user:42", "orders")
+	c.SetWithTags("orders:43", 3, NoExpiration, "user:43", "orders")
+	c.SetWithTags("rewritten:42", 4, NoExpiration, "user:42")
+	c.SetForever("rewritten:42", 5)
+
+	if n := c.InvalidateTag("user:42"); n != 2 {
+		t.Fatalf("expected 2 invalidated items, got %d", n)
+	}
+	sort.Strings(deleted)
+	if want := []string{"orders:42", "profile:42"}; !reflect.DeepEqual(deleted, want) {
+		t.Fatalf("expected %v, got: %v", want, deleted)
+	}
+	if _, ok := c.Get("rewritten:42"); !ok {
+		t.Fatal("the tags should be dropped when the key is rewritten")
+	}
+	if n := c.InvalidateTag("user:42"); n != 0 {
+		t.Fatalf("expected no invalidated items, got %d", n)
+	}
+	if n := c.InvalidateTag("orders"); n != 1 {
+		t.Fatalf("expected 1 invalidated item, got %d", n)
+	}
+	if c.Count() != 1 {
+		t.Fatalf("expected 1 item, got %d", c.Count())
+	}
+
+	// the index forgets the keys that left the cache
+	c.SetWithTags("a", 1, NoExpiration, "t")
+	c.Delete("a")
+	c.SetWithTags("b", 1, NoExpiration, "t")
+	c.SetWithTags("b", 2, NoExpiration, "t")
+	c.Delete("b")
+	x := c.(*xsyncMapWrapper).tags
+	if len(x.keys) != 0 {
+		t.Fatalf("expected an empty tag index, got: %v", x.keys)
+	}
+}
+
+func TestCache_InvalidateIf(t *testing.T) {
+	var reasons []EvictionReason
+	c := New(WithCleanupInterval(0), WithEvictedCallbackWithReason(func(k string, v interface{}, r EvictionReason) {
+		reasons = append(reasons, r)
+	}))
+	defer c.Close()
+
+	c.SetForever("user:1", 1)
+	c.SetForever("user:2", 2)
+	c.SetForever("order:1", 3)
+	c.InvalidateIf(func(k string) bool {
+		return strings.HasPrefix(k, "user:")
+	})
+	c.SetForever("user:3", 4)
+
+	if _, ok := c.Get("user:1"); ok {
+		t.Fatal("user:1 should be invalidated")
+	}
+	if v, ok := c.Get("user:3"); !ok || v != 4 {
+		t.Fatal("the items written after InvalidateIf should be kept")
+	}
+	if v, ok := c.Get("order:1"); !ok || v != 3 {
+		t.Fatal("order:1 should not be invalidated")
+	}
+	c.Range(func(k string, _ interface{}) bool {
+		if k == "user:2" {
+			t.Fatal("Range should skip the invalidated items")
+		}
+		return true
+	})
+
+	c.DeleteExpired()
+	if c.Count() != 2 {
+		t.Fatalf("expected 2 items, got %d", c.Count())
+	}
+	if want := []EvictionReason{ReasonExpired, ReasonExpired}; !reflect.DeepEqual(reasons, want) {
+		t.Fatalf("expected %v, got: %v", want, reasons)
+	}
+	if x := c.(*xsyncMapWrapper).invalidations; x.pending() != 0 {
+		t.Fatalf("expected no pending invalidations, got %d", x.pending())
+	}
+}
+
+func TestCache_Overflow(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir(), SnapshotGob, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := New(WithMaxEntries(2), WithOverflow(store))
+
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 2, NoExpiration)
+	c.Set("c", 3, NoExpiration)
+	if c.Count() != 2 {
+		t.Fatalf("expected 2 items, got %d", c.Count())
+	}
+
+	// a is reloaded from the disk, which spills b
+	v, ttl, ok := c.GetWithTTL("a")
+	if !ok || v != 1 {
+		t.Fatalf("expected a to be reloaded, got: %v, %v", v, ok)
+	}
+	if ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("expected the remaining lifetime of a, got %v", ttl)
+	}
+	c.(*xsyncMapWrapper).overflow.drain()
+	if _, _, ok, _ := store.Get("a"); ok {
+		t.Fatal("a should be deleted from the store once reloaded")
+	}
+	if _, _, ok, _ := store.Get("b"); !ok {
+		t.Fatal("b should be spilled")
+	}
+
+	// the spilled value is stale once the key is written or deleted
+	c.Delete("b")
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should be deleted")
+	}
+	c.(*xsyncMapWrapper).overflow.drain()
+	if _, _, ok, _ := store.Get("b"); ok {
+		t.Fatal("b should be deleted from the store")
+	}
+
+	_ = c.Close()
+	if _, _, ok, _ := store.Get("c"); ok {
+		t.Fatal("the store should be cleared on Close")
+	}
+}
+
+func TestCache_WriteThrough(t *testing.T) {
+	store := make(map[string]interface{})
+	c := New(WithWriteThrough(func(k string, v interface{}) error {
+		store[k] = v
+		return nil
+	}))
+	defer c.Close()
+
+	c.Set("a", 1, NoExpiration)
+	c.SetWithTags("b", 2, NoExpiration, "t")
+	c.GetOrSet("c", 3, NoExpiration)
+	c.GetOrSet("c", 4, NoExpiration)
+	c.GetAndSet("a", 5, NoExpiration)
+	if want := map[string]interface{}{"a": 5, "b": 2, "c": 3}; !reflect.DeepEqual(store, want) {
+		t.Fatalf("expected %v, got: %v", want, store)
+	}
+}
+
+func TestCache_WriteBehind(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		writes []string
+		fail   = true
+	)
+	c := New(WithWriteBehind(func(k string, v interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if k == "b" && fail {
+			fail = false
+			return errors.New("unavailable")
+		}
+		writes = append(writes, k+"="+strconv.Itoa(v.(int)))
+		return nil
+	}, 10*time.Millisecond))
+
+	for i := 0; i < 10; i++ {
+		c.Set("a", i, NoExpiration)
+	}
+	c.Set("b", 1, NoExpiration)
+	time.Sleep(50 * time.Millisecond)
+	c.Set("c", 1, NoExpiration)
+	_ = c.Close()
+
+	// the burst of writes to a is coalesced, the failed write of b is retried,
+	// and c is flushed on Close
+	sort.Strings(writes)
+	if want := []string{"a=9", "b=1", "c=1"}; !reflect.DeepEqual(writes, want) {
+		t.Fatalf("expected %v, got: %v", want, writes)
+	}
+}
+
+func TestCache_WriteCoalescing(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		writes []string
+	)
+	c := New(WithWriteBehind(func(k string, v interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		writes = append(writes, k+"="+strconv.Itoa(v.(int)))
+		return nil
+	}, 5*time.Millisecond), WithWriteCoalescing(100*time.Millisecond))
+
+	c.Set("a", 1, NoExpiration)
+	time.Sleep(20 * time.Millisecond)
+	c.Set("a", 2, NoExpiration)
+	mu.Lock()
+	n := len(writes)
+	mu.Unlock()
+	if n != 0 {
+		t.Fatalf("expected no writes within the window, got: %d", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	c.Set("b", 1, NoExpiration)
+	_ = c.Close()
+
+	// the writes to a across the flushes within the window are coalesced,
+	// and b is flushed on Close before its window ends
+	if want := []string{"a=2", "b=1"}; !reflect.DeepEqual(writes, want) {
+		t.Fatalf("expected %v, got: %v", want, writes)
+	}
+}
+
+func TestCache_Loader(t *testing.T) {
+	var calls int32
+	c := New(WithLoader(func(_ context.Context, k string) (interface{}, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		if k == "missing" {
+			return nil, 0, errors.New("not found")
+		}
+		time.Sleep(10 * time.Millisecond)
+		return "v:" + k, time.Minute, nil
+	}))
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := c.Get("a"); !ok || v != "v:a" {
+				t.Errorf("unexpected result: %v, %v", v, ok)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected 1 load, got %d", n)
+	}
+	if _, ttl, ok := c.GetWithTTL("a"); !ok || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected a to be stored for 1m, got %v", ttl)
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Fatal("the keys failing to load should be missing")
+	}
+	if c.Count() != 1 {
+		t.Fatalf("expected 1 item, got %d", c.Count())
+	}
+}
+
+func TestCache_StaleWhileRevalidate(t *testing.T) {
+	var version int32
+	c := New(
+		WithCleanupInterval(0),
+		WithLoader(func(_ context.Context, k string) (interface{}, time.Duration, error) {
+			return atomic.AddInt32(&version, 1), 20 * time.Millisecond, nil
+		}),
+		WithStaleWhileRevalidate(time.Hour),
+	)
+	defer c.Close()
+
+	if v, _ := c.Get("a"); v != int32(1) {
+		t.Fatalf("expected 1, got: %v", v)
+	}
+	time.Sleep(30 * time.Millisecond)
+	c.DeleteExpired()
+
+	// the stale value is served while it is reloaded
+	v, e, ok := c.GetWithExpiration("a")
+	if !ok || v != int32(1) || !e.Before(time.Now()) {
+		t.Fatalf("expected the stale value, got: %v, %v, %v", v, e, ok)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := c.Get("a"); v == int32(2) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the stale value should be revalidated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the other methods consider the stale items expired
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.GetOrSet("a", 0, NoExpiration); ok {
+		t.Fatal("GetOrSet should replace the stale value")
+	}
+}
+
+func TestCache_RefreshAhead(t *testing.T) {
+	var version int32
+	c := New(
+		WithLoader(func(_ context.Context, k string) (interface{}, time.Duration, error) {
+			return atomic.AddInt32(&version, 1), 100 * time.Millisecond, nil
+		}),
+		WithRefreshAhead(0.5),
+	)
+	defer c.Close()
+
+	c.Set("a", int32(0), 100*time.Millisecond)
+	c.Set("b", int32(0), NoExpiration)
+	if v, _ := c.Get("a"); v != int32(0) {
+		t.Fatalf("expected no refresh early in the lifetime, got: %v", v)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := c.Get("a"); v != int32(0) {
+		t.Fatalf("expected the current value while it is refreshed, got: %v", v)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		v, ttl, _ := c.GetWithTTL("a")
+		if v == int32(1) && ttl > 50*time.Millisecond {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("a should be refreshed before it expires")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v, _ := c.Get("b"); v != int32(0) {
+		t.Fatalf("the items that never expire should not be refreshed, got: %v", v)
+	}
+	if n := atomic.LoadInt32(&version); n != 1 {
+		t.Fatalf("expected 1 load, got %d", n)
+	}
+}
+
+func TestCache_TTLJitter(t *testing.T) {
+	c := New(WithTTLJitter(0.5))
+	defer c.Close()
+
+	e := time.Now().Add(time.Hour)
+	items := make(map[string]ItemWithExpiration)
+	for i := 0; i < 100; i++ {
+		k := strconv.Itoa(i)
+		c.Set(k, i, time.Hour)
+		items["loaded:"+k] = ItemWithExpiration{Value: i, Expiration: e}
+	}
+	c.SetForever("forever", 1)
+	c.LoadItemsWithExpiration(items)
+
+	ttls := make(map[time.Duration]bool)
+	for k, x := range c.ItemsWithExpiration() {
+		if k == "forever" {
+			if !x.Expiration.IsZero() {
+				t.Fatal("the items that never expire should not be jittered")
+			}
+			continue
+		}
+		ttl := time.Until(x.Expiration)
+		if ttl < 29*time.Minute || ttl > 91*time.Minute {
+			t.Fatalf("expected a lifetime within 1h ±50%%, got %v", ttl)
+		}
+		ttls[ttl.Round(time.Minute)] = true
+	}
+	if len(ttls) < 10 {
+		t.Fatalf("expected spread lifetimes, got %d distinct minutes", len(ttls))
+	}
+}
+
+func TestCache_ExpirePersistTouch(t *testing.T) {
+	c := New(WithDefaultExpiration(time.Hour), WithCleanupInterval(0))
+	defer c.Close()
+
+	c.SetWithMeta("a", 1, time.Millisecond, "meta")
+	if !c.Persist("a") {
+		t.Fatal("a should be found")
+	}
+	time.Sleep(2 * time.Millisecond)
+	v, meta, ok := c.GetWithMeta("a")
+	if !ok || v != 1 || meta != "meta" {
+		t.Fatalf("a should never expire, keeping its value and metadata, got: %v, %v, %v", v, meta, ok)
+	}
+
+	if !c.Touch("a") {
+		t.Fatal("a should be found")
+	}
+	if _, ttl, _ := c.GetWithTTL("a"); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("expected the default expiration, got %v", ttl)
+	}
+
+	if !c.Expire("a", time.Millisecond) {
+		t.Fatal("a should be found")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should be expired")
+	}
+	if c.Expire("a", time.Hour) || c.Persist("missing") || c.Touch("missing") {
+		t.Fatal("the missing keys should not be found")
+	}
+}
+
+func TestCache_SetExpirationFunc(t *testing.T) {
+	c := New(WithCleanupInterval(0))
+	defer c.Close()
+
+	c.SetForever("a", 1)
+	c.SetForever("b", 2)
+	c.Set("c", 3, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if n := c.SetExpirationForAll(time.Hour); n != 2 {
+		t.Fatalf("expected 2 items updated, got %d", n)
+	}
+	if _, ttl, _ := c.GetWithTTL("a"); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("expected an hour, got %v", ttl)
+	}
+
+	n := c.SetExpirationFunc(func(k string, v interface{}) time.Duration {
+		if v.(int) == 1 {
+			return NoExpiration
+		}
+		return time.Minute
+	})
+	if n != 2 {
+		t.Fatalf("expected 2 items updated, got %d", n)
+	}
+	if _, ttl, _ := c.GetWithTTL("a"); ttl != NoExpiration {
+		t.Fatalf("a should never expire, got %v", ttl)
+	}
+	if _, ttl, _ := c.GetWithTTL("b"); ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("expected a minute, got %v", ttl)
+	}
+
+	ns := c.Namespace("ns:")
+	ns.SetForever("a", 1)
+	if n := ns.SetExpirationForAll(time.Second); n != 1 {
+		t.Fatalf("expected 1 item of the namespace updated, got %d", n)
+	}
+	if _, ttl, _ := c.GetWithTTL("a"); ttl != NoExpiration {
+		t.Fatalf("the items outside the namespace should not be updated, got %v", ttl)
+	}
+}
+
+func TestCache_CompareAndSwap(t *testing.T) {
+	c := New(WithCleanupInterval(0))
+	defer c.Close()
+
+	if c.CompareAndSwap("a", 1, 2, NoExpiration) || c.CompareAndDelete("a", 1) {
+		t.Fatal("the missing key should not be swapped or deleted")
+	}
+	c.Set("a", 1, time.Minute)
+	if c.CompareAndSwap("a", 2, 3, NoExpiration) {
+		t.Fatal("a should not be swapped")
+	}
+	if !c.CompareAndSwap("a", 1, 2, NoExpiration) {
+		t.Fatal("a should be swapped")
+	}
+	if v, ttl, _ := c.GetWithTTL("a"); v != 2 || ttl != NoExpiration {
+		t.Fatalf("unexpected result: %v, %v", v, ttl)
+	}
+	if c.CompareAndDelete("a", 1) || !c.CompareAndDelete("a", 2) {
+		t.Fatal("a should be deleted once")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should be deleted")
+	}
+
+	c.Set("b", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if c.CompareAndSwap("b", 1, 2, NoExpiration) {
+		t.Fatal("the expired key should not be swapped")
+	}
+}
+
+func TestCache_SetIfVersion(t *testing.T) {
+	c := New(WithCleanupInterval(0))
+	defer c.Close()
+
+	if _, version, ok := c.GetWithVersion("a"); ok || version != 0 {
+		t.Fatalf("unexpected result: %v, %v", version, ok)
+	}
+	if c.SetIfVersion("a", 1, 1, NoExpiration) {
+		t.Fatal("the missing key should not be stored at a version")
+	}
+	if !c.SetIfVersion("a", 1, 0, NoExpiration) || c.SetIfVersion("a", 2, 0, NoExpiration) {
+		t.Fatal("a should be stored once at the version 0")
+	}
+	v, version, ok := c.GetWithVersion("a")
+	if !ok || v != 1 || version == 0 {
+		t.Fatalf("unexpected result: %v, %v, %v", v, version, ok)
+	}
+	c.Expire("a", time.Minute)
+	if _, expired, _ := c.GetWithVersion("a"); expired != version {
+		t.Fatalf("the version should not change on Expire: %v, %v", expired, version)
+	}
+
+	// a concurrent write
+	c.Set("a", 2, NoExpiration)
+	if c.SetIfVersion("a", 3, version, NoExpiration) {
+		t.Fatal("a should not be stored at a stale version")
+	}
+	_, version, _ = c.GetWithVersion("a")
+	if !c.SetIfVersion("a", 3, version, NoExpiration) {
+		t.Fatal("a should be stored at its version")
+	}
+	if v, next, _ := c.GetWithVersion("a"); v != 3 || next <= version {
+		t.Fatalf("unexpected result: %v, %v", v, next)
+	}
+
+	// the versions are not reused by a deleted key
+	c.Delete("a")
+	c.Set("a", 1, NoExpiration)
+	if _, next, _ := c.GetWithVersion("a"); next <= version {
+		t.Fatalf("the version should not be reused: %v, %v", next, version)
+	}
+
+	c.Set("b", 1, time.Millisecond)
+	_, version, _ = c.GetWithVersion("b")
+	time.Sleep(2 * time.Millisecond)
+	if c.SetIfVersion("b", 2, version, NoExpiration) {
+		t.Fatal("the expired key should not be stored at a version")
+	}
+}
+
+func TestCache_SetIfAbsentPresent(t *testing.T) {
+	c := New(WithCleanupInterval(0))
+	defer c.Close()
+
+	if c.SetIfPresent("a", 1, NoExpiration) {
+		t.Fatal("a should not be stored")
+	}
+	if !c.SetIfAbsent("a", 1, time.Millisecond) || c.SetIfAbsent("a", 2, NoExpiration) {
+		t.Fatal("a should be stored once")
+	}
+	if v, _ := c.Get("a"); v != 1 {
+		t.Fatalf("expected 1, got %v", v)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if c.SetIfPresent("a", 2, NoExpiration) {
+		t.Fatal("the expired a should not be replaced")
+	}
+	if !c.SetIfAbsent("a", 3, NoExpiration) || !c.SetIfPresent("a", 4, NoExpiration) {
+		t.Fatal("a should be stored")
+	}
+	if v, _ := c.Get("a"); v != 4 {
+		t.Fatalf("expected 4, got %v", v)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	c := New(WithDefaultExpiration(time.Hour))
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = Increment(c, "n", 2)
+		}()
+	}
+	wg.Wait()
+	if v, err := Decrement(c, "n", 1); err != nil || v != 199 {
+		t.Fatalf("expected 199, got %v, %v", v, err)
+	}
+
+	c.Set("f", 1.5, time.Minute)
+	if v, err := Increment(c, "f", 1.0); err != nil || v != 2.5 {
+		t.Fatalf("expected 2.5, got %v, %v", v, err)
+	}
+	if _, ttl, _ := c.GetWithTTL("f"); ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("the expiration time should be kept, got %v", ttl)
+	}
+	if _, err := Increment(c, "f", int64(1)); err != ErrNotNumber {
+		t.Fatalf("expected ErrNotNumber, got %v", err)
+	}
+	if v, _ := c.Get("f"); v != 2.5 {
+		t.Fatalf("the value should be unchanged, got %v", v)
+	}
+
+	ns := c.Namespace("ns:")
+	if v, err := Increment(ns, "n", uint8(3)); err != nil || v != 3 {
+		t.Fatalf("expected 3, got %v, %v", v, err)
+	}
+	if _, ttl, _ := ns.GetWithTTL("n"); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("expected the default expiration, got %v", ttl)
+	}
+}
+
+func TestAppendSliceMergeMap(t *testing.T) {
+	c := New()
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _ = AppendSlice(c, "s", i)
+			_, _ = MergeMap(c, "m", map[int]bool{i: true})
+		}(i)
+	}
+	wg.Wait()
+	s, err := AppendSlice[int](c, "s")
+	if err != nil || len(s) != 100 {
+		t.Fatalf("expected 100 elements, got %d, %v", len(s), err)
+	}
+	m, err := MergeMap(c, "m", map[int]bool{0: false})
+	if err != nil || len(m) != 100 || m[0] || !m[1] {
+		t.Fatalf("unexpected map: %v, %v", m, err)
+	}
+
+	c.Set("x", "x", time.Minute)
+	if _, err := AppendSlice(c, "x", "y"); err != ErrValueType {
+		t.Fatalf("expected ErrValueType, got %v", err)
+	}
+	if _, err := MergeMap(c, "s", map[int]bool{}); err != ErrValueType {
+		t.Fatalf("expected ErrValueType, got %v", err)
+	}
+}
@@ -4,6 +4,8 @@
 package cache
 
 import (
+	"context"
+	"io"
 	"time"
 )
 
@@ -15,6 +17,12 @@ type CacheOf[K comparable, V any] interface {
 	// which means never expires.
 	Set(k K, v V, d time.Duration)
 
+	// SetE add item to the cache like Set, but returns ErrClosed once closed in ClosedIgnore mode,
+	// or ErrCapacityExceeded if the item was evicted as soon as it was stored,
+	// e.g. costing more than the maximum cost or rejected by the admission policy.
+	// It returns the error of the WithWriteThroughOf function as is, and the item is not stored then.
+	SetE(k K, v V, d time.Duration) error
+
 	// SetDefault add item to the cache with the default expiration time,
 	// replacing any existing items.
 	SetDefault(k K, v V)
@@ -22,43 +30,174 @@ type CacheOf[K comparable, V any] interface {
 	// SetForever add item to cache and set to never expire, replacing any existing items.
 	SetForever(k K, v V)
 
+	// SetWithMeta add item to the cache along with the opaque metadata meta,
+	// e.g. its source, trace ID or cost, replacing any existing items.
+	// The metadata is dropped when the key is written by other methods, except GetAndRefresh,
+	// and it is not included in snapshots.
+	SetWithMeta(k K, v V, d time.Duration, meta any)
+
+	// SetWithCost add item to the cache with the given cost, e.g. its size in bytes,
+	// replacing any existing items. Items stored by other methods cost 1.
+	// Items are evicted when the total cost exceeds the maximum cost, see WithMaxCostOf.
+	// An item costing more than the maximum cost evicts all the items, itself included.
+	SetWithCost(k K, v V, d time.Duration, cost int64)
+
+	// SetWithCallback add item to the cache along with its own callback fn,
+	// e.g. closing a connection held in the value, replacing any existing items.
+	// fn is called, besides the evicted callback, when the item expires, is deleted or is evicted,
+	// but not when it is replaced. It is dropped when the key is written by other methods,
+	// except GetAndRefresh, and it is not included in snapshots.
+	SetWithCallback(k K, v V, d time.Duration, fn EvictedCallbackOf[K, V])
+
+	// SetWithTags add item to the cache along with tags, e.g. "user:42", replacing any existing items,
+	// so that the items sharing a tag can be deleted at once by InvalidateTag.
+	// The tags are dropped when the key is written by other methods, except GetAndRefresh,
+	// and they are not included in snapshots.
+	SetWithTags(k K, v V, d time.Duration, tags ...string)
+
 	// Get an item from the cache.
 	// Returns the item or nil,
 	// and a boolean indicating whether the key was found.
+	// With the default options, a hit or a miss does not allocate,
+	// see the cachetest package to check it with other options.
 	Get(k K) (value V, ok bool)
 
+	// GetE get an item from the cache like Get, but returns an error instead of a boolean:
+	// ErrNotFound if the key is missing or expired, the error of the loader if it failed
+	// to load the key, see WithLoaderOf, or ErrClosed once closed in ClosedIgnore mode.
+	GetE(k K) (value V, err error)
+
 	// GetWithExpiration get an item from the cache.
 	// Returns the item or nil,
 	// along with the expiration time, and a boolean indicating whether the key was found.
 	GetWithExpiration(k K) (value V, expiration time.Time, ok bool)
 
+	// GetWithExpirationNano get an item from the cache.
+	// Returns the item or nil, along with the expiration time in Unix nanoseconds, 0 if it never
+	// expires, and a boolean indicating whether the key was found.
+	// Unlike GetWithExpiration, it does not build a time.Time, for the hot paths.
+	GetWithExpirationNano(k K) (value V, expiration int64, ok bool)
+
 	// GetWithTTL get an item from the cache.
 	// Returns the item or nil,
 	// with the remaining lifetime and a boolean indicating whether the key was found.
 	GetWithTTL(k K) (value V, ttl time.Duration, ok bool)
 
+	// GetWithMeta get an item from the cache.
+	// Returns the item or nil,
+	// with the metadata set by SetWithMeta and a boolean indicating whether the key was found.
+	GetWithMeta(k K) (value V, meta any, ok bool)
+
+	// GetWithVersion get an item from the cache.
+	// Returns the item or the zero value, with its version and a boolean indicating whether the key was found.
+	// The version changes on every write of the value of the key, never on a read or an expiration change,
+	// and is never 0 for a key found, see SetIfVersion.
+	// The version is assigned by the first GetWithVersion since the write, so that the writes do not pay for it.
+	GetWithVersion(k K) (value V, version uint64, ok bool)
+
+	// Peek get an item from the cache like Get, without any side effect: a missing key is not loaded,
+	// the expiration is neither slid nor refreshed, and the stats, the events, the hot keys
+	// and the eviction policy do not see the read. An expired item is reported missing, but not deleted.
+	Peek(k K) (value V, ok bool)
+
+	// PeekWithExpiration get an item from the cache like GetWithExpiration, without any side effect, see Peek.
+	PeekWithExpiration(k K) (value V, expiration time.Time, ok bool)
+
+	// GetMultiple get the items of the keys from the cache, in one call.
+	// Returns the items of the keys found, the missing and expired keys are left out.
+	// The clock is read at most once for all the keys.
+	GetMultiple(keys []K) map[K]V
+
+	// SetIfAbsent adds the item to the cache only if the key is missing or expired,
+	// see Set for d, and reports whether it was stored.
+	SetIfAbsent(k K, v V, d time.Duration) bool
+
+	// SetIfPresent replaces the value of the key only if it holds an unexpired item,
+	// see Set for d, and reports whether it was stored.
+	SetIfPresent(k K, v V, d time.Duration) bool
+
 	// GetOrSet returns the existing value for the key if present.
 	// Otherwise, it stores and returns the given value.
 	// The loaded result is true if the value was loaded, false if stored.
+	// The existing item is checked for its expiration at the instant the value would be stored,
+	// under the lock of the key, so an item expiring meanwhile is never returned.
 	GetOrSet(k K, v V, d time.Duration) (value V, loaded bool)
 
 	// GetAndSet returns the existing value for the key if present,
 	// while setting the new value for the key.
 	// Otherwise, it stores and returns the given value.
 	// The loaded result is true if the value was loaded, false otherwise.
+	// Like with GetOrSet, an item expiring before the new value is stored is never returned.
 	GetAndSet(k K, v V, d time.Duration) (value V, loaded bool)
 
 	// GetAndRefresh Get an item from the cache, and refresh the item's expiration time.
-	// Returns the item or nil,
+	// Returns the item or the zero value,
 	// and a boolean indicating whether the key was found.
 	GetAndRefresh(k K, d time.Duration) (value V, loaded bool)
 
+	// RefreshOnly refreshes the expiration time of the key to d from now, like GetAndRefresh,
+	// but does not return its value, which saves copying a large value. It is the same as Expire.
+	// Returns false if the key was not found.
+	RefreshOnly(k K, d time.Duration) bool
+
+	// Expire sets the expiration time of the key to d from now, see Set for d,
+	// keeping its value, metadata, callback and tags.
+	// Returns false if the key was not found.
+	Expire(k K, d time.Duration) bool
+
+	// Persist makes the key never expire, keeping its value, metadata, callback and tags.
+	// Returns false if the key was not found.
+	Persist(k K) bool
+
+	// Touch resets the expiration time of the key to the default expiration time from now,
+	// keeping its value, metadata, callback and tags.
+	// Returns false if the key was not found.
+	Touch(k K) bool
+
+	// SetExpirationForAll sets the expiration time of all the unexpired items to d from now,
+	// see Set for d, and returns the number of items updated.
+	SetExpirationForAll(d time.Duration) int
+
+	// SetExpirationFunc sets the expiration time of each unexpired item to the duration returned
+	// by f from now, see Set for the duration, e.g. after LoadItemsWithExpiration to apply
+	// the policy of another environment, and returns the number of items updated.
+	// f is called for each item while its key is locked. f must not call the cache.
+	SetExpirationFunc(f func(k K, v V) time.Duration) int
+
 	// GetOrCompute returns the existing value for the key if present.
 	// Otherwise, it computes the value using the provided function and
 	// returns the computed value. The loaded result is true if the value
 	// was loaded, false if stored.
+	// valueFn runs while the bucket of the key is locked, blocking the keys sharing it,
+	// see GetOrComputeUnlocked for the slow computations.
 	GetOrCompute(k K, valueFn func() V, d time.Duration) (V, bool)
 
+	// GetOrComputeUnlocked returns the existing value for the key if present, like GetOrCompute,
+	// but valueFn runs without locking the bucket of the key, once for concurrent callers
+	// with the same key, so that a slow computation does not block the other keys.
+	// A value stored meanwhile by another method is kept and returned, loaded is true.
+	// If valueFn panics, the waiting callers get the zero value.
+	GetOrComputeUnlocked(k K, valueFn func() V, d time.Duration) (V, bool)
+
+	// GetOrLoad returns the existing value for the key if present.
+	// Otherwise, it calls loader once for concurrent callers with the same key,
+	// stores the value and returns it to all of them.
+	// The loaded result is true if the value was loaded, false if stored.
+	// If the loader returns an error, nothing is stored and the error is returned.
+	// Unlike GetOrCompute, the loader runs without locking the bucket of the key, so other keys
+	// are not blocked while it runs. If the loader panics, the waiting callers get ErrLoaderPanicked.
+	// With a distributed locker (see WithDistributedLockerOf), only the process holding the lease
+	// runs the loader, the others serve the expired value if it has not been deleted yet
+	// (loaded is true), or wait for the lease before running the loader.
+	// The leases are only held while loading, a process acquiring one afterwards runs the loader too.
+	GetOrLoad(k K, loader func(k K) (V, error), d time.Duration) (value V, loaded bool, err error)
+
+	// Warmup loads the keys with loader, at most parallelism at a time, and stores their values
+	// for the durations returned by loader, e.g. to prime the cache before serving traffic.
+	// Returns a *WarmupErrorOf listing the keys which failed to load: the keys left once ctx is done
+	// fail with its error, and those whose loader panicked with ErrLoaderPanicked.
+	Warmup(ctx context.Context, keys []K, loader LoaderOf[K, V], parallelism int) error
+
 	// Compute either sets the computed new value for the key or deletes
 	// the value for the key. When the delete result of the valueFn function
 	// is set to true, the value will be deleted, if it exists. When delete
@@ -72,6 +211,45 @@ type CacheOf[K comparable, V any] interface {
 		d time.Duration,
 	) (V, bool)
 
+	// ComputeWithOp either sets the computed new value for the key, deletes
+	// the value for the key, or does nothing, depending on the op result
+	// of the valueFn function. With UpdateOp, the value is updated to the
+	// newValue. With DeleteOp, the value is deleted, if it exists. With
+	// CancelOp, the cache is left unchanged: neither the value nor its
+	// expiration, and no callback is called.
+	// The ok result indicates whether the key is present in the cache after
+	// the call. The actual result contains the new value in cases where
+	// the value was computed and stored, or the unchanged value when
+	// an existing value is kept by CancelOp. See Compute.
+	ComputeWithOp(
+		k K,
+		valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
+		d time.Duration,
+	) (V, bool)
+
+	// CompareAndSwap replaces the value of the key by new, stored for d, see Set for d,
+	// if its current value is equal to old, and reports whether it was swapped.
+	// The values must be of a comparable type, like with sync.Map, use CompareAndSwapFunc otherwise.
+	CompareAndSwap(k K, old, new V, d time.Duration) (swapped bool)
+
+	// CompareAndDelete deletes the item of the key if its current value is equal to old,
+	// and reports whether it was deleted.
+	// The values must be of a comparable type, like with sync.Map, use CompareAndDeleteFunc otherwise.
+	CompareAndDelete(k K, old V) (deleted bool)
+
+	// CompareAndSwapFunc is CompareAndSwap for the values of any type: equal reports whether
+	// the current value of the key is equal to old. equal runs while the key is locked.
+	CompareAndSwapFunc(k K, old, new V, equal func(current, old V) bool, d time.Duration) (swapped bool)
+
+	// CompareAndDeleteFunc is CompareAndDelete for the values of any type, see CompareAndSwapFunc.
+	CompareAndDeleteFunc(k K, old V, equal func(current, old V) bool) (deleted bool)
+
+	// SetIfVersion replaces the value of the key by v, stored for d, see Set for d,
+	// only if its unexpired item is at the version returned by GetWithVersion,
+	// and reports whether it was stored.
+	// The version 0 stores the value only if the key is missing or expired, like SetIfAbsent.
+	SetIfVersion(k K, v V, version uint64, d time.Duration) bool
+
 	// GetAndDelete Get an item from the cache, and delete the key.
 	// Returns the item or nil,
 	// and a boolean indicating whether the key was found.
@@ -81,24 +259,146 @@ type CacheOf[K comparable, V any] interface {
 	// Does nothing if the key is not in the cache.
 	Delete(k K)
 
+	// DeleteMultiple deletes the items of the keys from the cache.
+	// The missing keys are ignored.
+	DeleteMultiple(keys []K)
+
+	// Update runs fn with a transaction buffering its writes, and applies them once fn returns nil,
+	// none if fn returns an error, which is returned. The writes are applied in the order of the
+	// first write of each key, each key atomically, and the transactions one at a time,
+	// but the other reads and writes may observe a transaction partially applied.
+	Update(fn func(tx TxnOf[K, V]) error) error
+
+	// DeleteFunc deletes the unexpired items for which f returns true, e.g. all the keys
+	// of a tenant, and returns the number of items deleted.
+	// f is called for each item while its key is locked, so it is deleted only if f still
+	// matches its current value. f must not call the cache.
+	DeleteFunc(f func(k K, v V) bool) int
+
+	// InvalidateTag deletes the items stored with the tag by SetWithTags,
+	// and returns the number of items deleted.
+	InvalidateTag(tag string) int
+
+	// InvalidateIf invalidates the items whose key matches f, in O(1).
+	// The invalidated items are handled like expired ones: they are no longer returned,
+	// and they are deleted when read or by DeleteExpired, being reported as expired.
+	// The items written after the call are not invalidated.
+	// f must be safe for concurrent use, it is called while reading until DeleteExpired has run.
+	InvalidateIf(f func(k K) bool)
+
 	// DeleteExpired delete all expired items from the cache.
 	DeleteExpired()
 
+	// DeleteExpiredN deletes up to maxItems expired items from the cache, like DeleteExpired,
+	// and returns the number of items deleted, e.g. to bound each pass of a manual cleanup.
+	// The expired items left are deleted by the next passes.
+	DeleteExpiredN(maxItems int) int
+
+	// DeleteExpiredFor deletes expired items from the cache for at most maxDuration, like DeleteExpired,
+	// and returns the number of items deleted, e.g. to bound each pass of a manual cleanup.
+	// The expired items left are deleted by the next passes.
+	DeleteExpiredFor(maxDuration time.Duration) int
+
 	// Range calls f sequentially for each key and value present in the map.
 	// If f returns false, range stops the iteration.
 	Range(f func(k K, v V) bool)
 
+	// RangeCursor calls f sequentially for the keys and values present in the cache,
+	// starting from the cursor, and returns the cursor to resume the iteration from.
+	// Start with cursor 0, the iteration is complete when the returned cursor is 0.
+	// Like with the Redis SCAN, the cursor is a position in the hash order of the keys, and each
+	// call examines up to count keys (DefaultScanCount if count is less than 1), or the keys of
+	// one position if more, and calls f for the unexpired ones, whatever the size of the cache.
+	// Keys present for the whole iteration are visited exactly once, even if the cache grows or shrinks
+	// meanwhile, keys added or deleted during the iteration may or may not be visited.
+	// The cursor is a plain value that can be handed out and resumed in a later request,
+	// but it is only valid for this cache instance.
+	RangeCursor(cursor uint64, count int, f func(k K, v V)) (next uint64)
+
+	// ScanItems returns the items present in the cache, starting from the cursor,
+	// and the cursor to resume the iteration from, like RangeCursor,
+	// e.g. to export a large cache page by page.
+	ScanItems(cursor uint64, count int) (items []KeyValueOf[K, V], next uint64)
+
+	// KeyFilter builds a Bloom filter of the unexpired keys in the cache, with the false positive
+	// rate p (DefaultFalsePositiveRate if not in (0, 1)), e.g. to check on edge nodes whether
+	// the cache probably has a key before making a network hop.
+	// The keys are added formatted with fmt.Sprint.
+	KeyFilter(p float64) *BloomFilter
+
 	// Items return the items in the cache.
 	// This is a snapshot, which may include items that are about to expire.
 	Items() map[K]V
 
+	// ItemsAtomic return the items in the cache as of a single point in time,
+	// the writes being blocked while they are copied, see WithConsistentSnapshotsOf.
+	// Without WithConsistentSnapshotsOf, it is equivalent to Items.
+	ItemsAtomic() map[K]V
+
+	// ItemsWhere returns the items in the cache for which f returns true, in one pass over
+	// the cache, without copying the other items. f is called with the cached values.
+	// This is a snapshot, which may include items that are about to expire.
+	ItemsWhere(f func(k K, v V) bool) map[K]V
+
+	// ItemsWithExpiration return the unexpired items in the cache along with their expiration time.
+	// This is a snapshot, which may include items that are about to expire.
+	ItemsWithExpiration() map[K]ItemWithExpirationOf[V]
+
+	// LoadItemsWithExpiration stores the unexpired items with their expiration time.
+	// The optional strategy decides which item is kept when a key is already present,
+	// the default is Overwrite.
+	LoadItemsWithExpiration(items map[K]ItemWithExpirationOf[V], strategy ...LoadStrategy)
+
+	// SaveTo writes a snapshot of the unexpired items in the cache to w.
+	// The snapshot starts with a versioned header (see SnapshotHeader),
+	// followed by the items encoded as JSON, or as set by WithSnapshotFormatOf.
+	SaveTo(w io.Writer) error
+
+	// LoadFrom reads a snapshot written by SaveTo from r, and stores its unexpired items
+	// in the cache with their original expiration time.
+	// The snapshot is fully verified before any item is stored, an unsupported version,
+	// mismatched key/value types or a bad checksum returns an error wrapping
+	// ErrSnapshotVersion, ErrSnapshotType or ErrSnapshotChecksum.
+	// The optional strategy decides which item is kept when a key is already present,
+	// the default is Overwrite.
+	LoadFrom(r io.Reader, strategy ...LoadStrategy) error
+
+	// SaveToFile writes a snapshot of the unexpired items in the cache to the file at path, see SaveTo.
+	// The snapshot is written to a temporary file renamed to path once complete,
+	// so path always holds a complete snapshot.
+	SaveToFile(path string) error
+
+	// LoadFromFile reads a snapshot written by SaveToFile or SaveTo from the file at path,
+	// see LoadFrom.
+	LoadFromFile(path string, strategy ...LoadStrategy) error
+
+	// ExportGzip writes the unexpired items in the cache to w, compressed with gzip, in chunks
+	// of ExportChunkSize items encoded like the snapshots of SaveTo, so that large caches
+	// are streamed instead of being buffered in memory.
+	ExportGzip(w io.Writer) error
+
+	// ImportGzip reads the items written by ExportGzip from r, chunk by chunk, and stores the
+	// unexpired items like LoadFrom. Each chunk is verified before its items are stored,
+	// so on error the items of the previous chunks have been stored.
+	ImportGzip(r io.Reader, strategy ...LoadStrategy) error
+
 	// Clear deletes all keys and values currently stored in the map.
 	Clear()
 
 	// Count returns the number of items in the cache.
-	// This may include items that have expired but have not been cleaned up.
+	// This may include items that have expired but have not been cleaned up,
+	// unless the cleanup loop is disabled by WithNoCleanupLoopOf.
 	Count() int
 
+	// CountWhere returns the number of unexpired items in the cache for which f returns true.
+	// f is called with the cached values.
+	CountWhere(f func(k K, v V) bool) int
+
+	// ExpiredCount returns the number of items in the cache that have expired but have not been
+	// cleaned up yet, in one pass over the cache without copying the items, e.g. to tell the live
+	// items from those awaiting the cleanup in Count.
+	ExpiredCount() int
+
 	// DefaultExpiration returns the default expiration time for the cache.
 	DefaultExpiration() time.Duration
 
@@ -106,6 +406,17 @@ type CacheOf[K comparable, V any] interface {
 	// Atomic safety.
 	SetDefaultExpiration(defaultExpiration time.Duration)
 
+	// PauseCleanup pauses the cleanup loop until ResumeCleanup, e.g. during a latency-critical phase.
+	// The expired items are still deleted when read, and by DeleteExpired.
+	PauseCleanup()
+
+	// ResumeCleanup resumes the cleanup loop paused by PauseCleanup.
+	ResumeCleanup()
+
+	// SetCleanupInterval sets the interval of the cleanup loop, a non-positive interval stops it
+	// until the next call. The loop applies it asynchronously. It has no effect if the cleanup loop is disabled by WithNoCleanupLoopOf.
+	SetCleanupInterval(interval time.Duration)
+
 	// EvictedCallback returns the callback function to execute
 	// when a key-value pair expires and is evicted.
 	EvictedCallback() EvictedCallbackOf[K, V]
@@ -114,6 +425,37 @@ type CacheOf[K comparable, V any] interface {
 	// when the key-value pair expires and is evicted.
 	// Atomic safety.
 	SetEvictedCallback(evictedCallback EvictedCallbackOf[K, V])
+
+	// RecentEvents returns the recent operations on the cache, oldest first.
+	// Returns nil if the event history is not enabled, see WithEventHistoryOf.
+	RecentEvents() []EventOf[K]
+
+	// Diagnostics returns a histogram of the remaining TTLs of the items, and a forecast
+	// of the number of items expiring in each of the next intervals cleanup intervals,
+	// to help size the cleanup interval and the default expiration. It walks the whole cache.
+	Diagnostics(intervals int) Diagnostics
+
+	// HotKeys returns up to n of the most read keys with their estimated number of reads,
+	// the most read first, all the tracked keys if n is less than 1.
+	// The reads are counted by a count-min sketch halved periodically, so the counts
+	// are approximate and favor the recent reads. Returns nil if not enabled, see WithHotKeysOf.
+	HotKeys(n int) []KeyStatOf[K]
+
+	// ShadowStats returns the simulated hit rate of each shadow policy, e.g.
+	// "LRU@100000 entries would have hit 91.00%".
+	// Returns nil if no shadow is configured, see WithShadowOf.
+	ShadowStats() []ShadowStats
+
+	// Close stops the background goroutines, waits for the queued evicted callbacks,
+	// flushes the write-behind writes, and saves a snapshot if a persistence path
+	// or a snapshot writer is configured, after deleting the expired items if WithCleanupOnCloseOf is set.
+	// The cache can still be used after Close, but expired items are no longer deleted automatically.
+	// Returns ErrClosed if the cache is already closed.
+	Close() error
+
+	// CloseContext closes the cache like Close, but returns ctx.Err() if ctx is done first,
+	// e.g. on a shutdown deadline, while the closing goes on in the background.
+	CloseContext(ctx context.Context) error
 }
 
 func NewOf[K comparable, V any](opts ...OptionOf[K, V]) CacheOf[K, V] {
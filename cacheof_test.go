@@ -4,8 +4,17 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -38,6 +47,61 @@ func mockCacheOf(opts ...OptionOf[string, any]) CacheOf[string, any] {
 	return c
 }
 
+// TestCacheOf_Parity keeps CacheOf and its options on par with Cache, whose implementation
+// is the one of CacheOf[string, interface{}].
+func TestCacheOf_Parity(t *testing.T) {
+	// the methods replaced by functions for the keys other than strings, or by other methods
+	stringKeysOnly := map[string]bool{
+		"GetBytes":        true,
+		"ItemsWithPrefix": true,
+		"ItemsSorted":     true,
+		"KeysSorted":      true,
+		"Namespace":       true,
+		"RangeSorted":     true,
+		"RangeSortedFunc": true,
+		"Scan":            true,
+		"SetBytes":        true,
+	}
+	methods := func(typ reflect.Type) (names []string) {
+		for i := 0; i < typ.NumMethod(); i++ {
+			if name := typ.Method(i).Name; !stringKeysOnly[name] {
+				names = append(names, name)
+			}
+		}
+		return
+	}
+	fields := func(typ reflect.Type) (names []string) {
+		for i := 0; i < typ.NumField(); i++ {
+			names = append(names, typ.Field(i).Name)
+		}
+		return
+	}
+	for _, x := range []struct {
+		name     string
+		got, exp []string
+	}{
+		{
+			"CacheOf",
+			methods(reflect.TypeOf((*CacheOf[string, any])(nil)).Elem()),
+			methods(reflect.TypeOf((*Cache)(nil)).Elem()),
+		},
+		{
+			"namespaceOf",
+			methods(reflect.TypeOf(&namespaceOf[any]{})),
+			methods(reflect.TypeOf(&namespace{})),
+		},
+		{
+			"ConfigOf",
+			fields(reflect.TypeOf(ConfigOf[string, any]{})),
+			fields(reflect.TypeOf(Config{})),
+		},
+	} {
+		if !reflect.DeepEqual(x.got, x.exp) {
+			t.Errorf("%s drifted:\n got: %v\nwant: %v", x.name, x.got, x.exp)
+		}
+	}
+}
+
 func TestCacheOf_Expire(t *testing.T) {
 	exp := 20 * time.Millisecond
 	interval := 1 * time.Millisecond
@@ -286,6 +350,85 @@ func TestCacheOf_GetAndSet(t *testing.T) {
 	}
 }
 
+// TestCacheOf_GetAndSetLinearizable races GetAndSet and GetOrSet around the expiration of the items,
+// each value holds its id and its expiration, and the writers hold mu for the clock not to move
+// during their call, so the result of each call can be checked at the instant it took effect.
+func TestCacheOf_GetAndSetLinearizable(t *testing.T) {
+	type value struct {
+		id int64
+		e  int64
+	}
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := NewOf[int, value](WithClockOf[int, value](clock), WithCleanupIntervalOf[int, value](time.Millisecond))
+	defer c.Close()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.RWMutex
+		stop     int32
+		ids      int64
+		replaced sync.Map
+	)
+	go func() {
+		for atomic.LoadInt32(&stop) == 0 {
+			mu.Lock()
+			clock.Advance(time.Millisecond)
+			mu.Unlock()
+			runtime.Gosched()
+		}
+	}()
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < 10000; n++ {
+				d := time.Duration(n%3+1) * time.Millisecond
+				k := n % 4
+				mu.RLock()
+				now := clock.Now().UnixNano()
+				v := value{id: atomic.AddInt64(&ids, 1), e: now + int64(d)}
+				var (
+					got    value
+					loaded bool
+				)
+				if (n+w)%2 == 0 {
+					got, loaded = c.GetAndSet(k, v, d)
+				} else {
+					got, loaded = c.GetOrSet(k, v, d)
+				}
+				mu.RUnlock()
+				switch {
+				case loaded && got.e < now:
+					t.Errorf("key %d: loaded the value %d expired at %d, at %d", k, got.id, got.e, now)
+					return
+				case !loaded && got != v:
+					t.Errorf("key %d: expected the stored value %d, got %d", k, v.id, got.id)
+					return
+				}
+				if loaded && (n+w)%2 == 0 {
+					if _, dup := replaced.LoadOrStore(got.id, k); dup {
+						t.Errorf("key %d: the value %d was replaced twice", k, got.id)
+						return
+					}
+				}
+				if n%64 == 0 {
+					runtime.Gosched()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	atomic.StoreInt32(&stop, 1)
+
+	for k := 0; k < 4; k++ {
+		if v, ok := c.Get(k); ok {
+			if _, gone := replaced.Load(v.id); gone {
+				t.Fatalf("key %d: the replaced value %d is still stored", k, v.id)
+			}
+		}
+	}
+}
+
 func TestCacheOf_GetAndRefresh(t *testing.T) {
 	c := NewOfDefault[string, int](100*time.Millisecond, testCleanupInterval)
 	c.SetDefault("x", 1)
@@ -309,6 +452,193 @@ func TestCacheOf_GetAndRefresh(t *testing.T) {
 	if !ok || v != 1 || tm.Before(time.Now()) {
 		t.Fatal("failed to get the value and expiration time of key x")
 	}
+
+	if !c.RefreshOnly("x", 2*time.Second) {
+		t.Fatal("key x should be refreshed")
+	}
+	if _, ttl, _ = c.GetWithTTL("x"); ttl < 1500*time.Millisecond {
+		t.Fatalf("key x lifetime is incorrect, expected >= 1.5s, got %d", ttl)
+	}
+	if c.RefreshOnly("y", time.Second) {
+		t.Fatal("missing key y should not be refreshed")
+	}
+}
+
+func TestCacheOf_GetWithExpirationNano(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+
+	c.Set("a", 1, time.Hour)
+	c.SetForever("b", 2)
+	_, e, _ := c.GetWithExpiration("a")
+	if v, n, ok := c.GetWithExpirationNano("a"); !ok || v != 1 || n != e.UnixNano() {
+		t.Fatalf("expected 1 expiring at %d, got %d at %d", e.UnixNano(), v, n)
+	}
+	if v, n, ok := c.GetWithExpirationNano("b"); !ok || v != 2 || n != 0 {
+		t.Fatalf("expected 2 never expiring, got %d at %d", v, n)
+	}
+	if v, _, ok := c.GetWithExpirationNano("c"); ok || v != 0 {
+		t.Fatalf("expected c to be missing, got %d", v)
+	}
+}
+
+func TestCacheOf_Peek(t *testing.T) {
+	c := NewOf[string, int](WithLoaderOf[string, int](func(context.Context, string) (int, time.Duration, error) {
+		return 9, NoExpiration, nil
+	}), WithEventHistoryOf[string, int](8), WithCleanupIntervalOf[string, int](0))
+	defer c.Close()
+
+	c.Set("a", 1, time.Hour)
+	c.Set("b", 2, time.Millisecond)
+	c.SetForever("c", 3)
+	time.Sleep(2 * time.Millisecond)
+	_, e, _ := c.PeekWithExpiration("a")
+	if v, ok := c.Peek("a"); !ok || v != 1 || e.IsZero() {
+		t.Fatalf("expected 1 expiring, got %d at %v", v, e)
+	}
+	if v, e, ok := c.PeekWithExpiration("c"); !ok || v != 3 || !e.IsZero() {
+		t.Fatalf("expected 3 never expiring, got %d at %v", v, e)
+	}
+	if _, ok := c.Peek("b"); ok {
+		t.Fatal("expected b to be expired")
+	}
+	if _, ok := c.Peek("d"); ok {
+		t.Fatal("expected d to be missing")
+	}
+	if n, events := c.Count(), c.RecentEvents(); n != 3 || len(events) != 3 {
+		t.Fatalf("expected the peeks to leave the cache unchanged, got %d items, %v", n, events)
+	}
+}
+
+func TestCacheOf_GetBytes(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+	SetBytesOf(c, []byte("a"), 1, NoExpiration)
+	buf := []byte("a")
+	if v, ok := GetBytesOf(c, buf); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	miss := []byte("b")
+	if n := testing.AllocsPerRun(100, func() {
+		GetBytesOf(c, buf)
+		GetBytesOf(c, miss)
+	}); n != 0 {
+		t.Fatalf("expected no allocation, got %v", n)
+	}
+	if v, ok := GetBytesOf(NamespaceOf(c, "ns:"), []byte("b")); ok {
+		t.Fatalf("expected a miss, got %v", v)
+	}
+}
+
+func TestCacheOf_WithKeyNormalizer(t *testing.T) {
+	abs := func(k int) int {
+		if k < 0 {
+			return -k
+		}
+		return k
+	}
+	c := NewOf[int, string](WithKeyNormalizerOf[int, string](abs))
+	defer c.Close()
+	c.SetForever(-1, "a")
+	if v, ok := c.Get(1); !ok || v != "a" {
+		t.Fatalf("expected a, got %v, %v", v, ok)
+	}
+	if v, ok := c.GetOrSet(-1, "b", NoExpiration); !ok || v != "a" {
+		t.Fatalf("expected a, got %v, %v", v, ok)
+	}
+	if items := c.GetMultiple([]int{-1, 2}); !reflect.DeepEqual(items, map[int]string{-1: "a"}) {
+		t.Fatalf("expected the items by the keys given, got %v", items)
+	}
+	n := NewOf[string, int](WithKeyNormalizerOf[string, int](strings.ToLower))
+	defer n.Close()
+	if v := IncrementOf(n, "N", 3); v != 3 {
+		t.Fatalf("expected 3, got %d", v)
+	}
+	if v, ok := GetBytesOf(n, []byte("N")); !ok || v != 3 {
+		t.Fatalf("expected 3, got %v, %v", v, ok)
+	}
+}
+
+func TestCacheOf_WithValueCopier(t *testing.T) {
+	c := NewOf[string, []int](WithValueCopierOf[string, []int](func(v []int) []int {
+		return append([]int(nil), v...)
+	}))
+	defer c.Close()
+
+	c.SetForever("a", []int{1, 2})
+	v, _ := c.Get("a")
+	v[0] = 100
+	c.GetMultiple([]string{"a"})["a"][1] = 100
+	if v, _ := c.Get("a"); !reflect.DeepEqual(v, []int{1, 2}) {
+		t.Fatalf("the cached value should not be mutated: %v", v)
+	}
+
+	// each read returning the cached value returns a copy
+	reads := map[string]func() []int{
+		"GetWithTTL": func() []int { v, _, _ := c.GetWithTTL("a"); return v },
+		"GetOrSet":   func() []int { v, _ := c.GetOrSet("a", nil, NoExpiration); return v },
+		"GetOrCompute": func() []int {
+			v, _ := c.GetOrCompute("a", func() []int { return nil }, NoExpiration)
+			return v
+		},
+		"GetOrComputeUnlocked": func() []int {
+			v, _ := c.GetOrComputeUnlocked("a", func() []int { return nil }, NoExpiration)
+			return v
+		},
+		"GetOrLoad": func() []int {
+			v, _, _ := c.GetOrLoad("a", func(string) ([]int, error) { return nil, nil }, NoExpiration)
+			return v
+		},
+		"GetAndRefresh": func() []int { v, _ := c.GetAndRefresh("a", NoExpiration); return v },
+		"Compute": func() []int {
+			v, _ := c.Compute("a", func(v []int, _ bool) ([]int, bool) { return v, false }, NoExpiration)
+			return v
+		},
+		"ComputeCancel": func() []int {
+			v, _ := c.ComputeWithOp("a", func(v []int, _ bool) ([]int, ComputeOp) { return nil, CancelOp }, NoExpiration)
+			return v
+		},
+		"Range": func() (v []int) {
+			c.Range(func(_ string, x []int) bool { v = x; return false })
+			return
+		},
+		"RangeCursor": func() (v []int) {
+			c.RangeCursor(0, 0, func(_ string, x []int) { v = x })
+			return
+		},
+		"ScanItems": func() []int { items, _ := c.ScanItems(0, 0); return items[0].Value },
+		"Items":     func() []int { return c.Items()["a"] },
+		"ItemsWhere": func() []int {
+			return c.ItemsWhere(func(string, []int) bool { return true })["a"]
+		},
+		"ItemsWithExpiration": func() []int { return c.ItemsWithExpiration()["a"].Value },
+		"ItemsSortedOf":       func() []int { return ItemsSortedOf[string, []int](c)[0].Value },
+	}
+	for name, read := range reads {
+		read()[0] = 100
+		if v, _ := c.Get("a"); !reflect.DeepEqual(v, []int{1, 2}) {
+			t.Fatalf("%s: the cached value should not be mutated: %v", name, v)
+		}
+	}
+}
+
+func TestCacheOf_WithValuePool(t *testing.T) {
+	pool := &testPool{}
+	c := NewOf[string, *bytes.Buffer](WithValuePoolOf[string, *bytes.Buffer](pool))
+	defer c.Close()
+
+	a1, a2 := &bytes.Buffer{}, &bytes.Buffer{}
+	c.SetForever("a", a1)
+	a1.WriteString("updated in place")
+	c.SetForever("a", a1)
+	c.SetForever("a", a2)
+	if got := pool.values(); len(got) != 1 || got[0] != a1 {
+		t.Fatalf("expected the overwritten value to be pooled, got: %v", got)
+	}
+	c.GetAndDelete("a")
+	if got := pool.values(); len(got) != 1 {
+		t.Fatalf("expected the value returned to be kept, got: %v", got)
+	}
 }
 
 func TestCacheOf_GetOrCompute(t *testing.T) {
@@ -397,6 +727,36 @@ func TestCacheOf_GetOrCompute_FunctionCalledOnce(t *testing.T) {
 	})
 }
 
+func TestCacheOf_GetOrComputeUnlocked(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+
+	if v, loaded := c.GetOrComputeUnlocked("a", func() int { return 1 }, NoExpiration); loaded || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, loaded)
+	}
+	if v, loaded := c.GetOrComputeUnlocked("a", func() int { return 2 }, NoExpiration); !loaded || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, loaded)
+	}
+
+	started := make(chan struct{})
+	done := make(chan int)
+	go func() {
+		v, _ := c.GetOrComputeUnlocked("b", func() int {
+			close(started)
+			<-done
+			return 1
+		}, NoExpiration)
+		done <- v
+	}()
+	<-started
+	// not blocked by the computation
+	c.Set("b", 2, NoExpiration)
+	done <- 0
+	if v := <-done; v != 2 {
+		t.Fatalf("expected the value set meanwhile, got %v", v)
+	}
+}
+
 func TestCacheOf_Compute(t *testing.T) {
 	c := NewOf[string, int]()
 	// Store a new value.
@@ -511,6 +871,56 @@ func TestCacheOf_Compute(t *testing.T) {
 	}
 }
 
+func TestCacheOf_ComputeWithOp(t *testing.T) {
+	evicted := 0
+	c := NewOf[string, int](WithEvictedCallbackOf[string, int](func(k string, v int) { evicted++ }))
+	c.Set("a", 1, time.Hour)
+	_, e, _ := c.GetWithExpirationNano("a")
+	_, r, _ := c.GetWithVersion("a")
+	v, ok := c.ComputeWithOp("a", func(oldValue int, loaded bool) (int, ComputeOp) {
+		if oldValue != 1 || !loaded {
+			t.Fatalf("unexpected old value: %d, %v", oldValue, loaded)
+		}
+		return 2, CancelOp
+	}, NoExpiration)
+	if v != 1 || !ok {
+		t.Fatalf("an existing value should be kept by CancelOp: %d, %v", v, ok)
+	}
+	if v, e2, _ := c.GetWithExpirationNano("a"); v != 1 || e2 != e {
+		t.Fatalf("the item should be unchanged: %d, %d, expected 1, %d", v, e2, e)
+	}
+	if _, r2, _ := c.GetWithVersion("a"); r2 != r {
+		t.Fatalf("the version should be unchanged: %d, expected %d", r2, r)
+	}
+	v, ok = c.ComputeWithOp("b", func(oldValue int, loaded bool) (int, ComputeOp) {
+		return 2, CancelOp
+	}, NoExpiration)
+	if v != 0 || ok {
+		t.Fatalf("a missing key should stay missing on CancelOp: %d, %v", v, ok)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should not be stored")
+	}
+	if evicted != 0 {
+		t.Fatalf("no callback should be called on CancelOp: %d", evicted)
+	}
+	v, ok = c.ComputeWithOp("a", func(oldValue int, loaded bool) (int, ComputeOp) {
+		return oldValue + 1, UpdateOp
+	}, NoExpiration)
+	if v != 2 || !ok {
+		t.Fatalf("the value should be updated by UpdateOp: %d, %v", v, ok)
+	}
+	v, ok = c.ComputeWithOp("a", func(oldValue int, loaded bool) (int, ComputeOp) {
+		return 0, DeleteOp
+	}, NoExpiration)
+	if v != 2 || ok {
+		t.Fatalf("the value should be deleted by DeleteOp: %d, %v", v, ok)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should be deleted")
+	}
+}
+
 func TestCacheOf_GetAndDelete(t *testing.T) {
 	c := NewOf[string, int]()
 	v, ok := c.GetAndDelete("x")
@@ -655,3 +1065,2269 @@ func TestCacheOf_Range(t *testing.T) {
 		t.Fatalf("incorrect number of items in cache, expected %d, got %d", 10, c.Count())
 	}
 }
+
+func TestCacheOf_RangeCursor(t *testing.T) {
+	const numEntries = 1000
+	c := NewOf[int, int]()
+	for i := 0; i < numEntries; i++ {
+		c.SetDefault(i, i)
+	}
+
+	seen := make(map[int]int)
+	var cursor uint64
+	for j := 0; ; j++ {
+		n := 0
+		cursor = c.RangeCursor(cursor, 100, func(k int, v int) {
+			if k != v {
+				t.Fatalf("key %d does not match value %d", k, v)
+			}
+			seen[k]++
+			n++
+		})
+		if n > 100 {
+			t.Fatalf("expected at most %d entries per call, got: %d", 100, n)
+		}
+		if cursor == 0 {
+			break
+		}
+		for i := 0; i < 500; i++ {
+			c.SetDefault(numEntries+j*500+i, numEntries+j*500+i)
+		}
+	}
+	for i := 0; i < numEntries; i++ {
+		if n := seen[i]; n != 1 {
+			t.Fatalf("key %d was visited %d times", i, n)
+		}
+	}
+}
+
+func TestCacheOf_ScanItems(t *testing.T) {
+	c := NewOf[int, int]()
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		c.SetForever(i, i*2)
+	}
+
+	seen := make(map[int]int)
+	var (
+		items  []KeyValueOf[int, int]
+		cursor uint64
+	)
+	for {
+		items, cursor = c.ScanItems(cursor, 30)
+		for _, x := range items {
+			seen[x.Key] = x.Value
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 100 || seen[42] != 84 {
+		t.Fatalf("expected 100 items, got %d", len(seen))
+	}
+}
+
+func TestScanOf(t *testing.T) {
+	c := NewOf[string, int]()
+	for i := 0; i < 100; i++ {
+		c.SetDefault("session:user:"+strconv.Itoa(i), i)
+		c.SetDefault("other:"+strconv.Itoa(i), i)
+	}
+
+	seen := make(map[string]int)
+	var cursor uint64
+	for {
+		var keys []string
+		keys, cursor = ScanOf(c, "session:*", cursor, 7)
+		for _, k := range keys {
+			seen[k]++
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 100 {
+		t.Fatalf("expected %d keys, got: %d", 100, len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 || !strings.HasPrefix(k, "session:user:") {
+			t.Fatalf("unexpected key %s, returned %d times", k, n)
+		}
+	}
+}
+
+func TestCacheOf_SaveToAndLoadFrom(t *testing.T) {
+	type value struct {
+		Name string
+		Tags []string
+	}
+	c := NewOf[int, value]()
+	for i := 0; i < 100; i++ {
+		c.Set(i, value{Name: strconv.Itoa(i), Tags: []string{"x"}}, testDefaultExpiration)
+	}
+	c.Set(100, value{}, 1*time.Millisecond)
+	<-time.After(2 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c2 := NewOf[int, value]()
+	if err := c2.LoadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c2.Count() != 100 {
+		t.Fatalf("expected number of items in cache to be 100, got: %d", c2.Count())
+	}
+	for i := 0; i < 100; i++ {
+		v, e, ok := c2.GetWithExpiration(i)
+		_, e1, _ := c.GetWithExpiration(i)
+		if !ok || !reflect.DeepEqual(v, value{Name: strconv.Itoa(i), Tags: []string{"x"}}) || !e.Equal(e1) {
+			t.Fatalf("key %d, unexpected value %v (%v)", i, v, e)
+		}
+	}
+
+	c3 := NewOf[string, value]()
+	if err := c3.LoadFrom(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrSnapshotType) {
+		t.Fatalf("expected ErrSnapshotType, got: %v", err)
+	}
+}
+
+func TestCacheOf_LoadItemsWithExpiration(t *testing.T) {
+	c := NewOf[string, int]()
+	c.Set("a", 1, 100*time.Millisecond)
+	c.SetForever("b", 2)
+	c.Set("c", 3, 1*time.Millisecond)
+	items := c.ItemsWithExpiration()
+	if len(items) != 3 || !items["b"].Expiration.IsZero() || items["a"].Expiration.IsZero() {
+		t.Fatalf("unexpected items: %v", items)
+	}
+	<-time.After(2 * time.Millisecond)
+	if items = c.ItemsWithExpiration(); len(items) != 2 {
+		t.Fatalf("expected %d items, got: %v", 2, items)
+	}
+
+	soon := time.Now().Add(50 * time.Millisecond)
+	later := time.Now().Add(1 * time.Second)
+	load := map[string]ItemWithExpirationOf[int]{
+		"a":       {Value: 10, Expiration: later},
+		"b":       {Value: 20, Expiration: soon},
+		"c":       {Value: 30, Expiration: soon},
+		"d":       {Value: 40},
+		"expired": {Value: 50, Expiration: time.Now().Add(-1 * time.Second)},
+	}
+	tests := []struct {
+		strategy LoadStrategy
+		want     map[string]int
+	}{
+		{Overwrite, map[string]int{"a": 10, "b": 20, "c": 30, "d": 40}},
+		{KeepExisting, map[string]int{"a": 1, "b": 2, "c": 30, "d": 40}},
+		{KeepNewestByExpiration, map[string]int{"a": 10, "b": 2, "c": 30, "d": 40}},
+	}
+	for _, tt := range tests {
+		c2 := NewOf[string, int]()
+		c2.LoadItemsWithExpiration(c.ItemsWithExpiration())
+		c2.LoadItemsWithExpiration(load, tt.strategy)
+		if got := c2.Items(); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("strategy %d, expected %v, got: %v", tt.strategy, tt.want, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	c3 := NewOf[string, int]()
+	c3.LoadItemsWithExpiration(load)
+	if err := c3.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c4 := NewOf[string, int]()
+	c4.Set("a", 1, NoExpiration)
+	c4.Set("b", 2, NoExpiration)
+	if err := c4.LoadFrom(&buf, KeepExisting); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]int{"a": 1, "b": 2, "c": 30, "d": 40}
+	if got := c4.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+}
+
+func TestCacheOf_RecentEvents(t *testing.T) {
+	if NewOf[int, int]().RecentEvents() != nil {
+		t.Fatal("event history should be disabled by default")
+	}
+
+	c := NewOf[int, int](WithEventHistoryOf[int, int](3))
+	c.Set(1, 1, NoExpiration)
+	c.GetOrSet(1, 2, NoExpiration)
+	c.GetAndRefresh(2, NoExpiration)
+	c.Compute(1, func(int, bool) (int, bool) { return 0, true }, NoExpiration)
+	events := c.RecentEvents()
+	want := []EventOf[int]{
+		{Op: EventGet, Key: 1, OK: true},
+		{Op: EventRefresh, Key: 2, OK: false},
+		{Op: EventCompute, Key: 1, OK: false},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got: %v", len(want), events)
+	}
+	for i, e := range events {
+		if e.Op != want[i].Op || e.Key != want[i].Key || e.OK != want[i].OK || e.Time.IsZero() {
+			t.Fatalf("event %d, expected %v, got: %v", i, want[i], e)
+		}
+	}
+}
+
+func TestCacheOf_ShadowStats(t *testing.T) {
+	c := NewOf[int, int](WithShadowOf[int, int](Shadow{Policy: LRU, Size: 1}))
+	c.Set(1, 1, NoExpiration)
+	c.Get(1)
+	c.Set(2, 2, NoExpiration)
+	c.Get(1)
+	c.Get(2)
+	c.Delete(2)
+	c.Get(2)
+	stats := c.ShadowStats()
+	if len(stats) != 1 || stats[0].Hits != 2 || stats[0].Misses != 2 {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+	if r := stats[0].HitRate(); r != 0.5 {
+		t.Fatalf("expected hit rate %v, got: %v", 0.5, r)
+	}
+}
+
+func TestCacheOf_Diagnostics(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := NewOf[int, int](WithClockOf[int, int](clock), WithNoCleanupLoopOf[int, int]())
+	defer c.Close()
+	c.SetForever(0, 0)
+	c.Set(1, 1, time.Second)
+	c.Set(2, 2, 15*time.Second)
+	c.Set(3, 3, 48*time.Hour)
+
+	d := c.Diagnostics(2)
+	if d.Items != 4 || d.Expired != 0 || d.NoExpiration != 1 || d.CleanupInterval != 0 {
+		t.Fatalf("unexpected diagnostics: %+v", d)
+	}
+	if b := d.TTLHistogram[len(d.TTLHistogram)-1]; b.Count != 1 {
+		t.Fatalf("expected 1 item in the last bucket, got %+v", b)
+	}
+	// without a cleanup loop, the intervals are DefaultCleanupInterval
+	if want := []int{1, 1}; !reflect.DeepEqual(d.Forecast, want) {
+		t.Fatalf("expected forecast %v, got %v", want, d.Forecast)
+	}
+}
+
+func TestCacheOf_HotKeys(t *testing.T) {
+	c := NewOf[int, int](WithHotKeysOf[int, int](2))
+	defer c.Close()
+	for i := 0; i < 1000; i++ {
+		c.Get(i % 10)
+		c.Get(42)
+	}
+	stats := c.HotKeys(1)
+	if len(stats) != 1 || stats[0].Key != 42 || stats[0].Count < 1000 {
+		t.Fatalf("unexpected hot keys: %v", stats)
+	}
+}
+
+func TestCacheOf_WithPersistencePath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	c := NewOf[string, int](WithPersistencePathOf[string, int](path))
+	c.SetForever("a", 1)
+	c.Set("b", 2, testDefaultExpiration)
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c2 := NewOf[string, int](WithPersistencePathOf[string, int](path))
+	defer c2.Close()
+	want := map[string]int{"a": 1, "b": 2}
+	if got := c2.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+
+	// a snapshot of another type is reported, and not saved over
+	var errs []error
+	c3 := NewOf[string, string](WithPersistencePathOf[string, string](path),
+		WithPersistenceErrorHandlerOf[string, string](func(err error) { errs = append(errs, err) }))
+	if c3.Count() != 0 {
+		t.Fatalf("expected an empty cache, got: %d", c3.Count())
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected the error of the snapshot, got: %v", errs)
+	}
+	c3.SetForever("c", "3")
+	if err := c3.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c4 := NewOf[string, int](WithPersistencePathOf[string, int](path))
+	defer c4.Close()
+	if got := c4.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+}
+
+func TestCacheOf_Warmup(t *testing.T) {
+	c := NewOf[string, string]()
+	defer c.Close()
+
+	errLoad := errors.New("load failed")
+	var running, peak int32
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	err := c.Warmup(context.Background(), keys, func(_ context.Context, k string) (string, time.Duration, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		switch k {
+		case "c":
+			return "", 0, errLoad
+		case "e":
+			panic("boom")
+		}
+		return k + "!", NoExpiration, nil
+	}, 2)
+	var werr *WarmupErrorOf[string]
+	if !errors.As(err, &werr) {
+		t.Fatalf("expected a *WarmupError, got: %v", err)
+	}
+	if !reflect.DeepEqual(werr.Keys, []string{"c", "e"}) {
+		t.Fatalf("unexpected failed keys: %v", werr.Keys)
+	}
+	if !errors.Is(err, errLoad) || !errors.Is(err, ErrLoaderPanicked) {
+		t.Fatalf("unexpected errors: %v", werr.Errs)
+	}
+	if p := atomic.LoadInt32(&peak); p > 2 {
+		t.Fatalf("expected at most 2 loads at a time, got: %d", p)
+	}
+	for _, k := range []string{"a", "b", "d", "f"} {
+		if v, ok := c.Get(k); !ok || v != k+"!" {
+			t.Fatalf("expected %s to be loaded, got: %v, %v", k, v, ok)
+		}
+	}
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("nothing should be stored for the failed keys")
+	}
+	if _, ok := c.Get("e"); ok {
+		t.Fatal("nothing should be stored for the failed keys")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = c.Warmup(ctx, []string{"x", "y"}, func(context.Context, string) (string, time.Duration, error) {
+		return "1", NoExpiration, nil
+	}, 4)
+	if !errors.As(err, &werr) || len(werr.Keys) != 2 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the keys to fail with the context error, got: %v", err)
+	}
+}
+
+func TestCacheOf_GetOrLoad(t *testing.T) {
+	locker := newTestLocker()
+	c := NewOf[int, string](WithDistributedLockerOf[int, string](locker, 0, -1), WithLockPrefixOf[int, string]("ints:"))
+	defer c.Close()
+
+	var calls int32
+	loader := func(k int) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return strconv.Itoa(k), nil
+	}
+	v, loaded, err := c.GetOrLoad(1, loader, NoExpiration)
+	if err != nil || loaded || v != "1" {
+		t.Fatalf("expected the loaded value, got: %v, %v, %v", v, loaded, err)
+	}
+	v, loaded, err = c.GetOrLoad(1, loader, NoExpiration)
+	if err != nil || !loaded || v != "1" || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expected the cached value, got: %v, %v, %v", v, loaded, err)
+	}
+	if locker.held("ints:1") {
+		t.Fatal("expected the lease to be released")
+	}
+
+	errLoad := errors.New("load failed")
+	_, _, err = c.GetOrLoad(2, func(int) (string, error) {
+		return "", errLoad
+	}, NoExpiration)
+	if err != errLoad {
+		t.Fatalf("expected the loader error, got: %v", err)
+	}
+	if _, ok := c.Get(2); ok {
+		t.Fatal("nothing should be stored on error")
+	}
+}
+
+func TestRangeSortedOf(t *testing.T) {
+	c := NewOf[int, string]()
+	defer c.Close()
+	for _, k := range []int{3, -1, 10, 2} {
+		c.SetForever(k, strconv.Itoa(k))
+	}
+
+	if keys := KeysSortedOf(c); !reflect.DeepEqual(keys, []int{-1, 2, 3, 10}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	var got []string
+	RangeSortedOf(c, func(k int, v string) bool {
+		got = append(got, v)
+		return k < 3
+	})
+	if !reflect.DeepEqual(got, []string{"-1", "2", "3"}) {
+		t.Fatalf("unexpected values: %v", got)
+	}
+
+	got = got[:0]
+	RangeSortedFuncOf(c, func(a, b int) bool { return a > b }, func(_ int, v string) bool {
+		got = append(got, v)
+		return true
+	})
+	if !reflect.DeepEqual(got, []string{"10", "3", "2", "-1"}) {
+		t.Fatalf("unexpected values: %v", got)
+	}
+	items := ItemsSortedOf(c)
+	if len(items) != 4 || items[0] != (KeyValueOf[int, string]{-1, "-1"}) {
+		t.Fatalf("unexpected items: %v", items)
+	}
+}
+
+func TestCacheOf_KeyFilter(t *testing.T) {
+	c := NewOf[int, int]()
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		c.SetForever(i, i)
+	}
+	f := c.KeyFilter(0.001)
+	for i := 0; i < 100; i++ {
+		if !f.MayContain(strconv.Itoa(i)) {
+			t.Fatalf("key %d should be in the filter", i)
+		}
+	}
+}
+
+func TestCacheOf_WithNoFinalizer(t *testing.T) {
+	c := NewOf[string, int](WithNoFinalizerOf[string, int]())
+	c.SetForever("a", 1)
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Close(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got: %v", err)
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("the cache should still be usable after Close, got: %v, %v", v, ok)
+	}
+}
+
+func TestCacheOf_SetWithMeta(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+	c.SetWithMeta("a", 1, NoExpiration, 3.5)
+	v, meta, ok := c.GetWithMeta("a")
+	if !ok || v != 1 || meta != 3.5 {
+		t.Fatalf("unexpected result: %v, %v, %v", v, meta, ok)
+	}
+	c.SetDefault("a", 2)
+	if v, meta, ok = c.GetWithMeta("a"); !ok || v != 2 || meta != nil {
+		t.Fatalf("the metadata should be dropped on set, got: %v, %v, %v", v, meta, ok)
+	}
+	if _, _, ok = c.GetWithMeta("b"); ok {
+		t.Fatal("b should not be found")
+	}
+}
+
+func TestSetOperationsOf(t *testing.T) {
+	a := NewOf[int, int]()
+	defer a.Close()
+	for i := 1; i <= 3; i++ {
+		a.SetForever(i, i)
+	}
+	b := NewKeySetOf(2, 3, 4)
+
+	got := UnionOf(CacheKeysOf(a), b)
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, []int{1, 2, 3, 4}) {
+		t.Fatalf("unexpected union: %v", got)
+	}
+	got = IntersectionOf(CacheKeysOf(a), b)
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Fatalf("unexpected intersection: %v", got)
+	}
+	if got = DifferenceOf[int](b, CacheKeysOf(a)); !reflect.DeepEqual(got, []int{4}) {
+		t.Fatalf("unexpected difference: %v", got)
+	}
+}
+
+func TestCacheOf_WithProfiler(t *testing.T) {
+	var n int32
+	c := NewOf[string, int](WithProfilerOf[string, int](ProfilerFunc(func(op ProfileOp, _ time.Duration) {
+		if op == ProfileGet {
+			atomic.AddInt32(&n, 1)
+		}
+	})))
+	defer c.Close()
+	c.Set("a", 1, NoExpiration)
+	c.Get("a")
+	c.GetWithExpiration("b")
+	if n != 2 {
+		t.Fatalf("expected 2 gets, got: %d", n)
+	}
+}
+
+func TestCacheOf_WithMaxEntries(t *testing.T) {
+	c := NewOf[int, int](WithMaxEntriesOf[int, int](2), WithEventHistoryOf[int, int](10))
+	defer c.Close()
+	c.SetForever(1, 1)
+	c.SetForever(2, 2)
+	c.GetOrSet(1, 1, NoExpiration)
+	c.SetForever(3, 3) // evicts 2
+	if _, ok := c.Get(2); ok {
+		t.Fatal("2 should be evicted")
+	}
+	if c.Count() != 2 {
+		t.Fatalf("expected 2 items, got: %d", c.Count())
+	}
+	var evicts int
+	for _, e := range c.RecentEvents() {
+		if e.Op == EventEvict {
+			evicts++
+		}
+	}
+	if evicts != 1 {
+		t.Fatalf("expected 1 evict event, got: %d", evicts)
+	}
+}
+
+func TestCacheOf_WithMemoryLimit(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c := NewOf[int, int](
+		WithClockOf[int, int](clock),
+		WithMemoryLimitOf[int, int](1000, func() uint64 {
+			return 1001
+		}),
+	)
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		c.SetForever(i, i)
+	}
+	clock.Advance(DefaultMemoryCheckInterval)
+	for i := 0; c.Count() != 4; i++ {
+		if i == 100 {
+			t.Fatalf("expected at least 1 item to be evicted over the limit, got %d items", c.Count())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := c.Get(0); ok {
+		t.Fatal("expected the least recently used key to be evicted")
+	}
+}
+
+func TestCacheOf_WithEvictionPolicy(t *testing.T) {
+	c := NewOf[int, int](WithMaxEntriesOf[int, int](2), WithEvictionPolicyOf[int, int](LFU))
+	defer c.Close()
+	c.SetForever(1, 1)
+	c.Get(1)
+	c.SetForever(2, 2)
+	c.SetForever(3, 3) // evicts 2
+	if keys := KeysSortedOf(c); !reflect.DeepEqual(keys, []int{1, 3}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestCacheOf_WithAdmissionPolicy(t *testing.T) {
+	c := NewOf[int, int](
+		WithMaxEntriesOf[int, int](2),
+		WithAdmissionPolicyOf[int, int](TinyLFU),
+	)
+	defer c.Close()
+	c.SetForever(1, 1)
+	c.SetForever(2, 2)
+	c.Get(1)
+	c.Get(2)
+	c.SetForever(3, 3) // rejected
+	if keys := KeysSortedOf(c); !reflect.DeepEqual(keys, []int{1, 2}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	c.Get(4)
+	c.Get(4)
+	c.SetForever(4, 4) // admitted, evicts 1
+	if keys := KeysSortedOf(c); !reflect.DeepEqual(keys, []int{2, 4}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestCacheOf_WithHasher(t *testing.T) {
+	type point struct{ x, y int32 }
+	c := NewOf[point, int](WithHasherOf[point, int](func(p point, seed uint64) uint64 {
+		h := (uint64(uint32(p.x))<<32 | uint64(uint32(p.y))) ^ seed
+		return h * 0x9e3779b97f4a7c15
+	}))
+	defer c.Close()
+	for i := int32(0); i < 100; i++ {
+		c.SetForever(point{i, -i}, int(i))
+	}
+	for i := int32(0); i < 100; i++ {
+		if v, ok := c.Get(point{i, -i}); !ok || v != int(i) {
+			t.Fatalf("expected %d, got %v, %v", i, v, ok)
+		}
+	}
+	if n := c.Count(); n != 100 {
+		t.Fatalf("expected 100 items, got %d", n)
+	}
+}
+
+func TestCacheOf_Key2(t *testing.T) {
+	type key = Key2[int64, string]
+	c := NewOf[key, int](WithHasherOf[key, int](Key2Hasher[int64, string]()))
+	defer c.Close()
+	c.SetForever(NewKey2[int64, string](1, "a"), 1)
+	c.SetForever(key{1, "b"}, 2)
+	c.SetForever(key{2, "a"}, 3)
+	if v, ok := c.Get(key{1, "a"}); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	if v, ok := c.Get(key{2, "a"}); !ok || v != 3 {
+		t.Fatalf("expected 3, got %v, %v", v, ok)
+	}
+	if _, ok := c.Get(key{2, "b"}); ok {
+		t.Fatal("expected a miss")
+	}
+
+	h := Key2Hasher[int64, string]()
+	if h(key{1, "a"}, 1) != h(NewKey2[int64, string](1, "a"), 1) || h(key{1, "a"}, 1) == h(key{1, "a"}, 2) {
+		t.Fatal("expected the hash to depend on the values and the seed only")
+	}
+
+	type key3 = Key3[string, int, bool]
+	c3 := NewOf[key3, int](WithHasherOf[key3, int](Key3Hasher[string, int, bool]()))
+	defer c3.Close()
+	c3.SetForever(NewKey3("t", 1, true), 1)
+	if v, ok := c3.Get(key3{"t", 1, true}); !ok || v != 1 {
+		t.Fatalf("expected 1, got %v, %v", v, ok)
+	}
+	if _, ok := c3.Get(key3{"t", 1, false}); ok {
+		t.Fatal("expected a miss")
+	}
+}
+
+func TestCacheOf_WithMaxCost(t *testing.T) {
+	c := NewOf[string, []byte](WithMaxCostOf[string, []byte](10), WithMaxEntriesOf[string, []byte](3))
+	defer c.Close()
+	for _, k := range []string{"a", "b", "c"} {
+		v := []byte(k + k + k)
+		c.SetWithCost(k, v, NoExpiration, int64(len(v)))
+	}
+	c.SetWithCost("d", []byte("dd"), NoExpiration, 2) // evicts a, by cost
+	if keys := KeysSortedOf(c); !reflect.DeepEqual(keys, []string{"b", "c", "d"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	c.Set("e", nil, NoExpiration) // evicts b, by count
+	if keys := KeysSortedOf(c); !reflect.DeepEqual(keys, []string{"c", "d", "e"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestCacheOf_SaveToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	c := NewOf[string, int]()
+	defer c.Close()
+	c.SetForever("a", 1)
+	if err := c.SaveToFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c2 := NewOf[string, int]()
+	defer c2.Close()
+	if err := c2.LoadFromFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, ok := c2.Get("a"); !ok || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+}
+
+func TestCacheOf_SnapshotGob(t *testing.T) {
+	type point struct{ X, Y int }
+	c := NewOf[int, point](WithSnapshotFormatOf[int, point](SnapshotGob))
+	defer c.Close()
+	c.SetForever(1, point{1, 2})
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c2 := NewOf[int, point]()
+	defer c2.Close()
+	if err := c2.LoadFrom(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, ok := c2.Get(1); !ok || v != (point{1, 2}) {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+}
+
+func TestCacheOf_WithCodec(t *testing.T) {
+	type point struct{ X, Y int }
+	c := NewOf[string, point](WithCodecOf[string, point](JSONCodec), WithSnapshotFormatOf[string, point](SnapshotGob))
+	defer c.Close()
+	c.SetForever("a", point{1, 2})
+	ns := NamespaceOf[point](c, "ns:")
+	ns.SetForever("b", point{3, 4})
+	var buf bytes.Buffer
+	if err := ns.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := buf.Bytes()
+	if h, err := ReadSnapshotHeader(bytes.NewReader(data)); err != nil || h.Format != SnapshotJSON {
+		t.Fatalf("expected the codec to override the format, got %+v, %v", h, err)
+	}
+	c2 := NewOf[string, point]()
+	defer c2.Close()
+	if err := NamespaceOf[point](c2, "ns:").LoadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, ok := c2.Get("ns:b"); !ok || v != (point{3, 4}) {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+}
+
+func TestCacheOf_ExportGzip(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+	c.SetForever("a", 1)
+	c.Set("ns:b", 2, time.Hour)
+	var buf bytes.Buffer
+	if err := NamespaceOf[int](c, "ns:").ExportGzip(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c2 := NewOf[string, int]()
+	defer c2.Close()
+	if err := c2.ImportGzip(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, ttl, ok := c2.GetWithTTL("b"); !ok || v != 2 || ttl <= 0 {
+		t.Fatalf("expected the item of the namespace with its expiration, got %d, %v", v, ttl)
+	}
+	if n := c2.Count(); n != 1 {
+		t.Fatalf("expected 1 item, got %d", n)
+	}
+}
+
+func TestCacheOf_WithSlidingExpiration(t *testing.T) {
+	c := NewOf[string, int](WithSlidingExpirationOf[string, int](), WithDefaultExpirationOf[string, int](50*time.Millisecond))
+	defer c.Close()
+	c.SetDefault("a", 1)
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, ok := c.Get("a"); !ok {
+			t.Fatal("a should not expire while read")
+		}
+	}
+}
+
+func TestCacheOf_SetWithCallback(t *testing.T) {
+	var closed []int
+	c := NewOf[string, int](WithMaxEntriesOf[string, int](1))
+	defer c.Close()
+	c.SetWithCallback("a", 1, NoExpiration, func(_ string, v int) {
+		closed = append(closed, v)
+	})
+	c.SetForever("b", 2) // evicts a
+	if !reflect.DeepEqual(closed, []int{1}) {
+		t.Fatalf("the callback should be called on eviction, got: %v", closed)
+	}
+}
+
+func TestCacheOf_EvictedCallbackWithReason(t *testing.T) {
+	var calls []string
+	fn := func(k string, v int, reason EvictionReason) {
+		calls = append(calls, k+"="+strconv.Itoa(v)+":"+reason.String())
+	}
+	c := NewOf[string, int](
+		WithCleanupIntervalOf[string, int](0),
+		WithMaxEntriesOf[string, int](4),
+		WithEvictedCallbackWithReasonOf[string, int](fn),
+	)
+	defer c.Close()
+
+	c.Set("expired", 1, time.Millisecond)
+	c.Set("lazy", 2, time.Millisecond)
+	c.SetForever("deleted", 3)
+	c.SetForever("replaced", 4)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.Get("lazy"); ok {
+		t.Fatal("lazy should be expired")
+	}
+	c.DeleteExpired()
+	c.Compute("deleted", func(int, bool) (int, bool) {
+		return 0, true
+	}, NoExpiration)
+	c.SetForever("replaced", 5)
+	c.GetAndSet("replaced", 6, NoExpiration)
+	for i := 0; i < 4; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	c.Clear()
+
+	sort.Strings(calls)
+	want := []string{
+		"0=0:cleared", "1=1:cleared", "2=2:cleared", "3=3:cleared",
+		"deleted=3:deleted", "expired=1:expired", "lazy=2:expired",
+		"replaced=4:replaced", "replaced=5:replaced", "replaced=6:capacity",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected %v, got: %v", want, calls)
+	}
+}
+
+func TestCacheOf_AsyncCallbacks(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		called  int32
+	)
+	c := NewOf[string, int](
+		WithCleanupIntervalOf[string, int](0),
+		WithAsyncCallbacksOf[string, int](2, 0),
+		WithEvictedCallbackWithReasonOf[string, int](func(k string, v int, reason EvictionReason) {
+			<-release
+			if reason == ReasonExpired {
+				atomic.AddInt32(&called, 1)
+			}
+		}),
+	)
+	for i := 0; i < 3; i++ {
+		c.Set(strconv.Itoa(i), i, time.Millisecond)
+	}
+	time.Sleep(2 * time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	c.DeleteExpired()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&called); n != 3 {
+		t.Fatalf("expected Close to wait for 3 callbacks, got %d", n)
+	}
+}
+
+func TestCacheOf_CloseContext(t *testing.T) {
+	var called int32
+	c := NewOf[string, int](
+		WithCleanupIntervalOf[string, int](0),
+		WithCleanupOnCloseOf[string, int](),
+		WithEvictedCallbackOf[string, int](func(k string, v int) {
+			atomic.AddInt32(&called, 1)
+		}),
+	)
+	c.Set("expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if err := c.CloseContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&called); n != 1 {
+		t.Fatalf("expected the callback of the expired item, got %d", n)
+	}
+}
+
+func TestCacheOf_ClosedMode(t *testing.T) {
+	c := NewOf[string, int](WithClosedModeOf[string, int](ClosedIgnore))
+	c.SetForever("a", 1)
+	c.Close()
+	c.SetForever("b", 2)
+	if v, ok := c.Get("a"); ok || v != 0 {
+		t.Fatalf("expected the closed cache to miss, got %d", v)
+	}
+	if _, _, err := c.GetOrLoad("b", func(string) (int, error) { return 2, nil }, NoExpiration); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	c = NewOf[string, int](WithClosedModeOf[string, int](ClosedPanic))
+	c.Close()
+	func() {
+		defer func() {
+			if r := recover(); r != ErrClosed {
+				t.Fatalf("expected a panic with ErrClosed, got %v", r)
+			}
+		}()
+		c.SetForever("a", 1)
+	}()
+}
+
+func TestCacheOf_ClosedMode_WriteThrough(t *testing.T) {
+	var writes, loads int32
+	c := NewOf[string, int](
+		WithClosedModeOf[string, int](ClosedIgnore),
+		WithWriteThroughOf[string, int](func(string, int) error {
+			atomic.AddInt32(&writes, 1)
+			return nil
+		}),
+		WithLoaderOf[string, int](func(context.Context, string) (int, time.Duration, error) {
+			atomic.AddInt32(&loads, 1)
+			return 1, NoExpiration, nil
+		}),
+	)
+	c.Set("a", 1, time.Minute)
+	c.Close()
+	atomic.StoreInt32(&writes, 0)
+	atomic.StoreInt32(&loads, 0)
+
+	c.Set("b", 2, time.Minute)
+	c.GetOrSet("c", 3, time.Minute)
+	c.SetIfAbsent("d", 4, time.Minute)
+	c.GetAndSet("a", 5, time.Minute)
+	c.GetOrComputeUnlocked("e", func() int { return 6 }, time.Minute)
+	c.GetOrCompute("g", func() int { return 7 }, time.Minute)
+	c.Compute("h", func(int, bool) (int, bool) { return 8, false }, time.Minute)
+	c.Get("f")
+	if n := atomic.LoadInt32(&writes); n != 0 {
+		t.Fatalf("expected no write through after Close, got %d", n)
+	}
+	if n := atomic.LoadInt32(&loads); n != 0 {
+		t.Fatalf("expected no load after Close, got %d", n)
+	}
+}
+
+func TestCacheOf_GetE(t *testing.T) {
+	c := NewOf[string, int](WithClosedModeOf[string, int](ClosedIgnore))
+	if _, err := c.GetE("a"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := c.SetE("a", 1, NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.GetE("a"); err != nil || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, err)
+	}
+	c.Close()
+	if err := c.SetE("a", 2, NoExpiration); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, err := c.GetE("a"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestCacheOf_WithPanicHandler(t *testing.T) {
+	var panics int32
+	c := NewOf[string, int](
+		WithCleanupIntervalOf[string, int](0),
+		WithPanicHandlerOf[string, int](func(r any) { atomic.AddInt32(&panics, 1) }),
+	)
+	defer c.Close()
+
+	if v, ok := c.GetOrCompute("a", func() int { panic("valueFn") }, NoExpiration); ok || v != 0 {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+	c.Set("a", 1, NoExpiration)
+	if n := c.DeleteFunc(func(string, int) bool { panic("f") }); n != 0 {
+		t.Fatalf("expected no items deleted, got %d", n)
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+	if n := atomic.LoadInt32(&panics); n != 2 {
+		t.Fatalf("expected 2 panics, got %d", n)
+	}
+}
+
+func TestCacheOf_WithClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c := NewOf[string, int](WithClockOf[string, int](clock), WithCleanupIntervalOf[string, int](0))
+	defer c.Close()
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Hour)
+	clock.Advance(time.Minute + 1)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected the item to have expired")
+	}
+	if v, ok := c.Get("b"); !ok || v != 2 {
+		t.Fatalf("expected 2, got %d", v)
+	}
+	c.DeleteExpired()
+	if n := c.Count(); n != 1 {
+		t.Fatalf("expected 1 item, got %d", n)
+	}
+}
+
+func TestCacheOf_ItemsWhere(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+	c.SetForever("user:1", 1)
+	c.SetForever("user:2", 2)
+	c.SetForever("order:1", 3)
+
+	items := c.ItemsWhere(func(k string, v int) bool {
+		return v%2 == 1
+	})
+	if want := map[string]int{"user:1": 1, "order:1": 3}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+	items = ItemsWithPrefixOf(c, "user:")
+	if want := map[string]int{"user:1": 1, "user:2": 2}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+	items = ItemsWithPrefixOf(NamespaceOf(c, "user:"), "2")
+	if want := map[string]int{"2": 2}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+}
+
+func TestCacheOf_ItemsAtomic(t *testing.T) {
+	const n = 8
+	c := NewOf[int, int](WithConsistentSnapshotsOf[int, int]())
+	defer c.Close()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := 1; ; r++ {
+			for k := 0; k < n; k++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c.SetForever(k, r)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		items := c.ItemsAtomic()
+		for k := 1; k < len(items); k++ {
+			if items[k] > items[k-1] || items[k-1] > items[k]+1 {
+				t.Fatalf("torn copy: %v", items)
+			}
+		}
+	}
+	close(stop)
+	<-done
+
+	s := NewOf[string, int](WithConsistentSnapshotsOf[string, int]())
+	defer s.Close()
+	s.SetForever("a", 1)
+	s.SetForever("ns:b", 2)
+	if items := NamespaceOf(s, "ns:").ItemsAtomic(); !reflect.DeepEqual(items, map[string]int{"b": 2}) {
+		t.Fatalf("expected the items of the namespace, got %v", items)
+	}
+}
+
+func TestCacheOf_DeleteExpiredIndex(t *testing.T) {
+	c := NewOf[int, int](WithCleanupIntervalOf[int, int](0), WithSlidingExpirationOf[int, int]())
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		c.Set(i, i, 40*time.Millisecond)
+	}
+	c.Set(100, 100, NoExpiration)
+	time.Sleep(20 * time.Millisecond)
+	// slides without being scheduled again
+	c.Get(0)
+	time.Sleep(30 * time.Millisecond)
+	c.DeleteExpired()
+	if n := c.Count(); n != 2 {
+		t.Fatalf("expected 2 items, got %d", n)
+	}
+	time.Sleep(20 * time.Millisecond)
+	c.DeleteExpired()
+	if _, ok := c.Get(0); ok || c.Count() != 1 {
+		t.Fatalf("expected the slid item to be deleted, got %d items", c.Count())
+	}
+}
+
+func TestCacheOf_ExpiredCount(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := NewOf[int, int](WithClockOf[int, int](clock), WithCleanupIntervalOf[int, int](time.Hour))
+	defer c.Close()
+
+	c.Set(1, 1, time.Minute)
+	c.Set(2, 2, time.Minute)
+	c.SetForever(3, 3)
+	c.SetForever(4, 4)
+	clock.Advance(2 * time.Minute)
+	if n, total := c.ExpiredCount(), c.Count(); n != 2 || total != 4 {
+		t.Fatalf("expected 2 expired items out of 4, got: %d, %d", n, total)
+	}
+	if n := c.CountWhere(func(k, _ int) bool { return k%2 == 0 }); n != 1 {
+		t.Fatalf("expected 1 unexpired even key, got: %d", n)
+	}
+}
+
+func TestCacheOf_DeleteExpiredN(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := NewOf[int, int](WithClockOf[int, int](clock), WithCleanupIntervalOf[int, int](time.Hour))
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.Set(i, i, time.Duration(i+1)*time.Second)
+	}
+	clock.Advance(time.Minute)
+	if n := c.DeleteExpiredN(4); n != 4 || c.ExpiredCount() != 6 {
+		t.Fatalf("expected 4 items deleted and 6 left, got: %d, %d", n, c.ExpiredCount())
+	}
+	if n := c.DeleteExpiredFor(time.Hour); n != 6 || c.Count() != 0 {
+		t.Fatalf("expected the 6 items left deleted, got: %d, %d", n, c.Count())
+	}
+}
+
+func TestCacheOf_PauseCleanup(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := NewOf[int, int](WithClockOf[int, int](clock), WithCleanupIntervalOf[int, int](0))
+	defer c.Close()
+
+	c.Set(1, 1, time.Second)
+	clock.Advance(time.Minute)
+	c.SetCleanupInterval(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for c.ExpiredCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the started loop to delete the expired item")
+		}
+		clock.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+
+	c.PauseCleanup()
+	c.Set(2, 2, time.Second)
+	clock.Advance(time.Minute)
+	time.Sleep(20 * time.Millisecond)
+	if n := c.ExpiredCount(); n != 1 {
+		t.Fatalf("expected the paused loop to leave the expired item, got: %d", n)
+	}
+	c.ResumeCleanup()
+}
+
+func TestCacheOf_WithCleanupHook(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	reports := make(chan CleanupReport, 1)
+	c := NewOf[int, int](
+		WithClockOf[int, int](clock),
+		WithCleanupIntervalOf[int, int](time.Second),
+		WithCleanupHookOf[int, int](func(report CleanupReport) {
+			reports <- report
+		}),
+	)
+	defer c.Close()
+
+	c.Set(1, 1, time.Second)
+	c.Set(2, 2, time.Second)
+	clock.Advance(time.Minute)
+	select {
+	case r := <-reports:
+		if r.Scanned != 2 || r.Deleted != 2 {
+			t.Fatalf("unexpected report: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a report of the cleanup loop")
+	}
+}
+
+func TestCacheOf_WithNoCleanupLoop(t *testing.T) {
+	c := NewOf[string, int](WithNoCleanupLoopOf[string, int](), WithCleanupIntervalOf[string, int](time.Millisecond))
+	defer c.Close()
+
+	c.Set("a", 1, time.Millisecond)
+	c.SetForever("b", 2)
+	time.Sleep(20 * time.Millisecond)
+	if n := len(c.Items()); n != 1 {
+		t.Fatalf("expected 1 item, got %d", n)
+	}
+	if n := c.Count(); n != 1 {
+		t.Fatalf("expected 1 item, got %d", n)
+	}
+}
+
+func TestCacheOf_GetMultiple(t *testing.T) {
+	c := NewOf[int, string](WithCleanupIntervalOf[int, string](0))
+	defer c.Close()
+	c.SetForever(1, "a")
+	c.SetForever(2, "b")
+	c.Set(3, "expired", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	items := c.GetMultiple([]int{1, 2, 3, 4})
+	if want := map[int]string{1: "a", 2: "b"}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+	if c.Count() != 2 {
+		t.Fatal("the expired item should be deleted")
+	}
+}
+
+func TestCacheOf_DeleteFunc(t *testing.T) {
+	c := NewOf[int, int](WithCleanupIntervalOf[int, int](0))
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		c.SetForever(i, i*10)
+	}
+	c.DeleteMultiple([]int{0, 1, 100})
+	n := c.DeleteFunc(func(k int, v int) bool {
+		return v >= 50
+	})
+	if n != 5 {
+		t.Fatalf("expected 5 deleted items, got %d", n)
+	}
+	if keys := KeysSortedOf[int, int](c); !reflect.DeepEqual(keys, []int{2, 3, 4}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestCacheOf_Update(t *testing.T) {
+	c := NewOf[string, int](WithCleanupIntervalOf[string, int](0))
+	defer c.Close()
+
+	c.SetForever("from", 10)
+	err := c.Update(func(tx TxnOf[string, int]) error {
+		v, _ := tx.Get("from")
+		tx.Set("from", v-3, NoExpiration)
+		tx.Set("to", 3, NoExpiration)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := c.Items(); !reflect.DeepEqual(items, map[string]int{"from": 7, "to": 3}) {
+		t.Fatalf("unexpected items: %v", items)
+	}
+
+	errAbort := errors.New("abort")
+	if err = c.Update(func(tx TxnOf[string, int]) error {
+		tx.Delete("from")
+		return errAbort
+	}); err != errAbort {
+		t.Fatalf("expected the error of the transaction, got %v", err)
+	}
+	if _, ok := c.Get("from"); !ok {
+		t.Fatal("the aborted transaction should not be applied")
+	}
+}
+
+func TestNamespaceOf(t *testing.T) {
+	c := NewOf[string, int](WithCleanupIntervalOf[string, int](0))
+	defer c.Close()
+	a := NamespaceOf(c, "a:")
+	b := NamespaceOf(a, "b:")
+
+	a.SetForever("x", 1)
+	b.SetForever("x", 2)
+	if v, ok := c.Get("a:b:x"); !ok || v != 2 {
+		t.Fatalf("expected the nested prefix in the cache, got: %v", v)
+	}
+	if items := a.Items(); !reflect.DeepEqual(items, map[string]int{"x": 1, "b:x": 2}) {
+		t.Fatalf("unexpected items: %v", items)
+	}
+	if n := b.DeleteFunc(func(k string, v int) bool { return true }); n != 1 {
+		t.Fatalf("expected 1 deleted item, got %d", n)
+	}
+	if n := a.Count(); n != 1 {
+		t.Fatalf("expected 1 item, got %d", n)
+	}
+	if keys := KeysSortedOf(a); !reflect.DeepEqual(keys, []string{"x"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestCacheOf_SetWithTags(t *testing.T) {
+	c := NewOf[int, string](WithCleanupIntervalOf[int, string](0))
+	defer c.Close()
+
+	c.SetWithTags(1, "a", NoExpiration, "even", "odd")
+	c.SetWithTags(2, "b", NoExpiration, "even")
+	c.SetWithTags(3, "c", time.Millisecond, "odd")
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+
+	if n := c.InvalidateTag("even"); n != 2 {
+		t.Fatalf("expected 2 invalidated items, got %d", n)
+	}
+	if c.Count() != 0 {
+		t.Fatalf("expected no items, got %d", c.Count())
+	}
+	if x := c.(*xsyncMapOfWrapper[int, string]).tags; len(x.keys) != 0 {
+		t.Fatalf("expected an empty tag index, got: %v", x.keys)
+	}
+
+	ns := NamespaceOf[int](NewOf[string, int](), "ns:")
+	ns.SetWithTags("k", 1, NoExpiration, "t")
+	if n := ns.InvalidateTag("t"); n != 1 {
+		t.Fatalf("expected 1 invalidated item, got %d", n)
+	}
+}
+
+func TestCacheOf_InvalidateIf(t *testing.T) {
+	c := NewOf[int, string](WithCleanupIntervalOf[int, string](0))
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.SetForever(i, strconv.Itoa(i))
+	}
+	c.InvalidateIf(func(k int) bool { return k%2 == 0 })
+	c.InvalidateIf(func(k int) bool { return k > 6 })
+	c.SetForever(8, "eight")
+
+	if _, ok := c.Get(4); ok {
+		t.Fatal("4 should be invalidated")
+	}
+	if v, ok := c.Get(8); !ok || v != "eight" {
+		t.Fatal("the items written after InvalidateIf should be kept")
+	}
+	c.DeleteExpired()
+	if keys := KeysSortedOf(c); !reflect.DeepEqual(keys, []int{1, 3, 5, 8}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if x := c.(*xsyncMapOfWrapper[int, string]).invalidations; x.pending() != 0 {
+		t.Fatalf("expected no pending invalidations, got %d", x.pending())
+	}
+
+	ns := NamespaceOf[int](NewOf[string, int](), "ns:")
+	ns.SetForever("k", 1)
+	ns.InvalidateIf(func(k string) bool { return k == "k" })
+	if _, ok := ns.Get("k"); ok {
+		t.Fatal("k should be invalidated")
+	}
+}
+
+func TestCacheOf_Overflow(t *testing.T) {
+	store, err := NewDiskStoreOf[int, string](t.TempDir(), SnapshotJSON, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := NewOf[int, string](WithMaxEntriesOf[int, string](1), WithOverflowOf[int, string](store))
+	defer c.Close()
+
+	c.Set(1, "a", NoExpiration)
+	c.Set(2, "b", NoExpiration)
+	if v, ok := c.Get(1); !ok || v != "a" {
+		t.Fatalf("expected 1 to be reloaded, got: %v, %v", v, ok)
+	}
+	if v, ok := c.Get(2); !ok || v != "b" {
+		t.Fatalf("expected 2 to be reloaded, got: %v, %v", v, ok)
+	}
+	c.Set(1, "c", NoExpiration)
+	c.Clear()
+	if _, ok := c.Get(1); ok {
+		t.Fatal("1 should be cleared")
+	}
+	if _, ok := c.Get(2); ok {
+		t.Fatal("the store should be cleared by Clear")
+	}
+}
+
+func TestCacheOf_Overflow_Background(t *testing.T) {
+	store, err := NewDiskStoreOf[int, string](t.TempDir(), SnapshotJSON, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := NewOf[int, string](WithMaxEntriesOf[int, string](1), WithOverflowOf[int, string](store))
+	defer c.Close()
+	o := c.(*xsyncMapOfWrapper[int, string]).overflow
+
+	// reloaded from memory until written
+	o.drainMu.Lock()
+	c.Set(1, "a", NoExpiration)
+	c.Set(2, "b", NoExpiration)
+	if v, ok := c.Get(1); !ok || v != "a" {
+		t.Fatalf("expected 1 to be reloaded, got: %v, %v", v, ok)
+	}
+	o.drainMu.Unlock()
+	o.drain()
+	if v, _, ok, _ := store.Get(2); !ok || v != "b" {
+		t.Fatalf("expected 2 to be written, got: %v, %v", v, ok)
+	}
+	if _, _, ok, _ := store.Get(1); ok {
+		t.Fatal("the reloaded 1 should not be written")
+	}
+
+	// the expired values are deleted from the store
+	c.Set(3, "c", 50*time.Millisecond)
+	c.Set(4, "d", NoExpiration)
+	o.drain()
+	if _, _, ok, _ := store.Get(3); !ok {
+		t.Fatal("expected 3 to be written")
+	}
+	time.Sleep(60 * time.Millisecond)
+	c.DeleteExpired()
+	o.drain()
+	if _, ok := o.keys.Load(3); ok {
+		t.Fatal("the expired 3 should be deleted")
+	}
+	if _, err := os.Stat(store.dir.path("3")); !os.IsNotExist(err) {
+		t.Fatalf("expected the file of 3 to be removed, got: %v", err)
+	}
+}
+
+func TestDiskStoreOf_SameHash(t *testing.T) {
+	s, err := NewDiskStoreOf[int, string](t.TempDir(), SnapshotJSON, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Set(1, "a", NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	// 2 in the file of 1, as if their hashes collided
+	err = s.dir.update("1", func(data []byte) ([]byte, error) {
+		items, err := s.decode(data)
+		if err != nil {
+			return nil, err
+		}
+		return s.encode(append(items, snapshotItemOf[int, string]{K: 2, V: "b"}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _, ok, _ := s.Get(1); !ok || v != "a" {
+		t.Fatalf("expected a, got: %v, %v", v, ok)
+	}
+	if err := s.Set(1, "c", NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.dir.read("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := s.decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []snapshotItemOf[int, string]{{K: 2, V: "b"}}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+}
+
+func TestDiskStoreOf_MaxSize(t *testing.T) {
+	s, err := NewDiskStoreOf[int, string](t.TempDir(), SnapshotJSON, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Set(1, "a", NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(2, strings.Repeat("b", 64), NoExpiration); err != ErrDiskStoreFull {
+		t.Fatalf("expected ErrDiskStoreFull, got: %v", err)
+	}
+	if _, _, ok, _ := s.Get(2); ok {
+		t.Fatal("2 should not be stored")
+	}
+	if err := s.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	if s.dir.size != 0 {
+		t.Fatalf("expected the size to be 0, got: %d", s.dir.size)
+	}
+	if err := s.Set(2, strings.Repeat("b", 32), NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheOf_WriteThrough_Compute(t *testing.T) {
+	store := make(map[int]string)
+	c := NewOf[int, string](WithWriteThroughOf[int, string](func(k int, v string) error {
+		store[k] = v
+		return nil
+	}))
+	defer c.Close()
+
+	c.Compute(1, func(string, bool) (string, bool) { return "a", false }, NoExpiration)
+	c.Compute(1, func(old string, _ bool) (string, bool) { return old + "b", false }, NoExpiration)
+	c.ComputeWithOp(2, func(string, bool) (string, ComputeOp) { return "c", CancelOp }, NoExpiration)
+	c.Compute(3, func(string, bool) (string, bool) { return "d", true }, NoExpiration)
+	c.GetOrCompute(4, func() string { return "e" }, NoExpiration)
+	c.GetOrCompute(4, func() string { return "f" }, NoExpiration)
+	if want := map[int]string{1: "ab", 4: "e"}; !reflect.DeepEqual(store, want) {
+		t.Fatalf("expected %v, got: %v", want, store)
+	}
+
+	// the deletions are not propagated
+	c.Compute(1, func(string, bool) (string, bool) { return "", true }, NoExpiration)
+	if want := map[int]string{1: "ab", 4: "e"}; !reflect.DeepEqual(store, want) {
+		t.Fatalf("expected %v, got: %v", want, store)
+	}
+}
+
+func TestCacheOf_WriteThrough_Loaded(t *testing.T) {
+	store := make(map[int]string)
+	c := NewOf[int, string](WithWriteThroughOf[int, string](func(k int, v string) error {
+		store[k] = v
+		return nil
+	}), WithLoaderOf[int, string](func(_ context.Context, k int) (string, time.Duration, error) {
+		return strconv.Itoa(k), NoExpiration, nil
+	}))
+	defer c.Close()
+
+	c.Get(1)
+	_, _, _ = c.GetOrLoad(2, func(k int) (string, error) { return "b", nil }, NoExpiration)
+	_ = c.Warmup(context.Background(), []int{3}, func(context.Context, int) (string, time.Duration, error) {
+		return "c", NoExpiration, nil
+	}, 1)
+	var buf bytes.Buffer
+	src := NewOf[int, string]()
+	defer src.Close()
+	src.SetForever(4, "d")
+	_ = src.SaveTo(&buf)
+	_ = c.LoadFrom(&buf, Overwrite)
+	c.LoadItemsWithExpiration(map[int]ItemWithExpirationOf[string]{5: {Value: "e"}}, Overwrite)
+	if n := c.Count(); n != 5 || len(store) != 0 {
+		t.Fatalf("expected the values loaded not to be written through, got %d items, %v", n, store)
+	}
+
+	c.SetForever(1, "a")
+	if want := map[int]string{1: "a"}; !reflect.DeepEqual(store, want) {
+		t.Fatalf("expected %v, got: %v", want, store)
+	}
+}
+
+func TestCacheOf_WriteThrough_SetE(t *testing.T) {
+	errWrite := errors.New("write failed")
+	store := make(map[int]string)
+	c := NewOf[int, string](WithWriteThroughOf[int, string](func(k int, v string) error {
+		if v == "" {
+			return errWrite
+		}
+		store[k] = v
+		return nil
+	}))
+	defer c.Close()
+
+	if err := c.SetE(1, "a", NoExpiration); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.SetE(1, "", NoExpiration); err != errWrite {
+		t.Fatalf("expected the write error, got: %v", err)
+	}
+	if v, ok := c.Get(1); !ok || v != "a" {
+		t.Fatalf("expected the value failing to be written not to be stored, got %q, %v", v, ok)
+	}
+	if err := c.SetE(2, "", NoExpiration); err != errWrite || c.Count() != 1 {
+		t.Fatalf("expected the write error and no new item, got: %v, %d items", err, c.Count())
+	}
+	if want := map[int]string{1: "a"}; !reflect.DeepEqual(store, want) {
+		t.Fatalf("expected %v, got: %v", want, store)
+	}
+}
+
+func TestCacheOf_WriteBehind(t *testing.T) {
+	store := make(map[int]string)
+	c := NewOf[int, string](WithWriteBehindOf[int, string](func(k int, v string) error {
+		store[k] = v
+		return nil
+	}, time.Hour))
+
+	c.Set(1, "a", NoExpiration)
+	c.Set(1, "b", NoExpiration)
+	c.GetAndSet(2, "c", NoExpiration)
+	if len(store) != 0 {
+		t.Fatalf("expected no writes before the flush, got: %v", store)
+	}
+	_ = c.Close()
+	if want := map[int]string{1: "b", 2: "c"}; !reflect.DeepEqual(store, want) {
+		t.Fatalf("expected %v, got: %v", want, store)
+	}
+}
+
+func TestCacheOf_WriteCoalescing(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	var (
+		mu    sync.Mutex
+		store = make(map[int]string)
+	)
+	c := NewOf[int, string](
+		WithClockOf[int, string](clock),
+		WithWriteBehindOf[int, string](func(k int, v string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			store[k] = v
+			return nil
+		}, time.Second),
+		WithWriteCoalescingOf[int, string](time.Minute),
+	)
+
+	c.Set(1, "a", NoExpiration)
+	clock.Advance(30 * time.Second)
+	c.Set(1, "b", NoExpiration)
+	c.Set(2, "c", NoExpiration)
+	clock.Advance(30 * time.Second)
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(store)
+		mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	if want := map[int]string{1: "b"}; !reflect.DeepEqual(store, want) {
+		t.Fatalf("expected %v, got: %v", want, store)
+	}
+	mu.Unlock()
+	_ = c.Close()
+	if want := map[int]string{1: "b", 2: "c"}; !reflect.DeepEqual(store, want) {
+		t.Fatalf("expected %v, got: %v", want, store)
+	}
+}
+
+func TestCacheOf_Loader(t *testing.T) {
+	c := NewOf[int, string](WithLoaderOf[int, string](func(_ context.Context, k int) (string, time.Duration, error) {
+		return strconv.Itoa(k), NoExpiration, nil
+	}))
+	defer c.Close()
+
+	if v, ok := c.Get(1); !ok || v != "1" {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+	items := c.GetMultiple([]int{1, 2})
+	if want := map[int]string{1: "1", 2: "2"}; !reflect.DeepEqual(items, want) {
+		t.Fatalf("expected %v, got: %v", want, items)
+	}
+}
+
+func TestCacheOf_StaleWhileRevalidate(t *testing.T) {
+	loaded := make(chan struct{}, 1)
+	c := NewOf[int, int](
+		WithCleanupIntervalOf[int, int](0),
+		WithLoaderOf[int, int](func(_ context.Context, k int) (int, time.Duration, error) {
+			defer func() { loaded <- struct{}{} }()
+			return k, 10 * time.Millisecond, errors.New("unavailable")
+		}),
+		WithStaleWhileRevalidateOf[int, int](20*time.Millisecond),
+	)
+	defer c.Close()
+
+	c.Set(1, 1, 10*time.Millisecond)
+	time.Sleep(15 * time.Millisecond)
+	if v, ok := c.Get(1); !ok || v != 1 {
+		t.Fatalf("expected the stale value, got: %v, %v", v, ok)
+	}
+	<-loaded
+	time.Sleep(20 * time.Millisecond)
+	c.DeleteExpired()
+	if c.Count() != 0 {
+		t.Fatal("the stale item should be deleted after its grace period")
+	}
+}
+
+func TestCacheOf_StaleWhileRevalidate_Concurrent(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	c := NewOf[int, int](
+		WithCleanupIntervalOf[int, int](0),
+		WithLoaderOf[int, int](func(_ context.Context, k int) (int, time.Duration, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return k, time.Minute, errors.New("unavailable")
+		}),
+		WithStaleWhileRevalidateOf[int, int](time.Minute),
+	)
+	defer c.Close()
+
+	c.Set(1, 1, 10*time.Millisecond)
+	time.Sleep(15 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := c.Get(1); !ok || v != 1 {
+				t.Errorf("expected the stale value, got: %v, %v", v, ok)
+			}
+		}()
+	}
+	wg.Wait()
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("expected a single reload for the concurrent reads, got %d", n)
+	}
+}
+
+func TestCacheOf_RefreshAhead(t *testing.T) {
+	loaded := make(chan int, 1)
+	c := NewOf[int, int](
+		WithLoaderOf[int, int](func(_ context.Context, k int) (int, time.Duration, error) {
+			loaded <- k
+			return k * 10, time.Minute, nil
+		}),
+		WithRefreshAheadOf[int, int](0.9),
+	)
+	defer c.Close()
+
+	c.Set(1, 1, 10*time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if v, ok := c.Get(1); !ok || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+	if k := <-loaded; k != 1 {
+		t.Fatalf("expected 1 to be refreshed, got %d", k)
+	}
+}
+
+func TestCacheOf_RefreshAhead_Concurrent(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	c := NewOf[int, int](
+		WithLoaderOf[int, int](func(_ context.Context, k int) (int, time.Duration, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return k, time.Minute, errors.New("unavailable")
+		}),
+		WithRefreshAheadOf[int, int](0.9),
+	)
+	defer c.Close()
+
+	c.Set(1, 1, time.Second)
+	time.Sleep(150 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := c.Get(1); !ok || v != 1 {
+				t.Errorf("expected the current value, got: %v, %v", v, ok)
+			}
+		}()
+	}
+	wg.Wait()
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("expected a single refresh for the concurrent reads, got %d", n)
+	}
+}
+
+func TestCacheOf_TTLJitter(t *testing.T) {
+	c := NewOf[int, int](WithTTLJitterOf[int, int](0.2), WithDefaultExpirationOf[int, int](time.Hour))
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.SetDefault(i, i)
+		if _, ttl, _ := c.GetWithTTL(i); ttl < 47*time.Minute || ttl > 73*time.Minute {
+			t.Fatalf("expected a lifetime within 1h ±20%%, got %v", ttl)
+		}
+	}
+}
+
+func TestCacheOf_ExpirePersistTouch(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+
+	c.SetForever("a", 1)
+	if !c.Expire("a", time.Minute) {
+		t.Fatal("a should be found")
+	}
+	if v, ttl, ok := c.GetWithTTL("a"); !ok || v != 1 || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected result: %v, %v, %v", v, ttl, ok)
+	}
+	if !c.Touch("a") {
+		t.Fatal("a should be found")
+	}
+	if _, ttl, _ := c.GetWithTTL("a"); ttl != NoExpiration {
+		t.Fatalf("expected the default expiration, got %v", ttl)
+	}
+
+	ns := NamespaceOf[int](c, "ns:")
+	ns.Set("b", 2, time.Minute)
+	if !ns.Persist("b") || ns.Persist("c") {
+		t.Fatal("unexpected result of Persist")
+	}
+}
+
+func TestCacheOf_SetExpirationFunc(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+
+	c.SetForever("a", 1)
+	c.SetForever("b", 2)
+	if n := c.SetExpirationForAll(time.Hour); n != 2 {
+		t.Fatalf("expected 2 items updated, got %d", n)
+	}
+	n := c.SetExpirationFunc(func(k string, v int) time.Duration {
+		return time.Duration(v) * time.Minute
+	})
+	if n != 2 {
+		t.Fatalf("expected 2 items updated, got %d", n)
+	}
+	if _, ttl, _ := c.GetWithTTL("b"); ttl <= time.Minute || ttl > 2*time.Minute {
+		t.Fatalf("expected 2 minutes, got %v", ttl)
+	}
+
+	ns := NamespaceOf[int](c, "ns:")
+	ns.SetForever("a", 1)
+	if n := ns.SetExpirationForAll(time.Second); n != 1 {
+		t.Fatalf("expected 1 item of the namespace updated, got %d", n)
+	}
+}
+
+func TestCacheOf_CompareAndSwap(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, _ := c.GetOrSet("n", 0, NoExpiration)
+				if c.CompareAndSwap("n", v, v+1, NoExpiration) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := c.Get("n"); v != 100 {
+		t.Fatalf("expected 100, got %v", v)
+	}
+	if c.CompareAndDelete("n", 99) || !c.CompareAndDelete("n", 100) {
+		t.Fatal("n should be deleted once")
+	}
+
+	ns := NamespaceOf[int](c, "ns:")
+	ns.SetForever("a", 1)
+	if !ns.CompareAndSwap("a", 1, 2, NoExpiration) {
+		t.Fatal("a should be swapped")
+	}
+}
+
+func TestCacheOf_CompareAndSwapFunc(t *testing.T) {
+	c := NewOf[string, []int]()
+	defer c.Close()
+	equal := func(a, b []int) bool { return reflect.DeepEqual(a, b) }
+
+	c.SetForever("a", []int{1})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic comparing uncomparable values")
+			}
+		}()
+		c.CompareAndSwap("a", []int{1}, []int{2}, NoExpiration)
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.SetForever("a", []int{1})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the key should be unlocked after the panic")
+	}
+
+	if c.CompareAndSwapFunc("a", []int{2}, []int{3}, equal, NoExpiration) {
+		t.Fatal("expected no swap of a different value")
+	}
+	if !c.CompareAndSwapFunc("a", []int{1}, []int{2}, equal, NoExpiration) {
+		t.Fatal("expected a swap of an equal value")
+	}
+	if v, _ := c.Get("a"); !equal(v, []int{2}) {
+		t.Fatalf("expected [2], got %v", v)
+	}
+	if c.CompareAndDeleteFunc("a", []int{1}, equal) || !c.CompareAndDeleteFunc("a", []int{2}, equal) {
+		t.Fatal("expected the equal value only to be deleted")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a to be deleted")
+	}
+}
+
+func TestCacheOf_CompareAndSwap_Expired(t *testing.T) {
+	c := NewOf[string, int](WithCleanupIntervalOf[string, int](0), WithMaxCostOf[string, int](100))
+	defer c.Close()
+
+	var called int32
+	fn := func(k string, v int) { atomic.AddInt32(&called, 1) }
+	c.SetWithCallback("a", 1, time.Millisecond, fn)
+	c.SetWithCallback("b", 2, time.Millisecond, fn)
+	c.SetWithTags("c", 3, time.Millisecond, "t")
+	time.Sleep(2 * time.Millisecond)
+
+	if c.CompareAndSwap("a", 1, 2, NoExpiration) {
+		t.Fatal("the expired item should not be swapped")
+	}
+	if c.Touch("b") {
+		t.Fatal("the expired item should not be touched")
+	}
+	if c.CompareAndDelete("c", 3) {
+		t.Fatal("the expired item should not be deleted")
+	}
+	x := c.(*xsyncMapOfWrapper[string, int])
+	x.evictor.mu.Lock()
+	n, cost := x.evictor.queue.len(), x.evictor.total
+	x.evictor.mu.Unlock()
+	if n != 0 || cost != 0 {
+		t.Fatalf("expected the evictor to forget the expired keys, got %d keys of cost %d", n, cost)
+	}
+	if len(x.tags.keys) != 0 {
+		t.Fatalf("expected an empty tag index, got: %v", x.tags.keys)
+	}
+	for i := 0; atomic.LoadInt32(&called) != 2; i++ {
+		if i == 100 {
+			t.Fatalf("expected the callbacks of the expired items to be called, got %d", atomic.LoadInt32(&called))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCacheOf_SetIfVersion(t *testing.T) {
+	c := NewOf[string, int](WithCleanupIntervalOf[string, int](0))
+	defer c.Close()
+
+	if !c.SetIfVersion("a", 1, 0, NoExpiration) || c.SetIfVersion("a", 2, 0, NoExpiration) {
+		t.Fatal("a should be stored once at the version 0")
+	}
+	v, version, ok := c.GetWithVersion("a")
+	if !ok || v != 1 || version == 0 {
+		t.Fatalf("unexpected result: %v, %v, %v", v, version, ok)
+	}
+	c.Set("a", 2, NoExpiration)
+	if c.SetIfVersion("a", 3, version, NoExpiration) {
+		t.Fatal("a should not be stored at a stale version")
+	}
+	_, version, _ = c.GetWithVersion("a")
+	if !c.SetIfVersion("a", 3, version, NoExpiration) {
+		t.Fatal("a should be stored at its version")
+	}
+	v, version, _ = c.GetWithVersion("a")
+	if v != 3 {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if _, again, _ := c.GetWithVersion("a"); again != version {
+		t.Fatalf("the version should not change on a read: %v, %v", again, version)
+	}
+
+	// an update in place is a write
+	IncrementOf[string, int](c, "a", 1)
+	if c.SetIfVersion("a", 5, version, NoExpiration) {
+		t.Fatal("a should not be stored at the version before the increment")
+	}
+	if v, next, _ := c.GetWithVersion("a"); v != 4 || next <= version {
+		t.Fatalf("unexpected result: %v, %v", v, next)
+	}
+}
+
+func TestCacheOf_SetIfAbsentPresent(t *testing.T) {
+	c := NewOf[string, int]()
+	defer c.Close()
+
+	if c.SetIfPresent("a", 1, NoExpiration) {
+		t.Fatal("a should not be stored")
+	}
+	if !c.SetIfAbsent("a", 1, NoExpiration) || c.SetIfAbsent("a", 2, NoExpiration) {
+		t.Fatal("a should be stored once")
+	}
+	if !c.SetIfPresent("a", 3, time.Minute) {
+		t.Fatal("a should be replaced")
+	}
+	if v, ttl, _ := c.GetWithTTL("a"); v != 3 || ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("unexpected result: %v, %v", v, ttl)
+	}
+
+	ns := NamespaceOf[int](c, "ns:")
+	if !ns.SetIfAbsent("a", 1, NoExpiration) || !ns.SetIfPresent("a", 2, NoExpiration) {
+		t.Fatal("a should be stored")
+	}
+}
+
+func TestIncrementOf(t *testing.T) {
+	c := NewOf[string, int64]()
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			IncrementOf(c, "n", 2)
+		}()
+	}
+	wg.Wait()
+	if v := DecrementOf(c, "n", 1); v != 199 {
+		t.Fatalf("expected 199, got %v", v)
+	}
+
+	c.Set("m", 10, time.Minute)
+	if v := DecrementOf(c, "m", 20); v != -10 {
+		t.Fatalf("expected -10, got %v", v)
+	}
+	if _, ttl, _ := c.GetWithTTL("m"); ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("the expiration time should be kept, got %v", ttl)
+	}
+
+	ns := NamespaceOf[int64](c, "ns:")
+	if v := IncrementOf[string, int64](ns, "n", 3); v != 3 {
+		t.Fatalf("expected 3, got %v", v)
+	}
+}
+
+func TestAppendSliceOfMergeMapOf(t *testing.T) {
+	s := NewOf[string, []string]()
+	defer s.Close()
+	s.Set("a", []string{"x"}, time.Minute)
+	old, _ := s.Get("a")
+	if r := AppendSliceOf(s, "a", "y", "z"); len(r) != 3 || r[2] != "z" {
+		t.Fatalf("unexpected slice: %v", r)
+	}
+	if len(old) != 1 {
+		t.Fatalf("the old slice should be unchanged, got %v", old)
+	}
+	if _, ttl, _ := s.GetWithTTL("a"); ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("the expiration time should be kept, got %v", ttl)
+	}
+
+	m := NewOf[string, map[string]int]()
+	defer m.Close()
+	MergeMapOf(m, "a", map[string]int{"x": 1, "y": 2})
+	if r := MergeMapOf(m, "a", map[string]int{"y": 3}); len(r) != 2 || r["x"] != 1 || r["y"] != 3 {
+		t.Fatalf("unexpected map: %v", r)
+	}
+}
+
+type mapBackendOf[K comparable, V any] struct {
+	sync.Mutex
+	items map[K]V
+}
+
+func (b *mapBackendOf[K, V]) Get(k K) (V, time.Duration, bool, error) {
+	b.Lock()
+	defer b.Unlock()
+	v, ok := b.items[k]
+	return v, NoExpiration, ok, nil
+}
+
+func (b *mapBackendOf[K, V]) Set(k K, v V, _ time.Duration) error {
+	b.Lock()
+	defer b.Unlock()
+	b.items[k] = v
+	return nil
+}
+
+func (b *mapBackendOf[K, V]) Delete(k K) error {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.items, k)
+	return nil
+}
+
+func TestTieredOf(t *testing.T) {
+	l1 := NewOf[int, string]()
+	defer l1.Close()
+	l2 := &mapBackendOf[int, string]{items: map[int]string{2: "b"}}
+	c := NewTieredOf[int, string](l1, l2, WithTieredMode(TieredWriteBehind))
+
+	_ = c.Set(1, "a", NoExpiration)
+	if v, ok, err := c.Get(2); err != nil || !ok || v != "b" {
+		t.Fatalf("unexpected result: %v, %v, %v", v, ok, err)
+	}
+	if v, ok := c.L1().Get(2); !ok || v != "b" {
+		t.Fatal("2 should be promoted to l1")
+	}
+	if v, ok, _ := c.Get(3); ok || v != "" {
+		t.Fatalf("expected a miss, got: %v", v)
+	}
+	_ = c.Close()
+	if v := l2.items[1]; v != "a" {
+		t.Fatalf("expected the write to be flushed, got: %v", v)
+	}
+}
+
+// gatedBackendOf returns the values read once the gate is closed.
+type gatedBackendOf[K comparable, V any] struct {
+	*mapBackendOf[K, V]
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (b *gatedBackendOf[K, V]) Get(k K) (V, time.Duration, bool, error) {
+	v, ttl, ok, err := b.mapBackendOf.Get(k)
+	close(b.started)
+	<-b.gate
+	return v, ttl, ok, err
+}
+
+func TestTieredOf_Promotion(t *testing.T) {
+	l1 := NewOf[int, string]()
+	defer l1.Close()
+	l2 := &gatedBackendOf[int, string]{
+		mapBackendOf: &mapBackendOf[int, string]{items: map[int]string{1: "a"}},
+		started:      make(chan struct{}),
+		gate:         make(chan struct{}),
+	}
+	c := NewTieredOf[int, string](l1, l2)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _ = c.Get(1)
+	}()
+	<-l2.started
+	_ = c.Delete(1)
+	close(l2.gate)
+	<-done
+	if v, ok := l1.Get(1); ok {
+		t.Fatalf("the deleted key should not be promoted, got %v", v)
+	}
+}
+
+type memTransportOf[K comparable, V any] struct {
+	sync.Mutex
+	handlers []func(msg ReplicationMessageOf[K, V])
+}
+
+func (t *memTransportOf[K, V]) Publish(msg ReplicationMessageOf[K, V]) error {
+	t.Lock()
+	handlers := t.handlers
+	t.Unlock()
+	for _, h := range handlers {
+		if h != nil {
+			h(msg)
+		}
+	}
+	return nil
+}
+
+func (t *memTransportOf[K, V]) Subscribe(handle func(msg ReplicationMessageOf[K, V])) (func(), error) {
+	t.Lock()
+	defer t.Unlock()
+	i := len(t.handlers)
+	t.handlers = append(t.handlers, handle)
+	return func() {
+		t.Lock()
+		t.handlers = append([]func(msg ReplicationMessageOf[K, V]){}, t.handlers...)
+		t.handlers[i] = nil
+		t.Unlock()
+	}, nil
+}
+
+func TestReplicatedOf(t *testing.T) {
+	tr := &memTransportOf[int, string]{}
+	c1 := NewOf[int, string]()
+	defer c1.Close()
+	c2 := NewOf[int, string]()
+	defer c2.Close()
+	r1, err := NewReplicatedOf[int, string](c1, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := NewReplicatedOf[int, string](c2, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	_ = r1.Set(1, "a", time.Minute)
+	if v, ttl, ok := c2.GetWithTTL(1); !ok || v != "a" || ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("1 should be replicated, got: %v, %v, %v", v, ttl, ok)
+	}
+	_ = r2.Delete(1)
+	if _, ok := r1.Get(1); ok {
+		t.Fatal("the deletion of 1 should be replicated")
+	}
+}
+
+func TestShardedOf(t *testing.T) {
+	s := NewShardedOf[int, int](0, WithCleanupIntervalOf[int, int](0))
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		s.Set(i, i, time.Hour)
+	}
+	if v, ok := s.Get(42); !ok || v != 42 {
+		t.Fatalf("expected 42, got %d", v)
+	}
+	if v, ok := s.Shard(42).Get(42); !ok || v != 42 {
+		t.Fatalf("expected the key in its shard, got %d", v)
+	}
+	if v, ok := s.GetAndDelete(42); !ok || v != 42 {
+		t.Fatalf("expected 42, got %d", v)
+	}
+	if n := s.Count(); n != 99 {
+		t.Fatalf("expected 99 items, got %d", n)
+	}
+	sum := 0
+	s.Range(func(k, v int) bool {
+		sum += v
+		return true
+	})
+	if sum != 4950-42 {
+		t.Fatalf("expected the range to cover all the shards, got %d", sum)
+	}
+}
+
+func TestShardedOf_Limits(t *testing.T) {
+	s := NewShardedOf[int, int](4, WithCleanupIntervalOf[int, int](0), WithMaxEntriesOf[int, int](100))
+	defer s.Close()
+	for i := 0; i < 1000; i++ {
+		s.Set(i, i, NoExpiration)
+	}
+	if n := s.Count(); n == 0 || n > 100 {
+		t.Fatalf("expected at most 100 items across the shards, got %d", n)
+	}
+}
+
+func TestCacheOf_WithRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := NewOf[int, string](WithRegistryOf[int, string](r, "c"))
+	x, ok := r.Lookup("c")
+	if !ok {
+		t.Fatal("c should be registered")
+	}
+	if _, ok := x.(CacheOf[int, string]); !ok {
+		t.Fatalf("unexpected type: %T", x)
+	}
+	_ = c.Close()
+	if _, ok := r.Lookup("c"); ok {
+		t.Fatal("c should be unregistered on Close")
+	}
+
+	// the registered handle is the normalizing view
+	n := NewOf[string, int](WithRegistryOf[string, int](r, "n"), WithKeyNormalizerOf[string, int](strings.ToLower))
+	if x, _ := r.Lookup("n"); x != n {
+		t.Fatalf("expected the normalizing view to be registered, got %T", x)
+	}
+	_ = n.Close()
+	if _, ok := r.Lookup("n"); ok {
+		t.Fatal("n should be unregistered on Close")
+	}
+}
+
+func TestCacheOf_WithMaxEntries_Concurrent(t *testing.T) {
+	const max = 100
+	c := NewOf[int, int](WithMaxEntriesOf[int, int](max))
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := (g*7919 + i*31) % 500
+				c.SetForever(k, i)
+				c.Get(k / 2)
+			}
+		}(g)
+	}
+	wg.Wait()
+	// back within the bound once the writes have returned
+	if n := c.Count(); n > max {
+		t.Fatalf("expected at most %d items, got %d", max, n)
+	}
+}
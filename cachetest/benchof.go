@@ -0,0 +1,110 @@
+//go:build go1.18
+// +build go1.18
+
+package cachetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fufuok/cache"
+)
+
+// benchmarkTTL the time to live of the items stored by the benchmarks, longer than any run.
+const benchmarkTTL = time.Hour
+
+// BenchmarkGetWithTTLOf benchmarks the parallel GetWithTTL of the keys, stored in c beforehand
+// with the values returned by value, e.g. to compare the key and value types of a workload.
+func BenchmarkGetWithTTLOf[K comparable, V any](b *testing.B, c cache.CacheOf[K, V], keys []K, value func(k K) V) {
+	b.Helper()
+	for _, k := range keys {
+		c.Set(k, value(k), benchmarkTTL)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			_, _, _ = c.GetWithTTL(keys[i%len(keys)])
+		}
+	})
+}
+
+// BenchmarkGetAndRefreshOf benchmarks the parallel GetAndRefresh of the keys, stored in c beforehand
+// with the values returned by value, each read extending the lifetime of its item.
+func BenchmarkGetAndRefreshOf[K comparable, V any](b *testing.B, c cache.CacheOf[K, V], keys []K, value func(k K) V) {
+	b.Helper()
+	for _, k := range keys {
+		c.Set(k, value(k), benchmarkTTL)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			_, _ = c.GetAndRefresh(keys[i%len(keys)], benchmarkTTL)
+		}
+	})
+}
+
+// BenchmarkComputeOf benchmarks the parallel Computes of the keys storing the values returned
+// by value, the fewer the keys, the more the goroutines contend for them.
+func BenchmarkComputeOf[K comparable, V any](b *testing.B, c cache.CacheOf[K, V], keys []K, value func(k K) V) {
+	b.Helper()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			k := keys[i%len(keys)]
+			_, _ = c.Compute(k, func(V, bool) (V, bool) {
+				return value(k), false
+			}, benchmarkTTL)
+		}
+	})
+}
+
+// BenchmarkDeleteExpiredOf benchmarks the DeleteExpired of caches created by newCache, holding
+// the keys with the values returned by value, the expiredRatio of them having expired.
+// The caches are filled outside of the timer, each DeleteExpired is an iteration.
+func BenchmarkDeleteExpiredOf[K comparable, V any](
+	b *testing.B,
+	newCache func() cache.CacheOf[K, V],
+	keys []K,
+	value func(k K) V,
+	expiredRatio float64,
+) {
+	b.Helper()
+	expired := int(float64(len(keys)) * expiredRatio)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		c := newCache()
+		for i, k := range keys {
+			d := benchmarkTTL
+			if i < expired {
+				d = time.Nanosecond
+			}
+			c.Set(k, value(k), d)
+		}
+		time.Sleep(time.Microsecond)
+		b.StartTimer()
+		c.DeleteExpired()
+		b.StopTimer()
+		_ = c.Close()
+		b.StartTimer()
+	}
+}
+
+// BenchmarkSetEvictingOf benchmarks the parallel Sets of the keys with the values returned
+// by value, in a cache c bounded below the number of keys, e.g. by WithMaxEntriesOf, so that
+// most Sets evict an item, to measure the overhead of its eviction and admission policies.
+func BenchmarkSetEvictingOf[K comparable, V any](b *testing.B, c cache.CacheOf[K, V], keys []K, value func(k K) V) {
+	b.Helper()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			k := keys[i%len(keys)]
+			c.Set(k, value(k), benchmarkTTL)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+//go:build go1.18
+// +build go1.18
+
+package cachetest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/fufuok/cache"
+)
+
+const benchmarkNumKeys = 10_000
+
+func benchmarkKeys(n int) []int {
+	keys := make([]int, n)
+	for i := range keys {
+		keys[i] = i
+	}
+	return keys
+}
+
+func benchmarkValue(k int) string {
+	return strconv.Itoa(k)
+}
+
+func BenchmarkCacheOf_GetWithTTL(b *testing.B) {
+	c := cache.NewOf[int, string]()
+	defer c.Close()
+	BenchmarkGetWithTTLOf(b, c, benchmarkKeys(benchmarkNumKeys), benchmarkValue)
+}
+
+func BenchmarkCacheOf_GetAndRefresh(b *testing.B) {
+	c := cache.NewOf[int, string]()
+	defer c.Close()
+	BenchmarkGetAndRefreshOf(b, c, benchmarkKeys(benchmarkNumKeys), benchmarkValue)
+}
+
+func BenchmarkCacheOf_Compute(b *testing.B) {
+	for _, n := range []int{1, 16, benchmarkNumKeys} {
+		b.Run(strconv.Itoa(n)+"-keys", func(b *testing.B) {
+			c := cache.NewOf[int, string]()
+			defer c.Close()
+			BenchmarkComputeOf(b, c, benchmarkKeys(n), benchmarkValue)
+		})
+	}
+}
+
+func BenchmarkCacheOf_DeleteExpired(b *testing.B) {
+	newCache := func() cache.CacheOf[int, string] {
+		return cache.NewOf[int, string](cache.WithCleanupIntervalOf[int, string](0))
+	}
+	for _, ratio := range []float64{0.01, 0.1, 0.5, 1} {
+		b.Run(strconv.FormatFloat(ratio*100, 'f', -1, 64)+"%-expired", func(b *testing.B) {
+			BenchmarkDeleteExpiredOf(b, newCache, benchmarkKeys(benchmarkNumKeys), benchmarkValue, ratio)
+		})
+	}
+}
+
+func BenchmarkCacheOf_SetEvicting(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []cache.OptionOf[int, string]
+	}{
+		{"LRU", nil},
+		{"LFU", []cache.OptionOf[int, string]{cache.WithEvictionPolicyOf[int, string](cache.LFU)}},
+		{"TinyLFU", []cache.OptionOf[int, string]{cache.WithAdmissionPolicyOf[int, string](cache.TinyLFU)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			opts := append([]cache.OptionOf[int, string]{
+				cache.WithMaxEntriesOf[int, string](benchmarkNumKeys / 10),
+			}, bc.opts...)
+			c := cache.NewOf[int, string](opts...)
+			defer c.Close()
+			BenchmarkSetEvictingOf(b, c, benchmarkKeys(benchmarkNumKeys), benchmarkValue)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Package cachetest checks in the tests and benchmarks of the users of the cache that its reads,
+// with their options, do not allocate, e.g. to gate a CI on the allocations of the hot paths:
+//
+//	func TestCacheGet(t *testing.T) {
+//		c := cache.New(opts...)
+//		c.SetForever("k", v)
+//		cachetest.AssertZeroAllocGet(t, c, "k")
+//	}
+//
+//	func BenchmarkCacheGet(b *testing.B) {
+//		c := cache.New(opts...)
+//		c.SetForever("k", v)
+//		cachetest.BenchmarkGet(b, c, "k")
+//	}
+//
+// It also benchmarks the operations on the expiration of the items against the key and value
+// types of a workload: GetWithTTL, GetAndRefresh, Compute under contention, DeleteExpired
+// at various ratios of expired items, and the Sets evicting items under a capacity.
+package cachetest
+
+import (
+	"testing"
+
+	"github.com/fufuok/cache"
+)
+
+// allocsRuns the number of calls measured by AllocsPerGet.
+const allocsRuns = 100
+
+// AllocsPerGet returns the average number of allocations of a Get of the key k.
+func AllocsPerGet(c cache.Cache, k string) float64 {
+	return testing.AllocsPerRun(allocsRuns, func() {
+		_, _ = c.Get(k)
+	})
+}
+
+// AssertZeroAllocGet reports an error to tb if a Get of the key k allocates.
+func AssertZeroAllocGet(tb testing.TB, c cache.Cache, k string) {
+	tb.Helper()
+	if n := AllocsPerGet(c, k); n > 0 {
+		tb.Errorf("cachetest: Get(%q) allocates %v times per call, expected 0", k, n)
+	}
+}
+
+// BenchmarkGet benchmarks the parallel Gets of the key k, reporting the allocations,
+// and fails the benchmark if a Get allocates, see AssertZeroAllocGet.
+func BenchmarkGet(b *testing.B, c cache.Cache, k string) {
+	b.Helper()
+	AssertZeroAllocGet(b, c, k)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = c.Get(k)
+		}
+	})
+}
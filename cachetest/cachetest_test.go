@@ -0,0 +1,46 @@
+package cachetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fufuok/cache"
+)
+
+func TestAssertZeroAllocGet(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []cache.Option
+	}{
+		{"default", nil},
+		{"max entries", []cache.Option{cache.WithMaxEntries(100)}},
+		{"tinylfu", []cache.Option{cache.WithMaxEntries(100), cache.WithAdmissionPolicy(cache.TinyLFU)}},
+		{"consistent snapshots", []cache.Option{cache.WithConsistentSnapshots()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := cache.New(tc.opts...)
+			defer c.Close()
+			c.SetForever("forever", 1)
+			c.Set("ttl", "v", time.Hour)
+			AssertZeroAllocGet(t, c, "forever")
+			AssertZeroAllocGet(t, c, "ttl")
+			AssertZeroAllocGet(t, c, "missing")
+		})
+	}
+}
+
+func TestAllocsPerGet(t *testing.T) {
+	c := cache.New(cache.WithSlidingExpiration())
+	defer c.Close()
+	c.Set("a", 1, time.Hour)
+	if n := AllocsPerGet(c, "a"); n == 0 {
+		t.Fatal("expected the sliding expiration to allocate")
+	}
+}
+
+func BenchmarkCache_Get(b *testing.B) {
+	c := cache.New()
+	defer c.Close()
+	c.SetForever("a", 1)
+	BenchmarkGet(b, c, "a")
+}
@@ -0,0 +1,39 @@
+//go:build go1.18
+// +build go1.18
+
+package cachetest
+
+import (
+	"testing"
+
+	"github.com/fufuok/cache"
+)
+
+// AllocsPerGetOf returns the average number of allocations of a Get of the key k.
+func AllocsPerGetOf[K comparable, V any](c cache.CacheOf[K, V], k K) float64 {
+	return testing.AllocsPerRun(allocsRuns, func() {
+		_, _ = c.Get(k)
+	})
+}
+
+// AssertZeroAllocGetOf reports an error to tb if a Get of the key k allocates.
+func AssertZeroAllocGetOf[K comparable, V any](tb testing.TB, c cache.CacheOf[K, V], k K) {
+	tb.Helper()
+	if n := AllocsPerGetOf(c, k); n > 0 {
+		tb.Errorf("cachetest: Get(%v) allocates %v times per call, expected 0", k, n)
+	}
+}
+
+// BenchmarkGetOf benchmarks the parallel Gets of the key k, reporting the allocations,
+// and fails the benchmark if a Get allocates, see AssertZeroAllocGetOf.
+func BenchmarkGetOf[K comparable, V any](b *testing.B, c cache.CacheOf[K, V], k K) {
+	b.Helper()
+	AssertZeroAllocGetOf(b, c, k)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = c.Get(k)
+		}
+	})
+}
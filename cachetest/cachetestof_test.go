@@ -0,0 +1,28 @@
+//go:build go1.18
+// +build go1.18
+
+package cachetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fufuok/cache"
+)
+
+func TestAssertZeroAllocGetOf(t *testing.T) {
+	c := cache.NewOf[string, int]()
+	defer c.Close()
+	c.SetForever("forever", 1)
+	c.Set("ttl", 2, time.Hour)
+	AssertZeroAllocGetOf(t, c, "forever")
+	AssertZeroAllocGetOf(t, c, "ttl")
+	AssertZeroAllocGetOf(t, c, "missing")
+}
+
+func BenchmarkCacheOf_Get(b *testing.B) {
+	c := cache.NewOf[string, int]()
+	defer c.Close()
+	c.SetForever("a", 1)
+	BenchmarkGetOf(b, c, "a")
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"sync"
+)
+
+// callbackDispatcher runs the evicted callbacks on a pool of workers, see WithAsyncCallbacks,
+// or inline without workers. The panics of the callbacks are passed to panics, see WithPanicHandler.
+type callbackDispatcher struct {
+	mu     sync.RWMutex
+	queue  chan func()
+	closed bool
+	panics PanicHandler
+}
+
+// newCallbackDispatcher starts the workers, tracked by wg, none if workers is less than 1.
+func newCallbackDispatcher(workers, queueSize int, panics PanicHandler, wg *sync.WaitGroup) *callbackDispatcher {
+	d := &callbackDispatcher{
+		panics: panics,
+	}
+	if workers < 1 {
+		return d
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	d.queue = make(chan func(), queueSize)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for fn := range d.queue {
+				recovering(d.panics, fn)
+			}
+		}()
+	}
+	return d
+}
+
+// do queues fn for the workers, waiting while the queue is full.
+// fn runs inline without workers or once closed.
+func (d *callbackDispatcher) do(fn func()) {
+	if d.queue == nil {
+		recovering(d.panics, fn)
+		return
+	}
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		recovering(d.panics, fn)
+		return
+	}
+	d.queue <- fn
+	d.mu.RUnlock()
+}
+
+// close stops the workers once the queued callbacks have run.
+func (d *callbackDispatcher) close() {
+	if d == nil || d.queue == nil {
+		return
+	}
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+}
@@ -0,0 +1,21 @@
+package cache
+
+import (
+	"time"
+)
+
+// CleanupReport the outcome of a pass deleting the expired items, see WithCleanupHook.
+type CleanupReport struct {
+	// Scanned the number of keys visited by the pass.
+	Scanned int
+
+	// Deleted the number of expired items deleted by the pass.
+	Deleted int
+
+	// Duration the time taken by the pass, including CallbackDuration.
+	Duration time.Duration
+
+	// CallbackDuration the time taken by the evicted callbacks and the item callbacks,
+	// or by queueing them with WithAsyncCallbacks.
+	CallbackDuration time.Duration
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock the time source of a cache, for the expiration of the items and the background
+// tickers, see WithClock. It lets the tests of the expiration use a FakeClock instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers the ticks of a Clock, like time.Ticker.
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// SystemClock the Clock of the time package, the default.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+// CoarseClock a Clock reading the time updated every millisecond by a single goroutine,
+// shared by all the caches using it, e.g. WithClock(CoarseClock) for a cache mostly read,
+// where time.Now on every Get is a measurable cost.
+// Its time lags up to a millisecond behind, so the items may live up to a millisecond longer.
+// It never goes backwards, it follows the monotonic clock since its first use,
+// and its goroutine runs from the first use until the program exits.
+// It is opt-in: the caches use SystemClock by default, which is only read for the items which expire.
+var CoarseClock Clock = &coarseClock{resolution: time.Millisecond}
+
+type coarseClock struct {
+	now        int64 // Unix nanoseconds, first for the 64-bit alignment of the atomic operations
+	resolution time.Duration
+	once       sync.Once
+}
+
+func (c *coarseClock) Now() time.Time {
+	c.once.Do(c.start)
+	return time.Unix(0, atomic.LoadInt64(&c.now))
+}
+
+func (c *coarseClock) NewTicker(d time.Duration) Ticker {
+	return SystemClock.NewTicker(d)
+}
+
+func (c *coarseClock) start() {
+	base := time.Now()
+	atomic.StoreInt64(&c.now, base.UnixNano())
+	go func() {
+		ticker := time.NewTicker(c.resolution)
+		for range ticker.C {
+			// the elapsed time is monotonic, the wall clock may jump
+			atomic.StoreInt64(&c.now, base.UnixNano()+int64(time.Since(base)))
+		}
+	}()
+}
+
+var _ Clock = (*FakeClock)(nil)
+
+// FakeClock a Clock whose time only moves with Advance, for tests.
+// The tickers fire once per Advance at most, whatever the number of periods elapsed, like time.Ticker.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock creates a clock stopped at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("cache: non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{
+		clock: c,
+		c:     make(chan time.Time, 1),
+		d:     d,
+		next:  c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires the tickers that are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if c.now.Before(t.next) {
+			continue
+		}
+		for !c.now.Before(t.next) {
+			t.next = t.next.Add(t.d)
+		}
+		select {
+		case t.c <- c.now:
+		default:
+			// drops the tick of a slow receiver, like time.Ticker
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	c     chan time.Time
+	d     time.Duration
+	next  time.Time
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, x := range t.clock.tickers {
+		if x == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
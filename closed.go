@@ -0,0 +1,17 @@
+package cache
+
+// ClosedMode what the operations of a cache do once it is closed, see WithClosedMode.
+type ClosedMode int
+
+const (
+	// ClosedAllow keeps the cache working after Close, without the cleanup loop, the default.
+	ClosedAllow ClosedMode = iota
+
+	// ClosedIgnore ignores the writes and misses the reads once the cache is closed,
+	// the methods returning an error return ErrClosed.
+	ClosedIgnore
+
+	// ClosedPanic panics with ErrClosed on any operation once the cache is closed,
+	// e.g. to catch the use of a closed cache in tests.
+	ClosedPanic
+)
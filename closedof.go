@@ -0,0 +1,179 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync/atomic"
+)
+
+// closedGuardOf guards the items of a cache closed in the ClosedIgnore and ClosedPanic modes.
+// It is sealed once Close has written the last snapshot. A nil closedGuardOf never seals.
+type closedGuardOf[K comparable, V any] struct {
+	MapOf[K, V]
+	mode   ClosedMode
+	sealed uint32
+}
+
+// newClosedGuardOf returns nil in ClosedAllow mode.
+func newClosedGuardOf[K comparable, V any](m MapOf[K, V], mode ClosedMode) *closedGuardOf[K, V] {
+	if mode != ClosedIgnore && mode != ClosedPanic {
+		return nil
+	}
+	return &closedGuardOf[K, V]{MapOf: m, mode: mode}
+}
+
+func (g *closedGuardOf[K, V]) seal() {
+	if g != nil {
+		atomic.StoreUint32(&g.sealed, 1)
+	}
+}
+
+// closed reports whether the operation must be skipped, it panics in ClosedPanic mode.
+func (g *closedGuardOf[K, V]) closed() bool {
+	if g == nil || atomic.LoadUint32(&g.sealed) == 0 {
+		return false
+	}
+	if g.mode == ClosedPanic {
+		panic(ErrClosed)
+	}
+	return true
+}
+
+// err returns ErrClosed if the cache is closed, see closed.
+func (g *closedGuardOf[K, V]) err() error {
+	if g.closed() {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (g *closedGuardOf[K, V]) Load(key K) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.Load(key)
+}
+
+func (g *closedGuardOf[K, V]) Store(key K, value V) {
+	if !g.closed() {
+		g.MapOf.Store(key, value)
+	}
+}
+
+func (g *closedGuardOf[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.LoadOrStore(key, value)
+}
+
+func (g *closedGuardOf[K, V]) LoadAndStore(key K, value V) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.LoadAndStore(key, value)
+}
+
+func (g *closedGuardOf[K, V]) Swap(key K, value V) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.Swap(key, value)
+}
+
+func (g *closedGuardOf[K, V]) LoadOrCompute(key K, valueFn func() V) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.LoadOrCompute(key, valueFn)
+}
+
+func (g *closedGuardOf[K, V]) LoadOrTryCompute(key K, valueFn func() (newValue V, cancel bool)) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.LoadOrTryCompute(key, valueFn)
+}
+
+func (g *closedGuardOf[K, V]) Compute(
+	key K,
+	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
+) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.Compute(key, valueFn)
+}
+
+func (g *closedGuardOf[K, V]) ComputeWithOp(
+	key K,
+	valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
+) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.ComputeWithOp(key, valueFn)
+}
+
+func (g *closedGuardOf[K, V]) UpdateIfPresent(key K, valueFn func(oldValue V) V) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.UpdateIfPresent(key, valueFn)
+}
+
+func (g *closedGuardOf[K, V]) LoadAndDelete(key K) (V, bool) {
+	if g.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	return g.MapOf.LoadAndDelete(key)
+}
+
+func (g *closedGuardOf[K, V]) Delete(key K) {
+	if !g.closed() {
+		g.MapOf.Delete(key)
+	}
+}
+
+func (g *closedGuardOf[K, V]) Range(f func(key K, value V) bool) {
+	if !g.closed() {
+		g.MapOf.Range(f)
+	}
+}
+
+func (g *closedGuardOf[K, V]) RangeSnapshot(f func(key K, value V) bool) {
+	if !g.closed() {
+		g.MapOf.RangeSnapshot(f)
+	}
+}
+
+func (g *closedGuardOf[K, V]) RangeCursor(cursor uint64, count int, f func(key K, value V)) uint64 {
+	if g.closed() {
+		return 0
+	}
+	return g.MapOf.RangeCursor(cursor, count, f)
+}
+
+func (g *closedGuardOf[K, V]) Clear() {
+	if !g.closed() {
+		g.MapOf.Clear()
+	}
+}
+
+func (g *closedGuardOf[K, V]) Size() int {
+	if g.closed() {
+		return 0
+	}
+	return g.MapOf.Size()
+}
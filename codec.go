@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Codec serializes the values crossing the process boundaries, see WithCodec:
+// the snapshots and the persistence of a cache, the backends of a tiered cache (e.g. the Redis
+// backend) and the transports of a replicated cache can share one Codec.
+// The modules codec/msgpack and codec/cbor provide MessagePack and CBOR codecs.
+// It must be safe for concurrent use.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes the values with encoding/json, numbers in interface{} values
+	// are restored as float64. The snapshots written with it use SnapshotJSON.
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec encodes the values with encoding/gob, keeping the concrete types of the values.
+	// Custom types stored in interface{} values must be registered with gob.Register.
+	// The snapshots written with it use SnapshotGob.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// codecFormat returns the snapshot format written with the codec, SnapshotCodec for a custom codec.
+func codecFormat(codec Codec, f SnapshotFormat) SnapshotFormat {
+	switch codec {
+	case nil:
+		return f
+	case JSONCodec:
+		return SnapshotJSON
+	case GobCodec:
+		return SnapshotGob
+	default:
+		return SnapshotCodec
+	}
+}
+
+// codecEncoder encodes each item with a codec, prefixed by its length, see SnapshotCodec.
+type codecEncoder struct {
+	codec Codec
+	w     io.Writer
+}
+
+func (e codecEncoder) Encode(v interface{}) error {
+	if e.codec == nil {
+		return fmt.Errorf("%w: no codec", ErrSnapshotFormat)
+	}
+	data, err := e.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var n [binary.MaxVarintLen64]byte
+	if _, err = e.w.Write(n[:binary.PutUvarint(n[:], uint64(len(data)))]); err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// codecDecoder decodes the items written by codecEncoder.
+type codecDecoder struct {
+	codec Codec
+	r     *bufio.Reader
+}
+
+func (d codecDecoder) Decode(v interface{}) error {
+	if d.codec == nil {
+		return fmt.Errorf("%w: no codec", ErrSnapshotFormat)
+	}
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	// grows as data arrives, a corrupted length cannot cause a huge allocation
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(io.LimitReader(d.r, int64(n))); err != nil {
+		return err
+	}
+	if uint64(buf.Len()) != n {
+		return io.ErrUnexpectedEOF
+	}
+	return d.codec.Unmarshal(buf.Bytes(), v)
+}
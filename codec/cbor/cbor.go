@@ -0,0 +1,37 @@
+// Package cbor implements a cache.Codec encoding the values with CBOR (RFC 8949), see cache.WithCodec.
+package cbor
+
+import (
+	"reflect"
+
+	fxcbor "github.com/fxamacker/cbor/v2"
+
+	"github.com/fufuok/cache"
+)
+
+var _ cache.Codec = Codec
+
+// Codec encodes the values with CBOR, several times smaller than JSON for large caches.
+// The integers in interface{} values are restored as int64 or uint64, unlike JSON which restores
+// them as float64, and the maps as map[string]interface{}, like JSON.
+var Codec cache.Codec = codec{}
+
+var decMode = func() fxcbor.DecMode {
+	dm, err := fxcbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return dm
+}()
+
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return fxcbor.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return decMode.Unmarshal(data, v)
+}
@@ -0,0 +1,12 @@
+module github.com/fufuok/cache/codec/cbor
+
+go 1.19
+
+require (
+	github.com/fufuok/cache v0.4.0
+	github.com/fxamacker/cbor/v2 v2.5.0
+)
+
+require github.com/x448/float16 v0.8.4 // indirect
+
+replace github.com/fufuok/cache => ../..
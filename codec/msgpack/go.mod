@@ -0,0 +1,12 @@
+module github.com/fufuok/cache/codec/msgpack
+
+go 1.19
+
+require (
+	github.com/fufuok/cache v0.4.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+
+replace github.com/fufuok/cache => ../..
@@ -0,0 +1,86 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/fufuok/cache"
+)
+
+func TestCodec(t *testing.T) {
+	type point struct{ X, Y int }
+	data, err := Codec.Marshal(point{1, 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var p point
+	if err := Codec.Unmarshal(data, &p); err != nil || p != (point{1, 2}) {
+		t.Fatalf("unexpected result: %v, %v", p, err)
+	}
+
+	// the integers in interface{} values are restored as int64, not float64
+	data, err = Codec.Marshal(map[string]interface{}{"a": 1, "b": "2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var m map[string]interface{}
+	if err := Codec.Unmarshal(data, &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"a": int64(1), "b": "2"}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("expected %v, got: %v", want, m)
+	}
+}
+
+func TestCodec_Snapshot(t *testing.T) {
+	c := cache.New(cache.WithCodec(Codec))
+	defer c.Close()
+	c.SetForever("a", 1)
+	c.SetForever("b", "2")
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := buf.Bytes()
+	if h, err := cache.ReadSnapshotHeader(bytes.NewReader(data)); err != nil || h.Format != cache.SnapshotCodec {
+		t.Fatalf("expected the codec format, got %+v, %v", h, err)
+	}
+
+	c2 := cache.New(cache.WithCodec(Codec))
+	defer c2.Close()
+	if err := c2.LoadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"a": int64(1), "b": "2"}
+	if got := c2.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+}
+
+func TestCodec_SnapshotGeneric(t *testing.T) {
+	type point struct{ X, Y int }
+	c := cache.New(cache.WithCodec(Codec))
+	defer c.Close()
+	c.SetForever("point", point{1, 2})
+	c.SetForever("slice", []int{1, 2})
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the concrete types are not kept in interface{} values
+	c2 := cache.New(cache.WithCodec(Codec))
+	defer c2.Close()
+	if err := c2.LoadFrom(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{
+		"point": map[string]interface{}{"X": int64(1), "Y": int64(2)},
+		"slice": []interface{}{int64(1), int64(2)},
+	}
+	if got := c2.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+}
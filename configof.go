@@ -4,13 +4,22 @@
 package cache
 
 import (
+	"context"
 	"time"
 )
 
 // EvictedCallbackOf callback function to execute when the key-value pair expires and is evicted.
-// Warning: cannot block, it is recommended to use goroutine.
+// Warning: cannot block, it is recommended to use goroutine, or WithAsyncCallbacksOf.
 type EvictedCallbackOf[K comparable, V any] func(k K, v V)
 
+// EvictedCallbackWithReasonOf callback function to execute when the key-value pair leaves the cache
+// for any reason, see EvictionReason.
+// Warning: cannot block, it is recommended to use goroutine, or WithAsyncCallbacksOf.
+type EvictedCallbackWithReasonOf[K comparable, V any] func(k K, v V, reason EvictionReason)
+
+// LoaderOf loads the value of a missing key along with its expiration time, see WithLoaderOf.
+type LoaderOf[K comparable, V any] func(ctx context.Context, k K) (v V, d time.Duration, err error)
+
 type ConfigOf[K comparable, V any] struct {
 	// DefaultExpiration default expiration time for key-value pairs.
 	DefaultExpiration time.Duration
@@ -23,6 +32,183 @@ type ConfigOf[K comparable, V any] struct {
 
 	// MinCapacity specify the initial cache capacity (minimum capacity)
 	MinCapacity int
+
+	// EventHistory the number of recent operations kept for debugging, 0 disables the history.
+	EventHistory int
+
+	// Shadows the eviction policies and capacities simulated against the access trace,
+	// to report what the hit rate would have been, see WithShadow.
+	Shadows []Shadow
+
+	// PersistencePath the file the cache is restored from when created, if present and valid,
+	// and saved to on Close.
+	PersistencePath string
+
+	// PersistenceErrorHandler is called with the error of the snapshot of PersistencePath
+	// which could not be restored, see WithPersistenceErrorHandlerOf.
+	PersistenceErrorHandler func(err error)
+
+	// DistributedLocker shares the loads of GetOrLoad between processes, see WithDistributedLocker.
+	DistributedLocker DistributedLocker
+
+	// LockLease the time a distributed lease is held for, at most, DefaultLockLease if 0.
+	LockLease time.Duration
+
+	// LockWait the time to wait for a distributed lease held by another process,
+	// DefaultLockWait if 0, a negative value does not wait.
+	LockWait time.Duration
+
+	// LockPrefix prefixes the names of the distributed leases, RegistryName if empty, see WithLockPrefix.
+	LockPrefix string
+
+	// NoFinalizer disables stopping the cleanup goroutine when the cache is garbage collected,
+	// Close must then be called explicitly, see WithNoFinalizer.
+	NoFinalizer bool
+
+	// Profiler receives the timing of each operation, nil disables the profiling, see WithProfiler.
+	Profiler Profiler
+
+	// MaxEntries the maximum number of items, the items are evicted according to the
+	// EvictionPolicy when it is exceeded, 0 means unbounded, see WithMaxEntries.
+	// The writes in progress may hold one more item each, see WithMaxEntries.
+	MaxEntries int
+
+	// EvictionPolicy decides which items are evicted first when MaxEntries is exceeded,
+	// LRU by default.
+	EvictionPolicy EvictionPolicy
+
+	// MaxCost the maximum total cost of the items, the items are evicted according to the
+	// EvictionPolicy when it is exceeded, 0 means unbounded, see WithMaxCost.
+	MaxCost int64
+
+	// SnapshotFormat the encoding of the items in the snapshots written by SaveTo,
+	// JSON by default, see WithSnapshotFormat.
+	SnapshotFormat SnapshotFormat
+
+	// SnapshotInterval the interval at which a snapshot is written to SnapshotWriter,
+	// 0 only writes it on Close, see WithSnapshot.
+	SnapshotInterval time.Duration
+
+	// SnapshotWriter opens the destination of the periodic snapshots and of the snapshot on Close.
+	SnapshotWriter SnapshotWriterFactory
+
+	// SlidingExpiration extends the lifetime of the items by their duration on each read,
+	// see WithSlidingExpiration.
+	SlidingExpiration bool
+
+	// EvictedCallbackWithReason executed when the key-value pair expires, is deleted, replaced,
+	// evicted or cleared, along with the reason, see WithEvictedCallbackWithReason.
+	EvictedCallbackWithReason EvictedCallbackWithReasonOf[K, V]
+
+	// CallbackWorkers the number of goroutines running the evicted callbacks,
+	// 0 runs them inline, see WithAsyncCallbacks.
+	CallbackWorkers int
+
+	// CallbackQueueSize the number of evicted callbacks waiting for the CallbackWorkers,
+	// beyond which the callers wait for a free worker.
+	CallbackQueueSize int
+
+	// NoCleanupLoop disables the cleanup goroutine, expired items are deleted lazily,
+	// and by Count, see WithNoCleanupLoop.
+	NoCleanupLoop bool
+
+	// Overflow receives the items evicted for capacity, they are reloaded from it on a miss,
+	// see WithOverflow.
+	Overflow BackendOf[K, V]
+
+	// WriteThrough receives the values written by the callers before the writes return, but neither
+	// the deletions nor the values loaded or restored from a snapshot, see WithWriteThrough.
+	WriteThrough WriteFuncOf[K, V]
+
+	// WriteBehind receives the values written like WriteThrough, batched by a background goroutine
+	// every WriteBehindInterval, see WithWriteBehind. It takes precedence over WriteThrough.
+	WriteBehind WriteFuncOf[K, V]
+
+	// WriteBehindInterval the interval at which the values are flushed to WriteBehind,
+	// DefaultWriteBehindInterval if 0.
+	WriteBehindInterval time.Duration
+
+	// Loader loads the missing keys read by Get and its variants, see WithLoader.
+	Loader LoaderOf[K, V]
+
+	// StaleWhileRevalidate the grace period during which the expired items are still returned
+	// while the Loader reloads them, 0 disables it, see WithStaleWhileRevalidate.
+	StaleWhileRevalidate time.Duration
+
+	// RefreshAhead the last fraction of their lifetime in which the items read are reloaded
+	// by the Loader in the background, 0 disables it, see WithRefreshAhead.
+	RefreshAhead float64
+
+	// TTLJitter the fraction by which the lifetime of each item is randomized, up or down,
+	// 0 disables it, see WithTTLJitter.
+	TTLJitter float64
+
+	// Registry the registry the cache is registered in under RegistryName until Close,
+	// see WithRegistry.
+	Registry     *Registry
+	RegistryName string
+
+	// CleanupOnClose deletes the expired items on Close, running their evicted callbacks,
+	// see WithCleanupOnCloseOf.
+	CleanupOnClose bool
+
+	// ClosedMode what the operations do once the cache is closed, ClosedAllow by default,
+	// see WithClosedModeOf.
+	ClosedMode ClosedMode
+
+	// Clock the time source of the expiration and of the background tasks, SystemClock by default,
+	// see WithClockOf.
+	Clock Clock
+
+	// MemoryLimit the memory in bytes over which a part of the items is evicted at each check,
+	// in the order of the EvictionPolicy, 0 means unlimited, see WithMemoryLimitOf.
+	MemoryLimit uint64
+
+	// MemorySampler returns the memory compared to MemoryLimit, HeapSampler by default.
+	MemorySampler MemorySampler
+
+	// Codec serializes the items of the snapshots, it overrides SnapshotFormat, see WithCodecOf.
+	Codec Codec
+
+	// ConsistentSnapshots makes ItemsAtomic and SaveTo copy the items as of a single point in time,
+	// blocking the writes meanwhile, see WithConsistentSnapshotsOf.
+	ConsistentSnapshots bool
+
+	// HotKeys the number of most read keys tracked, 0 means disabled, see WithHotKeysOf.
+	HotKeys int
+
+	// AdmissionPolicy decides whether a new key is admitted when MaxEntries or MaxCost is exceeded,
+	// see WithAdmissionPolicyOf.
+	AdmissionPolicy AdmissionPolicy
+
+	// Hasher hashes the keys with a seed instead of the built-in hash function, see WithHasherOf.
+	Hasher func(k K, seed uint64) uint64
+
+	// KeyNormalizer normalizes the keys on every operation, e.g. strings.ToLower, see WithKeyNormalizerOf.
+	KeyNormalizer func(k K) K
+
+	// PanicHandler is called with the panics of the functions given to the cache, see WithPanicHandlerOf.
+	PanicHandler PanicHandler
+
+	// ValueCopier copies the values returned by the reads, see WithValueCopierOf.
+	ValueCopier func(v V) V
+
+	// WriteCoalesceWindow the time the values written to a key are buffered, from its first write
+	// since it was last flushed to WriteBehind, see WithWriteCoalescingOf.
+	WriteCoalesceWindow time.Duration
+
+	// CleanupHook receives a report after each pass deleting the expired items, see WithCleanupHookOf.
+	CleanupHook func(report CleanupReport)
+
+	// ValuePool receives the values evicted, expired or overwritten, see WithValuePoolOf.
+	ValuePool ValuePool
+
+	// GrowOnly keeps the hash table of the items from shrinking, see WithGrowOnlyOf.
+	GrowOnly bool
+
+	// ShrinkThreshold is the fraction of occupation shrinking the hash table of the items,
+	// see WithShrinkThresholdOf.
+	ShrinkThreshold float64
 }
 
 func DefaultConfigOf[K comparable, V any]() ConfigOf[K, V] {
@@ -31,6 +217,7 @@ func DefaultConfigOf[K comparable, V any]() ConfigOf[K, V] {
 		CleanupInterval:   DefaultCleanupInterval,
 		EvictedCallback:   nil,
 		MinCapacity:       DefaultMinCapacity,
+		Clock:             SystemClock,
 	}
 }
 
@@ -45,12 +232,24 @@ func configDefaultOf[K comparable, V any](config ...ConfigOf[K, V]) ConfigOf[K,
 	if cfg.DefaultExpiration < 1 {
 		cfg.DefaultExpiration = NoExpiration
 	}
-	if cfg.CleanupInterval < 0 {
+	if cfg.CleanupInterval < 0 || cfg.NoCleanupLoop {
 		cfg.CleanupInterval = 0
 	}
+	if cfg.StaleWhileRevalidate < 0 || cfg.Loader == nil {
+		cfg.StaleWhileRevalidate = 0
+	}
+	if cfg.RefreshAhead <= 0 || cfg.RefreshAhead >= 1 || cfg.Loader == nil {
+		cfg.RefreshAhead = 0
+	}
+	if cfg.TTLJitter <= 0 || cfg.TTLJitter >= 1 {
+		cfg.TTLJitter = 0
+	}
 	if cfg.MinCapacity < DefaultMinCapacity {
 		cfg.MinCapacity = DefaultMinCapacity
 	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock
+	}
 
 	return cfg
 }
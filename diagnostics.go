@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"math"
+	"time"
+)
+
+// DiagnosticsTTLBuckets the upper bounds of the buckets of Diagnostics.TTLHistogram,
+// followed by a last bucket for the longer TTLs.
+var DiagnosticsTTLBuckets = []time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	24 * time.Hour,
+}
+
+// TTLBucket the number of items whose remaining TTL is at most Max, and more than
+// the Max of the previous bucket. The Max of the last bucket is math.MaxInt64.
+type TTLBucket struct {
+	Max   time.Duration
+	Count int
+}
+
+// Diagnostics the distribution of the expiration of the items, to help size the cleanup
+// interval and the default expiration, see Cache.Diagnostics.
+type Diagnostics struct {
+	// Items the number of unexpired items.
+	Items int
+
+	// Expired the number of expired items not deleted yet.
+	Expired int
+
+	// NoExpiration the number of unexpired items that never expire.
+	NoExpiration int
+
+	// TTLHistogram the unexpired items that expire, by remaining TTL, see DiagnosticsTTLBuckets.
+	TTLHistogram []TTLBucket
+
+	// CleanupInterval the interval of the cleanup loop, 0 if there is none.
+	CleanupInterval time.Duration
+
+	// Forecast the number of items expiring in each of the next cleanup intervals,
+	// the expired items not deleted yet are counted in the first one.
+	// Without a cleanup loop, the intervals are DefaultCleanupInterval.
+	Forecast []int
+}
+
+// newDiagnostics returns the diagnostics of a cache cleaned every interval,
+// forecasting intervals cleanup intervals.
+func newDiagnostics(interval time.Duration, intervals int) Diagnostics {
+	if intervals < 0 {
+		intervals = 0
+	}
+	d := Diagnostics{
+		TTLHistogram:    make([]TTLBucket, len(DiagnosticsTTLBuckets)+1),
+		CleanupInterval: interval,
+		Forecast:        make([]int, intervals),
+	}
+	for i, max := range DiagnosticsTTLBuckets {
+		d.TTLHistogram[i].Max = max
+	}
+	d.TTLHistogram[len(DiagnosticsTTLBuckets)].Max = math.MaxInt64
+	return d
+}
+
+// add counts an item expiring at e, in Unix nanoseconds, 0 means never.
+func (d *Diagnostics) add(e, now int64, expired bool) {
+	if expired {
+		d.Expired++
+		d.forecast(0)
+		return
+	}
+	d.Items++
+	if e == 0 {
+		d.NoExpiration++
+		return
+	}
+	ttl := time.Duration(e - now)
+	for i := range d.TTLHistogram {
+		if ttl <= d.TTLHistogram[i].Max {
+			d.TTLHistogram[i].Count++
+			break
+		}
+	}
+	interval := d.CleanupInterval
+	if interval <= 0 {
+		interval = DefaultCleanupInterval
+	}
+	// the items expiring during an interval are deleted at its end
+	d.forecast(int64((ttl - 1) / interval))
+}
+
+func (d *Diagnostics) forecast(i int64) {
+	if i < int64(len(d.Forecast)) {
+		d.Forecast[i]++
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"time"
+)
+
+// EventOp the type of operation recorded by the event history.
+type EventOp uint8
+
+const (
+	// EventSet the key was set, by Set, GetAndSet or GetOrSet.
+	EventSet EventOp = iota + 1
+
+	// EventGet the key was read.
+	EventGet
+
+	// EventRefresh the expiration time of the key was refreshed, by GetAndRefresh, Expire, Persist or Touch.
+	EventRefresh
+
+	// EventCompute the key was computed, by Compute, GetOrCompute or GetOrLoad.
+	EventCompute
+
+	// EventDelete the key was deleted, by Delete, GetAndDelete, DeleteMultiple or DeleteFunc.
+	EventDelete
+
+	// EventExpire the key expired and was removed.
+	EventExpire
+
+	// EventClear all keys were deleted.
+	EventClear
+
+	// EventLoad the key was loaded from a snapshot, by LoadItemsWithExpiration,
+	// or reloaded from the overflow store, see WithOverflow.
+	EventLoad
+
+	// EventEvict the key was evicted to keep the cache within its capacity, see WithMaxEntries.
+	EventEvict
+)
+
+var eventOpNames = [...]string{
+	EventSet:     "set",
+	EventGet:     "get",
+	EventRefresh: "refresh",
+	EventCompute: "compute",
+	EventDelete:  "delete",
+	EventExpire:  "expire",
+	EventClear:   "clear",
+	EventLoad:    "load",
+	EventEvict:   "evict",
+}
+
+func (op EventOp) String() string {
+	if int(op) < len(eventOpNames) && eventOpNames[op] != "" {
+		return eventOpNames[op]
+	}
+	return "unknown"
+}
+
+// Event an operation recorded by the event history, see WithEventHistory.
+type Event struct {
+	Op  EventOp
+	Key string
+
+	// OK the result of the operation: whether the key was found for get, refresh and delete,
+	// whether the key is present after compute and load, always true for the others.
+	OK bool
+
+	Time time.Time
+}
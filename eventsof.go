@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// EventOf an operation recorded by the event history, see WithEventHistoryOf.
+type EventOf[K comparable] struct {
+	Op  EventOp
+	Key K
+
+	// OK the result of the operation: whether the key was found for get, refresh and delete,
+	// whether the key is present after compute and load, always true for the others.
+	OK bool
+
+	Time time.Time
+}
+
+type eventRecordOf[K comparable] struct {
+	seq uint64
+	EventOf[K]
+}
+
+// eventHistoryOf keeps the last operations in a ring buffer.
+// Writers only claim a slot with an atomic increment, so recording never blocks.
+type eventHistoryOf[K comparable] struct {
+	pos    uint64
+	events []unsafe.Pointer // *eventRecordOf[K], atomic.Pointer needs Go 1.19
+}
+
+func newEventHistoryOf[K comparable](n int) *eventHistoryOf[K] {
+	if n < 1 {
+		return nil
+	}
+	return &eventHistoryOf[K]{events: make([]unsafe.Pointer, n)}
+}
+
+func (h *eventHistoryOf[K]) add(op EventOp, k K, ok bool) {
+	seq := atomic.AddUint64(&h.pos, 1) - 1
+	atomic.StorePointer(&h.events[seq%uint64(len(h.events))], unsafe.Pointer(&eventRecordOf[K]{
+		seq:     seq,
+		EventOf: EventOf[K]{Op: op, Key: k, OK: ok, Time: time.Now()},
+	}))
+}
+
+// recent returns the recorded events, oldest first.
+// Slots being overwritten concurrently are skipped.
+func (h *eventHistoryOf[K]) recent() []EventOf[K] {
+	pos := atomic.LoadUint64(&h.pos)
+	n := uint64(len(h.events))
+	start := uint64(0)
+	if pos > n {
+		start = pos - n
+	}
+	records := make([]*eventRecordOf[K], 0, pos-start)
+	for i := range h.events {
+		r := (*eventRecordOf[K])(atomic.LoadPointer(&h.events[i]))
+		if r != nil && r.seq >= start && r.seq < pos {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+	events := make([]EventOf[K], len(records))
+	for i, r := range records {
+		events[i] = r.EventOf
+	}
+	return events
+}
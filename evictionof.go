@@ -0,0 +1,412 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fufuok/cache/internal/xsync"
+)
+
+// readBufferSize the number of reads buffered by a stripe before they are applied to the eviction order.
+const readBufferSize = 64
+
+// evictorOf bounds the number or the total cost of the items in the cache,
+// see WithMaxEntriesOf and WithMaxCostOf, or orders them for WithMemoryLimitOf.
+// It follows the operations recorded on the cache, and returns the keys to evict
+// when the bound is exceeded. Since it is updated after the map, the bound is
+// approximate while the same key is written concurrently.
+// The writes are applied under its mutex, after the reads buffered so far. The reads are
+// buffered in a stripe per P, applied once a stripe is full if the mutex is free, and dropped
+// otherwise, so that they never wait.
+type evictorOf[K comparable] struct {
+	pending int32 // the number of reads buffered, first to be aligned
+	mu      sync.Mutex
+	max     int
+	policy  EvictionPolicy
+	queue   evictionQueueOf[K]
+	reads   []readBufferOf[K] // see read
+	mask    int
+
+	// the cost of each key, only tracked when the cost is bounded
+	maxCost int64
+	total   int64
+	costs   map[K]int64
+
+	// the frequency of the keys, only tracked with the TinyLFU admission policy
+	sketch *countMinSketch
+	hash   func(k K) uint64
+}
+
+// evictionQueueOf orders the keys by eviction priority.
+type evictionQueueOf[K comparable] interface {
+	// touch marks the key as used, adding it if missing.
+	touch(k K)
+
+	remove(k K)
+
+	// pop removes and returns the key to evict first.
+	pop() K
+
+	// peek returns the key to evict first.
+	peek() K
+
+	contains(k K) bool
+
+	len() int
+}
+
+func newEvictorOf[K comparable](
+	maxEntries int,
+	maxCost int64,
+	policy EvictionPolicy,
+	admission AdmissionPolicy,
+	unbounded bool,
+) *evictorOf[K] {
+	if maxEntries < 1 && maxCost < 1 && !unbounded {
+		return nil
+	}
+	e := &evictorOf[K]{
+		max:     maxEntries,
+		policy:  policy,
+		queue:   newEvictionQueueOf[K](policy),
+		maxCost: maxCost,
+	}
+	n := expiryShards()
+	e.reads, e.mask = make([]readBufferOf[K], n), n-1
+	if maxCost > 0 {
+		e.costs = make(map[K]int64)
+	}
+	if admission == TinyLFU {
+		hasher, seed := xsync.DefaultHasher[K](), xsync.MakeSeed()
+		e.sketch = newCountMinSketch(maxEntries)
+		e.hash = func(k K) uint64 {
+			return hasher(k, seed)
+		}
+	}
+	return e
+}
+
+// readOf a read of a key buffered by the evictor, hit if the key was found.
+type readOf[K comparable] struct {
+	k   K
+	hit bool
+}
+
+// readBufferOf a stripe of the reads not applied to the eviction order yet,
+// mostly used by one P, see xsync.ProcID.
+type readBufferOf[K comparable] struct {
+	mu    sync.Mutex
+	reads []readOf[K]
+	_     [64]byte // against the false sharing of the stripes
+}
+
+func newEvictionQueueOf[K comparable](policy EvictionPolicy) evictionQueueOf[K] {
+	if policy == LFU {
+		return &lfuQueueOf[K]{keys: make(map[K]*lfuKeyOf[K])}
+	}
+	return &lruQueueOf[K]{order: list.New(), keys: make(map[K]*list.Element)}
+}
+
+// trace follows an operation recorded with the given result on the key,
+// cost is the cost of the key when it is written. Returns the keys to evict,
+// the key itself if it is new and not admitted, see TinyLFU.
+func (e *evictorOf[K]) trace(op EventOp, k K, ok bool, cost int64) (victims []K) {
+	if op == EventGet || op == EventRefresh {
+		// the misses only count for the admission
+		if ok || e.sketch != nil {
+			e.read(k, ok)
+		}
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.drain()
+	candidate := false
+	if e.sketch != nil {
+		switch op {
+		case EventSet, EventLoad, EventCompute:
+			e.sketch.add(e.hash(k))
+			candidate = (ok || op != EventCompute) && !e.queue.contains(k)
+		}
+	}
+	switch op {
+	case EventSet, EventLoad:
+		e.write(k, cost)
+	case EventCompute:
+		if ok {
+			e.write(k, cost)
+		} else {
+			e.remove(k)
+		}
+	case EventDelete, EventExpire, EventEvict:
+		e.remove(k)
+	case EventClear:
+		e.queue = newEvictionQueueOf[K](e.policy)
+		if e.costs != nil {
+			e.total = 0
+			e.costs = make(map[K]int64)
+		}
+	}
+	for e.exceeded() {
+		if candidate {
+			// the new key is only admitted if more frequent than the first victim
+			candidate = false
+			if victim := e.queue.peek(); victim != k && e.sketch.estimate(e.hash(k)) <= e.sketch.estimate(e.hash(victim)) {
+				e.remove(k)
+				victims = append(victims, k)
+				continue
+			}
+		}
+		victim := e.queue.pop()
+		if e.costs != nil {
+			e.total -= e.costs[victim]
+			delete(e.costs, victim)
+		}
+		victims = append(victims, victim)
+	}
+	return
+}
+
+// read buffers the read of the key k, hit if it was found, in the stripe of the current P,
+// so that the reads of a goroutine are mostly applied in their order.
+// A full stripe is applied if the mutex is free, and dropped otherwise, like the read itself
+// if its stripe is busy: the eviction order may miss some reads under contention,
+// but the reads never wait.
+func (e *evictorOf[K]) read(k K, hit bool) {
+	b := &e.reads[xsync.ProcID()&e.mask]
+	if !b.mu.TryLock() {
+		return
+	}
+	b.reads = append(b.reads, readOf[K]{k: k, hit: hit})
+	atomic.AddInt32(&e.pending, 1)
+	if len(b.reads) >= readBufferSize {
+		if e.mu.TryLock() {
+			e.apply(b)
+			e.mu.Unlock()
+		} else {
+			e.reset(b)
+		}
+	}
+	b.mu.Unlock()
+}
+
+// drain applies the reads of all the stripes, with the mutex held, e.g. before a write.
+func (e *evictorOf[K]) drain() {
+	if atomic.LoadInt32(&e.pending) == 0 {
+		return
+	}
+	for i := range e.reads {
+		b := &e.reads[i]
+		b.mu.Lock()
+		e.apply(b)
+		b.mu.Unlock()
+	}
+}
+
+// apply applies the reads of the stripe b, with both mutexes held, then empties it.
+// The hits only move the keys still in the queue, the keys deleted meanwhile are not added back.
+func (e *evictorOf[K]) apply(b *readBufferOf[K]) {
+	for _, r := range b.reads {
+		if e.sketch != nil {
+			e.sketch.add(e.hash(r.k))
+		}
+		if r.hit && e.queue.contains(r.k) {
+			e.queue.touch(r.k)
+		}
+	}
+	e.reset(b)
+}
+
+// reset empties the stripe b, with its mutex held, without retaining the keys.
+func (e *evictorOf[K]) reset(b *readBufferOf[K]) {
+	var zeroed readOf[K]
+	for i := range b.reads {
+		b.reads[i] = zeroed
+	}
+	atomic.AddInt32(&e.pending, -int32(len(b.reads)))
+	b.reads = b.reads[:0]
+}
+
+// queued reports whether the key k is in the eviction order, e.g. written again since it was evicted.
+func (e *evictorOf[K]) queued(k K) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.queue.contains(k)
+}
+
+// shrink removes and returns the given fraction of the keys, at least one, to evict first.
+func (e *evictorOf[K]) shrink(fraction float64) (victims []K) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.drain()
+	n := e.queue.len()
+	if n == 0 {
+		return nil
+	}
+	n = int(float64(n) * fraction)
+	if n < 1 {
+		n = 1
+	}
+	for ; n > 0; n-- {
+		k := e.queue.pop()
+		if e.costs != nil {
+			e.total -= e.costs[k]
+			delete(e.costs, k)
+		}
+		victims = append(victims, k)
+	}
+	return
+}
+
+// exceeded reports whether the number or the total cost of the items is over the bound.
+func (e *evictorOf[K]) exceeded() bool {
+	n := e.queue.len()
+	if n == 0 {
+		return false
+	}
+	return (e.max > 0 && n > e.max) || (e.maxCost > 0 && e.total > e.maxCost)
+}
+
+func (e *evictorOf[K]) write(k K, cost int64) {
+	e.queue.touch(k)
+	if e.costs != nil {
+		e.total += cost - e.costs[k]
+		e.costs[k] = cost
+	}
+}
+
+func (e *evictorOf[K]) remove(k K) {
+	e.queue.remove(k)
+	if e.costs != nil {
+		e.total -= e.costs[k]
+		delete(e.costs, k)
+	}
+}
+
+type lruQueueOf[K comparable] struct {
+	order *list.List // front is the most recently used
+	keys  map[K]*list.Element
+}
+
+func (q *lruQueueOf[K]) touch(k K) {
+	if el, ok := q.keys[k]; ok {
+		q.order.MoveToFront(el)
+		return
+	}
+	q.keys[k] = q.order.PushFront(k)
+}
+
+func (q *lruQueueOf[K]) remove(k K) {
+	if el, ok := q.keys[k]; ok {
+		q.order.Remove(el)
+		delete(q.keys, k)
+	}
+}
+
+func (q *lruQueueOf[K]) pop() K {
+	k := q.order.Back().Value.(K)
+	q.remove(k)
+	return k
+}
+
+func (q *lruQueueOf[K]) peek() K {
+	return q.order.Back().Value.(K)
+}
+
+func (q *lruQueueOf[K]) contains(k K) bool {
+	_, ok := q.keys[k]
+	return ok
+}
+
+func (q *lruQueueOf[K]) len() int {
+	return q.order.Len()
+}
+
+type lfuKeyOf[K comparable] struct {
+	k     K
+	freq  uint64
+	tick  uint64
+	index int
+}
+
+// lfuKeyHeapOf is a min-heap ordered by frequency, then by last use.
+type lfuKeyHeapOf[K comparable] []*lfuKeyOf[K]
+
+func (h lfuKeyHeapOf[K]) Len() int { return len(h) }
+func (h lfuKeyHeapOf[K]) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuKeyHeapOf[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuKeyHeapOf[K]) Push(x any) {
+	e := x.(*lfuKeyOf[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuKeyHeapOf[K]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+type lfuQueueOf[K comparable] struct {
+	tick uint64
+	heap lfuKeyHeapOf[K]
+	keys map[K]*lfuKeyOf[K]
+}
+
+func (q *lfuQueueOf[K]) touch(k K) {
+	q.tick++
+	if e, ok := q.keys[k]; ok {
+		e.freq++
+		e.tick = q.tick
+		heap.Fix(&q.heap, e.index)
+		return
+	}
+	e := &lfuKeyOf[K]{k: k, freq: 1, tick: q.tick}
+	heap.Push(&q.heap, e)
+	q.keys[k] = e
+}
+
+func (q *lfuQueueOf[K]) remove(k K) {
+	if e, ok := q.keys[k]; ok {
+		heap.Remove(&q.heap, e.index)
+		delete(q.keys, k)
+	}
+}
+
+func (q *lfuQueueOf[K]) pop() K {
+	e := heap.Pop(&q.heap).(*lfuKeyOf[K])
+	delete(q.keys, e.k)
+	return e.k
+}
+
+func (q *lfuQueueOf[K]) peek() K {
+	return q.heap[0].k
+}
+
+func (q *lfuQueueOf[K]) contains(k K) bool {
+	_, ok := q.keys[k]
+	return ok
+}
+
+func (q *lfuQueueOf[K]) len() int {
+	return len(q.heap)
+}
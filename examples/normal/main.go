@@ -28,6 +28,14 @@ func main() {
 	// B 3 true
 	fmt.Println("B", val, ok)
 
+	// concurrent misses of the same key run the loader once
+	val, ok, err := c.GetOrLoad("E", func(k string) (interface{}, error) {
+		return k + "-loaded", nil
+	}, 1*time.Minute)
+	// E E-loaded false <nil>
+	fmt.Println("E", val, ok, err)
+	c.Delete("E")
+
 	c.SetDefault("C", 2)
 	c.SetForever("D", 3)
 
@@ -53,6 +61,7 @@ func main() {
 // B 2 false
 // B 2 true
 // B 3 true
+// E E-loaded false <nil>
 // Key -> D | Value -> 3
 // Key -> C | Value -> 2
 // Key -> A | Value -> 1
@@ -0,0 +1,207 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expiryResolution the time span of the keys grouped in a bucket of the expiry index.
+const expiryResolution = int64(time.Second)
+
+// expiryShards returns the number of shards, a power of 2, so that concurrent writers
+// rarely contend for the same shard.
+func expiryShards() int {
+	n := 1
+	for n < 4*runtime.GOMAXPROCS(0) {
+		n <<= 1
+	}
+	return n
+}
+
+// expiryIndexOf groups the keys by the second they expire in, so DeleteExpired only visits
+// the keys due instead of scanning the whole cache.
+// The index is a hint: the item of a key may have been deleted or rewritten since it was
+// added, DeleteExpired checks the item and adds the key again if it has not expired yet.
+type expiryIndexOf[K comparable] struct {
+	size    int64 // the number of keys indexed, first to be 64-bit aligned
+	buckets int64 // the number of buckets, including the emptied ones
+	shards  []expiryShardOf[K]
+	mask    uint64
+}
+
+// expiryShardOf the buckets of a shard, and their ids in a min-heap so that due only visits
+// the buckets due. A bucket emptied by add stays until due, so that each id is in the heap once.
+type expiryShardOf[K comparable] struct {
+	mu      sync.Mutex
+	buckets map[int64]map[K]struct{}
+	order   expiryBucketHeap
+}
+
+// expiryBucketHeap a min-heap of the ids of the buckets.
+type expiryBucketHeap []int64
+
+func (h expiryBucketHeap) Len() int           { return len(h) }
+func (h expiryBucketHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h expiryBucketHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryBucketHeap) Push(x any) {
+	*h = append(*h, x.(int64))
+}
+
+func (h *expiryBucketHeap) Pop() any {
+	old := *h
+	n := len(old)
+	b := old[n-1]
+	*h = old[:n-1]
+	return b
+}
+
+func newExpiryIndexOf[K comparable]() *expiryIndexOf[K] {
+	n := expiryShards()
+	x := &expiryIndexOf[K]{
+		shards: make([]expiryShardOf[K], n),
+		mask:   uint64(n - 1),
+	}
+	for i := range x.shards {
+		x.shards[i].buckets = make(map[int64]map[K]struct{})
+	}
+	return x
+}
+
+// add indexes the key k of hash h expiring at e, in nanoseconds, if it expires, and removes it
+// from the bucket of prev, its previous expiration, if it moves, so that a key rewritten many times
+// is indexed once. A key moved by concurrent writers may be left in a stale bucket, DeleteExpired drops it.
+func (x *expiryIndexOf[K]) add(h uint64, k K, prev, e int64) {
+	s := &x.shards[h&x.mask]
+	b := e / expiryResolution
+	s.mu.Lock()
+	if prev > 0 && (e <= 0 || prev/expiryResolution != b) {
+		x.remove(s, k, prev/expiryResolution)
+	}
+	if e <= 0 {
+		s.mu.Unlock()
+		return
+	}
+	keys, ok := s.buckets[b]
+	if !ok {
+		keys = make(map[K]struct{})
+		s.buckets[b] = keys
+		heap.Push(&s.order, b)
+		atomic.AddInt64(&x.buckets, 1)
+	}
+	if _, ok = keys[k]; !ok {
+		keys[k] = struct{}{}
+		atomic.AddInt64(&x.size, 1)
+	}
+	s.mu.Unlock()
+}
+
+// remove removes the key k from the bucket b of the shard s, locked.
+func (x *expiryIndexOf[K]) remove(s *expiryShardOf[K], k K, b int64) {
+	keys, ok := s.buckets[b]
+	if !ok {
+		return
+	}
+	if _, ok = keys[k]; !ok {
+		return
+	}
+	delete(keys, k)
+	atomic.AddInt64(&x.size, -1)
+}
+
+// due removes the keys that may have expired at now from the index, and calls f for each of them
+// until f returns false, the key passed to f then and the keys not visited yet stay in the index.
+// f is called without holding any lock, it may add the keys again.
+// The shards are not locked while no bucket is indexed, e.g. in a cache whose items never expire.
+func (x *expiryIndexOf[K]) due(now int64, f func(k K) bool) {
+	if atomic.LoadInt64(&x.buckets) == 0 {
+		return
+	}
+	last := now / expiryResolution
+	var (
+		buckets []int64
+		keys    []map[K]struct{}
+	)
+	for i := range x.shards {
+		s := &x.shards[i]
+		s.mu.Lock()
+		taken, dropped := 0, 0
+		for len(s.order) > 0 && s.order[0] <= last {
+			b := heap.Pop(&s.order).(int64)
+			if ks := s.buckets[b]; len(ks) > 0 {
+				buckets = append(buckets, b)
+				keys = append(keys, ks)
+				taken += len(ks)
+			}
+			delete(s.buckets, b)
+			dropped++
+		}
+		s.mu.Unlock()
+		atomic.AddInt64(&x.size, -int64(taken))
+		atomic.AddInt64(&x.buckets, -int64(dropped))
+		for j, ks := range keys {
+			for k := range ks {
+				if !f(k) {
+					x.restore(s, buckets[j:], keys[j:])
+					return
+				}
+				delete(ks, k)
+			}
+		}
+		buckets, keys = buckets[:0], keys[:0]
+	}
+}
+
+// restore adds back the keys of the buckets of the shard s removed by due, merged with those added since.
+func (x *expiryIndexOf[K]) restore(s *expiryShardOf[K], buckets []int64, keys []map[K]struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := 0
+	for i, b := range buckets {
+		if len(keys[i]) == 0 {
+			continue
+		}
+		ks, ok := s.buckets[b]
+		if !ok {
+			s.buckets[b] = keys[i]
+			heap.Push(&s.order, b)
+			atomic.AddInt64(&x.buckets, 1)
+			added += len(keys[i])
+			continue
+		}
+		for k := range keys[i] {
+			if _, ok = ks[k]; !ok {
+				ks[k] = struct{}{}
+				added++
+			}
+		}
+	}
+	atomic.AddInt64(&x.size, int64(added))
+}
+
+// clear removes all the keys from the index.
+func (x *expiryIndexOf[K]) clear() {
+	if atomic.LoadInt64(&x.buckets) == 0 {
+		return
+	}
+	for i := range x.shards {
+		s := &x.shards[i]
+		s.mu.Lock()
+		cleared := 0
+		for _, ks := range s.buckets {
+			cleared += len(ks)
+		}
+		dropped := len(s.buckets)
+		s.buckets = make(map[int64]map[K]struct{})
+		s.order = nil
+		s.mu.Unlock()
+		atomic.AddInt64(&x.size, -int64(cleared))
+		atomic.AddInt64(&x.buckets, -int64(dropped))
+	}
+}
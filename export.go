@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// ExportChunkSize the number of items in each chunk written by ExportGzip.
+const ExportChunkSize = 10000
+
+// snapshotChunker writes the items it is given as a sequence of snapshots of up to
+// ExportChunkSize items, so that only one chunk is buffered at a time.
+type snapshotChunker struct {
+	w         io.Writer
+	format    SnapshotFormat
+	codec     Codec
+	keyType   string
+	valueType string
+	buf       bytes.Buffer
+	enc       snapshotEncoder
+	count     int
+}
+
+// add encodes the item x, and writes the chunk once full.
+func (s *snapshotChunker) add(x interface{}) error {
+	if s.enc == nil {
+		// each chunk is decoded on its own, e.g. with the types of a gob stream
+		s.enc = newSnapshotEncoder(s.format, s.codec, &s.buf)
+	}
+	if err := s.enc.Encode(x); err != nil {
+		return err
+	}
+	s.count++
+	if s.count == ExportChunkSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes the pending items as a snapshot, if any.
+func (s *snapshotChunker) flush() error {
+	if s.count == 0 {
+		return nil
+	}
+	err := writeSnapshot(s.w, s.format, s.keyType, s.valueType, s.count, s.buf.Bytes())
+	s.buf.Reset()
+	s.enc = nil
+	s.count = 0
+	return err
+}
+
+// exportGzip writes the items passed to add by items to w, in chunks compressed with gzip.
+func exportGzip(
+	w io.Writer,
+	format SnapshotFormat,
+	codec Codec,
+	keyType, valueType string,
+	items func(add func(x interface{}) error) error,
+) error {
+	zw := gzip.NewWriter(w)
+	s := &snapshotChunker{
+		w:         zw,
+		format:    format,
+		codec:     codec,
+		keyType:   keyType,
+		valueType: valueType,
+	}
+	if err := items(s.add); err != nil {
+		return err
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// importGzip reads the chunks written by exportGzip from r, and calls load with the header
+// and the verified payload of each chunk, in order.
+func importGzip(r io.Reader, keyType, valueType string, load func(h SnapshotHeader, payload []byte) error) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSnapshotFormat, err)
+	}
+	defer zr.Close()
+	br := bufio.NewReader(zr)
+	for {
+		if _, err = br.Peek(1); err == io.EOF {
+			return nil
+		}
+		h, payload, err := readSnapshot(br, keyType, valueType)
+		if err != nil {
+			return err
+		}
+		if err = load(h, payload); err != nil {
+			return err
+		}
+	}
+}
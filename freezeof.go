@@ -0,0 +1,113 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync"
+)
+
+// freezerOf lets the writes of a cache run concurrently, but not during freeze,
+// for the point-in-time copies of ItemsAtomic and SaveTo, see WithConsistentSnapshotsOf.
+// A nil freezerOf never freezes.
+type freezerOf[K comparable, V any] struct {
+	MapOf[K, V]
+	mu sync.RWMutex
+}
+
+// newFreezerOf returns nil unless consistent is set.
+func newFreezerOf[K comparable, V any](m MapOf[K, V], consistent bool) *freezerOf[K, V] {
+	if !consistent {
+		return nil
+	}
+	return &freezerOf[K, V]{MapOf: m}
+}
+
+// freeze runs fn while the writes are blocked.
+func (f *freezerOf[K, V]) freeze(fn func()) {
+	if f == nil {
+		fn()
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+func (f *freezerOf[K, V]) Store(key K, value V) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	f.MapOf.Store(key, value)
+}
+
+func (f *freezerOf[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.LoadOrStore(key, value)
+}
+
+func (f *freezerOf[K, V]) LoadAndStore(key K, value V) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.LoadAndStore(key, value)
+}
+
+func (f *freezerOf[K, V]) Swap(key K, value V) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.Swap(key, value)
+}
+
+func (f *freezerOf[K, V]) LoadOrCompute(key K, valueFn func() V) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.LoadOrCompute(key, valueFn)
+}
+
+func (f *freezerOf[K, V]) LoadOrTryCompute(key K, valueFn func() (newValue V, cancel bool)) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.LoadOrTryCompute(key, valueFn)
+}
+
+func (f *freezerOf[K, V]) Compute(
+	key K,
+	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
+) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.Compute(key, valueFn)
+}
+
+func (f *freezerOf[K, V]) ComputeWithOp(
+	key K,
+	valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
+) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.ComputeWithOp(key, valueFn)
+}
+
+func (f *freezerOf[K, V]) UpdateIfPresent(key K, valueFn func(oldValue V) V) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.UpdateIfPresent(key, valueFn)
+}
+
+func (f *freezerOf[K, V]) LoadAndDelete(key K) (V, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.MapOf.LoadAndDelete(key)
+}
+
+func (f *freezerOf[K, V]) Delete(key K) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	f.MapOf.Delete(key)
+}
+
+func (f *freezerOf[K, V]) Clear() {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	f.MapOf.Clear()
+}
@@ -0,0 +1,233 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// modelItem is the reference of an item in fuzzModel, e is the expiration in nanoseconds, 0 never expires.
+type modelItem struct {
+	v int
+	e int64
+}
+
+// fuzzModel is the reference implementation the cache is checked against:
+// a map along with the expiration of the keys, read at the fake clock.
+type fuzzModel struct {
+	clock *FakeClock
+	def   time.Duration
+	items map[int]modelItem
+}
+
+func (m *fuzzModel) expiration(d time.Duration) int64 {
+	if d == DefaultExpiration {
+		d = m.def
+	}
+	if d > 0 {
+		return m.clock.Now().Add(d).UnixNano()
+	}
+	return 0
+}
+
+func (m *fuzzModel) get(k int) (int, bool) {
+	i, ok := m.items[k]
+	if !ok {
+		return 0, false
+	}
+	if i.e > 0 && m.clock.Now().UnixNano() > i.e {
+		delete(m.items, k)
+		return 0, false
+	}
+	return i.v, true
+}
+
+func (m *fuzzModel) set(k, v int, d time.Duration) {
+	m.items[k] = modelItem{v: v, e: m.expiration(d)}
+}
+
+func (m *fuzzModel) expire(k int, d time.Duration) bool {
+	if _, ok := m.get(k); !ok {
+		return false
+	}
+	i := m.items[k]
+	i.e = m.expiration(d)
+	m.items[k] = i
+	return true
+}
+
+// fuzzDuration maps a byte to the durations worth testing around the clock steps:
+// the special values, a few ticks, and the exact bounds of the expiration.
+func fuzzDuration(b byte) time.Duration {
+	switch b % 8 {
+	case 0:
+		return DefaultExpiration
+	case 1:
+		return NoExpiration
+	case 2:
+		return 0
+	default:
+		return time.Duration(b%8-2) * time.Millisecond
+	}
+}
+
+// FuzzCacheOf_Model interleaves random operations on the cache, and checks every result
+// against fuzzModel, three bytes by operation: the operation, the key and its argument.
+func FuzzCacheOf_Model(f *testing.F) {
+	f.Add([]byte{0, 1, 3, 9, 1, 1, 1, 1, 0})
+	f.Add([]byte{0, 1, 4, 8, 0, 2, 1, 1, 0, 8, 0, 1, 1, 1, 0})
+	f.Add([]byte{0, 2, 5, 5, 2, 0, 8, 0, 3, 1, 2, 0, 6, 2, 1, 7, 2, 0})
+	f.Add([]byte{4, 3, 3, 0, 3, 6, 8, 0, 2, 4, 3, 1, 1, 3, 0, 9, 0, 0, 1, 3, 0})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		c := NewOf[int, int](
+			WithClockOf[int, int](clock),
+			WithDefaultExpirationOf[int, int](2*time.Millisecond),
+			WithNoCleanupLoopOf[int, int](),
+		)
+		defer c.Close()
+		m := &fuzzModel{clock: clock, def: 2 * time.Millisecond, items: make(map[int]modelItem)}
+
+		for n := 0; n+2 < len(ops); n += 3 {
+			op, k, arg := ops[n]%10, int(ops[n+1]%4), ops[n+2]
+			switch op {
+			case 0:
+				c.Set(k, int(arg), fuzzDuration(arg))
+				m.set(k, int(arg), fuzzDuration(arg))
+			case 1:
+				v, ok := c.Get(k)
+				if mv, mok := m.get(k); v != mv || ok != mok {
+					t.Fatalf("op %d: Get(%d) = %d, %v, expected %d, %v", n/3, k, v, ok, mv, mok)
+				}
+			case 2:
+				c.Delete(k)
+				delete(m.items, k)
+			case 3:
+				clock.Advance(time.Duration(arg%4) * time.Millisecond)
+			case 4:
+				v, ok := c.Compute(k, func(old int, loaded bool) (int, bool) {
+					return old + 1, false
+				}, fuzzDuration(arg))
+				mv, _ := m.get(k)
+				m.set(k, mv+1, fuzzDuration(arg))
+				if v != mv+1 || !ok {
+					t.Fatalf("op %d: Compute(%d) = %d, %v, expected %d, true", n/3, k, v, ok, mv+1)
+				}
+			case 5:
+				ok := c.Expire(k, fuzzDuration(arg))
+				if mok := m.expire(k, fuzzDuration(arg)); ok != mok {
+					t.Fatalf("op %d: Expire(%d) = %v, expected %v", n/3, k, ok, mok)
+				}
+			case 6:
+				v, ok := c.GetAndRefresh(k, fuzzDuration(arg))
+				mv, mok := m.get(k)
+				if mok {
+					m.expire(k, fuzzDuration(arg))
+				}
+				if v != mv || ok != mok {
+					t.Fatalf("op %d: GetAndRefresh(%d) = %d, %v, expected %d, %v", n/3, k, v, ok, mv, mok)
+				}
+			case 7:
+				v, ok := c.GetAndDelete(k)
+				mv, mok := m.get(k)
+				delete(m.items, k)
+				if v != mv || ok != mok {
+					t.Fatalf("op %d: GetAndDelete(%d) = %d, %v, expected %d, %v", n/3, k, v, ok, mv, mok)
+				}
+			case 8:
+				ok := c.SetIfAbsent(k, int(arg), fuzzDuration(arg))
+				_, mok := m.get(k)
+				if !mok {
+					m.set(k, int(arg), fuzzDuration(arg))
+				}
+				if ok == mok {
+					t.Fatalf("op %d: SetIfAbsent(%d) = %v, expected %v", n/3, k, ok, !mok)
+				}
+			case 9:
+				c.DeleteExpired()
+			}
+		}
+
+		for k := 0; k < 4; k++ {
+			v, ok := c.Get(k)
+			if mv, mok := m.get(k); v != mv || ok != mok {
+				t.Fatalf("end: Get(%d) = %d, %v, expected %d, %v", k, v, ok, mv, mok)
+			}
+		}
+		if n := c.Count(); n != len(m.items) {
+			// Count includes the expired items not yet deleted
+			c.DeleteExpired()
+			if n = c.Count(); n != len(m.items) {
+				t.Fatalf("end: Count() = %d, expected %d", n, len(m.items))
+			}
+		}
+	})
+}
+
+// TestCacheOf_ExpirationRace hammers Get while the items are replaced and the clock moves,
+// each value is its expiration, so a value returned after it expired is caught.
+func TestCacheOf_ExpirationRace(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := NewOf[int, int64](WithClockOf[int, int64](clock), WithCleanupIntervalOf[int, int64](time.Millisecond))
+	defer c.Close()
+
+	var (
+		wg   sync.WaitGroup
+		stop int32
+		// the writers hold mu, for the clock not to move between the value and its expiration
+		mu sync.RWMutex
+	)
+	go func() {
+		for atomic.LoadInt32(&stop) == 0 {
+			mu.Lock()
+			clock.Advance(time.Millisecond)
+			mu.Unlock()
+			runtime.Gosched()
+		}
+	}()
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < 20000; n++ {
+				d := time.Duration(n%3+1) * time.Millisecond
+				k := (n + w) % 8
+				mu.RLock()
+				if n%2 == 0 {
+					c.Set(k, clock.Now().Add(d).UnixNano(), d)
+				} else {
+					c.Compute(k, func(int64, bool) (int64, bool) {
+						return clock.Now().Add(d).UnixNano(), false
+					}, d)
+				}
+				mu.RUnlock()
+				if n%64 == 0 {
+					runtime.Gosched()
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50000; n++ {
+				now := clock.Now().UnixNano()
+				if e, ok := c.Get(n % 8); ok && e < now {
+					t.Errorf("Get(%d) returned a value expired at %d, read at %d", n%8, e, now)
+					return
+				}
+				if n%64 == 0 {
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	atomic.StoreInt32(&stop, 1)
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// KeyStat the estimated number of reads of a key, see Cache.HotKeys.
+type KeyStat struct {
+	Key   string
+	Count uint64
+}
+
+// hotKeys tracks the top-k most read keys, their reads being estimated by a count-min sketch.
+// The keys are stored as interface{} to be shared by Cache and CacheOf.
+type hotKeys struct {
+	mu     sync.Mutex
+	k      int
+	sketch *countMinSketch
+	top    hotKeyHeap
+	index  map[interface{}]*hotKey
+}
+
+type hotKey struct {
+	key   interface{}
+	count uint64
+	pos   int
+}
+
+func newHotKeys(k int) *hotKeys {
+	if k <= 0 {
+		return nil
+	}
+	return &hotKeys{
+		k:      k,
+		sketch: newCountMinSketch(k * 64),
+		index:  make(map[interface{}]*hotKey, k),
+	}
+}
+
+// add counts a read of the key with hash h, and tracks the key if it is among the top-k.
+func (t *hotKeys) add(key interface{}, h uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	count, reset := t.sketch.add(h)
+	if reset {
+		for _, x := range t.top {
+			x.count >>= 1
+		}
+	}
+	if x, ok := t.index[key]; ok {
+		x.count = uint64(count)
+		heap.Fix(&t.top, x.pos)
+		return
+	}
+	if len(t.top) < t.k {
+		x := &hotKey{key: key, count: uint64(count)}
+		t.index[key] = x
+		heap.Push(&t.top, x)
+		return
+	}
+	if min := t.top[0]; uint64(count) > min.count {
+		delete(t.index, min.key)
+		min.key, min.count = key, uint64(count)
+		t.index[key] = min
+		heap.Fix(&t.top, 0)
+	}
+}
+
+// hottest returns up to n tracked keys, the most read first, all of them if n is less than 1.
+func (t *hotKeys) hottest(n int, f func(key interface{}, count uint64)) {
+	t.mu.Lock()
+	top := make([]hotKey, len(t.top))
+	for i, x := range t.top {
+		top[i] = *x
+	}
+	t.mu.Unlock()
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].count > top[j].count
+	})
+	if n > 0 && n < len(top) {
+		top = top[:n]
+	}
+	for _, x := range top {
+		f(x.key, x.count)
+	}
+}
+
+// hotKeyHeap a min-heap of the tracked keys by count.
+type hotKeyHeap []*hotKey
+
+func (q hotKeyHeap) Len() int           { return len(q) }
+func (q hotKeyHeap) Less(i, j int) bool { return q[i].count < q[j].count }
+
+func (q hotKeyHeap) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].pos = i
+	q[j].pos = j
+}
+
+func (q *hotKeyHeap) Push(x interface{}) {
+	e := x.(*hotKey)
+	e.pos = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *hotKeyHeap) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
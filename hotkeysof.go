@@ -0,0 +1,10 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+// KeyStatOf the estimated number of reads of a key, see CacheOf.HotKeys.
+type KeyStatOf[K comparable] struct {
+	Key   K
+	Count uint64
+}
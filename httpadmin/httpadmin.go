@@ -0,0 +1,326 @@
+// Package httpadmin exposes the caches of a process over HTTP, as JSON, so operators can inspect them,
+// look up and delete keys, and flush them, without redeploying.
+//
+// The endpoints, relative to where the handler is mounted, e.g. with http.StripPrefix:
+//
+//	GET    /                          the names and sizes of the caches
+//	GET    /{name}                    the stats of the cache
+//	GET    /{name}/keys?pattern=&cursor=&count=
+//	                                  a page of the keys, see cache.Cache.Scan, count is at most MaxCount
+//	GET    /{name}/keys/{key}         the value, expiration time and TTL of the key
+//	DELETE /{name}/keys/{key}         deletes the key
+//	DELETE /{name}/keys               flushes the cache
+//
+// The handler does not authenticate the requests, mount it behind the authentication of the service.
+package httpadmin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fufuok/cache"
+)
+
+// MaxCount the maximum number of keys examined by a request of a page of the keys,
+// a larger count is reduced to it, so that a request cannot hold up a large cache.
+const MaxCount = 1000
+
+// target the operations of the caches used by the handler, implemented by cache.Cache.
+type target interface {
+	Count() int
+	DefaultExpiration() time.Duration
+	ShadowStats() []cache.ShadowStats
+	Scan(pattern string, cursor uint64, count int) (keys []string, next uint64)
+	PeekWithExpiration(k string) (value interface{}, expiration time.Time, ok bool)
+	GetAndDelete(k string) (value interface{}, loaded bool)
+	Clear()
+}
+
+// Config the configuration of the handler, see New.
+type Config struct {
+	// ReadOnly rejects the deletions and flushes with 405 Method Not Allowed.
+	ReadOnly bool
+
+	// Registry the caches exposed besides those registered in the handler,
+	// only the cache.Cache are exposed, register the cache.CacheOf with RegisterOf.
+	Registry *cache.Registry
+}
+
+// Option configures the handler, see New.
+type Option func(config *Config)
+
+// WithReadOnly rejects the deletions and the flushes of the caches, the handler only reads them.
+func WithReadOnly() Option {
+	return func(config *Config) {
+		config.ReadOnly = true
+	}
+}
+
+// WithRegistry exposes the caches of r, as they are registered and unregistered.
+func WithRegistry(r *cache.Registry) Option {
+	return func(config *Config) {
+		config.Registry = r
+	}
+}
+
+// Handler the http.Handler of the admin endpoints.
+type Handler struct {
+	cfg    Config
+	mu     sync.RWMutex
+	caches map[string]target
+}
+
+// New returns a handler without caches, see Register.
+func New(opts ...Option) *Handler {
+	var cfg Config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Handler{
+		cfg:    cfg,
+		caches: make(map[string]target),
+	}
+}
+
+// Register exposes the cache c under name, replacing any cache registered under the same name.
+func (h *Handler) Register(name string, c cache.Cache) {
+	h.register(name, c)
+}
+
+// Unregister stops exposing the cache registered under name.
+func (h *Handler) Unregister(name string) {
+	h.mu.Lock()
+	delete(h.caches, name)
+	h.mu.Unlock()
+}
+
+func (h *Handler) register(name string, t target) {
+	h.mu.Lock()
+	h.caches[name] = t
+	h.mu.Unlock()
+}
+
+func (h *Handler) lookup(name string) (target, bool) {
+	h.mu.RLock()
+	t, ok := h.caches[name]
+	h.mu.RUnlock()
+	if ok || h.cfg.Registry == nil {
+		return t, ok
+	}
+	c, ok := h.cfg.Registry.Lookup(name)
+	if !ok {
+		return nil, false
+	}
+	t, ok = c.(target)
+	return t, ok
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.EscapedPath(), "/"), "/", 3)
+	if parts[0] == "" {
+		h.list(w, r)
+		return
+	}
+	name, err := url.PathUnescape(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, ok := h.lookup(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "cache not found")
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		h.stats(w, r, name, t)
+	case parts[1] != "keys":
+		writeError(w, http.StatusNotFound, "not found")
+	case len(parts) == 2:
+		h.keys(w, r, t)
+	default:
+		k, err := url.PathUnescape(parts[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.key(w, r, t, k)
+	}
+}
+
+type cacheInfo struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	seen := make(map[string]bool)
+	caches := []cacheInfo{}
+	h.mu.RLock()
+	for name, t := range h.caches {
+		seen[name] = true
+		caches = append(caches, cacheInfo{Name: name, Count: t.Count()})
+	}
+	h.mu.RUnlock()
+	if h.cfg.Registry != nil {
+		h.cfg.Registry.Range(func(name string, c cache.Registered) bool {
+			if _, ok := c.(target); ok && !seen[name] {
+				caches = append(caches, cacheInfo{Name: name, Count: c.Count()})
+			}
+			return true
+		})
+	}
+	sort.Slice(caches, func(i, j int) bool { return caches[i].Name < caches[j].Name })
+	writeJSON(w, http.StatusOK, caches)
+}
+
+type statsInfo struct {
+	Name              string   `json:"name"`
+	Count             int      `json:"count"`
+	DefaultExpiration string   `json:"default_expiration"`
+	Shadows           []string `json:"shadows,omitempty"`
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request, name string, t target) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	s := statsInfo{
+		Name:              name,
+		Count:             t.Count(),
+		DefaultExpiration: t.DefaultExpiration().String(),
+	}
+	for _, x := range t.ShadowStats() {
+		s.Shadows = append(s.Shadows, x.String())
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+type keysInfo struct {
+	Keys   []string `json:"keys"`
+	Cursor uint64   `json:"cursor"`
+}
+
+func (h *Handler) keys(w http.ResponseWriter, r *http.Request, t target) {
+	if r.Method == http.MethodDelete {
+		if !h.writable(w) {
+			return
+		}
+		t.Clear()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !allow(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	q := r.URL.Query()
+	var (
+		cursor uint64
+		count  int
+		err    error
+	)
+	if s := q.Get("cursor"); s != "" {
+		if cursor, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+	if s := q.Get("count"); s != "" {
+		if count, err = strconv.Atoi(s); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid count")
+			return
+		}
+		if count > MaxCount {
+			count = MaxCount
+		}
+	}
+	keys, next := t.Scan(q.Get("pattern"), cursor, count)
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, keysInfo{Keys: keys, Cursor: next})
+}
+
+type keyInfo struct {
+	Key        string      `json:"key"`
+	Value      interface{} `json:"value"`
+	Expiration *time.Time  `json:"expiration,omitempty"`
+	TTL        string      `json:"ttl,omitempty"`
+}
+
+func (h *Handler) key(w http.ResponseWriter, r *http.Request, t target, k string) {
+	switch r.Method {
+	case http.MethodGet:
+		v, e, ok := t.PeekWithExpiration(k)
+		if !ok {
+			writeError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		x := keyInfo{Key: k, Value: jsonValue(v)}
+		if !e.IsZero() {
+			x.Expiration = &e
+			x.TTL = time.Until(e).Round(time.Millisecond).String()
+		}
+		writeJSON(w, http.StatusOK, x)
+	case http.MethodDelete:
+		if !h.writable(w) {
+			return
+		}
+		if _, ok := t.GetAndDelete(k); !ok {
+			writeError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		allow(w, r, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (h *Handler) writable(w http.ResponseWriter) bool {
+	if h.cfg.ReadOnly {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "read-only")
+		return false
+	}
+	return true
+}
+
+// allow reports whether the method of r is one of methods, replying 405 Method Not Allowed otherwise.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// jsonValue returns v if it can be encoded as JSON, its fmt representation otherwise.
+func jsonValue(v interface{}) interface{} {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,145 @@
+package httpadmin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fufuok/cache"
+)
+
+func do(t *testing.T, h http.Handler, method, target string, code int, v interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	if w.Code != code {
+		t.Fatalf("%s %s: expected %d, got %d: %s", method, target, code, w.Code, w.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := cache.New()
+	defer c.Close()
+	c.Set("a/b", map[string]int{"x": 1}, time.Minute)
+	c.SetForever("c", func() {})
+	sessions := cache.NewOf[string, int]()
+	defer sessions.Close()
+	sessions.SetForever("s1", 1)
+
+	h := New()
+	h.Register("users", c)
+	RegisterOf[int](h, "sessions", sessions)
+
+	var caches []cacheInfo
+	do(t, h, http.MethodGet, "/", http.StatusOK, &caches)
+	if len(caches) != 2 || caches[0] != (cacheInfo{"sessions", 1}) || caches[1] != (cacheInfo{"users", 2}) {
+		t.Fatalf("unexpected caches: %v", caches)
+	}
+
+	var stats statsInfo
+	do(t, h, http.MethodGet, "/users", http.StatusOK, &stats)
+	if stats.Count != 2 {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+	do(t, h, http.MethodGet, "/missing", http.StatusNotFound, nil)
+
+	var keys keysInfo
+	do(t, h, http.MethodGet, "/users/keys?pattern=a*&count=10", http.StatusOK, &keys)
+	if len(keys.Keys) != 1 || keys.Keys[0] != "a/b" || keys.Cursor != 0 {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	var key keyInfo
+	do(t, h, http.MethodGet, "/users/keys/a%2Fb", http.StatusOK, &key)
+	if key.Key != "a/b" || key.Expiration == nil || key.TTL == "" {
+		t.Fatalf("unexpected key: %+v", key)
+	}
+	if m, ok := key.Value.(map[string]interface{}); !ok || m["x"] != 1.0 {
+		t.Fatalf("unexpected value: %v", key.Value)
+	}
+	key = keyInfo{}
+	do(t, h, http.MethodGet, "/users/keys/c", http.StatusOK, &key)
+	if _, ok := key.Value.(string); !ok || key.Expiration != nil {
+		t.Fatalf("unexpected key: %+v", key)
+	}
+
+	do(t, h, http.MethodDelete, "/users/keys/c", http.StatusNoContent, nil)
+	do(t, h, http.MethodDelete, "/users/keys/c", http.StatusNotFound, nil)
+	do(t, h, http.MethodPost, "/users/keys/c", http.StatusMethodNotAllowed, nil)
+	do(t, h, http.MethodDelete, "/sessions/keys", http.StatusNoContent, nil)
+	if sessions.Count() != 0 {
+		t.Fatal("sessions should be flushed")
+	}
+
+	r := cache.NewRegistry()
+	r.Register("sessions", sessions)
+	r.Register("users", c)
+	h = New(WithRegistry(r))
+	caches = nil
+	do(t, h, http.MethodGet, "/", http.StatusOK, &caches)
+	if len(caches) != 1 || caches[0].Name != "users" {
+		t.Fatalf("only the cache.Cache of the registry should be exposed, got %v", caches)
+	}
+	do(t, h, http.MethodGet, "/users/keys/a%2Fb", http.StatusOK, nil)
+	do(t, h, http.MethodGet, "/sessions", http.StatusNotFound, nil)
+
+	h = New(WithReadOnly())
+	h.Register("users", c)
+	do(t, h, http.MethodDelete, "/users/keys/a%2Fb", http.StatusMethodNotAllowed, nil)
+	h.Unregister("users")
+	do(t, h, http.MethodGet, "/users", http.StatusNotFound, nil)
+}
+
+func TestHandler_Peek(t *testing.T) {
+	var loads int
+	c := cache.New(cache.WithLoader(func(context.Context, string) (interface{}, time.Duration, error) {
+		loads++
+		return "loaded", cache.NoExpiration, nil
+	}), cache.WithSlidingExpiration(), cache.WithHotKeys(8))
+	defer c.Close()
+	c.Set("a", 1, time.Minute)
+	_, e, _ := c.PeekWithExpiration("a")
+
+	h := New()
+	h.Register("users", c)
+	do(t, h, http.MethodGet, "/users/keys/missing", http.StatusNotFound, nil)
+	do(t, h, http.MethodGet, "/users/keys/missing", http.StatusNotFound, nil)
+	if loads != 0 || c.Count() != 1 {
+		t.Fatalf("the missing key should not be loaded, got %d loads", loads)
+	}
+
+	time.Sleep(time.Millisecond)
+	do(t, h, http.MethodGet, "/users/keys/a", http.StatusOK, nil)
+	if _, e2, _ := c.PeekWithExpiration("a"); !e2.Equal(e) {
+		t.Fatal("the expiration should not slide")
+	}
+	if keys := c.HotKeys(8); len(keys) != 0 {
+		t.Fatalf("the reads should not be counted, got %v", keys)
+	}
+}
+
+func TestHandler_MaxCount(t *testing.T) {
+	c := cache.New()
+	defer c.Close()
+	for i := 0; i < 2*MaxCount; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	h := New()
+	h.Register("c", c)
+
+	var keys keysInfo
+	do(t, h, http.MethodGet, "/c/keys?count="+strconv.Itoa(4*MaxCount), http.StatusOK, &keys)
+	if len(keys.Keys) == 0 || len(keys.Keys) >= 2*MaxCount || keys.Cursor == 0 {
+		t.Fatalf("expected a page of at most about %d keys, got %d keys and the cursor %d",
+			MaxCount, len(keys.Keys), keys.Cursor)
+	}
+}
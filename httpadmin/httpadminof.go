@@ -0,0 +1,34 @@
+//go:build go1.18
+// +build go1.18
+
+package httpadmin
+
+import (
+	"time"
+
+	"github.com/fufuok/cache"
+)
+
+// RegisterOf exposes the cache c with string keys under name, see Handler.Register.
+func RegisterOf[V any](h *Handler, name string, c cache.CacheOf[string, V]) {
+	h.register(name, targetOf[V]{c})
+}
+
+// targetOf adapts a cache.CacheOf with string keys.
+type targetOf[V any] struct {
+	c cache.CacheOf[string, V]
+}
+
+func (t targetOf[V]) Count() int                                { return t.c.Count() }
+func (t targetOf[V]) DefaultExpiration() time.Duration          { return t.c.DefaultExpiration() }
+func (t targetOf[V]) ShadowStats() []cache.ShadowStats          { return t.c.ShadowStats() }
+func (t targetOf[V]) Clear()                                    { t.c.Clear() }
+func (t targetOf[V]) GetAndDelete(k string) (interface{}, bool) { return t.c.GetAndDelete(k) }
+
+func (t targetOf[V]) Scan(pattern string, cursor uint64, count int) ([]string, uint64) {
+	return cache.ScanOf(t.c, pattern, cursor, count)
+}
+
+func (t targetOf[V]) PeekWithExpiration(k string) (interface{}, time.Time, bool) {
+	return t.c.PeekWithExpiration(k)
+}
@@ -3,6 +3,7 @@ package xsync
 import (
 	"fmt"
 	"math"
+	"math/bits"
 	"runtime"
 	"strings"
 	"sync"
@@ -38,6 +39,19 @@ const (
 	maxMapCounterLen = 32
 )
 
+// ComputeOp tells Compute what to do with the value computed by its
+// function.
+type ComputeOp int
+
+const (
+	// UpdateOp stores the new value for the key.
+	UpdateOp ComputeOp = iota
+	// DeleteOp deletes the value for the key, if present.
+	DeleteOp
+	// CancelOp leaves the map unchanged.
+	CancelOp
+)
+
 var (
 	topHashMask       = uint64((1<<20)-1) << 44
 	topHashEntryMasks = [3]uint64{
@@ -76,6 +90,8 @@ type Map struct {
 	table        unsafe.Pointer // *mapTable
 	minTableLen  int
 	growOnly     bool
+	shrinkFrac   float64
+	hasher       func(string, uint64) uint64
 }
 
 type mapTable struct {
@@ -121,8 +137,9 @@ type rangeEntry struct {
 
 // MapConfig defines configurable Map/MapOf options.
 type MapConfig struct {
-	sizeHint int
-	growOnly bool
+	sizeHint       int
+	growOnly       bool
+	shrinkFraction float64
 }
 
 // WithPresize configures new Map/MapOf instance with capacity enough
@@ -147,9 +164,42 @@ func WithGrowOnly() func(*MapConfig) {
 	}
 }
 
+// WithShrinkThreshold configures new Map/MapOf instance to shrink
+// its hash table by half once the occupation of the table falls to
+// the given fraction of its capacity, 1/128 by default. A lower
+// fraction avoids resize thrash under bursty churn, a higher one
+// releases the memory sooner. The fraction must be positive and
+// below half of the load factor, 0.375, for a shrunk table not to
+// grow right away, other values are ignored.
+func WithShrinkThreshold(fraction float64) func(*MapConfig) {
+	return func(c *MapConfig) {
+		c.shrinkFraction = fraction
+	}
+}
+
+// shrinkFraction returns the fraction of the table occupation to start
+// a table shrinking, the one of the config if valid, see WithShrinkThreshold.
+func shrinkFraction(c *MapConfig) float64 {
+	if c.shrinkFraction > 0 && c.shrinkFraction < mapLoadFactor/2 {
+		return c.shrinkFraction
+	}
+	return 1.0 / mapShrinkFraction
+}
+
 // NewMap creates a new Map instance configured with the given
 // options.
 func NewMap(options ...func(*MapConfig)) *Map {
+	return NewMapWithHasher(hashString, options...)
+}
+
+// NewMapWithHasher creates a new Map instance configured with
+// the given hasher and options. The hash function is used instead
+// of the built-in hash function configured when a map is created
+// with the NewMap function.
+func NewMapWithHasher(
+	hasher func(string, uint64) uint64,
+	options ...func(*MapConfig),
+) *Map {
 	c := &MapConfig{
 		sizeHint: defaultMinMapTableLen * entriesPerMapBucket,
 	}
@@ -159,15 +209,17 @@ func NewMap(options ...func(*MapConfig)) *Map {
 
 	m := &Map{}
 	m.resizeCond = *sync.NewCond(&m.resizeMu)
+	m.hasher = hasher
 	var table *mapTable
 	if c.sizeHint <= defaultMinMapTableLen*entriesPerMapBucket {
-		table = newMapTable(defaultMinMapTableLen)
+		table = newMapTable(defaultMinMapTableLen, makeSeed())
 	} else {
 		tableLen := nextPowOf2(uint32((float64(c.sizeHint) / entriesPerMapBucket) / mapLoadFactor))
-		table = newMapTable(int(tableLen))
+		table = newMapTable(int(tableLen), makeSeed())
 	}
 	m.minTableLen = len(table.buckets)
 	m.growOnly = c.growOnly
+	m.shrinkFrac = shrinkFraction(c)
 	atomic.StorePointer(&m.table, unsafe.Pointer(table))
 	return m
 }
@@ -183,7 +235,8 @@ func NewMapPresized(sizeHint int) *Map {
 	return NewMap(WithPresize(sizeHint))
 }
 
-func newMapTable(minTableLen int) *mapTable {
+// newMapTable returns a table of minTableLen buckets hashing the keys with seed.
+func newMapTable(minTableLen int, seed uint64) *mapTable {
 	buckets := make([]bucketPadded, minTableLen)
 	counterLen := minTableLen >> 10
 	if counterLen < minMapCounterLen {
@@ -195,7 +248,7 @@ func newMapTable(minTableLen int) *mapTable {
 	t := &mapTable{
 		buckets: buckets,
 		size:    counter,
-		seed:    makeSeed(),
+		seed:    seed,
 	}
 	return t
 }
@@ -205,7 +258,7 @@ func newMapTable(minTableLen int) *mapTable {
 // The ok result indicates whether value was found in the map.
 func (m *Map) Load(key string) (value interface{}, ok bool) {
 	table := (*mapTable)(atomic.LoadPointer(&m.table))
-	hash := hashString(key, table.seed)
+	hash := m.hasher(key, table.seed)
 	bidx := uint64(len(table.buckets)-1) & hash
 	b := &table.buckets[bidx]
 	for {
@@ -241,8 +294,8 @@ func (m *Map) Load(key string) (value interface{}, ok bool) {
 func (m *Map) Store(key string, value interface{}) {
 	m.doCompute(
 		key,
-		func(interface{}, bool) (interface{}, bool) {
-			return value, false
+		func(interface{}, bool) (interface{}, ComputeOp) {
+			return value, UpdateOp
 		},
 		false,
 		false,
@@ -255,8 +308,8 @@ func (m *Map) Store(key string, value interface{}) {
 func (m *Map) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
 	return m.doCompute(
 		key,
-		func(interface{}, bool) (interface{}, bool) {
-			return value, false
+		func(interface{}, bool) (interface{}, ComputeOp) {
+			return value, UpdateOp
 		},
 		true,
 		false,
@@ -271,14 +324,23 @@ func (m *Map) LoadOrStore(key string, value interface{}) (actual interface{}, lo
 func (m *Map) LoadAndStore(key string, value interface{}) (actual interface{}, loaded bool) {
 	return m.doCompute(
 		key,
-		func(interface{}, bool) (interface{}, bool) {
-			return value, false
+		func(interface{}, bool) (interface{}, ComputeOp) {
+			return value, UpdateOp
 		},
 		false,
 		false,
 	)
 }
 
+// Swap stores the value for the key and returns the previous value, if any,
+// like sync.Map.Swap. The loaded result reports whether the key was present.
+func (m *Map) Swap(key string, value interface{}) (previous interface{}, loaded bool) {
+	if previous, loaded = m.LoadAndStore(key, value); !loaded {
+		previous = nil
+	}
+	return
+}
+
 // LoadOrCompute returns the existing value for the key if present.
 // Otherwise, it computes the value using the provided function and
 // returns the computed value. The loaded result is true if the value
@@ -291,8 +353,37 @@ func (m *Map) LoadAndStore(key string, value interface{}) (actual interface{}, l
 func (m *Map) LoadOrCompute(key string, valueFn func() interface{}) (actual interface{}, loaded bool) {
 	return m.doCompute(
 		key,
-		func(interface{}, bool) (interface{}, bool) {
-			return valueFn(), false
+		func(interface{}, bool) (interface{}, ComputeOp) {
+			return valueFn(), UpdateOp
+		},
+		true,
+		false,
+	)
+}
+
+// LoadOrTryCompute returns the existing value for the key if present.
+// Otherwise, it tries to compute the value using the provided function
+// and, unless cancelled, stores and returns the computed value.
+// The loaded result is true if the value was loaded, false if computed,
+// whether stored or not. If the computation is cancelled, for example
+// on an error, nothing is stored and the zero value is returned.
+//
+// This call locks a hash table bucket while the compute function
+// is executed. It means that modifications on other entries in
+// the bucket will be blocked until the valueFn executes. Consider
+// this when the function includes long-running operations.
+func (m *Map) LoadOrTryCompute(
+	key string,
+	valueFn func() (newValue interface{}, cancel bool),
+) (value interface{}, loaded bool) {
+	return m.doCompute(
+		key,
+		func(interface{}, bool) (interface{}, ComputeOp) {
+			newValue, cancel := valueFn()
+			if cancel {
+				return newValue, CancelOp
+			}
+			return newValue, UpdateOp
 		},
 		true,
 		false,
@@ -314,18 +405,76 @@ func (m *Map) LoadOrCompute(key string, valueFn func() interface{}) (actual inte
 func (m *Map) Compute(
 	key string,
 	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, delete bool),
+) (actual interface{}, ok bool) {
+	return m.ComputeWithOp(key, deleteOp(valueFn))
+}
+
+// ComputeWithOp either sets the computed new value for the key, deletes
+// the value for the key, or does nothing, depending on the op result
+// of the valueFn function. With UpdateOp, the value is updated to the
+// newValue. With DeleteOp, the value is deleted, if it exists. With
+// CancelOp, the map is left unchanged.
+// The ok result indicates whether the key is present in the map after
+// the call. The actual result contains the new value in cases where
+// the value was computed and stored, or the unchanged value when
+// an existing value is kept by CancelOp.
+//
+// This call locks a hash table bucket while the compute function
+// is executed, like Compute.
+func (m *Map) ComputeWithOp(
+	key string,
+	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, op ComputeOp),
 ) (actual interface{}, ok bool) {
 	return m.doCompute(key, valueFn, false, true)
 }
 
+// deleteOp returns the function of ComputeWithOp equivalent to the function
+// valueFn of Compute.
+func deleteOp(
+	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, delete bool),
+) func(oldValue interface{}, loaded bool) (interface{}, ComputeOp) {
+	return func(oldValue interface{}, loaded bool) (interface{}, ComputeOp) {
+		newValue, del := valueFn(oldValue, loaded)
+		if del {
+			return newValue, DeleteOp
+		}
+		return newValue, UpdateOp
+	}
+}
+
+// UpdateIfPresent replaces the value of the key by the one valueFn returns
+// from the current value, only if the key is present, and returns the new value.
+// The updated result reports whether the key was present, nothing is stored otherwise.
+//
+// This call locks a hash table bucket while the compute function
+// is executed. It means that modifications on other entries in
+// the bucket will be blocked until the valueFn executes. Consider
+// this when the function includes long-running operations.
+func (m *Map) UpdateIfPresent(
+	key string,
+	valueFn func(oldValue interface{}) interface{},
+) (value interface{}, updated bool) {
+	return m.doCompute(
+		key,
+		func(oldValue interface{}, loaded bool) (interface{}, ComputeOp) {
+			if !loaded {
+				return oldValue, CancelOp
+			}
+			return valueFn(oldValue), UpdateOp
+		},
+		false,
+		true,
+	)
+}
+
 // LoadAndDelete deletes the value for a key, returning the previous
 // value if any. The loaded result reports whether the key was
 // present.
 func (m *Map) LoadAndDelete(key string) (value interface{}, loaded bool) {
 	return m.doCompute(
 		key,
-		func(value interface{}, loaded bool) (interface{}, bool) {
-			return value, true
+		func(value interface{}, loaded bool) (interface{}, ComputeOp) {
+			return value, DeleteOp
 		},
 		false,
 		false,
@@ -336,8 +485,8 @@ func (m *Map) LoadAndDelete(key string) (value interface{}, loaded bool) {
 func (m *Map) Delete(key string) {
 	m.doCompute(
 		key,
-		func(value interface{}, loaded bool) (interface{}, bool) {
-			return value, true
+		func(value interface{}, loaded bool) (interface{}, ComputeOp) {
+			return value, DeleteOp
 		},
 		false,
 		false,
@@ -346,7 +495,7 @@ func (m *Map) Delete(key string) {
 
 func (m *Map) doCompute(
 	key string,
-	valueFn func(oldValue interface{}, loaded bool) (interface{}, bool),
+	valueFn func(oldValue interface{}, loaded bool) (interface{}, ComputeOp),
 	loadIfExists, computeOnly bool,
 ) (interface{}, bool) {
 	// Read-only path.
@@ -365,7 +514,7 @@ func (m *Map) doCompute(
 		)
 		table := (*mapTable)(atomic.LoadPointer(&m.table))
 		tableLen := len(table.buckets)
-		hash := hashString(key, table.seed)
+		hash := m.hasher(key, table.seed)
 		bidx := uint64(len(table.buckets)-1) & hash
 		rootb := &table.buckets[bidx]
 		lockBucket(&rootb.topHashMutex)
@@ -409,8 +558,9 @@ func (m *Map) doCompute(
 					// snapshot won't be correct in case of multiple Store calls
 					// using the same value.
 					oldValue := derefValue(vp)
-					newValue, del := valueFn(oldValue, true)
-					if del {
+					newValue, op := valueFn(oldValue, true)
+					switch op {
+					case DeleteOp:
 						// Deletion.
 						// First we update the value, then the key.
 						// This is important for atomic snapshot states.
@@ -428,18 +578,22 @@ func (m *Map) doCompute(
 							m.resize(table, mapShrinkHint)
 						}
 						return oldValue, !computeOnly
+					case UpdateOp:
+						nvp := unsafe.Pointer(&newValue)
+						if assertionsEnabled && vp == nvp {
+							panic("non-unique value pointer")
+						}
+						atomic.StorePointer(&b.values[i], nvp)
+						unlockBucket(&rootb.topHashMutex)
+						if computeOnly {
+							// Compute expects the new value to be returned.
+							return newValue, true
+						}
+						// LoadAndStore expects the old value to be returned.
+						return oldValue, true
 					}
-					nvp := unsafe.Pointer(&newValue)
-					if assertionsEnabled && vp == nvp {
-						panic("non-unique value pointer")
-					}
-					atomic.StorePointer(&b.values[i], nvp)
+					// CancelOp: the entry is left unchanged.
 					unlockBucket(&rootb.topHashMutex)
-					if computeOnly {
-						// Compute expects the new value to be returned.
-						return newValue, true
-					}
-					// LoadAndStore expects the old value to be returned.
 					return oldValue, true
 				}
 				hintNonEmpty++
@@ -448,8 +602,8 @@ func (m *Map) doCompute(
 				if emptyb != nil {
 					// Insertion into an existing bucket.
 					var zeroedV interface{}
-					newValue, del := valueFn(zeroedV, false)
-					if del {
+					newValue, op := valueFn(zeroedV, false)
+					if op != UpdateOp {
 						unlockBucket(&rootb.topHashMutex)
 						return zeroedV, false
 					}
@@ -472,10 +626,10 @@ func (m *Map) doCompute(
 				}
 				// Insertion into a new bucket.
 				var zeroedV interface{}
-				newValue, del := valueFn(zeroedV, false)
-				if del {
+				newValue, op := valueFn(zeroedV, false)
+				if op != UpdateOp {
 					unlockBucket(&rootb.topHashMutex)
-					return newValue, false
+					return nil, false
 				}
 				// Create and append a bucket.
 				newb := new(bucketPadded)
@@ -509,13 +663,19 @@ func (m *Map) waitForResize() {
 	m.resizeMu.Unlock()
 }
 
+// shrinkThreshold returns the number of entries to start a shrinking
+// of a table of tableLen buckets.
+func (m *Map) shrinkThreshold(tableLen int) int64 {
+	return int64(float64(tableLen*entriesPerMapBucket) * m.shrinkFrac)
+}
+
 func (m *Map) resize(knownTable *mapTable, hint mapResizeHint) {
 	knownTableLen := len(knownTable.buckets)
 	// Fast path for shrink attempts.
 	if hint == mapShrinkHint {
 		if m.growOnly ||
 			m.minTableLen == knownTableLen ||
-			knownTable.sumSize() > int64((knownTableLen*entriesPerMapBucket)/mapShrinkFraction) {
+			knownTable.sumSize() > m.shrinkThreshold(knownTableLen) {
 			return
 		}
 	}
@@ -530,15 +690,15 @@ func (m *Map) resize(knownTable *mapTable, hint mapResizeHint) {
 	tableLen := len(table.buckets)
 	switch hint {
 	case mapGrowHint:
-		// Grow the table with factor of 2.
+		// Grow the table with factor of 2. The seed is kept,
+		// so that the keys keep their positions for RangeCursor.
 		atomic.AddInt64(&m.totalGrowths, 1)
-		newTable = newMapTable(tableLen << 1)
+		newTable = newMapTable(tableLen<<1, table.seed)
 	case mapShrinkHint:
-		shrinkThreshold := int64((tableLen * entriesPerMapBucket) / mapShrinkFraction)
-		if tableLen > m.minTableLen && table.sumSize() <= shrinkThreshold {
+		if tableLen > m.minTableLen && table.sumSize() <= m.shrinkThreshold(tableLen) {
 			// Shrink the table with factor of 2.
 			atomic.AddInt64(&m.totalShrinks, 1)
-			newTable = newMapTable(tableLen >> 1)
+			newTable = newMapTable(tableLen>>1, table.seed)
 		} else {
 			// No need to shrink. Wake up all waiters and give up.
 			m.resizeMu.Lock()
@@ -548,14 +708,14 @@ func (m *Map) resize(knownTable *mapTable, hint mapResizeHint) {
 			return
 		}
 	case mapClearHint:
-		newTable = newMapTable(m.minTableLen)
+		newTable = newMapTable(m.minTableLen, makeSeed())
 	default:
 		panic(fmt.Sprintf("unexpected resize hint: %d", hint))
 	}
 	// Copy the data only if we're not clearing the map.
 	if hint != mapClearHint {
 		for i := 0; i < tableLen; i++ {
-			copied := copyBucket(&table.buckets[i], newTable)
+			copied := copyBucket(&table.buckets[i], newTable, m.hasher)
 			newTable.addSizePlain(uint64(i), copied)
 		}
 	}
@@ -567,14 +727,18 @@ func (m *Map) resize(knownTable *mapTable, hint mapResizeHint) {
 	m.resizeMu.Unlock()
 }
 
-func copyBucket(b *bucketPadded, destTable *mapTable) (copied int) {
+func copyBucket(
+	b *bucketPadded,
+	destTable *mapTable,
+	hasher func(string, uint64) uint64,
+) (copied int) {
 	rootb := b
 	lockBucket(&rootb.topHashMutex)
 	for {
 		for i := 0; i < entriesPerMapBucket; i++ {
 			if b.keys[i] != nil {
 				k := derefKey(b.keys[i])
-				hash := hashString(k, destTable.seed)
+				hash := hasher(k, destTable.seed)
 				bidx := uint64(len(destTable.buckets)-1) & hash
 				destb := &destTable.buckets[bidx]
 				appendToBucket(hash, b.keys[i], b.values[i], destb)
@@ -681,6 +845,87 @@ func (m *Map) Range(f func(key string, value interface{}) bool) {
 	}
 }
 
+// RangeSnapshot calls f sequentially for each key present in the map
+// when it is called, along with its current value. If f returns false,
+// range stops the iteration.
+//
+// Unlike Range, the keys are captured first, and their values are loaded
+// as they are visited: each key is visited at most once, the keys stored
+// during the call are not visited, and the keys deleted before their turn
+// are skipped. It is safe to modify the map while iterating it.
+func (m *Map) RangeSnapshot(f func(key string, value interface{}) bool) {
+	keys := make([]string, 0, m.Size())
+	m.Range(func(key string, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		if value, ok := m.Load(key); ok && !f(key, value) {
+			return
+		}
+	}
+}
+
+// RangeCursor calls f sequentially for the keys and values of the map,
+// starting from the position of the cursor, and returns the cursor to resume
+// the iteration from, 0 once all the keys have been visited,
+// see MapOf.RangeCursor.
+func (m *Map) RangeCursor(cursor uint64, count int, f func(key string, value interface{})) uint64 {
+	var zeroEntry rangeEntry
+	bentries := make([]rangeEntry, 0, entriesPerMapBucket)
+	table := (*mapTable)(atomic.LoadPointer(&m.table))
+	mask := uint64(len(table.buckets) - 1)
+	visited := 0
+	for {
+		start, next := cursorBucket(cursor, mask)
+		rootb := &table.buckets[bits.Reverse64(start)&mask]
+		b := rootb
+		lockBucket(&rootb.topHashMutex)
+		for {
+			for i := 0; i < entriesPerMapBucket; i++ {
+				if b.keys[i] != nil {
+					bentries = append(bentries, rangeEntry{
+						key:   b.keys[i],
+						value: b.values[i],
+					})
+				}
+			}
+			if b.next == nil {
+				unlockBucket(&rootb.topHashMutex)
+				break
+			}
+			b = (*bucketPadded)(b.next)
+		}
+		if visited > 0 && visited+len(bentries) > count {
+			// resumed from this bucket by the next call
+			return start
+		}
+		for j := range bentries {
+			k := derefKey(bentries[j].key)
+			// the keys of the bucket behind the cursor were visited, before a shrink
+			if cursor == start || bits.Reverse64(m.hasher(k, table.seed)) >= cursor {
+				f(k, derefValue(bentries[j].value))
+			}
+			bentries[j] = zeroEntry
+		}
+		visited += len(bentries)
+		bentries = bentries[:0]
+		if cursor = next; cursor == 0 {
+			return 0
+		}
+	}
+}
+
+// cursorBucket returns the position of the first key of the bucket of the position cursor
+// in a table of mask+1 buckets, and the position of the following bucket, 0 after the last one.
+// The position of a key is its hash reversed, so that the keys of a bucket, sharing the low bits
+// of their hashes, are contiguous, and a bucket is split by a growth or merged by a shrink
+// with its neighbours, like the positions of the Redis SCAN.
+func cursorBucket(cursor, mask uint64) (start, next uint64) {
+	low := ^uint64(0) >> bits.OnesCount64(mask)
+	return cursor &^ low, (cursor | low) + 1
+}
+
 // Clear deletes all keys and values currently stored in the map.
 func (m *Map) Clear() {
 	table := (*mapTable)(atomic.LoadPointer(&m.table))
@@ -6,6 +6,7 @@ package xsync
 import (
 	"fmt"
 	"math"
+	"math/bits"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -52,6 +53,7 @@ type MapOf[K comparable, V any] struct {
 	hasher       func(K, uint64) uint64
 	minTableLen  int
 	growOnly     bool
+	shrinkFrac   float64
 }
 
 type mapOfTable[K comparable, V any] struct {
@@ -110,13 +112,14 @@ func NewMapOfWithHasher[K comparable, V any](
 	m.hasher = hasher
 	var table *mapOfTable[K, V]
 	if c.sizeHint <= defaultMinMapTableLen*entriesPerMapOfBucket {
-		table = newMapOfTable[K, V](defaultMinMapTableLen)
+		table = newMapOfTable[K, V](defaultMinMapTableLen, makeSeed())
 	} else {
 		tableLen := nextPowOf2(uint32((float64(c.sizeHint) / entriesPerMapOfBucket) / mapLoadFactor))
-		table = newMapOfTable[K, V](int(tableLen))
+		table = newMapOfTable[K, V](int(tableLen), makeSeed())
 	}
 	m.minTableLen = len(table.buckets)
 	m.growOnly = c.growOnly
+	m.shrinkFrac = shrinkFraction(c)
 	atomic.StorePointer(&m.table, unsafe.Pointer(table))
 	return m
 }
@@ -132,7 +135,8 @@ func NewMapOfPresized[K comparable, V any](sizeHint int) *MapOf[K, V] {
 	return NewMapOf[K, V](WithPresize(sizeHint))
 }
 
-func newMapOfTable[K comparable, V any](minTableLen int) *mapOfTable[K, V] {
+// newMapOfTable returns a table of minTableLen buckets hashing the keys with seed.
+func newMapOfTable[K comparable, V any](minTableLen int, seed uint64) *mapOfTable[K, V] {
 	buckets := make([]bucketOfPadded, minTableLen)
 	for i := range buckets {
 		buckets[i].meta = defaultMeta
@@ -147,7 +151,7 @@ func newMapOfTable[K comparable, V any](minTableLen int) *mapOfTable[K, V] {
 	t := &mapOfTable[K, V]{
 		buckets: buckets,
 		size:    counter,
-		seed:    makeSeed(),
+		seed:    seed,
 	}
 	return t
 }
@@ -188,8 +192,8 @@ func (m *MapOf[K, V]) Load(key K) (value V, ok bool) {
 func (m *MapOf[K, V]) Store(key K, value V) {
 	m.doCompute(
 		key,
-		func(V, bool) (V, bool) {
-			return value, false
+		func(V, bool) (V, ComputeOp) {
+			return value, UpdateOp
 		},
 		false,
 		false,
@@ -202,8 +206,8 @@ func (m *MapOf[K, V]) Store(key K, value V) {
 func (m *MapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
 	return m.doCompute(
 		key,
-		func(V, bool) (V, bool) {
-			return value, false
+		func(V, bool) (V, ComputeOp) {
+			return value, UpdateOp
 		},
 		true,
 		false,
@@ -218,14 +222,24 @@ func (m *MapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
 func (m *MapOf[K, V]) LoadAndStore(key K, value V) (actual V, loaded bool) {
 	return m.doCompute(
 		key,
-		func(V, bool) (V, bool) {
-			return value, false
+		func(V, bool) (V, ComputeOp) {
+			return value, UpdateOp
 		},
 		false,
 		false,
 	)
 }
 
+// Swap stores the value for the key and returns the previous value, if any,
+// like sync.Map.Swap. The loaded result reports whether the key was present.
+func (m *MapOf[K, V]) Swap(key K, value V) (previous V, loaded bool) {
+	if previous, loaded = m.LoadAndStore(key, value); !loaded {
+		var zeroedV V
+		previous = zeroedV
+	}
+	return
+}
+
 // LoadOrCompute returns the existing value for the key if present.
 // Otherwise, it computes the value using the provided function and
 // returns the computed value. The loaded result is true if the value
@@ -238,8 +252,37 @@ func (m *MapOf[K, V]) LoadAndStore(key K, value V) (actual V, loaded bool) {
 func (m *MapOf[K, V]) LoadOrCompute(key K, valueFn func() V) (actual V, loaded bool) {
 	return m.doCompute(
 		key,
-		func(V, bool) (V, bool) {
-			return valueFn(), false
+		func(V, bool) (V, ComputeOp) {
+			return valueFn(), UpdateOp
+		},
+		true,
+		false,
+	)
+}
+
+// LoadOrTryCompute returns the existing value for the key if present.
+// Otherwise, it tries to compute the value using the provided function
+// and, unless cancelled, stores and returns the computed value.
+// The loaded result is true if the value was loaded, false if computed,
+// whether stored or not. If the computation is cancelled, for example
+// on an error, nothing is stored and the zero value is returned.
+//
+// This call locks a hash table bucket while the compute function
+// is executed. It means that modifications on other entries in
+// the bucket will be blocked until the valueFn executes. Consider
+// this when the function includes long-running operations.
+func (m *MapOf[K, V]) LoadOrTryCompute(
+	key K,
+	valueFn func() (newValue V, cancel bool),
+) (value V, loaded bool) {
+	return m.doCompute(
+		key,
+		func(V, bool) (V, ComputeOp) {
+			newValue, cancel := valueFn()
+			if cancel {
+				return newValue, CancelOp
+			}
+			return newValue, UpdateOp
 		},
 		true,
 		false,
@@ -261,18 +304,73 @@ func (m *MapOf[K, V]) LoadOrCompute(key K, valueFn func() V) (actual V, loaded b
 func (m *MapOf[K, V]) Compute(
 	key K,
 	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
+) (actual V, ok bool) {
+	return m.ComputeWithOp(key, deleteOpOf(valueFn))
+}
+
+// ComputeWithOp either sets the computed new value for the key, deletes
+// the value for the key, or does nothing, depending on the op result
+// of the valueFn function. With UpdateOp, the value is updated to the
+// newValue. With DeleteOp, the value is deleted, if it exists. With
+// CancelOp, the map is left unchanged.
+// The ok result indicates whether the key is present in the map after
+// the call. The actual result contains the new value in cases where
+// the value was computed and stored, or the unchanged value when
+// an existing value is kept by CancelOp.
+//
+// This call locks a hash table bucket while the compute function
+// is executed, like Compute.
+func (m *MapOf[K, V]) ComputeWithOp(
+	key K,
+	valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
 ) (actual V, ok bool) {
 	return m.doCompute(key, valueFn, false, true)
 }
 
+// deleteOpOf returns the function of ComputeWithOp equivalent to the function
+// valueFn of Compute.
+func deleteOpOf[V any](
+	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
+) func(oldValue V, loaded bool) (V, ComputeOp) {
+	return func(oldValue V, loaded bool) (V, ComputeOp) {
+		newValue, del := valueFn(oldValue, loaded)
+		if del {
+			return newValue, DeleteOp
+		}
+		return newValue, UpdateOp
+	}
+}
+
+// UpdateIfPresent replaces the value of the key by the one valueFn returns
+// from the current value, only if the key is present, and returns the new value.
+// The updated result reports whether the key was present, nothing is stored otherwise.
+//
+// This call locks a hash table bucket while the compute function
+// is executed. It means that modifications on other entries in
+// the bucket will be blocked until the valueFn executes. Consider
+// this when the function includes long-running operations.
+func (m *MapOf[K, V]) UpdateIfPresent(key K, valueFn func(oldValue V) V) (value V, updated bool) {
+	return m.doCompute(
+		key,
+		func(oldValue V, loaded bool) (V, ComputeOp) {
+			if !loaded {
+				return oldValue, CancelOp
+			}
+			return valueFn(oldValue), UpdateOp
+		},
+		false,
+		true,
+	)
+}
+
 // LoadAndDelete deletes the value for a key, returning the previous
 // value if any. The loaded result reports whether the key was
 // present.
 func (m *MapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
 	return m.doCompute(
 		key,
-		func(value V, loaded bool) (V, bool) {
-			return value, true
+		func(value V, loaded bool) (V, ComputeOp) {
+			return value, DeleteOp
 		},
 		false,
 		false,
@@ -283,8 +381,8 @@ func (m *MapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
 func (m *MapOf[K, V]) Delete(key K) {
 	m.doCompute(
 		key,
-		func(value V, loaded bool) (V, bool) {
-			return value, true
+		func(value V, loaded bool) (V, ComputeOp) {
+			return value, DeleteOp
 		},
 		false,
 		false,
@@ -293,7 +391,7 @@ func (m *MapOf[K, V]) Delete(key K) {
 
 func (m *MapOf[K, V]) doCompute(
 	key K,
-	valueFn func(oldValue V, loaded bool) (V, bool),
+	valueFn func(oldValue V, loaded bool) (V, ComputeOp),
 	loadIfExists, computeOnly bool,
 ) (V, bool) {
 	// Read-only path.
@@ -351,8 +449,9 @@ func (m *MapOf[K, V]) doCompute(
 						// snapshot won't be correct in case of multiple Store calls
 						// using the same value.
 						oldv := e.value
-						newv, del := valueFn(oldv, true)
-						if del {
+						newv, op := valueFn(oldv, true)
+						switch op {
+						case DeleteOp:
 							// Deletion.
 							// First we update the hash, then the entry.
 							newmetaw := setByte(metaw, emptyMetaSlot, idx)
@@ -365,17 +464,21 @@ func (m *MapOf[K, V]) doCompute(
 								m.resize(table, mapShrinkHint)
 							}
 							return oldv, !computeOnly
+						case UpdateOp:
+							newe := new(entryOf[K, V])
+							newe.key = key
+							newe.value = newv
+							atomic.StorePointer(&b.entries[idx], unsafe.Pointer(newe))
+							rootb.mu.Unlock()
+							if computeOnly {
+								// Compute expects the new value to be returned.
+								return newv, true
+							}
+							// LoadAndStore expects the old value to be returned.
+							return oldv, true
 						}
-						newe := new(entryOf[K, V])
-						newe.key = key
-						newe.value = newv
-						atomic.StorePointer(&b.entries[idx], unsafe.Pointer(newe))
+						// CancelOp: the entry is left unchanged.
 						rootb.mu.Unlock()
-						if computeOnly {
-							// Compute expects the new value to be returned.
-							return newv, true
-						}
-						// LoadAndStore expects the old value to be returned.
 						return oldv, true
 					}
 				}
@@ -394,8 +497,8 @@ func (m *MapOf[K, V]) doCompute(
 				if emptyb != nil {
 					// Insertion into an existing bucket.
 					var zeroedV V
-					newValue, del := valueFn(zeroedV, false)
-					if del {
+					newValue, op := valueFn(zeroedV, false)
+					if op != UpdateOp {
 						rootb.mu.Unlock()
 						return zeroedV, false
 					}
@@ -418,10 +521,10 @@ func (m *MapOf[K, V]) doCompute(
 				}
 				// Insertion into a new bucket.
 				var zeroedV V
-				newValue, del := valueFn(zeroedV, false)
-				if del {
+				newValue, op := valueFn(zeroedV, false)
+				if op != UpdateOp {
 					rootb.mu.Unlock()
-					return newValue, false
+					return zeroedV, false
 				}
 				// Create and append a bucket.
 				newb := new(bucketOfPadded)
@@ -457,13 +560,19 @@ func (m *MapOf[K, V]) waitForResize() {
 	m.resizeMu.Unlock()
 }
 
+// shrinkThreshold returns the number of entries to start a shrinking
+// of a table of tableLen buckets.
+func (m *MapOf[K, V]) shrinkThreshold(tableLen int) int64 {
+	return int64(float64(tableLen*entriesPerMapOfBucket) * m.shrinkFrac)
+}
+
 func (m *MapOf[K, V]) resize(knownTable *mapOfTable[K, V], hint mapResizeHint) {
 	knownTableLen := len(knownTable.buckets)
 	// Fast path for shrink attempts.
 	if hint == mapShrinkHint {
 		if m.growOnly ||
 			m.minTableLen == knownTableLen ||
-			knownTable.sumSize() > int64((knownTableLen*entriesPerMapOfBucket)/mapShrinkFraction) {
+			knownTable.sumSize() > m.shrinkThreshold(knownTableLen) {
 			return
 		}
 	}
@@ -478,15 +587,15 @@ func (m *MapOf[K, V]) resize(knownTable *mapOfTable[K, V], hint mapResizeHint) {
 	tableLen := len(table.buckets)
 	switch hint {
 	case mapGrowHint:
-		// Grow the table with factor of 2.
+		// Grow the table with factor of 2. The seed is kept,
+		// so that the keys keep their positions for RangeCursor.
 		atomic.AddInt64(&m.totalGrowths, 1)
-		newTable = newMapOfTable[K, V](tableLen << 1)
+		newTable = newMapOfTable[K, V](tableLen<<1, table.seed)
 	case mapShrinkHint:
-		shrinkThreshold := int64((tableLen * entriesPerMapOfBucket) / mapShrinkFraction)
-		if tableLen > m.minTableLen && table.sumSize() <= shrinkThreshold {
+		if tableLen > m.minTableLen && table.sumSize() <= m.shrinkThreshold(tableLen) {
 			// Shrink the table with factor of 2.
 			atomic.AddInt64(&m.totalShrinks, 1)
-			newTable = newMapOfTable[K, V](tableLen >> 1)
+			newTable = newMapOfTable[K, V](tableLen>>1, table.seed)
 		} else {
 			// No need to shrink. Wake up all waiters and give up.
 			m.resizeMu.Lock()
@@ -496,7 +605,7 @@ func (m *MapOf[K, V]) resize(knownTable *mapOfTable[K, V], hint mapResizeHint) {
 			return
 		}
 	case mapClearHint:
-		newTable = newMapOfTable[K, V](m.minTableLen)
+		newTable = newMapOfTable[K, V](m.minTableLen, makeSeed())
 	default:
 		panic(fmt.Sprintf("unexpected resize hint: %d", hint))
 	}
@@ -592,6 +701,83 @@ func (m *MapOf[K, V]) Range(f func(key K, value V) bool) {
 	}
 }
 
+// RangeSnapshot calls f sequentially for each key present in the map
+// when it is called, along with its current value. If f returns false,
+// range stops the iteration.
+//
+// Unlike Range, the keys are captured first, and their values are loaded
+// as they are visited: each key is visited at most once, the keys stored
+// during the call are not visited, and the keys deleted before their turn
+// are skipped. It is safe to modify the map while iterating it.
+func (m *MapOf[K, V]) RangeSnapshot(f func(key K, value V) bool) {
+	keys := make([]K, 0, m.Size())
+	m.Range(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		if value, ok := m.Load(key); ok && !f(key, value) {
+			return
+		}
+	}
+}
+
+// RangeCursor calls f sequentially for the keys and values of the map,
+// starting from the position of the cursor, and returns the cursor to resume
+// the iteration from, 0 once all the keys have been visited. Start with
+// cursor 0. The buckets are visited while their entries add up to at most
+// count, and at least one bucket is visited.
+//
+// The cursor is a position in the order of the reversed hashes of the keys,
+// in which the buckets of the table are contiguous ranges: like the cursor of
+// the Redis SCAN, it is kept by the resizes of the table between the calls,
+// the seed being kept, but the keys behind it are skipped even when a shrink
+// merges their bucket with a bucket to visit. The keys present for the whole
+// iteration are thus visited once, and the keys stored or deleted during the
+// iteration may or may not be visited.
+func (m *MapOf[K, V]) RangeCursor(cursor uint64, count int, f func(key K, value V)) uint64 {
+	var zeroPtr unsafe.Pointer
+	bentries := make([]unsafe.Pointer, 0, entriesPerMapOfBucket)
+	table := (*mapOfTable[K, V])(atomic.LoadPointer(&m.table))
+	mask := uint64(len(table.buckets) - 1)
+	visited := 0
+	for {
+		start, next := cursorBucket(cursor, mask)
+		rootb := &table.buckets[bits.Reverse64(start)&mask]
+		b := rootb
+		rootb.mu.Lock()
+		for {
+			for i := 0; i < entriesPerMapOfBucket; i++ {
+				if b.entries[i] != nil {
+					bentries = append(bentries, b.entries[i])
+				}
+			}
+			if b.next == nil {
+				rootb.mu.Unlock()
+				break
+			}
+			b = (*bucketOfPadded)(b.next)
+		}
+		if visited > 0 && visited+len(bentries) > count {
+			// resumed from this bucket by the next call
+			return start
+		}
+		for j := range bentries {
+			entry := (*entryOf[K, V])(bentries[j])
+			// the keys of the bucket behind the cursor were visited, before a shrink
+			if cursor == start || bits.Reverse64(h1(m.hasher(entry.key, table.seed))) >= cursor {
+				f(entry.key, entry.value)
+			}
+			bentries[j] = zeroPtr
+		}
+		visited += len(bentries)
+		bentries = bentries[:0]
+		if cursor = next; cursor == 0 {
+			return 0
+		}
+	}
+}
+
 // Clear deletes all keys and values currently stored in the map.
 func (m *MapOf[K, V]) Clear() {
 	table := (*mapOfTable[K, V])(atomic.LoadPointer(&m.table))
@@ -46,6 +46,20 @@ func parallelism() uint32 {
 //go:linkname runtime_fastrand runtime.fastrand
 func runtime_fastrand() uint32
 
+//go:linkname runtime_procPin runtime.procPin
+func runtime_procPin() int
+
+//go:linkname runtime_procUnpin runtime.procUnpin
+func runtime_procUnpin()
+
+// ProcID returns the ID of the P running the calling goroutine, which may run
+// on another P by the time it returns, e.g. to pick a stripe used mostly by one P.
+func ProcID() int {
+	id := runtime_procPin()
+	runtime_procUnpin()
+	return id
+}
+
 func broadcast(b uint8) uint64 {
 	return 0x101010101010101 * uint64(b)
 }
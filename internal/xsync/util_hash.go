@@ -54,3 +54,13 @@ func runtime_typehash64(t uintptr, p unsafe.Pointer, seed uint64) uint64 {
 //go:noescape
 //go:linkname runtime_typehash runtime.typehash
 func runtime_typehash(t uintptr, p unsafe.Pointer, h uintptr) uintptr
+
+// MakeSeed creates a random non-zero seed for hash functions.
+func MakeSeed() uint64 {
+	return makeSeed()
+}
+
+// HashString calculates a hash of s with the given seed.
+func HashString(s string, seed uint64) uint64 {
+	return hashString(s, seed)
+}
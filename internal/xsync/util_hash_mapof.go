@@ -28,3 +28,9 @@ func defaultHasher[T comparable]() func(T, uint64) uint64 {
 		}
 	}
 }
+
+// DefaultHasher returns the hash function used by MapOf for the given
+// comparable type, the same limitations as defaultHasher apply.
+func DefaultHasher[T comparable]() func(T, uint64) uint64 {
+	return defaultHasher[T]()
+}
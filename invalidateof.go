@@ -0,0 +1,88 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// invalidationOf a predicate on the keys, the items written before its generation
+// whose key matches are invalidated, see InvalidateIf.
+type invalidationOf[K comparable] struct {
+	gen uint64
+	f   func(k K) bool
+}
+
+// invalidationsOf the pending invalidations, checked when the items are read,
+// until the cleanup has deleted all the items they invalidate.
+type invalidationsOf[K comparable] struct {
+	gen  uint64       // the current generation, the items are written with it, see stamp
+	list atomic.Value // []invalidationOf[K], copied on write
+	mu   sync.Mutex
+}
+
+func newInvalidationsOf[K comparable]() *invalidationsOf[K] {
+	x := &invalidationsOf[K]{}
+	x.list.Store([]invalidationOf[K](nil))
+	return x
+}
+
+// generation returns the current generation.
+func (x *invalidationsOf[K]) generation() uint64 {
+	return atomic.LoadUint64(&x.gen)
+}
+
+// stamp returns the generation to write the items with: the current one while
+// invalidations are pending, 0 otherwise, so that the items written once none is
+// pending need no metadata. An item of the generation 0 is older than any invalidation.
+func (x *invalidationsOf[K]) stamp() uint64 {
+	if len(x.list.Load().([]invalidationOf[K])) == 0 {
+		return 0
+	}
+	return x.generation()
+}
+
+// add starts a new generation, invalidating the items of the previous ones whose key matches f.
+func (x *invalidationsOf[K]) add(f func(k K) bool) {
+	x.mu.Lock()
+	list := x.list.Load().([]invalidationOf[K])
+	list = append(list[:len(list):len(list)], invalidationOf[K]{
+		gen: atomic.AddUint64(&x.gen, 1),
+		f:   f,
+	})
+	x.list.Store(list)
+	x.mu.Unlock()
+}
+
+// match reports whether the item of the key k written in the generation gen is invalidated.
+func (x *invalidationsOf[K]) match(k K, gen uint64) bool {
+	for _, inv := range x.list.Load().([]invalidationOf[K]) {
+		if inv.gen > gen && inv.f(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// pending returns the generation of the last pending invalidation, 0 if there are none.
+func (x *invalidationsOf[K]) pending() uint64 {
+	list := x.list.Load().([]invalidationOf[K])
+	if len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].gen
+}
+
+// compact drops the invalidations up to the generation gen, once their items are deleted.
+func (x *invalidationsOf[K]) compact(gen uint64) {
+	x.mu.Lock()
+	list := x.list.Load().([]invalidationOf[K])
+	n := 0
+	for n < len(list) && list[n].gen <= gen {
+		n++
+	}
+	x.list.Store(append([]invalidationOf[K](nil), list[n:]...))
+	x.mu.Unlock()
+}
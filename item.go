@@ -1,20 +1,78 @@
 package cache
 
 import (
+	"math/rand"
 	"time"
 )
 
-type item struct {
-	v interface{}
-	e int64
+// ItemWithExpiration an item along with its expiration time.
+type ItemWithExpiration struct {
+	Value interface{}
+
+	// Expiration the expiration time, the zero value means the item never expires.
+	Expiration time.Time
+}
+
+// KeyValue a key along with its value, see ItemsSorted and ScanItems.
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// LoadStrategy decides which item is kept when a loaded item conflicts with an existing item.
+type LoadStrategy int
+
+const (
+	// Overwrite replaces existing items with the loaded items.
+	Overwrite LoadStrategy = iota
+
+	// KeepExisting keeps existing unexpired items, only missing keys are loaded.
+	KeepExisting
+
+	// KeepNewestByExpiration keeps whichever item expires later,
+	// an item that never expires is the newest.
+	KeepNewestByExpiration
+)
+
+// keep reports whether the existing item old wins over the loaded item with expiration e.
+func (s LoadStrategy) keep(old, e int64) bool {
+	switch s {
+	case KeepExisting:
+		return true
+	case KeepNewestByExpiration:
+		return old == 0 || (e > 0 && old >= e)
+	default:
+		return false
+	}
+}
+
+func loadStrategy(strategy []LoadStrategy) LoadStrategy {
+	if len(strategy) > 0 {
+		return strategy[0]
+	}
+	return Overwrite
+}
+
+// expirationTime returns the expiration time of e, the zero value means never expires.
+func expirationTime(e int64) time.Time {
+	if e > 0 {
+		return time.Unix(0, e)
+	}
+	return time.Time{}
 }
 
-// returns true if the item has expired.
-func (i *item) expired() bool {
-	return i.e > 0 && time.Now().UnixNano() > i.e
+// jitter randomizes the duration d by up to ±fraction of d, see WithTTLJitter.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction == 0 || d <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*fraction*float64(d))
 }
 
-// returns true if the item has expired.
-func (i *item) expiredWithNow(now int64) bool {
-	return i.e > 0 && now > i.e
+// expirationNano returns the expiration of t in Unix nanoseconds, 0 means never expires.
+func expirationNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
 }
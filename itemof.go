@@ -10,14 +10,67 @@ import (
 type itemOf[V any] struct {
 	v V
 	e int64
+	x *itemExt // the metadata of the item, nil for the items without any
 }
 
-// returns true if the item has expired.
-func (i *itemOf[V]) expired() bool {
-	return i.e > 0 && time.Now().UnixNano() > i.e
+// itemExt the metadata of an item, allocated only when one of the features using it is,
+// so that the items of a cache without them stay small. It is shared by the copies of the item,
+// and copied to be changed once the item is stored, see withExt.
+type itemExt struct {
+	m any
+	t int64    // the sliding lifetime, see WithSlidingExpirationOf
+	f func()   // the callback of the item, see SetWithCallback
+	g []string // the tags of the item, see SetWithTags
+	n uint64   // the generation the item was written in, see InvalidateIf
+	r uint64   // the version of the item, see GetWithVersion
+	d int64    // the lifetime, see WithRefreshAhead
+}
+
+// noExt the metadata of the items without any.
+var noExt itemExt
+
+// ext returns the metadata of the item, not to be changed, see withExt.
+func (i *itemOf[V]) ext() *itemExt {
+	if i.x == nil {
+		return &noExt
+	}
+	return i.x
+}
+
+// withExt returns the item i with a copy of its metadata changed by f,
+// allocated only if f leaves some.
+func (i itemOf[V]) withExt(f func(x *itemExt)) itemOf[V] {
+	x := *i.ext()
+	f(&x)
+	i.x = x.alloc()
+	return i
+}
+
+// alloc returns a copy of the metadata x on the heap, nil if x is empty.
+func (x itemExt) alloc() *itemExt {
+	if x.m == nil && x.t == 0 && x.f == nil && len(x.g) == 0 && x.n == 0 && x.r == 0 && x.d == 0 {
+		return nil
+	}
+	p := new(itemExt)
+	*p = x
+	return p
 }
 
 // returns true if the item has expired.
 func (i *itemOf[V]) expiredWithNow(now int64) bool {
 	return i.e > 0 && now > i.e
 }
+
+// ItemWithExpirationOf an item along with its expiration time.
+type ItemWithExpirationOf[V any] struct {
+	Value V
+
+	// Expiration the expiration time, the zero value means the item never expires.
+	Expiration time.Time
+}
+
+// KeyValueOf a key along with its value, see ItemsSortedOf and ScanItems.
+type KeyValueOf[K comparable, V any] struct {
+	Key   K
+	Value V
+}
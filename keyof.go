@@ -0,0 +1,50 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"github.com/fufuok/cache/internal/xsync"
+)
+
+// Key2 a composite key of two comparable values, e.g. Key2[int64, string]{tenantID, objectID},
+// instead of the concatenation of their strings with fmt.Sprintf on every access.
+// The keys are compared with ==, see Key2Hasher for their hash.
+type Key2[A, B comparable] struct {
+	A A
+	B B
+}
+
+// NewKey2 returns the composite key of a and b.
+func NewKey2[A, B comparable](a A, b B) Key2[A, B] {
+	return Key2[A, B]{a, b}
+}
+
+// Key3 a composite key of three comparable values, see Key2.
+type Key3[A, B, C comparable] struct {
+	A A
+	B B
+	C C
+}
+
+// NewKey3 returns the composite key of a, b and c.
+func NewKey3[A, B, C comparable](a A, b B, c C) Key3[A, B, C] {
+	return Key3[A, B, C]{a, b, c}
+}
+
+// Key2Hasher returns a hasher of Key2 chaining the hashes of its values, each seeding the next,
+// for WithHasherOf, e.g. NewOf[Key2[int64, string], V](WithHasherOf[Key2[int64, string], V](Key2Hasher[int64, string]())).
+func Key2Hasher[A, B comparable]() func(k Key2[A, B], seed uint64) uint64 {
+	ha, hb := xsync.DefaultHasher[A](), xsync.DefaultHasher[B]()
+	return func(k Key2[A, B], seed uint64) uint64 {
+		return hb(k.B, ha(k.A, seed))
+	}
+}
+
+// Key3Hasher returns a hasher of Key3 chaining the hashes of its values, see Key2Hasher.
+func Key3Hasher[A, B, C comparable]() func(k Key3[A, B, C], seed uint64) uint64 {
+	ha, hb, hc := xsync.DefaultHasher[A](), xsync.DefaultHasher[B](), xsync.DefaultHasher[C]()
+	return func(k Key3[A, B, C], seed uint64) uint64 {
+		return hc(k.C, hb(k.B, ha(k.A, seed)))
+	}
+}
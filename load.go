@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultLockLease the default time a distributed lease is held for, at most.
+	DefaultLockLease = 10 * time.Second
+
+	// DefaultLockWait the default time to wait for a distributed lease held by another process.
+	DefaultLockWait = 1 * time.Second
+
+	lockRetryInterval = 10 * time.Millisecond
+)
+
+// ErrLoaderPanicked returned to the callers waiting for a loader that panicked.
+var ErrLoaderPanicked = errors.New("cache: loader panicked")
+
+// DistributedLocker acquires named leases shared between processes, e.g. with Redis SET NX PX,
+// so that only one replica runs the loader for a missing key, see WithDistributedLocker.
+type DistributedLocker interface {
+	// TryLock tries to acquire the lease for name, held for at most ttl.
+	// Returns false if the lease is held by another process.
+	TryLock(ctx context.Context, name string, ttl time.Duration) (bool, error)
+
+	// Unlock releases the lease for name.
+	Unlock(ctx context.Context, name string) error
+}
+
+type distributedLock struct {
+	locker DistributedLocker
+	lease  time.Duration
+	wait   time.Duration
+	prefix string
+}
+
+func newDistributedLock(locker DistributedLocker, lease, wait time.Duration, prefix string) *distributedLock {
+	if locker == nil {
+		return nil
+	}
+	if prefix == "" {
+		panic("cache: the leases of a distributed locker need a prefix, see WithLockPrefix")
+	}
+	if lease <= 0 {
+		lease = DefaultLockLease
+	}
+	if wait == 0 {
+		wait = DefaultLockWait
+	} else if wait < 0 {
+		wait = 0
+	}
+	return &distributedLock{
+		locker: locker,
+		lease:  lease,
+		wait:   wait,
+		prefix: prefix,
+	}
+}
+
+// lockPrefix returns the prefix of the names of the leases, the registry name by default.
+func lockPrefix(prefix, registryName string) string {
+	if prefix == "" {
+		return registryName
+	}
+	return prefix
+}
+
+// acquire tries to acquire the lease for name, prefixed. If it is held by another process,
+// it returns immediately when a stale value can be served, otherwise it waits for
+// the lease up to the wait time, after which the caller loads anyway.
+// Errors from the locker are ignored, the caller loads without the lease.
+// Returns the function releasing the lease, and whether the stale value should be served.
+func (l *distributedLock) acquire(name string, canServeStale bool) (release func(), serveStale bool) {
+	release = func() {}
+	if l == nil {
+		return
+	}
+	ctx := context.Background()
+	name = l.prefix + name
+	deadline := time.Now().Add(l.wait)
+	for {
+		ok, err := l.locker.TryLock(ctx, name, l.lease)
+		if err != nil {
+			return
+		}
+		if ok {
+			return func() { _ = l.locker.Unlock(ctx, name) }, false
+		}
+		if canServeStale {
+			return release, true
+		}
+		if !time.Now().Before(deadline) {
+			return
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
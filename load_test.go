@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testLocker struct {
+	mu     sync.Mutex
+	leases map[string]bool
+}
+
+func newTestLocker() *testLocker {
+	return &testLocker{leases: make(map[string]bool)}
+}
+
+func (l *testLocker) TryLock(_ context.Context, name string, _ time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.leases[name] {
+		return false, nil
+	}
+	l.leases[name] = true
+	return true, nil
+}
+
+func (l *testLocker) Unlock(_ context.Context, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.leases, name)
+	return nil
+}
+
+func (l *testLocker) held(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leases[name]
+}
+
+func TestDistributedLock(t *testing.T) {
+	if newDistributedLock(nil, 0, 0, "p:") != nil {
+		t.Fatal("expected no lock without a locker")
+	}
+	var nl *distributedLock
+	release, stale := nl.acquire("a", true)
+	release()
+	if stale {
+		t.Fatal("expected to load without a lock")
+	}
+
+	locker := newTestLocker()
+	l := newDistributedLock(locker, 0, 0, "p:")
+	if l.lease != DefaultLockLease || l.wait != DefaultLockWait {
+		t.Fatalf("expected the default lease and wait, got: %v, %v", l.lease, l.wait)
+	}
+
+	release, stale = l.acquire("a", true)
+	if stale || !locker.held("p:a") {
+		t.Fatal("expected the lease to be acquired")
+	}
+	if _, stale = l.acquire("a", true); !stale {
+		t.Fatal("expected to serve stale while the lease is held")
+	}
+
+	l = newDistributedLock(locker, 0, 50*time.Millisecond, "p:")
+	start := time.Now()
+	release2, stale := l.acquire("a", false)
+	release2()
+	if stale || time.Since(start) < 50*time.Millisecond {
+		t.Fatal("expected to wait for the lease, then load anyway")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		release()
+	}()
+	l = newDistributedLock(locker, 0, time.Second, "p:")
+	release, stale = l.acquire("a", false)
+	if stale || !locker.held("p:a") {
+		t.Fatal("expected the lease to be acquired once released")
+	}
+	release()
+	if locker.held("p:a") {
+		t.Fatal("expected the lease to be released")
+	}
+}
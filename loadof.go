@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync"
+)
+
+type loadCallOf[V any] struct {
+	wg     sync.WaitGroup
+	v      V
+	loaded bool
+	err    error
+}
+
+// loadGroupOf deduplicates concurrent loads of the same key.
+type loadGroupOf[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*loadCallOf[V]
+}
+
+// do calls fn once for concurrent callers with the same key, they all get its result.
+func (g *loadGroupOf[K, V]) do(k K, fn func() (V, bool, error)) (V, bool, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[K]*loadCallOf[V])
+	}
+	if call, ok := g.calls[k]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.v, call.loaded, call.err
+	}
+	call := &loadCallOf[V]{err: ErrLoaderPanicked}
+	call.wg.Add(1)
+	g.calls[k] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, k)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+	call.v, call.loaded, call.err = fn()
+	return call.v, call.loaded, call.err
+}
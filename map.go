@@ -4,6 +4,19 @@ import (
 	"github.com/fufuok/cache/internal/xsync"
 )
 
+// ComputeOp tells ComputeWithOp what to do with the value computed
+// by its function.
+type ComputeOp = xsync.ComputeOp
+
+const (
+	// UpdateOp stores the new value for the key.
+	UpdateOp = xsync.UpdateOp
+	// DeleteOp deletes the value for the key, if present.
+	DeleteOp = xsync.DeleteOp
+	// CancelOp leaves the value for the key, or its absence, unchanged.
+	CancelOp = xsync.CancelOp
+)
+
 type Map interface {
 	// Load returns the value stored in the map for a key, or nil if no
 	// value is present.
@@ -25,12 +38,24 @@ type Map interface {
 	// false otherwise.
 	LoadAndStore(key string, value interface{}) (actual interface{}, loaded bool)
 
+	// Swap stores the value for the key and returns the previous value, if any,
+	// like sync.Map.Swap. The loaded result reports whether the key was present.
+	Swap(key string, value interface{}) (previous interface{}, loaded bool)
+
 	// LoadOrCompute returns the existing value for the key if present.
 	// Otherwise, it computes the value using the provided function and
 	// returns the computed value. The loaded result is true if the value
 	// was loaded, false if stored.
 	LoadOrCompute(key string, valueFn func() interface{}) (actual interface{}, loaded bool)
 
+	// LoadOrTryCompute returns the existing value for the key if present.
+	// Otherwise, it tries to compute the value using the provided function
+	// and, unless cancelled, stores and returns the computed value.
+	// The loaded result is true if the value was loaded, false if computed,
+	// whether stored or not. If the computation is cancelled, for example
+	// on an error, nothing is stored and the zero value is returned.
+	LoadOrTryCompute(key string, valueFn func() (newValue interface{}, cancel bool)) (value interface{}, loaded bool)
+
 	// Compute either sets the computed new value for the key or deletes
 	// the value for the key. When the delete result of the valueFn function
 	// is set to true, the value will be deleted, if it exists. When delete
@@ -43,6 +68,25 @@ type Map interface {
 		valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, delete bool),
 	) (actual interface{}, ok bool)
 
+	// ComputeWithOp either sets the computed new value for the key, deletes
+	// the value for the key, or does nothing, depending on the op result
+	// of the valueFn function. With UpdateOp, the value is updated to the
+	// newValue. With DeleteOp, the value is deleted, if it exists. With
+	// CancelOp, the map is left unchanged.
+	// The ok result indicates whether the key is present in the map after
+	// the call. The actual result contains the new value in cases where
+	// the value was computed and stored, or the unchanged value when
+	// an existing value is kept by CancelOp. See Compute.
+	ComputeWithOp(
+		key string,
+		valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, op ComputeOp),
+	) (actual interface{}, ok bool)
+
+	// UpdateIfPresent replaces the value of the key by the one valueFn returns
+	// from the current value, only if the key is present, and returns the new value.
+	// The updated result reports whether the key was present, nothing is stored otherwise.
+	UpdateIfPresent(key string, valueFn func(oldValue interface{}) interface{}) (value interface{}, updated bool)
+
 	// LoadAndDelete deletes the value for a key, returning the previous
 	// value if any. The loaded result reports whether the key was
 	// present.
@@ -65,6 +109,26 @@ type Map interface {
 	// reflected in the subsequently iterated entries.
 	Range(f func(key string, value interface{}) bool)
 
+	// RangeSnapshot calls f sequentially for each key present in the map
+	// when it is called, along with its current value. If f returns false,
+	// range stops the iteration.
+	//
+	// Unlike Range, the keys are captured first, and their values are loaded
+	// as they are visited: each key is visited at most once, the keys stored
+	// during the call are not visited, and the keys deleted before their turn
+	// are skipped. It is safe to modify the map while iterating it.
+	RangeSnapshot(f func(key string, value interface{}) bool)
+
+	// RangeCursor calls f sequentially for the keys and values present in
+	// the map, starting from the cursor, and returns the cursor to resume the
+	// iteration from. Start with cursor 0, the iteration is complete when the
+	// returned cursor is 0. Like with the Redis SCAN, the cursor is a position
+	// in the hash order of the keys: each call visits up to count keys, or the
+	// keys of one position if more, whatever the size of the map. The keys
+	// present for the whole iteration are visited exactly once, even if the map
+	// grows or shrinks meanwhile.
+	RangeCursor(cursor uint64, count int, f func(key string, value interface{})) (next uint64)
+
 	// Clear deletes all keys and values currently stored in the map.
 	Clear()
 
@@ -72,13 +136,55 @@ type Map interface {
 	Size() int
 }
 
+// MapOption configures a new Map or MapOf, see NewMap and NewMapOf.
+type MapOption func(*xsync.MapConfig)
+
+// WithMapGrowOnly makes the hash table of the map grow when keys are added,
+// but never shrink when they are deleted, except by Clear, for the maps
+// whose cardinality comes back up after the deletions.
+func WithMapGrowOnly() MapOption {
+	return MapOption(xsync.WithGrowOnly())
+}
+
+// WithMapShrinkThreshold makes the hash table of the map shrink by half once
+// its occupation falls to fraction of its capacity, 1/128 by default.
+// A lower fraction avoids resize thrash under bursty churn, a higher one releases
+// the memory sooner. Values outside (0, 0.375) are ignored.
+func WithMapShrinkThreshold(fraction float64) MapOption {
+	return MapOption(xsync.WithShrinkThreshold(fraction))
+}
+
+// mapOptions converts the options for the xsync constructors, after the presize to sizeHint if positive.
+func mapOptions(sizeHint int, opts []MapOption) []func(*xsync.MapConfig) {
+	options := make([]func(*xsync.MapConfig), 0, len(opts)+1)
+	if sizeHint > 0 {
+		options = append(options, xsync.WithPresize(sizeHint))
+	}
+	for _, o := range opts {
+		options = append(options, o)
+	}
+	return options
+}
+
+// itemsMapOptions returns the options of the map of the items of a cache.
+func itemsMapOptions(minCapacity int, growOnly bool, shrinkThreshold float64) []func(*xsync.MapConfig) {
+	var opts []MapOption
+	if growOnly {
+		opts = append(opts, WithMapGrowOnly())
+	}
+	if shrinkThreshold > 0 {
+		opts = append(opts, WithMapShrinkThreshold(shrinkThreshold))
+	}
+	return mapOptions(minCapacity, opts)
+}
+
 // NewMap the keys never expire, similar to the use of sync.Map.
-func NewMap() Map {
-	return xsync.NewMap()
+func NewMap(opts ...MapOption) Map {
+	return xsync.NewMap(mapOptions(0, opts)...)
 }
 
 // NewMapPresized creates a new Map instance with capacity enough to hold
 // sizeHint entries. If sizeHint is zero or negative, the value is ignored.
-func NewMapPresized(sizeHint int) Map {
-	return xsync.NewMap(xsync.WithPresize(sizeHint))
+func NewMapPresized(sizeHint int, opts ...MapOption) Map {
+	return xsync.NewMap(mapOptions(sizeHint, opts)...)
 }
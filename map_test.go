@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"testing"
 	"time"
+
+	"github.com/fufuok/cache/internal/xsync"
 )
 
 func TestMap_UniqueValuePointers_Int(t *testing.T) {
@@ -168,6 +170,39 @@ func TestMapLoadAndStore_NonNilValue(t *testing.T) {
 	}
 }
 
+func TestMapSwap(t *testing.T) {
+	m := NewMap()
+	if v, loaded := m.Swap("foo", "bar"); loaded || v != nil {
+		t.Fatalf("expected no previous value, got %v", v)
+	}
+	if v, loaded := m.Swap("foo", "baz"); !loaded || v != "bar" {
+		t.Fatalf("expected the previous value bar, got %v", v)
+	}
+	if v, ok := m.Load("foo"); !ok || v != "baz" {
+		t.Fatalf("expected baz, got %v", v)
+	}
+}
+
+func TestMapUpdateIfPresent(t *testing.T) {
+	m := NewMap()
+	inc := func(old interface{}) interface{} {
+		return old.(int) + 1
+	}
+	if v, updated := m.UpdateIfPresent("foo", inc); updated || v != nil {
+		t.Fatalf("missing key should not be updated, got %v", v)
+	}
+	if _, ok := m.Load("foo"); ok {
+		t.Fatal("missing key should not be stored")
+	}
+	m.Store("foo", 1)
+	if v, updated := m.UpdateIfPresent("foo", inc); !updated || v != 2 {
+		t.Fatalf("expected 2, got %v", v)
+	}
+	if v, ok := m.Load("foo"); !ok || v != 2 {
+		t.Fatalf("expected 2, got %v", v)
+	}
+}
+
 func TestMapRange(t *testing.T) {
 	const numEntries = 1000
 	m := NewMap()
@@ -195,6 +230,111 @@ func TestMapRange(t *testing.T) {
 	}
 }
 
+func TestMapRangeSnapshot(t *testing.T) {
+	const numEntries = 1000
+	m := NewMap()
+	for i := 0; i < numEntries; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	met := make(map[string]int)
+	deleted := make(map[string]bool)
+	m.RangeSnapshot(func(key string, value interface{}) bool {
+		if deleted[key] {
+			t.Fatalf("deleted key %s visited", key)
+		}
+		met[key]++
+		// delete the next key, and store a new one
+		i := value.(int)
+		next := strconv.Itoa(i + 1)
+		if met[next] == 0 {
+			deleted[next] = true
+		}
+		m.Delete(next)
+		m.Store(strconv.Itoa(numEntries+i), numEntries+i)
+		return true
+	})
+	if met[strconv.Itoa(numEntries)] != 0 {
+		t.Fatal("stored key visited")
+	}
+	for i := 0; i < numEntries; i++ {
+		key := strconv.Itoa(i)
+		if c := met[key]; c > 1 || (c == 0) != deleted[key] {
+			t.Fatalf("key %s visited %d times, deleted before: %v", key, c, deleted[key])
+		}
+	}
+}
+
+func TestMapRangeCursor(t *testing.T) {
+	const numEntries = 1000
+	m := NewMap()
+	for i := 0; i < numEntries; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	met := make(map[string]int)
+	var cursor uint64
+	for calls := 1; ; calls++ {
+		n := 0
+		cursor = m.RangeCursor(cursor, 100, func(key string, value interface{}) {
+			if key != strconv.Itoa(value.(int)) {
+				t.Fatalf("key %s does not match value %v", key, value)
+			}
+			met[key]++
+			n++
+		})
+		if n > 100 {
+			t.Fatalf("expected at most 100 entries per call, got %d", n)
+		}
+		if cursor == 0 {
+			break
+		}
+		if calls <= 3 {
+			// the table grows between the calls
+			for i := 0; i < numEntries; i++ {
+				m.Store(strconv.Itoa(numEntries*calls+i), numEntries*calls+i)
+			}
+		}
+	}
+	for i := 0; i < numEntries; i++ {
+		if c := met[strconv.Itoa(i)]; c != 1 {
+			t.Fatalf("key %d visited %d times", i, c)
+		}
+	}
+}
+
+func TestMapRangeCursor_Shrink(t *testing.T) {
+	const numEntries = 10000
+	// the table shrinks in the middle of the scan, after each of its first calls in turn
+	for at := 1; at <= 20; at++ {
+		m := NewMap(WithMapShrinkThreshold(0.3))
+		for i := 0; i < numEntries; i++ {
+			m.Store(strconv.Itoa(i), i)
+		}
+		met := make(map[string]int)
+		var cursor uint64
+		for calls := 1; ; calls++ {
+			cursor = m.RangeCursor(cursor, 100, func(key string, _ interface{}) {
+				met[key]++
+			})
+			if cursor == 0 {
+				break
+			}
+			if calls == at {
+				for i := 1; i < numEntries; i += 2 {
+					m.Delete(strconv.Itoa(i))
+				}
+			}
+		}
+		if s := m.(*xsync.Map).Stats(); s.TotalShrinks == 0 {
+			t.Fatal("expected the table to shrink")
+		}
+		for i := 0; i < numEntries; i += 2 {
+			if c := met[strconv.Itoa(i)]; c != 1 {
+				t.Fatalf("key %d visited %d times after a shrink at the call %d", i, c, at)
+			}
+		}
+	}
+}
+
 func TestMapRange_FalseReturned(t *testing.T) {
 	m := NewMap()
 	for i := 0; i < 100; i++ {
@@ -284,6 +424,38 @@ func TestMapLoadOrCompute(t *testing.T) {
 	}
 }
 
+func TestMapLoadOrTryCompute(t *testing.T) {
+	const numEntries = 1000
+	m := NewMap()
+	for i := 0; i < numEntries; i++ {
+		v, loaded := m.LoadOrTryCompute(strconv.Itoa(i), func() (interface{}, bool) {
+			// cancel the odd keys
+			return i, i%2 == 1
+		})
+		if loaded {
+			t.Fatalf("value not computed for %d", i)
+		}
+		if i%2 == 1 && v != nil {
+			t.Fatalf("cancelled computation for %d returned %v", i, v)
+		}
+		if i%2 == 0 && v != i {
+			t.Fatalf("values do not match for %d: %v", i, v)
+		}
+	}
+	if size := m.Size(); size != numEntries/2 {
+		t.Fatalf("expected %d stored values, got %d", numEntries/2, size)
+	}
+	for i := 0; i < numEntries; i += 2 {
+		v, loaded := m.LoadOrTryCompute(strconv.Itoa(i), func() (interface{}, bool) {
+			t.Fatalf("value computed again for %d", i)
+			return nil, true
+		})
+		if !loaded || v != i {
+			t.Fatalf("value not loaded for %d: %v", i, v)
+		}
+	}
+}
+
 func TestMapLoadOrCompute_FunctionCalledOnce(t *testing.T) {
 	m := NewMap()
 	for i := 0; i < 100; {
@@ -379,6 +551,34 @@ func TestMapCompute(t *testing.T) {
 	}
 }
 
+func TestMapComputeWithOp(t *testing.T) {
+	m := NewMap()
+	m.Store("foobar", 42)
+	v, ok := m.ComputeWithOp("foobar", func(oldValue interface{}, loaded bool) (interface{}, ComputeOp) {
+		return oldValue.(int) + 1, CancelOp
+	})
+	if v != 42 || !ok {
+		t.Fatalf("the existing value should be kept when cancelling: %v, %v", v, ok)
+	}
+	if v, ok := m.Load("foobar"); v != 42 || !ok {
+		t.Fatalf("the value should be unchanged: %v, %v", v, ok)
+	}
+	v, ok = m.ComputeWithOp("barbaz", func(oldValue interface{}, loaded bool) (interface{}, ComputeOp) {
+		return 42, CancelOp
+	})
+	if v != nil || ok {
+		t.Fatalf("nothing should be stored when cancelling: %v, %v", v, ok)
+	}
+	if _, ok := m.Load("barbaz"); ok || m.Size() != 1 {
+		t.Fatalf("barbaz should not be stored, size: %d", m.Size())
+	}
+	if _, ok := m.ComputeWithOp("foobar", func(interface{}, bool) (interface{}, ComputeOp) {
+		return nil, DeleteOp
+	}); ok || m.Size() != 0 {
+		t.Fatalf("foobar should be deleted, size: %d", m.Size())
+	}
+}
+
 func TestMapStoreThenDelete(t *testing.T) {
 	const numEntries = 1000
 	m := NewMapPresized(numEntries)
@@ -452,6 +652,36 @@ func TestMapSize(t *testing.T) {
 	}
 }
 
+func TestMapShrinkOptions(t *testing.T) {
+	const numEntries = 3000
+	for _, tc := range []struct {
+		name    string
+		opts    []MapOption
+		shrinks bool
+	}{
+		{"default", nil, false},
+		{"threshold", []MapOption{WithMapShrinkThreshold(0.3)}, true},
+		{"invalid threshold", []MapOption{WithMapShrinkThreshold(0.5)}, false},
+		{"grow only", []MapOption{WithMapShrinkThreshold(0.3), WithMapGrowOnly()}, false},
+	} {
+		m := NewMap(tc.opts...)
+		for i := 0; i < numEntries; i++ {
+			m.Store(strconv.Itoa(i), i)
+		}
+		// down to a quarter of the keys, below 0.3 of the capacity but above 1/128
+		for i := 0; i < numEntries*3/4; i++ {
+			m.Delete(strconv.Itoa(i))
+		}
+		stats := m.(*xsync.Map).Stats()
+		if shrinks := stats.TotalShrinks > 0; shrinks != tc.shrinks {
+			t.Fatalf("%s: expected shrinks %v, got %d", tc.name, tc.shrinks, stats.TotalShrinks)
+		}
+		if size := m.Size(); size != numEntries/4 {
+			t.Fatalf("%s: expected %d entries, got %d", tc.name, numEntries/4, size)
+		}
+	}
+}
+
 func TestMapClear(t *testing.T) {
 	const numEntries = 1000
 	m := NewMap()
@@ -28,12 +28,24 @@ type MapOf[K comparable, V any] interface {
 	// false otherwise.
 	LoadAndStore(key K, value V) (actual V, loaded bool)
 
+	// Swap stores the value for the key and returns the previous value, if any,
+	// like sync.Map.Swap. The loaded result reports whether the key was present.
+	Swap(key K, value V) (previous V, loaded bool)
+
 	// LoadOrCompute returns the existing value for the key if present.
 	// Otherwise, it computes the value using the provided function and
 	// returns the computed value. The loaded result is true if the value
 	// was loaded, false if stored.
 	LoadOrCompute(key K, valueFn func() V) (actual V, loaded bool)
 
+	// LoadOrTryCompute returns the existing value for the key if present.
+	// Otherwise, it tries to compute the value using the provided function
+	// and, unless cancelled, stores and returns the computed value.
+	// The loaded result is true if the value was loaded, false if computed,
+	// whether stored or not. If the computation is cancelled, for example
+	// on an error, nothing is stored and the zero value is returned.
+	LoadOrTryCompute(key K, valueFn func() (newValue V, cancel bool)) (value V, loaded bool)
+
 	// Compute either sets the computed new value for the key or deletes
 	// the value for the key. When the delete result of the valueFn function
 	// is set to true, the value will be deleted, if it exists. When delete
@@ -46,6 +58,25 @@ type MapOf[K comparable, V any] interface {
 		valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
 	) (actual V, ok bool)
 
+	// ComputeWithOp either sets the computed new value for the key, deletes
+	// the value for the key, or does nothing, depending on the op result
+	// of the valueFn function. With UpdateOp, the value is updated to the
+	// newValue. With DeleteOp, the value is deleted, if it exists. With
+	// CancelOp, the map is left unchanged.
+	// The ok result indicates whether the key is present in the map after
+	// the call. The actual result contains the new value in cases where
+	// the value was computed and stored, or the unchanged value when
+	// an existing value is kept by CancelOp. See Compute.
+	ComputeWithOp(
+		key K,
+		valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
+	) (actual V, ok bool)
+
+	// UpdateIfPresent replaces the value of the key by the one valueFn returns
+	// from the current value, only if the key is present, and returns the new value.
+	// The updated result reports whether the key was present, nothing is stored otherwise.
+	UpdateIfPresent(key K, valueFn func(oldValue V) V) (value V, updated bool)
+
 	// LoadAndDelete deletes the value for a key, returning the previous
 	// value if any. The loaded result reports whether the key was
 	// present.
@@ -68,6 +99,26 @@ type MapOf[K comparable, V any] interface {
 	// reflected in the subsequently iterated entries.
 	Range(f func(key K, value V) bool)
 
+	// RangeSnapshot calls f sequentially for each key present in the map
+	// when it is called, along with its current value. If f returns false,
+	// range stops the iteration.
+	//
+	// Unlike Range, the keys are captured first, and their values are loaded
+	// as they are visited: each key is visited at most once, the keys stored
+	// during the call are not visited, and the keys deleted before their turn
+	// are skipped. It is safe to modify the map while iterating it.
+	RangeSnapshot(f func(key K, value V) bool)
+
+	// RangeCursor calls f sequentially for the keys and values present in
+	// the map, starting from the cursor, and returns the cursor to resume the
+	// iteration from. Start with cursor 0, the iteration is complete when the
+	// returned cursor is 0. Like with the Redis SCAN, the cursor is a position
+	// in the hash order of the keys: each call visits up to count keys, or the
+	// keys of one position if more, whatever the size of the map. The keys
+	// present for the whole iteration are visited exactly once, even if the map
+	// grows or shrinks meanwhile.
+	RangeCursor(cursor uint64, count int, f func(key K, value V)) (next uint64)
+
 	// Clear deletes all keys and values currently stored in the map.
 	Clear()
 
@@ -77,13 +128,13 @@ type MapOf[K comparable, V any] interface {
 
 // NewMapOf creates a new HashMapOf instance with string keys.
 // The keys never expire, similar to the use of sync.Map.
-func NewMapOf[K comparable, V any]() MapOf[K, V] {
-	return xsync.NewMapOf[K, V]()
+func NewMapOf[K comparable, V any](opts ...MapOption) MapOf[K, V] {
+	return xsync.NewMapOf[K, V](mapOptions(0, opts)...)
 }
 
 // NewMapOfPresized creates a new MapOf instance with string keys and capacity
 // enough to hold sizeHint entries. If sizeHint is zero or negative, the value
 // is ignored.
-func NewMapOfPresized[K comparable, V any](sizeHint int) MapOf[K, V] {
-	return xsync.NewMapOf[K, V](xsync.WithPresize(sizeHint))
+func NewMapOfPresized[K comparable, V any](sizeHint int, opts ...MapOption) MapOf[K, V] {
+	return xsync.NewMapOf[K, V](mapOptions(sizeHint, opts)...)
 }
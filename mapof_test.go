@@ -6,6 +6,8 @@ package cache
 import (
 	"strconv"
 	"testing"
+
+	"github.com/fufuok/cache/internal/xsync"
 )
 
 func TestMapOf_UniqueValuePointers_Int(t *testing.T) {
@@ -168,6 +170,39 @@ func TestMapOfLoadAndStore_NonNilValue(t *testing.T) {
 	}
 }
 
+func TestMapOfSwap(t *testing.T) {
+	m := NewMapOf[string, string]()
+	if v, loaded := m.Swap("foo", "bar"); loaded || v != "" {
+		t.Fatalf("expected no previous value, got %v", v)
+	}
+	if v, loaded := m.Swap("foo", "baz"); !loaded || v != "bar" {
+		t.Fatalf("expected the previous value bar, got %v", v)
+	}
+	if v, ok := m.Load("foo"); !ok || v != "baz" {
+		t.Fatalf("expected baz, got %v", v)
+	}
+}
+
+func TestMapOfUpdateIfPresent(t *testing.T) {
+	m := NewMapOf[string, int]()
+	inc := func(old int) int {
+		return old + 1
+	}
+	if v, updated := m.UpdateIfPresent("foo", inc); updated || v != 0 {
+		t.Fatalf("missing key should not be updated, got %v", v)
+	}
+	if _, ok := m.Load("foo"); ok {
+		t.Fatal("missing key should not be stored")
+	}
+	m.Store("foo", 1)
+	if v, updated := m.UpdateIfPresent("foo", inc); !updated || v != 2 {
+		t.Fatalf("expected 2, got %v", v)
+	}
+	if v, ok := m.Load("foo"); !ok || v != 2 {
+		t.Fatalf("expected 2, got %v", v)
+	}
+}
+
 func TestMapOfRange(t *testing.T) {
 	const numEntries = 1000
 	m := NewMapOf[string, int]()
@@ -195,6 +230,117 @@ func TestMapOfRange(t *testing.T) {
 	}
 }
 
+func TestMapOfRangeSnapshot(t *testing.T) {
+	const numEntries = 1000
+	m := NewMapOf[int, int]()
+	for i := 0; i < numEntries; i++ {
+		m.Store(i, i)
+	}
+	met := make(map[int]int)
+	deleted := make(map[int]bool)
+	m.RangeSnapshot(func(key, value int) bool {
+		if deleted[key] {
+			t.Fatalf("deleted key %d visited", key)
+		}
+		met[key]++
+		// delete the next key, and store a new one
+		if met[value+1] == 0 {
+			deleted[value+1] = true
+		}
+		m.Delete(value + 1)
+		m.Store(numEntries+value, numEntries+value)
+		return true
+	})
+	if met[numEntries] != 0 {
+		t.Fatal("stored key visited")
+	}
+	for i := 0; i < numEntries; i++ {
+		if c := met[i]; c > 1 || (c == 0) != deleted[i] {
+			t.Fatalf("key %d visited %d times, deleted before: %v", i, c, deleted[i])
+		}
+	}
+}
+
+func TestMapOfRangeCursor(t *testing.T) {
+	const numEntries = 1000
+	m := NewMapOf[int, int]()
+	for i := 0; i < numEntries; i++ {
+		m.Store(i, i)
+	}
+	met := make(map[int]int)
+	var cursor uint64
+	for calls := 1; ; calls++ {
+		n := 0
+		cursor = m.RangeCursor(cursor, 100, func(key, value int) {
+			if key != value {
+				t.Fatalf("key %d does not match value %d", key, value)
+			}
+			met[key]++
+			n++
+		})
+		if n > 100 {
+			t.Fatalf("expected at most 100 entries per call, got %d", n)
+		}
+		if cursor == 0 {
+			break
+		}
+		if calls <= 3 {
+			// the table grows between the calls
+			for i := 0; i < numEntries; i++ {
+				m.Store(numEntries*calls+i, numEntries*calls+i)
+			}
+		}
+	}
+	for i := 0; i < numEntries; i++ {
+		if c := met[i]; c != 1 {
+			t.Fatalf("key %d visited %d times", i, c)
+		}
+	}
+
+	// each call visits about count keys, not the whole map
+	calls := 0
+	for cursor = m.RangeCursor(0, 100, func(int, int) {}); cursor != 0; calls++ {
+		cursor = m.RangeCursor(cursor, 100, func(int, int) {})
+	}
+	if max := 2 * m.Size() / 100; calls > max {
+		t.Fatalf("expected at most %d calls, got %d", max, calls)
+	}
+}
+
+func TestMapOfRangeCursor_Shrink(t *testing.T) {
+	const numEntries = 10000
+	// the table shrinks in the middle of the scan, after each of its first calls in turn
+	for at := 1; at <= 20; at++ {
+		m := NewMapOf[int, int](WithMapShrinkThreshold(0.3))
+		for i := 0; i < numEntries; i++ {
+			m.Store(i, i)
+		}
+		met := make(map[int]int)
+		var cursor uint64
+		for calls := 1; ; calls++ {
+			cursor = m.RangeCursor(cursor, 100, func(key, _ int) {
+				met[key]++
+			})
+			if cursor == 0 {
+				break
+			}
+			if calls == at {
+				for i := 1; i < numEntries; i += 2 {
+					m.Delete(i)
+				}
+			}
+		}
+		if s := m.(*xsync.MapOf[int, int]).Stats(); s.TotalShrinks == 0 {
+			t.Fatal("expected the table to shrink")
+		}
+		for i := 0; i < numEntries; i += 2 {
+			if c := met[i]; c != 1 {
+				t.Fatalf("key %d visited %d times after a shrink at the call %d", i, c, at)
+			}
+		}
+	}
+}
+
 func TestMapOfRange_FalseReturned(t *testing.T) {
 	m := NewMapOf[string, int]()
 	for i := 0; i < 100; i++ {
@@ -363,6 +509,38 @@ func TestMapOfLoadOrCompute(t *testing.T) {
 	}
 }
 
+func TestMapOfLoadOrTryCompute(t *testing.T) {
+	const numEntries = 1000
+	m := NewMapOf[string, int]()
+	for i := 0; i < numEntries; i++ {
+		v, loaded := m.LoadOrTryCompute(strconv.Itoa(i), func() (int, bool) {
+			// cancel the odd keys
+			return i, i%2 == 1
+		})
+		if loaded {
+			t.Fatalf("value not computed for %d", i)
+		}
+		if i%2 == 1 && v != 0 {
+			t.Fatalf("cancelled computation for %d returned %v", i, v)
+		}
+		if i%2 == 0 && v != i {
+			t.Fatalf("values do not match for %d: %v", i, v)
+		}
+	}
+	if size := m.Size(); size != numEntries/2 {
+		t.Fatalf("expected %d stored values, got %d", numEntries/2, size)
+	}
+	for i := 0; i < numEntries; i += 2 {
+		v, loaded := m.LoadOrTryCompute(strconv.Itoa(i), func() (int, bool) {
+			t.Fatalf("value computed again for %d", i)
+			return 0, true
+		})
+		if !loaded || v != i {
+			t.Fatalf("value not loaded for %d: %v", i, v)
+		}
+	}
+}
+
 func TestMapOfLoadOrCompute_FunctionCalledOnce(t *testing.T) {
 	m := NewMapOf[int, int]()
 	for i := 0; i < 100; {
@@ -456,6 +634,35 @@ func TestMapOfCompute(t *testing.T) {
 	}
 }
 
+func TestMapOfComputeWithOp(t *testing.T) {
+	m := NewMapOf[string, int]()
+	m.Store("foobar", 42)
+	v, ok := m.ComputeWithOp("foobar", func(oldValue int, loaded bool) (int, ComputeOp) {
+		return oldValue + 1, CancelOp
+	})
+	if v != 42 || !ok {
+		t.Fatalf("the existing value should be kept when cancelling: %d, %v", v, ok)
+	}
+	if v, ok := m.Load("foobar"); v != 42 || !ok {
+		t.Fatalf("the value should be unchanged: %d, %v", v, ok)
+	}
+	v, ok = m.ComputeWithOp("barbaz", func(oldValue int, loaded bool) (int, ComputeOp) {
+		return 42, CancelOp
+	})
+	if v != 0 || ok {
+		t.Fatalf("nothing should be stored when cancelling: %d, %v", v, ok)
+	}
+	if _, ok := m.Load("barbaz"); ok || m.Size() != 1 {
+		t.Fatalf("barbaz should not be stored, size: %d", m.Size())
+	}
+	v, ok = m.ComputeWithOp("foobar", func(oldValue int, loaded bool) (int, ComputeOp) {
+		return oldValue, DeleteOp
+	})
+	if v != 42 || ok || m.Size() != 0 {
+		t.Fatalf("the value should be deleted by DeleteOp: %d, %v, size: %d", v, ok, m.Size())
+	}
+}
+
 func TestMapOfStoreThenDelete(t *testing.T) {
 	const numEntries = 1000
 	m := NewMapOfPresized[string, int](numEntries)
@@ -597,6 +804,36 @@ func TestMapOfSize(t *testing.T) {
 	}
 }
 
+func TestMapOfShrinkOptions(t *testing.T) {
+	const numEntries = 3000
+	for _, tc := range []struct {
+		name    string
+		opts    []MapOption
+		shrinks bool
+	}{
+		{"default", nil, false},
+		{"threshold", []MapOption{WithMapShrinkThreshold(0.3)}, true},
+		{"invalid threshold", []MapOption{WithMapShrinkThreshold(0.5)}, false},
+		{"grow only", []MapOption{WithMapShrinkThreshold(0.3), WithMapGrowOnly()}, false},
+	} {
+		m := NewMapOf[int, int](tc.opts...)
+		for i := 0; i < numEntries; i++ {
+			m.Store(i, i)
+		}
+		// down to a quarter of the keys, below 0.3 of the capacity but above 1/128
+		for i := 0; i < numEntries*3/4; i++ {
+			m.Delete(i)
+		}
+		stats := m.(*xsync.MapOf[int, int]).Stats()
+		if shrinks := stats.TotalShrinks > 0; shrinks != tc.shrinks {
+			t.Fatalf("%s: expected shrinks %v, got %d", tc.name, tc.shrinks, stats.TotalShrinks)
+		}
+		if size := m.Size(); size != numEntries/4 {
+			t.Fatalf("%s: expected %d entries, got %d", tc.name, numEntries/4, size)
+		}
+	}
+}
+
 func TestMapOfClear(t *testing.T) {
 	const numEntries = 1000
 	m := NewMapOf[string, int]()
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"runtime/metrics"
+	"time"
+)
+
+// DefaultMemoryCheckInterval the interval at which the memory is sampled, see WithMemoryLimit.
+const DefaultMemoryCheckInterval = time.Second
+
+// memoryShedFraction the part of the items evicted at each check while the memory is over the limit.
+const memoryShedFraction = 0.1
+
+// MemorySampler returns the memory in use in bytes, e.g. the heap of the process
+// or the usage of its container, see WithMemoryLimit.
+type MemorySampler func() uint64
+
+// HeapSampler the default MemorySampler, the bytes of the heap objects of the process,
+// live or not swept yet, read with runtime/metrics.
+func HeapSampler() uint64 {
+	s := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
@@ -0,0 +1,86 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"errors"
+)
+
+// ErrValueType is returned by AppendSlice and MergeMap when the value of the key
+// is not of the type of the slice or map.
+var ErrValueType = errors.New("cache: value is not of the type of the slice or map")
+
+// AppendSlice atomically appends elems to the slice of the key k and returns the new slice.
+// The key keeps its expiration time, or is stored with elems and the default expiration if absent.
+// The slice is copied, so the slices returned before are never modified.
+// Returns ErrValueType, leaving the value unchanged, if the value is not a []E.
+func AppendSlice[E any](c Cache, k string, elems ...E) ([]E, error) {
+	v, ok := update(c, k, func(old interface{}, loaded bool) (interface{}, bool) {
+		if !loaded {
+			return appendSlice(nil, elems), true
+		}
+		s, ok := old.([]E)
+		return appendSlice(s, elems), ok
+	})
+	if !ok {
+		return nil, ErrValueType
+	}
+	return v.([]E), nil
+}
+
+// AppendSliceOf atomically appends elems to the slice of the key k and returns the new slice.
+// The key keeps its expiration time, or is stored with elems and the default expiration if absent.
+// The slice is copied, so the slices returned before are never modified.
+func AppendSliceOf[K comparable, E any](c CacheOf[K, []E], k K, elems ...E) []E {
+	v, _ := updateOf(c, k, func(old []E, _ bool) ([]E, bool) {
+		return appendSlice(old, elems), true
+	})
+	return v
+}
+
+// MergeMap atomically copies the entries of m into the map of the key k and returns the new map.
+// The key keeps its expiration time, or is stored with the entries and the default expiration if absent.
+// The map is copied, so the maps returned before are never modified.
+// Returns ErrValueType, leaving the value unchanged, if the value is not a map[MK]MV.
+func MergeMap[MK comparable, MV any](c Cache, k string, m map[MK]MV) (map[MK]MV, error) {
+	v, ok := update(c, k, func(old interface{}, loaded bool) (interface{}, bool) {
+		if !loaded {
+			return mergeMap(nil, m), true
+		}
+		dst, ok := old.(map[MK]MV)
+		return mergeMap(dst, m), ok
+	})
+	if !ok {
+		return nil, ErrValueType
+	}
+	return v.(map[MK]MV), nil
+}
+
+// MergeMapOf atomically copies the entries of m into the map of the key k and returns the new map.
+// The key keeps its expiration time, or is stored with the entries and the default expiration if absent.
+// The map is copied, so the maps returned before are never modified.
+func MergeMapOf[K, MK comparable, MV any](c CacheOf[K, map[MK]MV], k K, m map[MK]MV) map[MK]MV {
+	v, _ := updateOf(c, k, func(old map[MK]MV, _ bool) (map[MK]MV, bool) {
+		return mergeMap(old, m), true
+	})
+	return v
+}
+
+// appendSlice returns a new slice holding the elements of s followed by elems.
+func appendSlice[E any](s, elems []E) []E {
+	r := make([]E, 0, len(s)+len(elems))
+	return append(append(r, s...), elems...)
+}
+
+// mergeMap returns a new map holding the entries of dst overwritten by those of m.
+func mergeMap[K comparable, V any](dst, m map[K]V) map[K]V {
+	r := make(map[K]V, len(dst)+len(m))
+	for k, v := range dst {
+		r[k] = v
+	}
+	for k, v := range m {
+		r[k] = v
+	}
+	return r
+}
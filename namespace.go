@@ -0,0 +1,645 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+var _ Cache = (*namespace)(nil)
+
+// namespace is a view of a cache whose keys are prefixed, see Cache.Namespace.
+type namespace struct {
+	parent         Cache
+	prefix         string
+	snapshotFormat SnapshotFormat
+	codec          Codec
+}
+
+func newNamespace(parent Cache, prefix string, f SnapshotFormat, codec Codec) *namespace {
+	return &namespace{
+		parent:         parent,
+		prefix:         prefix,
+		snapshotFormat: f,
+		codec:          codec,
+	}
+}
+
+// key returns the key of k in the parent cache.
+func (n *namespace) key(k string) string {
+	return n.prefix + k
+}
+
+// tagKeys returns the tags in the parent cache.
+func (n *namespace) tagKeys(tags []string) []string {
+	nt := make([]string, len(tags))
+	for i, tag := range tags {
+		nt[i] = n.key(tag)
+	}
+	return nt
+}
+
+// local returns the key of k in the namespace, and whether k belongs to the namespace.
+func (n *namespace) local(k string) (string, bool) {
+	if !strings.HasPrefix(k, n.prefix) {
+		return "", false
+	}
+	return k[len(n.prefix):], true
+}
+
+func (n *namespace) Set(k string, v interface{}, d time.Duration) {
+	n.parent.Set(n.key(k), v, d)
+}
+
+func (n *namespace) SetE(k string, v interface{}, d time.Duration) error {
+	return n.parent.SetE(n.key(k), v, d)
+}
+
+func (n *namespace) SetDefault(k string, v interface{}) {
+	n.parent.SetDefault(n.key(k), v)
+}
+
+func (n *namespace) SetForever(k string, v interface{}) {
+	n.parent.SetForever(n.key(k), v)
+}
+
+func (n *namespace) SetWithMeta(k string, v interface{}, d time.Duration, meta interface{}) {
+	n.parent.SetWithMeta(n.key(k), v, d, meta)
+}
+
+func (n *namespace) SetWithCost(k string, v interface{}, d time.Duration, cost int64) {
+	n.parent.SetWithCost(n.key(k), v, d, cost)
+}
+
+func (n *namespace) SetWithCallback(k string, v interface{}, d time.Duration, fn EvictedCallback) {
+	if fn == nil {
+		n.parent.SetWithCallback(n.key(k), v, d, nil)
+		return
+	}
+	n.parent.SetWithCallback(n.key(k), v, d, func(_ string, v interface{}) {
+		fn(k, v)
+	})
+}
+
+// SetWithTags prefixes the tags too, so the tags of namespaces do not collide.
+func (n *namespace) SetWithTags(k string, v interface{}, d time.Duration, tags ...string) {
+	n.parent.SetWithTags(n.key(k), v, d, n.tagKeys(tags)...)
+}
+
+func (n *namespace) Get(k string) (interface{}, bool) {
+	return n.parent.Get(n.key(k))
+}
+
+func (n *namespace) GetE(k string) (interface{}, error) {
+	return n.parent.GetE(n.key(k))
+}
+
+func (n *namespace) GetWithExpiration(k string) (interface{}, time.Time, bool) {
+	return n.parent.GetWithExpiration(n.key(k))
+}
+
+func (n *namespace) GetWithExpirationNano(k string) (interface{}, int64, bool) {
+	return n.parent.GetWithExpirationNano(n.key(k))
+}
+
+func (n *namespace) GetWithTTL(k string) (interface{}, time.Duration, bool) {
+	return n.parent.GetWithTTL(n.key(k))
+}
+
+func (n *namespace) GetWithMeta(k string) (interface{}, interface{}, bool) {
+	return n.parent.GetWithMeta(n.key(k))
+}
+
+func (n *namespace) GetWithVersion(k string) (interface{}, uint64, bool) {
+	return n.parent.GetWithVersion(n.key(k))
+}
+
+func (n *namespace) Peek(k string) (interface{}, bool) {
+	return n.parent.Peek(n.key(k))
+}
+
+func (n *namespace) PeekWithExpiration(k string) (interface{}, time.Time, bool) {
+	return n.parent.PeekWithExpiration(n.key(k))
+}
+
+func (n *namespace) GetMultiple(keys []string) map[string]interface{} {
+	nk := make([]string, len(keys))
+	for i, k := range keys {
+		nk[i] = n.key(k)
+	}
+	items := make(map[string]interface{}, len(keys))
+	for k, v := range n.parent.GetMultiple(nk) {
+		k, _ = n.local(k)
+		items[k] = v
+	}
+	return items
+}
+
+func (n *namespace) SetIfAbsent(k string, v interface{}, d time.Duration) bool {
+	return n.parent.SetIfAbsent(n.key(k), v, d)
+}
+
+func (n *namespace) SetIfPresent(k string, v interface{}, d time.Duration) bool {
+	return n.parent.SetIfPresent(n.key(k), v, d)
+}
+
+func (n *namespace) GetOrSet(k string, v interface{}, d time.Duration) (interface{}, bool) {
+	return n.parent.GetOrSet(n.key(k), v, d)
+}
+
+func (n *namespace) GetAndSet(k string, v interface{}, d time.Duration) (interface{}, bool) {
+	return n.parent.GetAndSet(n.key(k), v, d)
+}
+
+func (n *namespace) GetAndRefresh(k string, d time.Duration) (interface{}, bool) {
+	return n.parent.GetAndRefresh(n.key(k), d)
+}
+
+func (n *namespace) RefreshOnly(k string, d time.Duration) bool {
+	return n.parent.RefreshOnly(n.key(k), d)
+}
+
+func (n *namespace) Expire(k string, d time.Duration) bool {
+	return n.parent.Expire(n.key(k), d)
+}
+
+func (n *namespace) Persist(k string) bool {
+	return n.parent.Persist(n.key(k))
+}
+
+func (n *namespace) Touch(k string) bool {
+	return n.parent.Touch(n.key(k))
+}
+
+// SetExpirationForAll sets the expiration time of the unexpired items of the namespace.
+func (n *namespace) SetExpirationForAll(d time.Duration) int {
+	return n.SetExpirationFunc(func(string, interface{}) time.Duration {
+		return d
+	})
+}
+
+// SetExpirationFunc sets the expiration time of the unexpired items of the namespace,
+// it walks the whole cache. Unlike the cache, f is called without locking the key.
+func (n *namespace) SetExpirationFunc(f func(k string, v interface{}) time.Duration) int {
+	count := 0
+	n.Range(func(k string, v interface{}) bool {
+		if n.parent.Expire(n.key(k), f(k, v)) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+func (n *namespace) GetOrCompute(k string, valueFn func() interface{}, d time.Duration) (interface{}, bool) {
+	return n.parent.GetOrCompute(n.key(k), valueFn, d)
+}
+
+func (n *namespace) GetOrComputeUnlocked(k string, valueFn func() interface{}, d time.Duration) (interface{}, bool) {
+	return n.parent.GetOrComputeUnlocked(n.key(k), valueFn, d)
+}
+
+func (n *namespace) Warmup(ctx context.Context, keys []string, loader Loader, parallelism int) error {
+	return warmup(ctx, keys, loader, parallelism, n.Set)
+}
+
+func (n *namespace) GetOrLoad(
+	k string,
+	loader func(k string) (interface{}, error),
+	d time.Duration,
+) (interface{}, bool, error) {
+	return n.parent.GetOrLoad(n.key(k), func(string) (interface{}, error) {
+		return loader(k)
+	}, d)
+}
+
+func (n *namespace) Compute(
+	k string,
+	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, delete bool),
+	d time.Duration,
+) (interface{}, bool) {
+	return n.parent.Compute(n.key(k), valueFn, d)
+}
+
+func (n *namespace) ComputeWithOp(
+	k string,
+	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, op ComputeOp),
+	d time.Duration,
+) (interface{}, bool) {
+	return n.parent.ComputeWithOp(n.key(k), valueFn, d)
+}
+
+func (n *namespace) update(k string, f func(old interface{}, loaded bool) (interface{}, bool)) (interface{}, bool) {
+	return update(n.parent, n.key(k), f)
+}
+
+func (n *namespace) CompareAndSwap(k string, old, new interface{}, d time.Duration) bool {
+	return n.parent.CompareAndSwap(n.key(k), old, new, d)
+}
+
+func (n *namespace) CompareAndDelete(k string, old interface{}) bool {
+	return n.parent.CompareAndDelete(n.key(k), old)
+}
+
+func (n *namespace) CompareAndSwapFunc(k string, old, new interface{}, equal func(current, old interface{}) bool, d time.Duration) bool {
+	return n.parent.CompareAndSwapFunc(n.key(k), old, new, equal, d)
+}
+
+func (n *namespace) CompareAndDeleteFunc(k string, old interface{}, equal func(current, old interface{}) bool) bool {
+	return n.parent.CompareAndDeleteFunc(n.key(k), old, equal)
+}
+
+func (n *namespace) SetIfVersion(k string, v interface{}, version uint64, d time.Duration) bool {
+	return n.parent.SetIfVersion(n.key(k), v, version, d)
+}
+
+func (n *namespace) GetAndDelete(k string) (interface{}, bool) {
+	return n.parent.GetAndDelete(n.key(k))
+}
+
+func (n *namespace) Delete(k string) {
+	n.parent.Delete(n.key(k))
+}
+
+func (n *namespace) Update(fn func(tx Txn) error) error {
+	return n.parent.Update(func(tx Txn) error {
+		return fn(keyedTxn{tx: tx, key: n.key})
+	})
+}
+
+func (n *namespace) DeleteMultiple(keys []string) {
+	nk := make([]string, len(keys))
+	for i, k := range keys {
+		nk[i] = n.key(k)
+	}
+	n.parent.DeleteMultiple(nk)
+}
+
+func (n *namespace) DeleteFunc(f func(k string, v interface{}) bool) int {
+	return n.parent.DeleteFunc(func(k string, v interface{}) bool {
+		k, ok := n.local(k)
+		return ok && f(k, v)
+	})
+}
+
+func (n *namespace) InvalidateTag(tag string) int {
+	return n.parent.InvalidateTag(n.key(tag))
+}
+
+func (n *namespace) InvalidateIf(f func(k string) bool) {
+	if f == nil {
+		return
+	}
+	n.parent.InvalidateIf(func(k string) bool {
+		k, ok := n.local(k)
+		return ok && f(k)
+	})
+}
+
+// DeleteExpired deletes the expired items of the whole cache.
+func (n *namespace) DeleteExpired() {
+	n.parent.DeleteExpired()
+}
+
+// DeleteExpiredN deletes up to maxItems expired items of the whole cache.
+func (n *namespace) DeleteExpiredN(maxItems int) int {
+	return n.parent.DeleteExpiredN(maxItems)
+}
+
+// DeleteExpiredFor deletes expired items of the whole cache for at most maxDuration.
+func (n *namespace) DeleteExpiredFor(maxDuration time.Duration) int {
+	return n.parent.DeleteExpiredFor(maxDuration)
+}
+
+func (n *namespace) Range(f func(k string, v interface{}) bool) {
+	if f == nil {
+		return
+	}
+	n.parent.Range(func(k string, v interface{}) bool {
+		if k, ok := n.local(k); ok {
+			return f(k, v)
+		}
+		return true
+	})
+}
+
+// RangeCursor iterates over the whole cache, calling f for the keys of the namespace,
+// so a call may visit fewer than count keys while the iteration is not yet complete.
+func (n *namespace) RangeCursor(cursor uint64, count int, f func(k string, v interface{})) uint64 {
+	return n.parent.RangeCursor(cursor, count, func(k string, v interface{}) {
+		if k, ok := n.local(k); ok {
+			f(k, v)
+		}
+	})
+}
+
+func (n *namespace) ScanItems(cursor uint64, count int) (items []KeyValue, next uint64) {
+	next = n.RangeCursor(cursor, count, func(k string, v interface{}) {
+		items = append(items, KeyValue{k, v})
+	})
+	return
+}
+
+func (n *namespace) Scan(pattern string, cursor uint64, count int) (keys []string, next uint64) {
+	next = n.RangeCursor(cursor, count, func(k string, _ interface{}) {
+		if pattern == "" || matchPattern(pattern, k) {
+			keys = append(keys, k)
+		}
+	})
+	return
+}
+
+func (n *namespace) RangeSorted(f func(k string, v interface{}) bool) {
+	n.RangeSortedFunc(func(a, b string) bool { return a < b }, f)
+}
+
+func (n *namespace) RangeSortedFunc(less func(a, b string) bool, f func(k string, v interface{}) bool) {
+	if f == nil {
+		return
+	}
+	for _, x := range sortedItems(n.Range, 0, less) {
+		if !f(x.Key, x.Value) {
+			return
+		}
+	}
+}
+
+func (n *namespace) ItemsSorted() []KeyValue {
+	return sortedItems(n.Range, 0, func(a, b string) bool { return a < b })
+}
+
+func (n *namespace) KeysSorted() []string {
+	var keys []string
+	n.Range(func(k string, _ interface{}) bool {
+		keys = append(keys, k)
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func (n *namespace) KeyFilter(p float64) *BloomFilter {
+	keys := n.KeysSorted()
+	f := NewBloomFilter(len(keys), p)
+	for _, k := range keys {
+		f.Add(k)
+	}
+	return f
+}
+
+func (n *namespace) Items() map[string]interface{} {
+	items := make(map[string]interface{})
+	n.Range(func(k string, v interface{}) bool {
+		items[k] = v
+		return true
+	})
+	return items
+}
+
+func (n *namespace) ItemsWhere(f func(k string, v interface{}) bool) map[string]interface{} {
+	return n.localItems(n.parent.ItemsWhere(func(k string, v interface{}) bool {
+		k, ok := n.local(k)
+		return ok && f(k, v)
+	}))
+}
+
+func (n *namespace) ItemsWithPrefix(prefix string) map[string]interface{} {
+	return n.localItems(n.parent.ItemsWithPrefix(n.key(prefix)))
+}
+
+// localItems returns the items of the parent with the keys of the namespace.
+func (n *namespace) localItems(parent map[string]interface{}) map[string]interface{} {
+	items := make(map[string]interface{}, len(parent))
+	for k, v := range parent {
+		k, _ = n.local(k)
+		items[k] = v
+	}
+	return items
+}
+
+func (n *namespace) GetBytes(k []byte) (interface{}, bool) {
+	return n.Get(string(k))
+}
+
+func (n *namespace) SetBytes(k []byte, v interface{}, d time.Duration) {
+	n.Set(string(k), v, d)
+}
+
+func (n *namespace) ItemsAtomic() map[string]interface{} {
+	items := make(map[string]interface{})
+	for k, v := range n.parent.ItemsAtomic() {
+		if k, ok := n.local(k); ok {
+			items[k] = v
+		}
+	}
+	return items
+}
+
+func (n *namespace) ItemsWithExpiration() map[string]ItemWithExpiration {
+	items := make(map[string]ItemWithExpiration)
+	for k, x := range n.parent.ItemsWithExpiration() {
+		if k, ok := n.local(k); ok {
+			items[k] = x
+		}
+	}
+	return items
+}
+
+func (n *namespace) LoadItemsWithExpiration(items map[string]ItemWithExpiration, strategy ...LoadStrategy) {
+	nitems := make(map[string]ItemWithExpiration, len(items))
+	for k, x := range items {
+		nitems[n.key(k)] = x
+	}
+	n.parent.LoadItemsWithExpiration(nitems, strategy...)
+}
+
+// SaveTo writes a snapshot of the unexpired items of the namespace to w, with their keys
+// in the namespace, so it can be loaded into another namespace or cache.
+func (n *namespace) SaveTo(w io.Writer) error {
+	var buf bytes.Buffer
+	enc := newSnapshotEncoder(n.snapshotFormat, n.codec, &buf)
+	items := n.ItemsWithExpiration()
+	for k, x := range items {
+		if err := enc.Encode(snapshotItem{K: k, V: x.Value, E: expirationNano(x.Expiration)}); err != nil {
+			return err
+		}
+	}
+	return writeSnapshot(w, n.snapshotFormat, snapshotKeyType, snapshotValueType, len(items), buf.Bytes())
+}
+
+func (n *namespace) LoadFrom(r io.Reader, strategy ...LoadStrategy) error {
+	h, payload, err := readSnapshot(r, snapshotKeyType, snapshotValueType)
+	if err != nil {
+		return err
+	}
+	return n.loadSnapshot(h, payload, strategy...)
+}
+
+// loadSnapshot stores the items of the verified payload of a snapshot in the namespace.
+func (n *namespace) loadSnapshot(h SnapshotHeader, payload []byte, strategy ...LoadStrategy) error {
+	items := make(map[string]ItemWithExpiration)
+	err := decodeSnapshot(h, n.codec, payload, func(dec snapshotDecoder) error {
+		var x snapshotItem
+		if err := dec.Decode(&x); err != nil {
+			return err
+		}
+		items[x.K] = ItemWithExpiration{Value: x.V, Expiration: expirationTime(x.E)}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	n.LoadItemsWithExpiration(items, strategy...)
+	return nil
+}
+
+func (n *namespace) ExportGzip(w io.Writer) error {
+	return exportGzip(w, n.snapshotFormat, n.codec, snapshotKeyType, snapshotValueType,
+		func(add func(x interface{}) error) error {
+			for k, x := range n.ItemsWithExpiration() {
+				if err := add(snapshotItem{K: k, V: x.Value, E: expirationNano(x.Expiration)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+func (n *namespace) ImportGzip(r io.Reader, strategy ...LoadStrategy) error {
+	return importGzip(r, snapshotKeyType, snapshotValueType, func(h SnapshotHeader, payload []byte) error {
+		return n.loadSnapshot(h, payload, strategy...)
+	})
+}
+
+func (n *namespace) SaveToFile(path string) error {
+	return writeFileAtomic(path, n.SaveTo)
+}
+
+func (n *namespace) LoadFromFile(path string, strategy ...LoadStrategy) error {
+	return readFile(path, func(r io.Reader) error {
+		return n.LoadFrom(r, strategy...)
+	})
+}
+
+// Clear deletes the items of the namespace, they are reported as deleted to the callbacks.
+func (n *namespace) Clear() {
+	n.DeleteFunc(func(string, interface{}) bool { return true })
+}
+
+// Count returns the number of unexpired items in the namespace, it walks the whole cache.
+func (n *namespace) Count() int {
+	count := 0
+	n.Range(func(string, interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func (n *namespace) CountWhere(f func(k string, v interface{}) bool) int {
+	count := 0
+	n.Range(func(k string, v interface{}) bool {
+		if f(k, v) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// ExpiredCount returns the number of expired items of the whole cache.
+func (n *namespace) ExpiredCount() int {
+	return n.parent.ExpiredCount()
+}
+
+func (n *namespace) DefaultExpiration() time.Duration {
+	return n.parent.DefaultExpiration()
+}
+
+// SetDefaultExpiration sets the default expiration time of the whole cache.
+func (n *namespace) SetDefaultExpiration(defaultExpiration time.Duration) {
+	n.parent.SetDefaultExpiration(defaultExpiration)
+}
+
+// PauseCleanup pauses the cleanup loop of the whole cache.
+func (n *namespace) PauseCleanup() {
+	n.parent.PauseCleanup()
+}
+
+// ResumeCleanup resumes the cleanup loop of the whole cache.
+func (n *namespace) ResumeCleanup() {
+	n.parent.ResumeCleanup()
+}
+
+// SetCleanupInterval sets the interval of the cleanup loop of the whole cache.
+func (n *namespace) SetCleanupInterval(interval time.Duration) {
+	n.parent.SetCleanupInterval(interval)
+}
+
+func (n *namespace) EvictedCallback() EvictedCallback {
+	return n.parent.EvictedCallback()
+}
+
+// SetEvictedCallback sets the evicted callback of the whole cache, called with the keys of the cache.
+func (n *namespace) SetEvictedCallback(evictedCallback EvictedCallback) {
+	n.parent.SetEvictedCallback(evictedCallback)
+}
+
+// RecentEvents returns the recent operations on the keys of the namespace, oldest first.
+func (n *namespace) RecentEvents() []Event {
+	events := n.parent.RecentEvents()
+	if events == nil {
+		return nil
+	}
+	ns := events[:0]
+	for _, e := range events {
+		if k, ok := n.local(e.Key); ok {
+			e.Key = k
+			ns = append(ns, e)
+		}
+	}
+	return ns
+}
+
+// Diagnostics returns the diagnostics of the whole cache.
+func (n *namespace) Diagnostics(intervals int) Diagnostics {
+	return n.parent.Diagnostics(intervals)
+}
+
+// HotKeys returns the most read keys of the namespace, among those tracked for the whole cache.
+func (n *namespace) HotKeys(count int) []KeyStat {
+	var stats []KeyStat
+	for _, x := range n.parent.HotKeys(0) {
+		if k, ok := n.local(x.Key); ok {
+			x.Key = k
+			stats = append(stats, x)
+		}
+	}
+	if count > 0 && count < len(stats) {
+		stats = stats[:count]
+	}
+	return stats
+}
+
+// ShadowStats returns the simulated hit rates of the whole cache.
+func (n *namespace) ShadowStats() []ShadowStats {
+	return n.parent.ShadowStats()
+}
+
+// Namespace returns a view of the namespace whose keys are prefixed, nested in this namespace.
+func (n *namespace) Namespace(prefix string) Cache {
+	return newNamespace(n.parent, n.prefix+prefix, n.snapshotFormat, n.codec)
+}
+
+// Close does nothing, the namespace does not own the cache.
+func (n *namespace) Close() error {
+	return nil
+}
+
+// CloseContext does nothing, the namespace does not own the cache.
+func (n *namespace) CloseContext(context.Context) error {
+	return nil
+}
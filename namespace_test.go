@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNamespace(t *testing.T) {
+	c := New(WithCleanupInterval(0), WithEventHistory(10))
+	defer c.Close()
+	users := c.Namespace("users:")
+	orders := c.Namespace("orders:")
+
+	users.SetForever("1", "alice")
+	users.SetForever("2", "bob")
+	orders.SetForever("1", 100)
+	if v, ok := c.Get("users:1"); !ok || v != "alice" {
+		t.Fatalf("expected the prefixed key in the cache, got: %v", v)
+	}
+	if v, ok := orders.Get("1"); !ok || v != 100 {
+		t.Fatalf("expected 100, got: %v", v)
+	}
+	if keys := users.KeysSorted(); !reflect.DeepEqual(keys, []string{"1", "2"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if items := users.GetMultiple([]string{"1", "3"}); !reflect.DeepEqual(items, map[string]interface{}{"1": "alice"}) {
+		t.Fatalf("unexpected items: %v", items)
+	}
+	v, loaded, err := users.GetOrLoad("3", func(k string) (interface{}, error) {
+		return "user " + k, nil
+	}, NoExpiration)
+	if err != nil || loaded || v != "user 3" {
+		t.Fatalf("unexpected load: %v, %v, %v", v, loaded, err)
+	}
+	if keys, _ := users.Scan("[12]", 0, 100); len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got: %v", keys)
+	}
+	for _, e := range users.RecentEvents() {
+		if e.Key != "1" && e.Key != "2" && e.Key != "3" {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	}
+
+	// nested
+	admins := users.Namespace("admins:")
+	admins.SetForever("1", "root")
+	if _, ok := c.Get("users:admins:1"); !ok {
+		t.Fatal("expected the nested prefix in the cache")
+	}
+
+	// snapshots keep the keys of the namespace
+	var buf bytes.Buffer
+	if err := orders.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	archive := c.Namespace("archive:")
+	if err := archive.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("archive:1"); !ok {
+		t.Fatal("expected the snapshot to be loaded in the namespace")
+	}
+
+	if n := users.Count(); n != 4 {
+		t.Fatalf("expected 4 users, got %d", n)
+	}
+	users.Clear()
+	if n := users.Count(); n != 0 {
+		t.Fatalf("expected no users, got %d", n)
+	}
+	if n := c.Count(); n != 2 {
+		t.Fatalf("expected the other namespaces to be kept, got %d items", n)
+	}
+	if err := users.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("k", 1, time.Minute)
+	if _, ok := c.Get("k"); !ok {
+		t.Fatal("closing a namespace should not affect the cache")
+	}
+}
@@ -0,0 +1,618 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+var _ CacheOf[string, any] = (*namespaceOf[any])(nil)
+
+// namespaceOf is a view of a cache whose keys are prefixed, see NamespaceOf.
+type namespaceOf[V any] struct {
+	parent         CacheOf[string, V]
+	prefix         string
+	snapshotFormat SnapshotFormat
+	codec          Codec
+}
+
+// NamespaceOf returns a view of the cache whose keys are prefixed by prefix, e.g. "users:",
+// so that subsystems can share the cache without their keys colliding.
+// Clear, Count and the iterations of the view only cover its keys, but walk the whole cache.
+// The expiration, the callbacks and the statistics are shared with the cache,
+// and Close of the view does nothing. Namespaces of a namespace are nested.
+func NamespaceOf[V any](c CacheOf[string, V], prefix string) CacheOf[string, V] {
+	f := SnapshotJSON
+	var codec Codec
+	switch p := c.(type) {
+	case *namespaceOf[V]:
+		return newNamespaceOf[V](p.parent, p.prefix+prefix, p.snapshotFormat, p.codec)
+	case *xsyncMapOfWrapper[string, V]:
+		f, codec = p.snapshotFormat, p.codec
+	}
+	return newNamespaceOf[V](c, prefix, f, codec)
+}
+
+func newNamespaceOf[V any](parent CacheOf[string, V], prefix string, f SnapshotFormat, codec Codec) *namespaceOf[V] {
+	return &namespaceOf[V]{
+		parent:         parent,
+		prefix:         prefix,
+		snapshotFormat: f,
+		codec:          codec,
+	}
+}
+
+// key returns the key of k in the parent cache.
+func (n *namespaceOf[V]) key(k string) string {
+	return n.prefix + k
+}
+
+// tagKeys returns the tags in the parent cache.
+func (n *namespaceOf[V]) tagKeys(tags []string) []string {
+	nt := make([]string, len(tags))
+	for i, tag := range tags {
+		nt[i] = n.key(tag)
+	}
+	return nt
+}
+
+// local returns the key of k in the namespace, and whether k belongs to the namespace.
+func (n *namespaceOf[V]) local(k string) (string, bool) {
+	if !strings.HasPrefix(k, n.prefix) {
+		return "", false
+	}
+	return k[len(n.prefix):], true
+}
+
+func (n *namespaceOf[V]) Set(k string, v V, d time.Duration) {
+	n.parent.Set(n.key(k), v, d)
+}
+
+func (n *namespaceOf[V]) SetE(k string, v V, d time.Duration) error {
+	return n.parent.SetE(n.key(k), v, d)
+}
+
+func (n *namespaceOf[V]) SetDefault(k string, v V) {
+	n.parent.SetDefault(n.key(k), v)
+}
+
+func (n *namespaceOf[V]) SetForever(k string, v V) {
+	n.parent.SetForever(n.key(k), v)
+}
+
+func (n *namespaceOf[V]) SetWithMeta(k string, v V, d time.Duration, meta any) {
+	n.parent.SetWithMeta(n.key(k), v, d, meta)
+}
+
+func (n *namespaceOf[V]) SetWithCost(k string, v V, d time.Duration, cost int64) {
+	n.parent.SetWithCost(n.key(k), v, d, cost)
+}
+
+func (n *namespaceOf[V]) SetWithCallback(k string, v V, d time.Duration, fn EvictedCallbackOf[string, V]) {
+	if fn == nil {
+		n.parent.SetWithCallback(n.key(k), v, d, nil)
+		return
+	}
+	n.parent.SetWithCallback(n.key(k), v, d, func(_ string, v V) {
+		fn(k, v)
+	})
+}
+
+// SetWithTags prefixes the tags too, so the tags of namespaces do not collide.
+func (n *namespaceOf[V]) SetWithTags(k string, v V, d time.Duration, tags ...string) {
+	n.parent.SetWithTags(n.key(k), v, d, n.tagKeys(tags)...)
+}
+
+func (n *namespaceOf[V]) Get(k string) (V, bool) {
+	return n.parent.Get(n.key(k))
+}
+
+func (n *namespaceOf[V]) GetE(k string) (V, error) {
+	return n.parent.GetE(n.key(k))
+}
+
+func (n *namespaceOf[V]) GetWithExpiration(k string) (V, time.Time, bool) {
+	return n.parent.GetWithExpiration(n.key(k))
+}
+
+func (n *namespaceOf[V]) GetWithExpirationNano(k string) (V, int64, bool) {
+	return n.parent.GetWithExpirationNano(n.key(k))
+}
+
+func (n *namespaceOf[V]) GetWithTTL(k string) (V, time.Duration, bool) {
+	return n.parent.GetWithTTL(n.key(k))
+}
+
+func (n *namespaceOf[V]) GetWithMeta(k string) (V, any, bool) {
+	return n.parent.GetWithMeta(n.key(k))
+}
+
+func (n *namespaceOf[V]) GetWithVersion(k string) (V, uint64, bool) {
+	return n.parent.GetWithVersion(n.key(k))
+}
+
+func (n *namespaceOf[V]) Peek(k string) (V, bool) {
+	return n.parent.Peek(n.key(k))
+}
+
+func (n *namespaceOf[V]) PeekWithExpiration(k string) (V, time.Time, bool) {
+	return n.parent.PeekWithExpiration(n.key(k))
+}
+
+func (n *namespaceOf[V]) GetMultiple(keys []string) map[string]V {
+	nk := make([]string, len(keys))
+	for i, k := range keys {
+		nk[i] = n.key(k)
+	}
+	items := make(map[string]V, len(keys))
+	for k, v := range n.parent.GetMultiple(nk) {
+		k, _ = n.local(k)
+		items[k] = v
+	}
+	return items
+}
+
+func (n *namespaceOf[V]) SetIfAbsent(k string, v V, d time.Duration) bool {
+	return n.parent.SetIfAbsent(n.key(k), v, d)
+}
+
+func (n *namespaceOf[V]) SetIfPresent(k string, v V, d time.Duration) bool {
+	return n.parent.SetIfPresent(n.key(k), v, d)
+}
+
+func (n *namespaceOf[V]) GetOrSet(k string, v V, d time.Duration) (V, bool) {
+	return n.parent.GetOrSet(n.key(k), v, d)
+}
+
+func (n *namespaceOf[V]) GetAndSet(k string, v V, d time.Duration) (V, bool) {
+	return n.parent.GetAndSet(n.key(k), v, d)
+}
+
+func (n *namespaceOf[V]) GetAndRefresh(k string, d time.Duration) (V, bool) {
+	return n.parent.GetAndRefresh(n.key(k), d)
+}
+
+func (n *namespaceOf[V]) RefreshOnly(k string, d time.Duration) bool {
+	return n.parent.RefreshOnly(n.key(k), d)
+}
+
+func (n *namespaceOf[V]) Expire(k string, d time.Duration) bool {
+	return n.parent.Expire(n.key(k), d)
+}
+
+func (n *namespaceOf[V]) Persist(k string) bool {
+	return n.parent.Persist(n.key(k))
+}
+
+func (n *namespaceOf[V]) Touch(k string) bool {
+	return n.parent.Touch(n.key(k))
+}
+
+// SetExpirationForAll sets the expiration time of the unexpired items of the namespace.
+func (n *namespaceOf[V]) SetExpirationForAll(d time.Duration) int {
+	return n.SetExpirationFunc(func(string, V) time.Duration {
+		return d
+	})
+}
+
+// SetExpirationFunc sets the expiration time of the unexpired items of the namespace,
+// it walks the whole cache. Unlike the cache, f is called without locking the key.
+func (n *namespaceOf[V]) SetExpirationFunc(f func(k string, v V) time.Duration) int {
+	count := 0
+	n.Range(func(k string, v V) bool {
+		if n.parent.Expire(n.key(k), f(k, v)) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+func (n *namespaceOf[V]) GetOrCompute(k string, valueFn func() V, d time.Duration) (V, bool) {
+	return n.parent.GetOrCompute(n.key(k), valueFn, d)
+}
+
+func (n *namespaceOf[V]) GetOrComputeUnlocked(k string, valueFn func() V, d time.Duration) (V, bool) {
+	return n.parent.GetOrComputeUnlocked(n.key(k), valueFn, d)
+}
+
+func (n *namespaceOf[V]) Warmup(ctx context.Context, keys []string, loader LoaderOf[string, V], parallelism int) error {
+	return warmupOf(ctx, keys, loader, parallelism, n.Set)
+}
+
+func (n *namespaceOf[V]) GetOrLoad(
+	k string,
+	loader func(k string) (V, error),
+	d time.Duration,
+) (V, bool, error) {
+	return n.parent.GetOrLoad(n.key(k), func(string) (V, error) {
+		return loader(k)
+	}, d)
+}
+
+func (n *namespaceOf[V]) Compute(
+	k string,
+	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
+	d time.Duration,
+) (V, bool) {
+	return n.parent.Compute(n.key(k), valueFn, d)
+}
+
+func (n *namespaceOf[V]) ComputeWithOp(
+	k string,
+	valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
+	d time.Duration,
+) (V, bool) {
+	return n.parent.ComputeWithOp(n.key(k), valueFn, d)
+}
+
+func (n *namespaceOf[V]) update(k string, f func(old V, loaded bool) (V, bool)) (V, bool) {
+	return updateOf(n.parent, n.key(k), f)
+}
+
+func (n *namespaceOf[V]) CompareAndSwap(k string, old, new V, d time.Duration) bool {
+	return n.parent.CompareAndSwap(n.key(k), old, new, d)
+}
+
+func (n *namespaceOf[V]) CompareAndDelete(k string, old V) bool {
+	return n.parent.CompareAndDelete(n.key(k), old)
+}
+
+func (n *namespaceOf[V]) CompareAndSwapFunc(k string, old, new V, equal func(current, old V) bool, d time.Duration) bool {
+	return n.parent.CompareAndSwapFunc(n.key(k), old, new, equal, d)
+}
+
+func (n *namespaceOf[V]) CompareAndDeleteFunc(k string, old V, equal func(current, old V) bool) bool {
+	return n.parent.CompareAndDeleteFunc(n.key(k), old, equal)
+}
+
+func (n *namespaceOf[V]) SetIfVersion(k string, v V, version uint64, d time.Duration) bool {
+	return n.parent.SetIfVersion(n.key(k), v, version, d)
+}
+
+func (n *namespaceOf[V]) GetAndDelete(k string) (V, bool) {
+	return n.parent.GetAndDelete(n.key(k))
+}
+
+func (n *namespaceOf[V]) Delete(k string) {
+	n.parent.Delete(n.key(k))
+}
+
+func (n *namespaceOf[V]) Update(fn func(tx TxnOf[string, V]) error) error {
+	return n.parent.Update(func(tx TxnOf[string, V]) error {
+		return fn(keyedTxnOf[string, V]{tx: tx, key: n.key})
+	})
+}
+
+func (n *namespaceOf[V]) DeleteMultiple(keys []string) {
+	nk := make([]string, len(keys))
+	for i, k := range keys {
+		nk[i] = n.key(k)
+	}
+	n.parent.DeleteMultiple(nk)
+}
+
+func (n *namespaceOf[V]) DeleteFunc(f func(k string, v V) bool) int {
+	return n.parent.DeleteFunc(func(k string, v V) bool {
+		k, ok := n.local(k)
+		return ok && f(k, v)
+	})
+}
+
+func (n *namespaceOf[V]) InvalidateTag(tag string) int {
+	return n.parent.InvalidateTag(n.key(tag))
+}
+
+func (n *namespaceOf[V]) InvalidateIf(f func(k string) bool) {
+	if f == nil {
+		return
+	}
+	n.parent.InvalidateIf(func(k string) bool {
+		k, ok := n.local(k)
+		return ok && f(k)
+	})
+}
+
+// DeleteExpired deletes the expired items of the whole cache.
+func (n *namespaceOf[V]) DeleteExpired() {
+	n.parent.DeleteExpired()
+}
+
+// DeleteExpiredN deletes up to maxItems expired items of the whole cache.
+func (n *namespaceOf[V]) DeleteExpiredN(maxItems int) int {
+	return n.parent.DeleteExpiredN(maxItems)
+}
+
+// DeleteExpiredFor deletes expired items of the whole cache for at most maxDuration.
+func (n *namespaceOf[V]) DeleteExpiredFor(maxDuration time.Duration) int {
+	return n.parent.DeleteExpiredFor(maxDuration)
+}
+
+func (n *namespaceOf[V]) Range(f func(k string, v V) bool) {
+	if f == nil {
+		return
+	}
+	n.parent.Range(func(k string, v V) bool {
+		if k, ok := n.local(k); ok {
+			return f(k, v)
+		}
+		return true
+	})
+}
+
+// RangeCursor iterates over the whole cache, calling f for the keys of the namespace,
+// so a call may visit fewer than count keys while the iteration is not yet complete.
+func (n *namespaceOf[V]) RangeCursor(cursor uint64, count int, f func(k string, v V)) uint64 {
+	return n.parent.RangeCursor(cursor, count, func(k string, v V) {
+		if k, ok := n.local(k); ok {
+			f(k, v)
+		}
+	})
+}
+
+func (n *namespaceOf[V]) ScanItems(cursor uint64, count int) (items []KeyValueOf[string, V], next uint64) {
+	next = n.RangeCursor(cursor, count, func(k string, v V) {
+		items = append(items, KeyValueOf[string, V]{k, v})
+	})
+	return
+}
+
+func (n *namespaceOf[V]) Scan(pattern string, cursor uint64, count int) (keys []string, next uint64) {
+	next = n.RangeCursor(cursor, count, func(k string, _ V) {
+		if pattern == "" || matchPattern(pattern, k) {
+			keys = append(keys, k)
+		}
+	})
+	return
+}
+
+func (n *namespaceOf[V]) KeyFilter(p float64) *BloomFilter {
+	var keys []string
+	n.Range(func(k string, _ V) bool {
+		keys = append(keys, k)
+		return true
+	})
+	f := NewBloomFilter(len(keys), p)
+	for _, k := range keys {
+		f.Add(k)
+	}
+	return f
+}
+
+func (n *namespaceOf[V]) Items() map[string]V {
+	items := make(map[string]V)
+	n.Range(func(k string, v V) bool {
+		items[k] = v
+		return true
+	})
+	return items
+}
+
+func (n *namespaceOf[V]) ItemsWhere(f func(k string, v V) bool) map[string]V {
+	items := make(map[string]V)
+	for k, v := range n.parent.ItemsWhere(func(k string, v V) bool {
+		k, ok := n.local(k)
+		return ok && f(k, v)
+	}) {
+		k, _ = n.local(k)
+		items[k] = v
+	}
+	return items
+}
+
+func (n *namespaceOf[V]) ItemsAtomic() map[string]V {
+	items := make(map[string]V)
+	for k, v := range n.parent.ItemsAtomic() {
+		if k, ok := n.local(k); ok {
+			items[k] = v
+		}
+	}
+	return items
+}
+
+func (n *namespaceOf[V]) ItemsWithExpiration() map[string]ItemWithExpirationOf[V] {
+	items := make(map[string]ItemWithExpirationOf[V])
+	for k, x := range n.parent.ItemsWithExpiration() {
+		if k, ok := n.local(k); ok {
+			items[k] = x
+		}
+	}
+	return items
+}
+
+func (n *namespaceOf[V]) LoadItemsWithExpiration(items map[string]ItemWithExpirationOf[V], strategy ...LoadStrategy) {
+	nitems := make(map[string]ItemWithExpirationOf[V], len(items))
+	for k, x := range items {
+		nitems[n.key(k)] = x
+	}
+	n.parent.LoadItemsWithExpiration(nitems, strategy...)
+}
+
+// SaveTo writes a snapshot of the unexpired items of the namespace to w, with their keys
+// in the namespace, so it can be loaded into another namespace or cache.
+func (n *namespaceOf[V]) SaveTo(w io.Writer) error {
+	var buf bytes.Buffer
+	enc := newSnapshotEncoder(n.snapshotFormat, n.codec, &buf)
+	items := n.ItemsWithExpiration()
+	for k, x := range items {
+		if err := enc.Encode(snapshotItemOf[string, V]{K: k, V: x.Value, E: expirationNano(x.Expiration)}); err != nil {
+			return err
+		}
+	}
+	return writeSnapshot(w, n.snapshotFormat, typeName[string](), typeName[V](), len(items), buf.Bytes())
+}
+
+func (n *namespaceOf[V]) LoadFrom(r io.Reader, strategy ...LoadStrategy) error {
+	h, payload, err := readSnapshot(r, typeName[string](), typeName[V]())
+	if err != nil {
+		return err
+	}
+	return n.loadSnapshot(h, payload, strategy...)
+}
+
+// loadSnapshot stores the items of the verified payload of a snapshot in the namespace.
+func (n *namespaceOf[V]) loadSnapshot(h SnapshotHeader, payload []byte, strategy ...LoadStrategy) error {
+	items := make(map[string]ItemWithExpirationOf[V])
+	err := decodeSnapshot(h, n.codec, payload, func(dec snapshotDecoder) error {
+		var x snapshotItemOf[string, V]
+		if err := dec.Decode(&x); err != nil {
+			return err
+		}
+		items[x.K] = ItemWithExpirationOf[V]{Value: x.V, Expiration: expirationTime(x.E)}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	n.LoadItemsWithExpiration(items, strategy...)
+	return nil
+}
+
+func (n *namespaceOf[V]) ExportGzip(w io.Writer) error {
+	return exportGzip(w, n.snapshotFormat, n.codec, typeName[string](), typeName[V](),
+		func(add func(x interface{}) error) error {
+			for k, x := range n.ItemsWithExpiration() {
+				err := add(snapshotItemOf[string, V]{K: k, V: x.Value, E: expirationNano(x.Expiration)})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+func (n *namespaceOf[V]) ImportGzip(r io.Reader, strategy ...LoadStrategy) error {
+	return importGzip(r, typeName[string](), typeName[V](), func(h SnapshotHeader, payload []byte) error {
+		return n.loadSnapshot(h, payload, strategy...)
+	})
+}
+
+func (n *namespaceOf[V]) SaveToFile(path string) error {
+	return writeFileAtomic(path, n.SaveTo)
+}
+
+func (n *namespaceOf[V]) LoadFromFile(path string, strategy ...LoadStrategy) error {
+	return readFile(path, func(r io.Reader) error {
+		return n.LoadFrom(r, strategy...)
+	})
+}
+
+// Clear deletes the items of the namespace, they are reported as deleted to the callbacks.
+func (n *namespaceOf[V]) Clear() {
+	n.DeleteFunc(func(string, V) bool { return true })
+}
+
+// Count returns the number of unexpired items in the namespace, it walks the whole cache.
+func (n *namespaceOf[V]) Count() int {
+	count := 0
+	n.Range(func(string, V) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func (n *namespaceOf[V]) CountWhere(f func(k string, v V) bool) int {
+	count := 0
+	n.Range(func(k string, v V) bool {
+		if f(k, v) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// ExpiredCount returns the number of expired items of the whole cache.
+func (n *namespaceOf[V]) ExpiredCount() int {
+	return n.parent.ExpiredCount()
+}
+
+func (n *namespaceOf[V]) DefaultExpiration() time.Duration {
+	return n.parent.DefaultExpiration()
+}
+
+// SetDefaultExpiration sets the default expiration time of the whole cache.
+func (n *namespaceOf[V]) SetDefaultExpiration(defaultExpiration time.Duration) {
+	n.parent.SetDefaultExpiration(defaultExpiration)
+}
+
+// PauseCleanup pauses the cleanup loop of the whole cache.
+func (n *namespaceOf[V]) PauseCleanup() {
+	n.parent.PauseCleanup()
+}
+
+// ResumeCleanup resumes the cleanup loop of the whole cache.
+func (n *namespaceOf[V]) ResumeCleanup() {
+	n.parent.ResumeCleanup()
+}
+
+// SetCleanupInterval sets the interval of the cleanup loop of the whole cache.
+func (n *namespaceOf[V]) SetCleanupInterval(interval time.Duration) {
+	n.parent.SetCleanupInterval(interval)
+}
+
+func (n *namespaceOf[V]) EvictedCallback() EvictedCallbackOf[string, V] {
+	return n.parent.EvictedCallback()
+}
+
+// SetEvictedCallback sets the evicted callback of the whole cache, called with the keys of the cache.
+func (n *namespaceOf[V]) SetEvictedCallback(evictedCallback EvictedCallbackOf[string, V]) {
+	n.parent.SetEvictedCallback(evictedCallback)
+}
+
+// RecentEvents returns the recent operations on the keys of the namespace, oldest first.
+func (n *namespaceOf[V]) RecentEvents() []EventOf[string] {
+	events := n.parent.RecentEvents()
+	if events == nil {
+		return nil
+	}
+	ns := events[:0]
+	for _, e := range events {
+		if k, ok := n.local(e.Key); ok {
+			e.Key = k
+			ns = append(ns, e)
+		}
+	}
+	return ns
+}
+
+// Diagnostics returns the diagnostics of the whole cache.
+func (n *namespaceOf[V]) Diagnostics(intervals int) Diagnostics {
+	return n.parent.Diagnostics(intervals)
+}
+
+// HotKeys returns the most read keys of the namespace, among those tracked for the whole cache.
+func (n *namespaceOf[V]) HotKeys(count int) []KeyStatOf[string] {
+	var stats []KeyStatOf[string]
+	for _, x := range n.parent.HotKeys(0) {
+		if k, ok := n.local(x.Key); ok {
+			x.Key = k
+			stats = append(stats, x)
+		}
+	}
+	if count > 0 && count < len(stats) {
+		stats = stats[:count]
+	}
+	return stats
+}
+
+// ShadowStats returns the simulated hit rates of the whole cache.
+func (n *namespaceOf[V]) ShadowStats() []ShadowStats {
+	return n.parent.ShadowStats()
+}
+
+// Close does nothing, the namespace does not own the cache.
+func (n *namespaceOf[V]) Close() error {
+	return nil
+}
+
+// CloseContext does nothing, the namespace does not own the cache.
+func (n *namespaceOf[V]) CloseContext(context.Context) error {
+	return nil
+}
@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// normalized a cache whose keys are normalized on every operation, see WithKeyNormalizer.
+// The methods not taking keys are those of the cache.
+type normalized struct {
+	Cache
+	normalize func(k string) string
+}
+
+func newNormalized(c Cache, normalize func(k string) string) *normalized {
+	return &normalized{Cache: c, normalize: normalize}
+}
+
+func (c *normalized) normalizeKeys(keys []string) []string {
+	nk := make([]string, len(keys))
+	for i, k := range keys {
+		nk[i] = c.normalize(k)
+	}
+	return nk
+}
+
+func (c *normalized) Set(k string, v interface{}, d time.Duration) {
+	c.Cache.Set(c.normalize(k), v, d)
+}
+
+func (c *normalized) SetE(k string, v interface{}, d time.Duration) error {
+	return c.Cache.SetE(c.normalize(k), v, d)
+}
+
+func (c *normalized) SetDefault(k string, v interface{}) {
+	c.Cache.SetDefault(c.normalize(k), v)
+}
+
+func (c *normalized) SetForever(k string, v interface{}) {
+	c.Cache.SetForever(c.normalize(k), v)
+}
+
+func (c *normalized) SetWithMeta(k string, v interface{}, d time.Duration, meta interface{}) {
+	c.Cache.SetWithMeta(c.normalize(k), v, d, meta)
+}
+
+func (c *normalized) SetWithCost(k string, v interface{}, d time.Duration, cost int64) {
+	c.Cache.SetWithCost(c.normalize(k), v, d, cost)
+}
+
+func (c *normalized) SetWithCallback(k string, v interface{}, d time.Duration, fn EvictedCallback) {
+	c.Cache.SetWithCallback(c.normalize(k), v, d, fn)
+}
+
+func (c *normalized) SetWithTags(k string, v interface{}, d time.Duration, tags ...string) {
+	c.Cache.SetWithTags(c.normalize(k), v, d, tags...)
+}
+
+func (c *normalized) SetBytes(k []byte, v interface{}, d time.Duration) {
+	c.Cache.Set(c.normalize(string(k)), v, d)
+}
+
+func (c *normalized) Get(k string) (interface{}, bool) {
+	return c.Cache.Get(c.normalize(k))
+}
+
+func (c *normalized) GetE(k string) (interface{}, error) {
+	return c.Cache.GetE(c.normalize(k))
+}
+
+func (c *normalized) GetBytes(k []byte) (interface{}, bool) {
+	return c.Cache.Get(c.normalize(string(k)))
+}
+
+func (c *normalized) GetWithExpiration(k string) (interface{}, time.Time, bool) {
+	return c.Cache.GetWithExpiration(c.normalize(k))
+}
+
+func (c *normalized) GetWithExpirationNano(k string) (interface{}, int64, bool) {
+	return c.Cache.GetWithExpirationNano(c.normalize(k))
+}
+
+func (c *normalized) GetWithTTL(k string) (interface{}, time.Duration, bool) {
+	return c.Cache.GetWithTTL(c.normalize(k))
+}
+
+func (c *normalized) GetWithMeta(k string) (interface{}, interface{}, bool) {
+	return c.Cache.GetWithMeta(c.normalize(k))
+}
+
+func (c *normalized) GetWithVersion(k string) (interface{}, uint64, bool) {
+	return c.Cache.GetWithVersion(c.normalize(k))
+}
+
+func (c *normalized) Peek(k string) (interface{}, bool) {
+	return c.Cache.Peek(c.normalize(k))
+}
+
+func (c *normalized) PeekWithExpiration(k string) (interface{}, time.Time, bool) {
+	return c.Cache.PeekWithExpiration(c.normalize(k))
+}
+
+// GetMultiple returns the items by the keys given, not normalized.
+func (c *normalized) GetMultiple(keys []string) map[string]interface{} {
+	found := c.Cache.GetMultiple(c.normalizeKeys(keys))
+	items := make(map[string]interface{}, len(found))
+	for _, k := range keys {
+		if v, ok := found[c.normalize(k)]; ok {
+			items[k] = v
+		}
+	}
+	return items
+}
+
+func (c *normalized) SetIfAbsent(k string, v interface{}, d time.Duration) bool {
+	return c.Cache.SetIfAbsent(c.normalize(k), v, d)
+}
+
+func (c *normalized) SetIfPresent(k string, v interface{}, d time.Duration) bool {
+	return c.Cache.SetIfPresent(c.normalize(k), v, d)
+}
+
+func (c *normalized) GetOrSet(k string, v interface{}, d time.Duration) (interface{}, bool) {
+	return c.Cache.GetOrSet(c.normalize(k), v, d)
+}
+
+func (c *normalized) GetAndSet(k string, v interface{}, d time.Duration) (interface{}, bool) {
+	return c.Cache.GetAndSet(c.normalize(k), v, d)
+}
+
+func (c *normalized) GetAndRefresh(k string, d time.Duration) (interface{}, bool) {
+	return c.Cache.GetAndRefresh(c.normalize(k), d)
+}
+
+func (c *normalized) RefreshOnly(k string, d time.Duration) bool {
+	return c.Cache.RefreshOnly(c.normalize(k), d)
+}
+
+func (c *normalized) Expire(k string, d time.Duration) bool {
+	return c.Cache.Expire(c.normalize(k), d)
+}
+
+func (c *normalized) Persist(k string) bool {
+	return c.Cache.Persist(c.normalize(k))
+}
+
+func (c *normalized) Touch(k string) bool {
+	return c.Cache.Touch(c.normalize(k))
+}
+
+func (c *normalized) GetOrCompute(k string, valueFn func() interface{}, d time.Duration) (interface{}, bool) {
+	return c.Cache.GetOrCompute(c.normalize(k), valueFn, d)
+}
+
+func (c *normalized) GetOrComputeUnlocked(k string, valueFn func() interface{}, d time.Duration) (interface{}, bool) {
+	return c.Cache.GetOrComputeUnlocked(c.normalize(k), valueFn, d)
+}
+
+func (c *normalized) Warmup(ctx context.Context, keys []string, loader Loader, parallelism int) error {
+	return warmup(ctx, keys, loader, parallelism, c.Set)
+}
+
+func (c *normalized) GetOrLoad(
+	k string,
+	loader func(k string) (interface{}, error),
+	d time.Duration,
+) (interface{}, bool, error) {
+	return c.Cache.GetOrLoad(c.normalize(k), loader, d)
+}
+
+func (c *normalized) Compute(
+	k string,
+	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, delete bool),
+	d time.Duration,
+) (interface{}, bool) {
+	return c.Cache.Compute(c.normalize(k), valueFn, d)
+}
+
+func (c *normalized) ComputeWithOp(
+	k string,
+	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, op ComputeOp),
+	d time.Duration,
+) (interface{}, bool) {
+	return c.Cache.ComputeWithOp(c.normalize(k), valueFn, d)
+}
+
+func (c *normalized) update(k string, f func(old interface{}, loaded bool) (interface{}, bool)) (interface{}, bool) {
+	return update(c.Cache, c.normalize(k), f)
+}
+
+func (c *normalized) CompareAndSwap(k string, old, new interface{}, d time.Duration) bool {
+	return c.Cache.CompareAndSwap(c.normalize(k), old, new, d)
+}
+
+func (c *normalized) CompareAndDelete(k string, old interface{}) bool {
+	return c.Cache.CompareAndDelete(c.normalize(k), old)
+}
+
+func (c *normalized) CompareAndSwapFunc(k string, old, new interface{}, equal func(current, old interface{}) bool, d time.Duration) bool {
+	return c.Cache.CompareAndSwapFunc(c.normalize(k), old, new, equal, d)
+}
+
+func (c *normalized) CompareAndDeleteFunc(k string, old interface{}, equal func(current, old interface{}) bool) bool {
+	return c.Cache.CompareAndDeleteFunc(c.normalize(k), old, equal)
+}
+
+func (c *normalized) SetIfVersion(k string, v interface{}, version uint64, d time.Duration) bool {
+	return c.Cache.SetIfVersion(c.normalize(k), v, version, d)
+}
+
+func (c *normalized) GetAndDelete(k string) (interface{}, bool) {
+	return c.Cache.GetAndDelete(c.normalize(k))
+}
+
+func (c *normalized) Delete(k string) {
+	c.Cache.Delete(c.normalize(k))
+}
+
+func (c *normalized) Update(fn func(tx Txn) error) error {
+	return c.Cache.Update(func(tx Txn) error {
+		return fn(keyedTxn{tx: tx, key: c.normalize})
+	})
+}
+
+func (c *normalized) DeleteMultiple(keys []string) {
+	c.Cache.DeleteMultiple(c.normalizeKeys(keys))
+}
+
+func (c *normalized) LoadItemsWithExpiration(items map[string]ItemWithExpiration, strategy ...LoadStrategy) {
+	nitems := make(map[string]ItemWithExpiration, len(items))
+	for k, x := range items {
+		nitems[c.normalize(k)] = x
+	}
+	c.Cache.LoadItemsWithExpiration(nitems, strategy...)
+}
+
+// ItemsWithPrefix returns the items in the cache whose keys start with the normalized prefix.
+func (c *normalized) ItemsWithPrefix(prefix string) map[string]interface{} {
+	return c.Cache.ItemsWithPrefix(c.normalize(prefix))
+}
+
+// Namespace returns a view of the cache whose keys are prefixed by the normalized prefix,
+// and normalized, see Cache.Namespace.
+func (c *normalized) Namespace(prefix string) Cache {
+	f, codec := SnapshotJSON, Codec(nil)
+	if w, ok := c.Cache.(*xsyncMapWrapper); ok {
+		f, codec = w.snapshotFormat, w.codec
+	}
+	return newNamespace(c, c.normalize(prefix), f, codec)
+}
@@ -0,0 +1,230 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// normalizedOf a cache whose keys are normalized on every operation, see WithKeyNormalizerOf.
+// The methods not taking keys are those of the cache.
+type normalizedOf[K comparable, V any] struct {
+	CacheOf[K, V]
+	normalize func(k K) K
+}
+
+func newNormalizedOf[K comparable, V any](c CacheOf[K, V], normalize func(k K) K) *normalizedOf[K, V] {
+	return &normalizedOf[K, V]{CacheOf: c, normalize: normalize}
+}
+
+func (c *normalizedOf[K, V]) normalizeKeys(keys []K) []K {
+	nk := make([]K, len(keys))
+	for i, k := range keys {
+		nk[i] = c.normalize(k)
+	}
+	return nk
+}
+
+func (c *normalizedOf[K, V]) Set(k K, v V, d time.Duration) {
+	c.CacheOf.Set(c.normalize(k), v, d)
+}
+
+func (c *normalizedOf[K, V]) SetE(k K, v V, d time.Duration) error {
+	return c.CacheOf.SetE(c.normalize(k), v, d)
+}
+
+func (c *normalizedOf[K, V]) SetDefault(k K, v V) {
+	c.CacheOf.SetDefault(c.normalize(k), v)
+}
+
+func (c *normalizedOf[K, V]) SetForever(k K, v V) {
+	c.CacheOf.SetForever(c.normalize(k), v)
+}
+
+func (c *normalizedOf[K, V]) SetWithMeta(k K, v V, d time.Duration, meta any) {
+	c.CacheOf.SetWithMeta(c.normalize(k), v, d, meta)
+}
+
+func (c *normalizedOf[K, V]) SetWithCost(k K, v V, d time.Duration, cost int64) {
+	c.CacheOf.SetWithCost(c.normalize(k), v, d, cost)
+}
+
+func (c *normalizedOf[K, V]) SetWithCallback(k K, v V, d time.Duration, fn EvictedCallbackOf[K, V]) {
+	c.CacheOf.SetWithCallback(c.normalize(k), v, d, fn)
+}
+
+func (c *normalizedOf[K, V]) SetWithTags(k K, v V, d time.Duration, tags ...string) {
+	c.CacheOf.SetWithTags(c.normalize(k), v, d, tags...)
+}
+
+func (c *normalizedOf[K, V]) Get(k K) (V, bool) {
+	return c.CacheOf.Get(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) GetE(k K) (V, error) {
+	return c.CacheOf.GetE(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) GetWithExpiration(k K) (V, time.Time, bool) {
+	return c.CacheOf.GetWithExpiration(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) GetWithExpirationNano(k K) (V, int64, bool) {
+	return c.CacheOf.GetWithExpirationNano(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) GetWithTTL(k K) (V, time.Duration, bool) {
+	return c.CacheOf.GetWithTTL(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) GetWithMeta(k K) (V, any, bool) {
+	return c.CacheOf.GetWithMeta(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) GetWithVersion(k K) (V, uint64, bool) {
+	return c.CacheOf.GetWithVersion(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) Peek(k K) (V, bool) {
+	return c.CacheOf.Peek(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) PeekWithExpiration(k K) (V, time.Time, bool) {
+	return c.CacheOf.PeekWithExpiration(c.normalize(k))
+}
+
+// GetMultiple returns the items by the keys given, not normalized.
+func (c *normalizedOf[K, V]) GetMultiple(keys []K) map[K]V {
+	found := c.CacheOf.GetMultiple(c.normalizeKeys(keys))
+	items := make(map[K]V, len(found))
+	for _, k := range keys {
+		if v, ok := found[c.normalize(k)]; ok {
+			items[k] = v
+		}
+	}
+	return items
+}
+
+func (c *normalizedOf[K, V]) SetIfAbsent(k K, v V, d time.Duration) bool {
+	return c.CacheOf.SetIfAbsent(c.normalize(k), v, d)
+}
+
+func (c *normalizedOf[K, V]) SetIfPresent(k K, v V, d time.Duration) bool {
+	return c.CacheOf.SetIfPresent(c.normalize(k), v, d)
+}
+
+func (c *normalizedOf[K, V]) GetOrSet(k K, v V, d time.Duration) (V, bool) {
+	return c.CacheOf.GetOrSet(c.normalize(k), v, d)
+}
+
+func (c *normalizedOf[K, V]) GetAndSet(k K, v V, d time.Duration) (V, bool) {
+	return c.CacheOf.GetAndSet(c.normalize(k), v, d)
+}
+
+func (c *normalizedOf[K, V]) GetAndRefresh(k K, d time.Duration) (V, bool) {
+	return c.CacheOf.GetAndRefresh(c.normalize(k), d)
+}
+
+func (c *normalizedOf[K, V]) RefreshOnly(k K, d time.Duration) bool {
+	return c.CacheOf.RefreshOnly(c.normalize(k), d)
+}
+
+func (c *normalizedOf[K, V]) Expire(k K, d time.Duration) bool {
+	return c.CacheOf.Expire(c.normalize(k), d)
+}
+
+func (c *normalizedOf[K, V]) Persist(k K) bool {
+	return c.CacheOf.Persist(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) Touch(k K) bool {
+	return c.CacheOf.Touch(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) GetOrCompute(k K, valueFn func() V, d time.Duration) (V, bool) {
+	return c.CacheOf.GetOrCompute(c.normalize(k), valueFn, d)
+}
+
+func (c *normalizedOf[K, V]) GetOrComputeUnlocked(k K, valueFn func() V, d time.Duration) (V, bool) {
+	return c.CacheOf.GetOrComputeUnlocked(c.normalize(k), valueFn, d)
+}
+
+func (c *normalizedOf[K, V]) Warmup(ctx context.Context, keys []K, loader LoaderOf[K, V], parallelism int) error {
+	return warmupOf(ctx, keys, loader, parallelism, c.Set)
+}
+
+func (c *normalizedOf[K, V]) GetOrLoad(
+	k K,
+	loader func(k K) (V, error),
+	d time.Duration,
+) (V, bool, error) {
+	return c.CacheOf.GetOrLoad(c.normalize(k), loader, d)
+}
+
+func (c *normalizedOf[K, V]) Compute(
+	k K,
+	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
+	d time.Duration,
+) (V, bool) {
+	return c.CacheOf.Compute(c.normalize(k), valueFn, d)
+}
+
+func (c *normalizedOf[K, V]) ComputeWithOp(
+	k K,
+	valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
+	d time.Duration,
+) (V, bool) {
+	return c.CacheOf.ComputeWithOp(c.normalize(k), valueFn, d)
+}
+
+func (c *normalizedOf[K, V]) update(k K, f func(old V, loaded bool) (V, bool)) (V, bool) {
+	return updateOf[K, V](c.CacheOf, c.normalize(k), f)
+}
+
+func (c *normalizedOf[K, V]) CompareAndSwap(k K, old, new V, d time.Duration) bool {
+	return c.CacheOf.CompareAndSwap(c.normalize(k), old, new, d)
+}
+
+func (c *normalizedOf[K, V]) CompareAndDelete(k K, old V) bool {
+	return c.CacheOf.CompareAndDelete(c.normalize(k), old)
+}
+
+func (c *normalizedOf[K, V]) CompareAndSwapFunc(k K, old, new V, equal func(current, old V) bool, d time.Duration) bool {
+	return c.CacheOf.CompareAndSwapFunc(c.normalize(k), old, new, equal, d)
+}
+
+func (c *normalizedOf[K, V]) CompareAndDeleteFunc(k K, old V, equal func(current, old V) bool) bool {
+	return c.CacheOf.CompareAndDeleteFunc(c.normalize(k), old, equal)
+}
+
+func (c *normalizedOf[K, V]) SetIfVersion(k K, v V, version uint64, d time.Duration) bool {
+	return c.CacheOf.SetIfVersion(c.normalize(k), v, version, d)
+}
+
+func (c *normalizedOf[K, V]) GetAndDelete(k K) (V, bool) {
+	return c.CacheOf.GetAndDelete(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) Delete(k K) {
+	c.CacheOf.Delete(c.normalize(k))
+}
+
+func (c *normalizedOf[K, V]) Update(fn func(tx TxnOf[K, V]) error) error {
+	return c.CacheOf.Update(func(tx TxnOf[K, V]) error {
+		return fn(keyedTxnOf[K, V]{tx: tx, key: c.normalize})
+	})
+}
+
+func (c *normalizedOf[K, V]) DeleteMultiple(keys []K) {
+	c.CacheOf.DeleteMultiple(c.normalizeKeys(keys))
+}
+
+func (c *normalizedOf[K, V]) LoadItemsWithExpiration(items map[K]ItemWithExpirationOf[V], strategy ...LoadStrategy) {
+	nitems := make(map[K]ItemWithExpirationOf[V], len(items))
+	for k, x := range items {
+		nitems[c.normalize(k)] = x
+	}
+	c.CacheOf.LoadItemsWithExpiration(nitems, strategy...)
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"errors"
+)
+
+// ErrNotNumber is returned by Increment and Decrement when the value of the key
+// is not a number of the type of the delta.
+var ErrNotNumber = errors.New("cache: value is not a number of the type of the delta")
+
+// updater is implemented by the caches that can replace a value keeping its expiration time.
+type updater interface {
+	// update sets the value of the key k to the value returned by f, keeping its expiration time,
+	// or stores it with the default expiration if the key is absent.
+	// The item is left unchanged if f returns false.
+	update(k string, f func(old interface{}, loaded bool) (interface{}, bool)) (interface{}, bool)
+}
+
+// update updates the value of the key k in c, see updater.
+// The expiration time is reset to the default one by the other implementations of Cache.
+func update(c Cache, k string, f func(old interface{}, loaded bool) (interface{}, bool)) (interface{}, bool) {
+	if u, ok := c.(updater); ok {
+		return u.update(k, f)
+	}
+	var ok bool
+	v, _ := c.ComputeWithOp(
+		k,
+		func(old interface{}, loaded bool) (interface{}, ComputeOp) {
+			var v interface{}
+			if v, ok = f(old, loaded); !ok {
+				return old, CancelOp
+			}
+			return v, UpdateOp
+		},
+		DefaultExpiration,
+	)
+	return v, ok
+}
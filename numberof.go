@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+// Number is a constraint for the value types of the counters, see Increment and IncrementOf.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Increment atomically adds delta to the value of the key k and returns the new value.
+// The key keeps its expiration time, or is stored with delta and the default expiration if absent.
+// Returns ErrNotNumber, leaving the value unchanged, if the value is not of the type V.
+func Increment[V Number](c Cache, k string, delta V) (V, error) {
+	v, ok := update(c, k, func(old interface{}, loaded bool) (interface{}, bool) {
+		if !loaded {
+			return delta, true
+		}
+		n, ok := old.(V)
+		return n + delta, ok
+	})
+	if !ok {
+		return 0, ErrNotNumber
+	}
+	return v.(V), nil
+}
+
+// Decrement atomically subtracts delta from the value of the key k, see Increment.
+func Decrement[V Number](c Cache, k string, delta V) (V, error) {
+	return Increment(c, k, -delta)
+}
+
+// IncrementOf atomically adds delta to the value of the key k and returns the new value.
+// The key keeps its expiration time, or is stored with delta and the default expiration if absent.
+func IncrementOf[K comparable, V Number](c CacheOf[K, V], k K, delta V) V {
+	v, _ := updateOf(c, k, func(old V, loaded bool) (V, bool) {
+		return old + delta, true
+	})
+	return v
+}
+
+// DecrementOf atomically subtracts delta from the value of the key k, see IncrementOf.
+func DecrementOf[K comparable, V Number](c CacheOf[K, V], k K, delta V) V {
+	return IncrementOf(c, k, -delta)
+}
+
+// updaterOf is implemented by the caches that can replace a value keeping its expiration time.
+type updaterOf[K comparable, V any] interface {
+	// update sets the value of the key k to the value returned by f, keeping its expiration time,
+	// or stores it with the default expiration if the key is absent.
+	// The item is left unchanged if f returns false.
+	update(k K, f func(old V, loaded bool) (V, bool)) (V, bool)
+}
+
+// updateOf updates the value of the key k in c, see updaterOf.
+// The expiration time is reset to the default one by the other implementations of CacheOf.
+func updateOf[K comparable, V any](c CacheOf[K, V], k K, f func(old V, loaded bool) (V, bool)) (V, bool) {
+	if u, ok := c.(updaterOf[K, V]); ok {
+		return u.update(k, f)
+	}
+	var ok bool
+	v, _ := c.ComputeWithOp(
+		k,
+		func(old V, loaded bool) (V, ComputeOp) {
+			var v V
+			if v, ok = f(old, loaded); !ok {
+				return old, CancelOp
+			}
+			return v, UpdateOp
+		},
+		DefaultExpiration,
+	)
+	return v, ok
+}
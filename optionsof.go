@@ -32,3 +32,436 @@ func WithMinCapacityOf[K comparable, V any](sizeHint int) OptionOf[K, V] {
 		config.MinCapacity = sizeHint
 	}
 }
+
+// WithEventHistoryOf keeps the last n operations (op, key, result, time), see CacheOf.RecentEvents.
+func WithEventHistoryOf[K comparable, V any](n int) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.EventHistory = n
+	}
+}
+
+// WithShadowOf simulates each eviction policy and capacity against the access trace of the cache,
+// e.g. Shadow{Policy: LRU, Size: 100_000}, see CacheOf.ShadowStats.
+// It helps to size caches from real traffic, at the cost of a mutex and a key hash per operation.
+func WithShadowOf[K comparable, V any](shadows ...Shadow) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.Shadows = shadows
+	}
+}
+
+// WithPersistencePathOf restores the cache from the snapshot file at path when created,
+// if present and valid, and saves a snapshot to it on Close, for warm restarts.
+func WithPersistencePathOf[K comparable, V any](path string) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.PersistencePath = path
+	}
+}
+
+// WithPersistenceErrorHandlerOf calls handler with the error of the snapshot of WithPersistencePathOf
+// which could not be restored, e.g. a corrupt file, not with a missing file.
+func WithPersistenceErrorHandlerOf[K comparable, V any](handler func(err error)) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.PersistenceErrorHandler = handler
+	}
+}
+
+// WithMaxEntriesOf bounds the cache to n items, the least recently used items are evicted
+// (with the evicted callback) when it is exceeded, see WithEvictionPolicyOf. 0 means unbounded, the default.
+// Every write then updates the recency order under a mutex, the reads are buffered by P
+// and applied once a buffer is full, or dropped if the mutex is busy, so the order is approximate.
+// The bound is enforced once each write is recorded, after its item is stored: the cache may hold
+// one more item per write in progress, and is back within the bound once they return.
+func WithMaxEntriesOf[K comparable, V any](n int) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.MaxEntries = n
+	}
+}
+
+// WithMaxCostOf bounds the total cost of the items in the cache, e.g. their size in bytes,
+// items are evicted (with the evicted callback) when it is exceeded, see WithEvictionPolicyOf.
+// The cost of an item is set by SetWithCost, items stored by other methods cost 1.
+// 0 means unbounded, the default. It can be combined with WithMaxEntriesOf.
+func WithMaxCostOf[K comparable, V any](maxCost int64) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.MaxCost = maxCost
+	}
+}
+
+// WithEvictionPolicyOf decides which items are evicted first when the maximum number of items
+// is exceeded, e.g. LFU keeps the frequently read items under capacity pressure.
+// The default is LRU, see WithMaxEntriesOf.
+func WithEvictionPolicyOf[K comparable, V any](policy EvictionPolicy) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.EvictionPolicy = policy
+	}
+}
+
+// WithSnapshotOf writes a snapshot of the cache (see SaveTo) to a writer opened by newWriter,
+// every interval and on Close, so a restarted process can warm-start from the last snapshot.
+// Errors of the periodic snapshots are ignored, the snapshot is retried on the next tick,
+// Close returns the error of its final snapshot. An interval of 0 only writes it on Close.
+func WithSnapshotOf[K comparable, V any](interval time.Duration, newWriter SnapshotWriterFactory) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.SnapshotInterval = interval
+		config.SnapshotWriter = newWriter
+	}
+}
+
+// WithSnapshotFormatOf sets the encoding of the items in the snapshots written by SaveTo,
+// e.g. SnapshotGob to persist and restore large caches faster. LoadFrom reads any format.
+func WithSnapshotFormatOf[K comparable, V any](f SnapshotFormat) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.SnapshotFormat = f
+	}
+}
+
+// WithNoFinalizerOf does not stop the cleanup goroutine when the cache is garbage collected.
+// The finalizer keeps the cache alive for an extra GC cycle, which is unwelcome with object pools
+// and confuses heap profiles. Close must be called explicitly, or the cleanup goroutine leaks.
+func WithNoFinalizerOf[K comparable, V any]() OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.NoFinalizer = true
+	}
+}
+
+// WithProfilerOf reports the timing of each get, set, delete, compute and cleanup operation to p,
+// e.g. to feed a continuous profiling system. Without a profiler, the cost is a nil check.
+func WithProfilerOf[K comparable, V any](p Profiler) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.Profiler = p
+	}
+}
+
+// WithDistributedLockerOf deduplicates the loads of GetOrLoad across processes:
+// only the replica holding the lease of a missing key runs the loader,
+// the others serve the expired value if still present, or wait up to wait for the lease.
+// A lease or wait of 0 uses DefaultLockLease or DefaultLockWait, a negative wait does not wait.
+// The loads are only deduplicated while the lease is held: once the wait is over, the replica
+// runs the loader anyway, and a replica acquiring the lease released by another one runs it again,
+// unless the loader reads a store filled by the first load, e.g. a shared cache.
+// The leases are named after the keys formatted with fmt.Sprint, prefixed by the name of the cache,
+// see WithLockPrefixOf, the cache panics if it has none.
+func WithDistributedLockerOf[K comparable, V any](locker DistributedLocker, lease, wait time.Duration) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.DistributedLocker = locker
+		config.LockLease = lease
+		config.LockWait = wait
+	}
+}
+
+// WithLockPrefixOf prefixes the names of the leases of the distributed locker by prefix, e.g. "users:",
+// so that the caches or the services sharing a locker do not block each other on their keys,
+// the registry name of the cache by default, see WithDistributedLockerOf and WithRegistryOf.
+func WithLockPrefixOf[K comparable, V any](prefix string) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.LockPrefix = prefix
+	}
+}
+
+// WithSlidingExpirationOf extends the lifetime of an item by the duration it was stored with
+// on each Get, GetWithExpiration, GetWithTTL and GetWithMeta, so items expire after being idle
+// for their duration. Items loaded from snapshots keep a fixed expiration.
+// Each read of an expiring item then writes it, which costs read throughput.
+func WithSlidingExpirationOf[K comparable, V any]() OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.SlidingExpiration = true
+	}
+}
+
+// WithEvictedCallbackWithReasonOf set the callback executed when a key-value pair leaves the cache,
+// along with why it left: expired, deleted, replaced, evicted for capacity or cleared.
+// It is called besides the evicted callback, which is not called when items are replaced or cleared.
+func WithEvictedCallbackWithReasonOf[K comparable, V any](ec EvictedCallbackWithReasonOf[K, V]) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.EvictedCallbackWithReason = ec
+	}
+}
+
+// WithAsyncCallbacksOf runs the evicted callbacks and the item callbacks on workers goroutines
+// owned by the cache, instead of inline in DeleteExpired, GetAndDelete or Set, so they may block.
+// Up to queueSize callbacks wait for a worker, then the callers wait for a free worker.
+// The callbacks of an item may run concurrently and in any order.
+// Close waits for the queued callbacks, the callbacks of a closed cache run inline.
+func WithAsyncCallbacksOf[K comparable, V any](workers, queueSize int) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.CallbackWorkers = workers
+		config.CallbackQueueSize = queueSize
+	}
+}
+
+// WithNoCleanupLoopOf does not start the cleanup goroutine, e.g. in serverless or wasm environments
+// where a goroutine per cache is unwelcome, whatever the cleanup interval.
+// Expired items are never returned: they are deleted when read, and Count deletes the expired
+// items before counting, while Items and Range skip them.
+func WithNoCleanupLoopOf[K comparable, V any]() OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.NoCleanupLoop = true
+	}
+}
+
+// WithOverflowOf spills the items evicted for capacity (see WithMaxEntries) to store, e.g. a DiskStoreOf,
+// for a larger effective cache: they are reloaded on a miss of Get and its variants, for their
+// remaining lifetime. The other methods, the iterations, Count and the snapshots ignore them.
+// The spilled values are deleted from store once the key is written, deleted, reloaded
+// or expired (by DeleteExpired), by Clear and by Close. They are written to store in the background,
+// and reloaded from memory until then: the values evicted while too many are waiting,
+// and those store fails to write, are dropped.
+func WithOverflowOf[K comparable, V any](store BackendOf[K, V]) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.Overflow = store
+	}
+}
+
+// WithWriteThroughOf calls fn with each value written by the callers, before the write returns, to keep
+// a persistent store up to date: the values of Set and its variants, SetIfAbsent, SetIfPresent,
+// SetIfVersion, CompareAndSwap, GetOrSet, GetAndSet, Increment and Decrement, and the values computed
+// by Compute, GetOrCompute and GetOrComputeUnlocked. The values read from a store are not written back:
+// those loaded by GetOrLoad, Warmup and WithLoaderOf, and the snapshots restored.
+// fn cannot delete, so the deletions are not propagated: Delete and its variants, GetAndDelete,
+// CompareAndDelete, DeleteFunc, Compute with DeleteOp, the invalidations, expirations and evictions,
+// and Clear leave the persistent store unchanged.
+// SetE returns the errors of fn, without storing the value, the other writes ignore them:
+// fn must handle them itself.
+func WithWriteThroughOf[K comparable, V any](fn WriteFuncOf[K, V]) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.WriteThrough = fn
+	}
+}
+
+// WithWriteBehindOf calls fn in a background goroutine every flushInterval, with the last value
+// written to each key since the previous flush, like WithWriteThroughOf, so bursts of writes to
+// a key are written once. The values fn fails to write are retried at the next flush, unless
+// the key has been written again since, and the pending values are flushed by Close.
+// DefaultWriteBehindInterval is used if flushInterval is not positive.
+func WithWriteBehindOf[K comparable, V any](fn WriteFuncOf[K, V], flushInterval time.Duration) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.WriteBehind = fn
+		config.WriteBehindInterval = flushInterval
+	}
+}
+
+// WithLoaderOf makes the cache read-through: a miss of Get and its variants calls loader,
+// once for concurrent callers with the same key, stores the value for the returned duration,
+// see Set, and returns it. If loader returns an error, nothing is stored and the key is missing.
+func WithLoaderOf[K comparable, V any](loader LoaderOf[K, V]) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.Loader = loader
+	}
+}
+
+// WithStaleWhileRevalidateOf keeps serving the items for staleTTL after they expire: Get and its
+// variants return the stale value at once, e.g. with a past expiration time for GetWithExpiration,
+// while a background goroutine reloads the key with the loader of WithLoaderOf, once for concurrent
+// reads. The stale items are deleted once staleTTL has elapsed, or if the loader fails until then.
+// It has no effect without WithLoaderOf, and the other methods consider the stale items expired.
+func WithStaleWhileRevalidateOf[K comparable, V any](staleTTL time.Duration) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.StaleWhileRevalidate = staleTTL
+	}
+}
+
+// WithRefreshAheadOf reloads the items read by Get and its variants in the last threshold fraction
+// of their lifetime, e.g. 0.2 for the last 20%, with the loader of WithLoaderOf in the background,
+// once for concurrent reads, so that popular keys are refreshed before they expire.
+// threshold must be between 0 and 1 exclusive, and it has no effect without WithLoaderOf.
+// Only the items written with an expiration time by Set and its variants, GetOrSet, GetAndSet,
+// GetOrCompute, Compute, GetOrLoad and the loader are refreshed.
+func WithRefreshAheadOf[K comparable, V any](threshold float64) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.RefreshAhead = threshold
+	}
+}
+
+// WithTTLJitterOf randomizes the lifetime of each item written with an expiration time by up to
+// ±fraction of it, e.g. 0.1 for ±10%, including the remaining lifetime of the items loaded by
+// LoadItemsWithExpiration and from snapshots, so that items written together do not all expire
+// at once and hit the origin simultaneously. fraction must be between 0 and 1 exclusive.
+func WithTTLJitterOf[K comparable, V any](fraction float64) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.TTLJitter = fraction
+	}
+}
+
+// WithRegistryOf registers the cache in r under name until it is closed, in DefaultRegistry if r is nil.
+// A cache registered later under the same name replaces it.
+func WithRegistryOf[K comparable, V any](r *Registry, name string) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		if r == nil {
+			r = DefaultRegistry
+		}
+		config.Registry = r
+		config.RegistryName = name
+	}
+}
+
+// WithCleanupOnCloseOf deletes the expired items on Close, before the snapshot is saved,
+// so that their evicted callbacks run, e.g. to release the resources they hold.
+func WithCleanupOnCloseOf[K comparable, V any]() OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.CleanupOnClose = true
+	}
+}
+
+// WithClosedModeOf sets what the operations do once the cache is closed, e.g. ClosedPanic
+// to catch the use of a closed cache, see ClosedMode.
+// The mode applies once Close has returned, after the last snapshot is saved.
+func WithClosedModeOf[K comparable, V any](mode ClosedMode) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.ClosedMode = mode
+	}
+}
+
+// WithClockOf sets the time source of the expiration and of the background tasks,
+// e.g. a FakeClock to test the expiration without sleeping, or CoarseClock for a cache mostly read.
+// The clock is only read for the items which expire, the others are read and written without it.
+func WithClockOf[K comparable, V any](clock Clock) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.Clock = clock
+	}
+}
+
+// WithMemoryLimitOf evicts a part of the items, in the order of the eviction policy (see WithEvictionPolicyOf),
+// at each check while the memory returned by sampler is over bytes, e.g. the limit of a container
+// minus a margin, to shed the cache before the process runs out of memory.
+// The memory is checked every DefaultMemoryCheckInterval, with HeapSampler if sampler is nil.
+// The recency order is then updated like with WithMaxEntriesOf.
+func WithMemoryLimitOf[K comparable, V any](bytes uint64, sampler MemorySampler) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.MemoryLimit = bytes
+		config.MemorySampler = sampler
+	}
+}
+
+// WithCodecOf sets the codec serializing the items of the snapshots written by SaveTo,
+// and so of the persistence, e.g. to share one Codec with a tiered backend and a transport.
+// JSONCodec and GobCodec write the SnapshotJSON and SnapshotGob formats, readable by any cache,
+// another codec writes the SnapshotCodec format, only readable by a cache with the same codec.
+// It overrides WithSnapshotFormatOf.
+func WithCodecOf[K comparable, V any](codec Codec) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.Codec = codec
+	}
+}
+
+// WithConsistentSnapshotsOf makes ItemsAtomic and SaveTo, and so the persistence, copy the items
+// as of a single point in time, e.g. for the backups, instead of a view interleaved with the writes.
+// The writes then share a read-write lock, and wait while the items are copied.
+// The callbacks run inside a write, e.g. the valueFn of Compute, must not call ItemsAtomic or SaveTo.
+func WithConsistentSnapshotsOf[K comparable, V any]() OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.ConsistentSnapshots = true
+	}
+}
+
+// WithHotKeysOf tracks the k most read keys, reported by HotKeys, e.g. to find the skewed
+// access patterns that warrant a dedicated handling or a longer expiration.
+// Every read then updates a count-min sketch under a mutex.
+func WithHotKeysOf[K comparable, V any](k int) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.HotKeys = k
+	}
+}
+
+// WithAdmissionPolicyOf[K decides whether a new key is admitted when the maximum number of items
+// or the maximum cost is exceeded. With TinyLFU, a new key only evicts an item if it has been
+// accessed more often, e.g. read and missed before being set, so a scan of keys read once
+// does not flush the hot items. The rejected key is evicted right away, with the evicted callback.
+func WithAdmissionPolicyOf[K comparable, V any](policy AdmissionPolicy) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.AdmissionPolicy = policy
+	}
+}
+
+// WithHasherOf hashes the keys with hasher instead of the built-in hash function,
+// e.g. for the keys whose distribution defeats it, or a cheaper hash of the keys of a known shape.
+// The hash must mix the seed, which differs between the tables of the cache and between the shards
+// of a sharded cache, and spread the keys over the low bits, which pick the buckets.
+func WithHasherOf[K comparable, V any](hasher func(k K, seed uint64) uint64) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.Hasher = hasher
+	}
+}
+
+// WithKeyNormalizerOf normalizes the keys given to every operation, e.g. strings.ToLower
+// for the HTTP header or host names, so the keys differing only by case share an item.
+// The keys reported by the cache, e.g. by Range or to the callbacks, are the normalized ones,
+// except by GetMultiple, and the keys of the snapshots loaded are not normalized.
+// normalize must be idempotent, and is called again by the operations calling another one.
+func WithKeyNormalizerOf[K comparable, V any](normalize func(k K) K) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.KeyNormalizer = normalize
+	}
+}
+
+// WithPanicHandlerOf recovers the panics of the functions given to the cache, and calls handler
+// with their values. The panics of the evicted callbacks and of the loaders run in the background
+// are recovered even without a handler, so that they do not kill the cleanup goroutine or the process.
+// The panics of the functions computing a value, e.g. of GetOrCompute, Compute or DeleteFunc,
+// leave the item unchanged, and are raised again in the calling goroutine without a handler.
+func WithPanicHandlerOf[K comparable, V any](handler PanicHandler) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.PanicHandler = handler
+	}
+}
+
+// WithValueCopierOf copies with copier the values held by the cache which the reads return,
+// e.g. a deep copy of the slices, maps or pointers, so that the callers cannot mutate the cached
+// values by accident: Get and its variants, GetE, GetMultiple, GetOrSet, GetAndRefresh, GetOrCompute,
+// GetOrComputeUnlocked, GetOrLoad, Compute, Range, RangeCursor, ScanItems and the Items methods.
+// The values which left the cache, returned by GetAndSet and GetAndDelete, are not copied,
+// nor the values passed to the functions of Compute, ItemsWhere and CountWhere.
+func WithValueCopierOf[K comparable, V any](copier func(v V) V) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.ValueCopier = copier
+	}
+}
+
+// WithWriteCoalescingOf buffers the values written to a key for window from its first write since it
+// was last flushed to the WithWriteBehindOf function, so that the rapid successive writes to a key
+// are written once even across the flushes. The key is written at the first flush after window,
+// with its last value, and the pending values are flushed by Close regardless of window.
+func WithWriteCoalescingOf[K comparable, V any](window time.Duration) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.WriteCoalesceWindow = window
+	}
+}
+
+// WithCleanupHookOf calls hook after each pass deleting the expired items, by the cleanup loop,
+// DeleteExpired, DeleteExpiredN or DeleteExpiredFor, with the number of keys visited and of items
+// deleted, and the time taken, e.g. to alert on slow cleanups. hook runs synchronously at the end
+// of the pass, it must be fast.
+func WithCleanupHookOf[K comparable, V any](hook func(report CleanupReport)) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.CleanupHook = hook
+	}
+}
+
+// WithValuePoolOf puts the values evicted for the capacity, expired, overwritten by Set and its
+// variants, loaded over, or cleared, into pool, e.g. a *sync.Pool of buffers, after their
+// evicted callbacks, to reuse them in allocation-heavy workloads. The values are only pooled
+// where no caller may hold them: not when deleted, returned by GetAndSet, passed to Compute
+// or CompareAndSwap, stored again, or spilled to the overflow store. The values read must
+// not be used once they may have left the cache, e.g. copy them with WithValueCopierOf.
+func WithValuePoolOf[K comparable, V any](pool ValuePool) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.ValuePool = pool
+	}
+}
+
+// WithGrowOnlyOf keeps the hash table of the items from shrinking when the keys are deleted
+// or expire, except by Clear, for the caches whose cardinality churns in bursts, e.g.
+// millions of keys a day, so that the table is not resized down and up again.
+func WithGrowOnlyOf[K comparable, V any]() OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.GrowOnly = true
+	}
+}
+
+// WithShrinkThresholdOf shrinks the hash table of the items by half once its occupation falls
+// to fraction of its capacity, 1/128 by default, see WithMapShrinkThreshold.
+// Values outside (0, 0.375) are ignored.
+func WithShrinkThresholdOf[K comparable, V any](fraction float64) OptionOf[K, V] {
+	return func(config *ConfigOf[K, V]) {
+		config.ShrinkThreshold = fraction
+	}
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"errors"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// ErrDiskStoreFull returned by the Set of a disk store whose files would exceed its maximum size,
+// see NewDiskStore.
+var ErrDiskStoreFull = errors.New("cache: disk store full")
+
+// diskDir the files of a disk store, one per hash of the keys, holding the values of the keys
+// of the hash. A positive maxSize bounds the total size of the files.
+type diskDir struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex // serializes the updates
+	size    int64      // the total size of the files
+}
+
+func newDiskDir(dir string, maxSize int64) (*diskDir, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(dir, "cache-overflow-*")
+	if err != nil {
+		return nil, err
+	}
+	return &diskDir{dir: dir, maxSize: maxSize}, nil
+}
+
+func (d *diskDir) path(name string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return filepath.Join(d.dir, strconv.FormatUint(h.Sum64(), 16))
+}
+
+// read returns the content of the file of name, nil if it is missing.
+func (d *diskDir) read(name string) ([]byte, error) {
+	return readDiskFile(d.path(name))
+}
+
+// update replaces the content of the file of name by the one f returns from its current content,
+// nil if missing, and removes the file if f returns no content. It returns ErrDiskStoreFull,
+// leaving the file unchanged, if the files would grow over the maximum size.
+func (d *diskDir) update(name string, f func(data []byte) ([]byte, error)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := d.path(name)
+	old, err := readDiskFile(path)
+	if err != nil {
+		return err
+	}
+	data, err := f(old)
+	if err != nil {
+		return err
+	}
+	size := d.size - int64(len(old)) + int64(len(data))
+	if d.maxSize > 0 && len(data) > len(old) && size > d.maxSize {
+		return ErrDiskStoreFull
+	}
+	if len(data) == 0 {
+		err = os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = d.write(path, data)
+	}
+	if err == nil {
+		d.size = size
+	}
+	return err
+}
+
+// write replaces the file at path with data, through a temporary file so readers
+// never see a partial file. The file is not synced, the store does not survive a crash.
+func (d *diskDir) write(path string, data []byte) error {
+	f, err := os.CreateTemp(d.dir, ".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (d *diskDir) close() error {
+	return os.RemoveAll(d.dir)
+}
+
+// readDiskFile returns the content of the file at path, nil if it is missing.
+func readDiskFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+var _ Backend = (*DiskStore)(nil)
+
+// DiskStore a Backend keeping the values in files in a temporary directory, e.g. for WithOverflow,
+// see DiskStoreOf.
+type DiskStore struct {
+	*DiskStoreOf[string, interface{}]
+}
+
+// NewDiskStore creates a store in a new temporary directory in dir, removed by Close.
+// A positive maxSize bounds the total size of the files in bytes, see DiskStoreOf.
+func NewDiskStore(dir string, format SnapshotFormat, maxSize int64) (*DiskStore, error) {
+	s, err := NewDiskStoreOf[string, interface{}](dir, format, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskStore{s}, nil
+}
@@ -0,0 +1,390 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// overflowQueueSize the maximum number of spilled values waiting to be written to the store,
+// the values evicted beyond are dropped.
+const overflowQueueSize = 1024
+
+// overflowOf spills the items evicted for capacity to a store, and reloads them on a miss,
+// see WithOverflowOf. A nil overflowOf does nothing.
+// The store is written by a background goroutine, in the order of the spills and the deletions,
+// the values waiting to be written are reloaded from memory.
+type overflowOf[K comparable, V any] struct {
+	store   BackendOf[K, V]
+	keys    sync.Map // the spilled keys, *spilledOf[V], only changed under mu
+	mu      sync.Mutex
+	ops     []overflowOpOf[K, V] // the writes and the deletions waiting for the store
+	queued  int                  // the writes in ops
+	closed  bool
+	drainMu sync.Mutex // serializes the drains, so the ops reach the store in order
+	wake    chan struct{}
+}
+
+// spilledOf a spilled value, and whether it is queued, written to the store, or taken since.
+type spilledOf[V any] struct {
+	v     V
+	e     int64
+	state uint32
+}
+
+const (
+	spillQueued uint32 = iota
+	spillWritten
+	spillTaken
+)
+
+// overflowOpOf a write of the spilled value s of the key k to the store, a deletion if s is nil.
+type overflowOpOf[K comparable, V any] struct {
+	k K
+	s *spilledOf[V]
+}
+
+// newOverflowOf returns the overflow to store, whose writes run in a goroutine added to wg until stop.
+func newOverflowOf[K comparable, V any](store BackendOf[K, V], wg *sync.WaitGroup, stop <-chan struct{}) *overflowOf[K, V] {
+	if store == nil {
+		return nil
+	}
+	o := &overflowOf[K, V]{store: store, wake: make(chan struct{}, 1)}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-o.wake:
+				o.drain()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return o
+}
+
+// spill queues the value v of the key k expiring at e for the store, unless it has expired,
+// or too many values are queued.
+func (o *overflowOf[K, V]) spill(k K, v V, e int64) {
+	if o == nil || e > 0 && e <= time.Now().UnixNano() {
+		return
+	}
+	o.mu.Lock()
+	if o.closed || o.queued >= overflowQueueSize {
+		o.mu.Unlock()
+		return
+	}
+	if old, ok := o.keys.Load(k); ok {
+		// overwritten in the store by the new value
+		atomic.StoreUint32(&old.(*spilledOf[V]).state, spillTaken)
+	}
+	s := &spilledOf[V]{v: v, e: e}
+	o.keys.Store(k, s)
+	o.ops = append(o.ops, overflowOpOf[K, V]{k: k, s: s})
+	o.queued++
+	o.mu.Unlock()
+	o.notify()
+}
+
+// take removes the spilled value of the key k, if it is still s when s is not nil,
+// and returns it and the state it was in. The caller holds mu.
+func (o *overflowOf[K, V]) take(k K, s *spilledOf[V]) (*spilledOf[V], uint32, bool) {
+	x, ok := o.keys.Load(k)
+	if !ok || s != nil && x.(*spilledOf[V]) != s {
+		return nil, 0, false
+	}
+	o.keys.Delete(k)
+	s = x.(*spilledOf[V])
+	return s, atomic.SwapUint32(&s.state, spillTaken), true
+}
+
+// reload takes the value of the key k and its remaining time to live, from memory
+// if it is not written to the store yet.
+func (o *overflowOf[K, V]) reload(k K) (V, time.Duration, bool) {
+	var zeroedV V
+	if o == nil {
+		return zeroedV, 0, false
+	}
+	if _, ok := o.keys.Load(k); !ok {
+		return zeroedV, 0, false
+	}
+	o.mu.Lock()
+	s, state, ok := o.take(k, nil)
+	o.mu.Unlock()
+	if !ok {
+		return zeroedV, 0, false
+	}
+	switch state {
+	case spillQueued:
+		// the write is skipped, or its file deleted
+		ttl := NoExpiration
+		if s.e > 0 {
+			if ttl = time.Until(time.Unix(0, s.e)); ttl <= 0 {
+				return zeroedV, 0, false
+			}
+		}
+		return s.v, ttl, true
+	case spillWritten:
+		v, ttl, ok, err := o.store.Get(k)
+		o.mu.Lock()
+		if _, spilled := o.keys.Load(k); !spilled {
+			// otherwise the file is overwritten by the new value
+			o.ops = append(o.ops, overflowOpOf[K, V]{k: k})
+		}
+		o.mu.Unlock()
+		o.notify()
+		if err != nil || !ok {
+			return zeroedV, 0, false
+		}
+		if ttl <= 0 {
+			ttl = NoExpiration
+		}
+		return v, ttl, true
+	default:
+		return zeroedV, 0, false
+	}
+}
+
+// forget deletes the spilled value of the key k, which has been written or deleted since.
+func (o *overflowOf[K, V]) forget(k K) {
+	if o == nil {
+		return
+	}
+	if _, ok := o.keys.Load(k); !ok {
+		return
+	}
+	o.mu.Lock()
+	o.forgetLocked(k, nil)
+	o.mu.Unlock()
+	o.notify()
+}
+
+// forgetLocked deletes the spilled value of the key k, if it is still s when s is not nil.
+// The caller holds mu.
+func (o *overflowOf[K, V]) forgetLocked(k K, s *spilledOf[V]) {
+	if _, state, ok := o.take(k, s); ok && state == spillWritten {
+		o.ops = append(o.ops, overflowOpOf[K, V]{k: k})
+	}
+}
+
+// sweep deletes the spilled values expired at now, in Unix nanoseconds.
+func (o *overflowOf[K, V]) sweep(now int64) {
+	if o == nil {
+		return
+	}
+	o.keys.Range(func(k, x any) bool {
+		if s := x.(*spilledOf[V]); s.e > 0 && s.e <= now {
+			o.mu.Lock()
+			o.forgetLocked(k.(K), s)
+			o.mu.Unlock()
+		}
+		return true
+	})
+	o.notify()
+}
+
+// clear deletes all the spilled values.
+func (o *overflowOf[K, V]) clear() {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	o.keys.Range(func(k, _ any) bool {
+		o.forgetLocked(k.(K), nil)
+		return true
+	})
+	o.mu.Unlock()
+	o.notify()
+}
+
+// close deletes all the spilled values from the store, once its goroutine is stopped,
+// and drops the values spilled afterwards.
+func (o *overflowOf[K, V]) close() {
+	if o == nil {
+		return
+	}
+	o.clear()
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+	o.drain()
+}
+
+func (o *overflowOf[K, V]) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drain applies the queued writes and deletions to the store, in order.
+func (o *overflowOf[K, V]) drain() {
+	o.drainMu.Lock()
+	defer o.drainMu.Unlock()
+	for {
+		o.mu.Lock()
+		ops := o.ops
+		o.ops = nil
+		o.mu.Unlock()
+		if len(ops) == 0 {
+			return
+		}
+		for _, op := range ops {
+			if op.s == nil {
+				_ = o.store.Delete(op.k)
+				continue
+			}
+			o.write(op.k, op.s)
+			o.mu.Lock()
+			o.queued--
+			o.mu.Unlock()
+		}
+	}
+}
+
+// write writes the spilled value s of the key k to the store, unless taken meanwhile.
+func (o *overflowOf[K, V]) write(k K, s *spilledOf[V]) {
+	if atomic.LoadUint32(&s.state) != spillQueued {
+		return
+	}
+	d := NoExpiration
+	if s.e > 0 {
+		d = time.Until(time.Unix(0, s.e))
+	}
+	if (s.e == 0 || d > 0) && o.store.Set(k, s.v, d) == nil {
+		if atomic.CompareAndSwapUint32(&s.state, spillQueued, spillWritten) {
+			var zeroedV V
+			s.v = zeroedV
+			return
+		}
+	} else {
+		// dropped once expired, or e.g. once the store is full, along with the value it replaced
+		o.mu.Lock()
+		o.take(k, s)
+		o.mu.Unlock()
+	}
+	// the stored value is stale unless spilled again, e.g. reloaded or deleted while written
+	o.mu.Lock()
+	_, spilled := o.keys.Load(k)
+	o.mu.Unlock()
+	if !spilled {
+		_ = o.store.Delete(k)
+	}
+}
+
+// DiskStoreOf a BackendOf keeping the values in files in a temporary directory, e.g. for WithOverflowOf.
+// The files are named after the hash of the keys formatted with fmt, each file holds the keys
+// of its hash with their values, encoded like the snapshots, see SnapshotFormat.
+// The expired values are dropped when the file of their hash is written.
+type DiskStoreOf[K comparable, V any] struct {
+	dir    *diskDir
+	format SnapshotFormat
+}
+
+// NewDiskStoreOf creates a store in a new temporary directory in dir, removed by Close.
+// A positive maxSize bounds the total size of the files in bytes, Set returns ErrDiskStoreFull
+// instead of growing them over it.
+func NewDiskStoreOf[K comparable, V any](dir string, format SnapshotFormat, maxSize int64) (*DiskStoreOf[K, V], error) {
+	d, err := newDiskDir(dir, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskStoreOf[K, V]{dir: d, format: format}, nil
+}
+
+func (s *DiskStoreOf[K, V]) Get(k K) (V, time.Duration, bool, error) {
+	var zeroedV V
+	data, err := s.dir.read(fmt.Sprint(k))
+	if err != nil || data == nil {
+		return zeroedV, 0, false, err
+	}
+	items, err := s.decode(data)
+	if err != nil {
+		return zeroedV, 0, false, err
+	}
+	for _, x := range items {
+		if x.K != k {
+			// another key with the same hash
+			continue
+		}
+		ttl := NoExpiration
+		if x.E > 0 {
+			if ttl = time.Until(time.Unix(0, x.E)); ttl <= 0 {
+				return zeroedV, 0, false, nil
+			}
+		}
+		return x.V, ttl, true, nil
+	}
+	return zeroedV, 0, false, nil
+}
+
+func (s *DiskStoreOf[K, V]) Set(k K, v V, d time.Duration) error {
+	x := snapshotItemOf[K, V]{K: k, V: v}
+	if d > 0 {
+		x.E = time.Now().Add(d).UnixNano()
+	}
+	return s.update(k, &x)
+}
+
+func (s *DiskStoreOf[K, V]) Delete(k K) error {
+	return s.update(k, nil)
+}
+
+// update replaces the value of the key k in its file by x, deletes it if x is nil.
+func (s *DiskStoreOf[K, V]) update(k K, x *snapshotItemOf[K, V]) error {
+	return s.dir.update(fmt.Sprint(k), func(data []byte) ([]byte, error) {
+		items, err := s.decode(data)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UnixNano()
+		kept := items[:0]
+		for _, i := range items {
+			if i.K != k && (i.E <= 0 || i.E > now) {
+				kept = append(kept, i)
+			}
+		}
+		if x != nil {
+			kept = append(kept, *x)
+		}
+		return s.encode(kept)
+	})
+}
+
+func (s *DiskStoreOf[K, V]) encode(items []snapshotItemOf[K, V]) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := newSnapshotEncoder(s.format, nil, &buf)
+	for _, x := range items {
+		if err := enc.Encode(x); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *DiskStoreOf[K, V]) decode(data []byte) ([]snapshotItemOf[K, V], error) {
+	var items []snapshotItemOf[K, V]
+	dec := newSnapshotDecoder(s.format, nil, bytes.NewReader(data))
+	for {
+		var x snapshotItemOf[K, V]
+		if err := dec.Decode(&x); err == io.EOF {
+			return items, nil
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, x)
+	}
+}
+
+// Close removes the directory of the store.
+func (s *DiskStoreOf[K, V]) Close() error {
+	return s.dir.close()
+}
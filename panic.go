@@ -0,0 +1,15 @@
+package cache
+
+// PanicHandler is called with the value of a panic recovered from a function given to the cache,
+// see WithPanicHandler.
+type PanicHandler func(r interface{})
+
+// recovering runs fn, passing the value of a panic to handler, dropped if handler is nil.
+func recovering(handler PanicHandler, fn func()) {
+	defer func() {
+		if r := recover(); r != nil && handler != nil {
+			handler(r)
+		}
+	}()
+	fn()
+}
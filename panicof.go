@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+// unpanickedOf wraps the function fn computing the new value of an item, so that a panic of fn
+// leaves the item unchanged instead of its bucket locked. The value of the panic is stored in p,
+// to be raised once the bucket is unlocked, see xsyncMapOf.panicked.
+func unpanickedOf[V any](fn func(value V, loaded bool) (V, ComputeOp), p *any) func(value V, loaded bool) (V, ComputeOp) {
+	return func(value V, loaded bool) (v V, op ComputeOp) {
+		defer func() {
+			if r := recover(); r != nil {
+				*p = r
+				v, op = value, CancelOp
+			}
+		}()
+		return fn(value, loaded)
+	}
+}
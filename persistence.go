@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes to a temporary file in the same directory as path,
+// and renames it to path once complete, so path always holds a complete file.
+func writeFileAtomic(path string, write func(w io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	if err = write(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readFile calls read with the buffered content of the file at path.
+func readFile(path string, read func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return read(bufio.NewReader(f))
+}
+
+// restoreFile restores a cache from the snapshot file at path with load, and reports whether
+// the file may be saved over: a missing file is ignored, the other errors are passed to handler,
+// and the file is kept, see WithPersistencePath.
+func restoreFile(path string, load func(path string, strategy ...LoadStrategy) error, handler func(err error)) bool {
+	err := load(path)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	if handler != nil {
+		handler(fmt.Errorf("cache: restore %s: %w", path, err))
+	}
+	return false
+}
+
+// SnapshotWriterFactory opens the destination of a snapshot, e.g. a file or an object
+// storage upload, see WithSnapshot. The writer is closed once the snapshot is written.
+type SnapshotWriterFactory func() (io.WriteCloser, error)
+
+// saveSnapshot writes a snapshot with save to a writer opened by factory, and closes it.
+func saveSnapshot(factory SnapshotWriterFactory, save func(w io.Writer) error) error {
+	w, err := factory()
+	if err != nil {
+		return err
+	}
+	if err = save(w); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,24 @@
+package cache
+
+import (
+	"reflect"
+)
+
+// ValuePool receives the values that left the cache for reuse, e.g. a *sync.Pool, see WithValuePool.
+type ValuePool interface {
+	Put(v interface{})
+}
+
+// sameRef reports whether a and b refer to the same memory, e.g. a value read, updated in place
+// and stored again, which must not be pooled.
+func sameRef(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() || va.Type() != vb.Type() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.UnsafePointer:
+		return va.Pointer() == vb.Pointer()
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"time"
+)
+
+// ProfileOp the type of operation reported to the Profiler.
+type ProfileOp uint8
+
+const (
+	// ProfileGet a read, by Get, GetWithExpiration, GetWithExpirationNano, GetWithTTL or GetWithMeta.
+	ProfileGet ProfileOp = iota + 1
+
+	// ProfileSet a write, by Set, SetWithMeta, SetWithCost, SetWithCallback, SetIfAbsent, SetIfPresent,
+	// GetOrSet, GetAndSet or CompareAndSwap.
+	ProfileSet
+
+	// ProfileDelete a delete, by Delete, GetAndDelete, DeleteMultiple, DeleteFunc or CompareAndDelete.
+	ProfileDelete
+
+	// ProfileCompute a computation, by Compute or GetOrCompute, including the compute function.
+	ProfileCompute
+
+	// ProfileCleanup a cleanup of the expired items, by DeleteExpired, including the evicted callbacks.
+	ProfileCleanup
+)
+
+var profileOpNames = [...]string{
+	ProfileGet:     "get",
+	ProfileSet:     "set",
+	ProfileDelete:  "delete",
+	ProfileCompute: "compute",
+	ProfileCleanup: "cleanup",
+}
+
+func (op ProfileOp) String() string {
+	if int(op) < len(profileOpNames) && profileOpNames[op] != "" {
+		return profileOpNames[op]
+	}
+	return "unknown"
+}
+
+// Profiler receives the timing of each cache operation, see WithProfiler.
+// Observe is called synchronously on the hot path, it must be fast and safe for concurrent use,
+// e.g. adding to a histogram.
+type Profiler interface {
+	Observe(op ProfileOp, elapsed time.Duration)
+}
+
+// ProfilerFunc an adapter to use an ordinary function as a Profiler.
+type ProfilerFunc func(op ProfileOp, elapsed time.Duration)
+
+// Observe calls f(op, elapsed).
+func (f ProfilerFunc) Observe(op ProfileOp, elapsed time.Duration) {
+	f(op, elapsed)
+}
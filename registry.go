@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registered the methods shared by Cache and CacheOf, for the code walking a Registry.
+// Assert the cache to its own type to use the other methods.
+type Registered interface {
+	Count() int
+	Clear()
+	DeleteExpired()
+	DefaultExpiration() time.Duration
+	ShadowStats() []ShadowStats
+	Close() error
+}
+
+// Registry a set of caches registered by name, e.g. for metrics exporters, admin endpoints
+// and shutdown code to discover every cache of the process. It is safe for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	caches map[string]Registered
+}
+
+// DefaultRegistry the registry of WithRegistry when none is given.
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{caches: make(map[string]Registered)}
+}
+
+// Register adds the cache c under name, replacing any cache registered under the same name.
+func (r *Registry) Register(name string, c Registered) {
+	r.mu.Lock()
+	r.caches[name] = c
+	r.mu.Unlock()
+}
+
+// Unregister removes the cache registered under name, it does not close it.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	delete(r.caches, name)
+	r.mu.Unlock()
+}
+
+// unregister removes the cache registered under name if match returns true for it.
+func (r *Registry) unregister(name string, match func(c Registered) bool) {
+	r.mu.Lock()
+	if c, ok := r.caches[name]; ok && match(c) {
+		delete(r.caches, name)
+	}
+	r.mu.Unlock()
+}
+
+// Lookup returns the cache registered under name.
+func (r *Registry) Lookup(name string) (Registered, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.caches[name]
+	return c, ok
+}
+
+// Names returns the names of the registered caches in ascending order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.caches))
+	for name := range r.caches {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Range calls f sequentially for each registered cache, in ascending name order.
+// If f returns false, range stops the iteration. f may register and unregister caches.
+func (r *Registry) Range(f func(name string, c Registered) bool) {
+	for _, name := range r.Names() {
+		if c, ok := r.Lookup(name); ok && !f(name, c) {
+			return
+		}
+	}
+}
+
+// Close closes and unregisters all the caches, e.g. on shutdown, and returns the first error,
+// ignoring the caches already closed.
+func (r *Registry) Close() error {
+	var err error
+	r.Range(func(name string, c Registered) bool {
+		if cerr := c.Close(); cerr != nil && !errors.Is(cerr, ErrClosed) && err == nil {
+			err = cerr
+		}
+		r.Unregister(name)
+		return true
+	})
+	return err
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c1 := New(WithRegistry(r, "c1"))
+	c2 := New(WithRegistry(r, "c2"))
+	c2.SetForever("a", 1)
+	r.Register("ns", c2.Namespace("ns:"))
+
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"c1", "c2", "ns"}) {
+		t.Fatalf("unexpected names: %v", names)
+	}
+	if c, ok := r.Lookup("c2"); !ok || c.Count() != 1 {
+		t.Fatal("c2 should be registered")
+	}
+	if c, _ := r.Lookup("c1"); c.(Cache) != c1 {
+		t.Fatal("the registered cache should be c1")
+	}
+
+	if err := c1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Lookup("c1"); ok {
+		t.Fatal("c1 should be unregistered on Close")
+	}
+
+	// replaced by another cache, not unregistered by the closed one
+	c3 := New(WithRegistry(r, "c2"))
+	if err := c2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := r.Lookup("c2"); !ok || c.(Cache) != c3 {
+		t.Fatal("c3 should be registered")
+	}
+
+	var names []string
+	r.Range(func(name string, _ Registered) bool {
+		names = append(names, name)
+		return false
+	})
+	if len(names) != 1 {
+		t.Fatalf("expected the range to stop, got %v", names)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Names()) != 0 {
+		t.Fatal("the registry should be empty")
+	}
+	if err := c3.Close(); err != ErrClosed {
+		t.Fatalf("c3 should be closed, got %v", err)
+	}
+
+	c4 := New(WithRegistry(nil, "TestRegistry"))
+	defer c4.Close()
+	if _, ok := DefaultRegistry.Lookup("TestRegistry"); !ok {
+		t.Fatal("c4 should be registered in the default registry")
+	}
+
+	// the registered handle is the normalizing view
+	c5 := New(WithRegistry(r, "c5"), WithKeyNormalizer(strings.ToLower))
+	if c, _ := r.Lookup("c5"); c.(Cache) != c5 {
+		t.Fatal("the registered cache should be c5")
+	}
+	if err := c5.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Lookup("c5"); ok {
+		t.Fatal("c5 should be unregistered on Close")
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// ReplicationOp the operation of a replicated write.
+type ReplicationOp uint8
+
+const (
+	// ReplicateSet stores the value of the key.
+	ReplicateSet ReplicationOp = iota + 1
+
+	// ReplicateDelete deletes the key.
+	ReplicateDelete
+)
+
+// ReplicationMessage a write replicated to the other nodes, see NewReplicated.
+type ReplicationMessage = ReplicationMessageOf[string, interface{}]
+
+// Transport broadcasts the replicated writes between the nodes, e.g. over NATS,
+// Redis pub/sub or gossip, encoding the messages as it sees fit, e.g. with the Codec of the cache.
+// It must be safe for concurrent use.
+type Transport interface {
+	// Publish sends the message to all the nodes.
+	Publish(msg ReplicationMessage) error
+
+	// Subscribe calls handle for each message published by any node, this one included,
+	// until cancel is called.
+	Subscribe(handle func(msg ReplicationMessage)) (cancel func(), err error)
+}
+
+type ReplicationConfig struct {
+	// NodeID the ID of this node, unique among the nodes, random by default.
+	NodeID string
+
+	// TombstoneWindow how long the version of a key is kept after its last write, the deletions
+	// included, DefaultReplicationTombstoneWindow by default, see WithReplicationTombstoneWindow.
+	TombstoneWindow time.Duration
+}
+
+// DefaultReplicationTombstoneWindow the default time the version of a key is kept after its last write.
+const DefaultReplicationTombstoneWindow = time.Minute
+
+type ReplicationOption func(config *ReplicationConfig)
+
+func WithReplicationNodeID(id string) ReplicationOption {
+	return func(config *ReplicationConfig) {
+		config.NodeID = id
+	}
+}
+
+// WithReplicationTombstoneWindow sets how long the version of a key is kept after its last write,
+// to skip the older writes of the key received meanwhile. A write received later than that,
+// e.g. delayed by the transport, is applied even if a newer write of the key was.
+func WithReplicationTombstoneWindow(d time.Duration) ReplicationOption {
+	return func(config *ReplicationConfig) {
+		config.TombstoneWindow = d
+	}
+}
+
+func replicationConfigDefault(opts ...ReplicationOption) ReplicationConfig {
+	var cfg ReplicationConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.NodeID == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		cfg.NodeID = hex.EncodeToString(b)
+	}
+	if cfg.TombstoneWindow <= 0 {
+		cfg.TombstoneWindow = DefaultReplicationTombstoneWindow
+	}
+	return cfg
+}
+
+// Replicated a cache whose writes are replicated to the caches of the other nodes through a Transport,
+// so the local caches of several processes stay coherent, see ReplicatedOf.
+type Replicated struct {
+	*ReplicatedOf[string, interface{}]
+	c Cache
+}
+
+// NewReplicated returns a replicated cache over the local cache c, subscribed to the transport t.
+// Closing the replicated cache unsubscribes it, it does not close c.
+func NewReplicated(c Cache, t Transport, opts ...ReplicationOption) (*Replicated, error) {
+	r, err := newReplicatedOf[string, interface{}](c, t, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Replicated{r, c}, nil
+}
+
+// Cache returns the local cache.
+func (r *Replicated) Cache() Cache {
+	return r.c
+}
+
+// replicatedTTL returns the time to live of a replicated value expiring at e,
+// false if it has already expired.
+func replicatedTTL(e time.Time) (time.Duration, bool) {
+	if e.IsZero() {
+		return NoExpiration, true
+	}
+	d := time.Until(e)
+	return d, d > 0
+}
+
+func replicationError(op string, k interface{}, err error) error {
+	return fmt.Errorf("cache: replicated %s %v: %w", op, k, err)
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// memTransport broadcasts the messages synchronously to the subscribers of the process,
+// or holds them until flush if hold is set.
+type memTransport struct {
+	sync.Mutex
+	handlers map[int]func(msg ReplicationMessage)
+	next     int
+	hold     bool
+	held     []ReplicationMessage
+}
+
+func (t *memTransport) Publish(msg ReplicationMessage) error {
+	t.Lock()
+	if t.hold {
+		t.held = append(t.held, msg)
+		t.Unlock()
+		return nil
+	}
+	handlers := make([]func(msg ReplicationMessage), 0, len(t.handlers))
+	for _, h := range t.handlers {
+		handlers = append(handlers, h)
+	}
+	t.Unlock()
+	for _, h := range handlers {
+		h(msg)
+	}
+	return nil
+}
+
+// flush broadcasts the messages held, in reverse order.
+func (t *memTransport) flush() {
+	t.Lock()
+	held := t.held
+	t.hold, t.held = false, nil
+	t.Unlock()
+	for i := len(held) - 1; i >= 0; i-- {
+		_ = t.Publish(held[i])
+	}
+}
+
+func (t *memTransport) Subscribe(handle func(msg ReplicationMessage)) (func(), error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.handlers == nil {
+		t.handlers = make(map[int]func(msg ReplicationMessage))
+	}
+	id := t.next
+	t.next++
+	t.handlers[id] = handle
+	return func() {
+		t.Lock()
+		delete(t.handlers, id)
+		t.Unlock()
+	}, nil
+}
+
+func TestReplicated(t *testing.T) {
+	tr := &memTransport{}
+	c1 := New(WithDefaultExpiration(time.Hour))
+	defer c1.Close()
+	c2 := New()
+	defer c2.Close()
+	r1, err := NewReplicated(c1, tr, WithReplicationNodeID("n1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := NewReplicated(c2, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2.NodeID() == "" || r2.NodeID() == r1.NodeID() {
+		t.Fatalf("unexpected node ID: %q", r2.NodeID())
+	}
+
+	if err := r1.Set("a", 1, DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if v, ttl, ok := c2.GetWithTTL("a"); !ok || v != 1 || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("a should be replicated with the expiration of n1, got: %v, %v, %v", v, ttl, ok)
+	}
+	if err := r2.Set("b", 2, NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if v, ttl, _ := r1.Cache().GetWithTTL("b"); v != 2 || ttl != NoExpiration {
+		t.Fatalf("b should be replicated, got: %v, %v", v, ttl)
+	}
+	if err := r2.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r1.Get("a"); ok {
+		t.Fatal("the deletion of a should be replicated")
+	}
+
+	_ = r2.Close()
+	_ = r1.Set("c", 3, NoExpiration)
+	if _, ok := c2.Get("c"); ok {
+		t.Fatal("a closed node should not receive the writes")
+	}
+
+	// expired in transit
+	r1.apply(ReplicationMessage{Node: "n2", Clock: 100, Op: ReplicateSet, Key: "b", Value: 4, Expiration: time.Now().Add(-time.Second)})
+	if _, ok := c1.Get("b"); ok {
+		t.Fatal("b should be deleted")
+	}
+}
+
+func TestReplicated_ConcurrentWrites(t *testing.T) {
+	tr := &memTransport{}
+	a, err := NewReplicated(New(), tr, WithReplicationNodeID("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewReplicated(New(), tr, WithReplicationNodeID("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// both nodes write the key before receiving the write of the other
+	tr.hold = true
+	_ = a.Set("k", 1, NoExpiration)
+	_ = b.Set("k", 2, NoExpiration)
+	tr.flush()
+	va, _ := a.Get("k")
+	vb, _ := b.Get("k")
+	if va != vb {
+		t.Fatalf("expected the nodes to agree on the value, got %v on a and %v on b", va, vb)
+	}
+
+	// a later write wins over the concurrent writes, and an older write received late is skipped
+	_ = a.Set("k", 3, NoExpiration)
+	if v, _ := b.Get("k"); v != 3 {
+		t.Fatalf("expected the later write of a, got %v", v)
+	}
+	tr.hold = true
+	_ = b.Delete("k")
+	_ = a.Set("k", 4, NoExpiration)
+	tr.flush()
+	va, okA := a.Get("k")
+	vb, okB := b.Get("k")
+	if va != vb || okA != okB {
+		t.Fatalf("expected the nodes to agree on the key, got %v, %v on a and %v, %v on b", va, okA, vb, okB)
+	}
+	b.apply(ReplicationMessage{Node: "a", Clock: 1, Op: ReplicateSet, Key: "k", Value: 0})
+	if v, _ := b.Get("k"); v != vb {
+		t.Fatalf("expected a stale write to be skipped, got %v", v)
+	}
+}
+
+func TestReplicated_TombstoneWindow(t *testing.T) {
+	tr := &memTransport{}
+	a, err := NewReplicated(New(), tr, WithReplicationNodeID("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewReplicated(New(), tr, WithReplicationNodeID("b"),
+		WithReplicationTombstoneWindow(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	_ = a.Set("k", 1, NoExpiration)
+	_ = a.Delete("k")
+	if n := a.versions.Size(); n != 1 {
+		t.Fatalf("expected the version of the deleted key to be kept, got %d versions", n)
+	}
+	a.prune(time.Now().Add(-time.Minute).UnixNano())
+	if n := a.versions.Size(); n != 1 {
+		t.Fatalf("expected the version within the window to be kept, got %d versions", n)
+	}
+	a.prune(time.Now().Add(time.Nanosecond).UnixNano())
+	if n := a.versions.Size(); n != 0 {
+		t.Fatalf("expected the version to be dropped after the window, got %d versions", n)
+	}
+
+	// dropped by the loop of b every window
+	for i := 0; b.versions.Size() > 0; i++ {
+		if i == 100 {
+			t.Fatalf("expected the versions to be dropped, got %d versions", b.versions.Size())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,258 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fufuok/cache/internal/xsync"
+)
+
+// ReplicationMessageOf a write replicated to the other nodes, see NewReplicatedOf.
+type ReplicationMessageOf[K comparable, V any] struct {
+	// Node the ID of the node the write comes from.
+	Node string
+
+	// Clock the Lamport clock of the write on its node, which orders the writes of a key
+	// across the nodes, along with Node for the writes of the same clock.
+	Clock uint64
+
+	Op    ReplicationOp
+	Key   K
+	Value V
+
+	// Expiration the expiration time of the value, the zero value means it never expires.
+	// The clocks of the nodes are expected to be roughly in sync.
+	Expiration time.Time
+}
+
+// TransportOf broadcasts the replicated writes between the nodes, e.g. over NATS,
+// Redis pub/sub or gossip, encoding the messages as it sees fit, e.g. with the Codec of the cache.
+// It must be safe for concurrent use.
+type TransportOf[K comparable, V any] interface {
+	// Publish sends the message to all the nodes.
+	Publish(msg ReplicationMessageOf[K, V]) error
+
+	// Subscribe calls handle for each message published by any node, this one included,
+	// until cancel is called.
+	Subscribe(handle func(msg ReplicationMessageOf[K, V])) (cancel func(), err error)
+}
+
+// ReplicatedOf a cache whose writes are replicated to the caches of the other nodes through a TransportOf,
+// so the local caches of several processes stay coherent.
+// Only the writes made through Set and Delete are replicated. Each write of a key is versioned
+// by the Lamport clock of its node and the node ID, and a write received from another node
+// is skipped if the key was written since by a later version, here or on another node,
+// so that the nodes writing the same key concurrently end up with the same value,
+// whatever the order the messages are received in.
+// The version of a key is kept for the tombstone window after its last write, the deleted keys
+// included, and dropped afterwards, see WithReplicationTombstoneWindow.
+type ReplicatedOf[K comparable, V any] struct {
+	c        replicaOf[K, V]
+	cache    CacheOf[K, V]
+	t        TransportOf[K, V]
+	node     string
+	clock    uint64
+	versions *xsync.MapOf[K, replicationVersion]
+	window   time.Duration
+	cancel   func()
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// replicaOf the local cache of a replicated cache, CacheOf or Cache.
+type replicaOf[K comparable, V any] interface {
+	Get(k K) (V, bool)
+	Set(k K, v V, d time.Duration)
+	Delete(k K)
+	DefaultExpiration() time.Duration
+}
+
+// replicationVersion the version of the last write of a key, see ReplicatedOf.
+type replicationVersion struct {
+	clock uint64
+	node  string
+	at    int64 // the local time of the write, in nanoseconds, see prune
+}
+
+// before reports whether the version v is older than w.
+func (v replicationVersion) before(w replicationVersion) bool {
+	return v.clock < w.clock || v.clock == w.clock && v.node < w.node
+}
+
+// NewReplicatedOf returns a replicated cache over the local cache c, subscribed to the transport t.
+// Closing the replicated cache unsubscribes it, it does not close c.
+func NewReplicatedOf[K comparable, V any](
+	c CacheOf[K, V],
+	t TransportOf[K, V],
+	opts ...ReplicationOption,
+) (*ReplicatedOf[K, V], error) {
+	r, err := newReplicatedOf[K, V](c, t, opts...)
+	if err != nil {
+		return nil, err
+	}
+	r.cache = c
+	return r, nil
+}
+
+// newReplicatedOf returns a replicated cache over the local cache c, see NewReplicatedOf and NewReplicated.
+func newReplicatedOf[K comparable, V any](
+	c replicaOf[K, V],
+	t TransportOf[K, V],
+	opts ...ReplicationOption,
+) (*ReplicatedOf[K, V], error) {
+	cfg := replicationConfigDefault(opts...)
+	r := &ReplicatedOf[K, V]{
+		c:        c,
+		t:        t,
+		node:     cfg.NodeID,
+		versions: xsync.NewMapOf[K, replicationVersion](),
+		window:   cfg.TombstoneWindow,
+		stop:     make(chan struct{}),
+	}
+	cancel, err := t.Subscribe(r.apply)
+	if err != nil {
+		return nil, fmt.Errorf("cache: replicated subscribe: %w", err)
+	}
+	r.cancel = cancel
+	go r.pruneLoop()
+	return r, nil
+}
+
+// Get returns the value of the key from the local cache.
+func (r *ReplicatedOf[K, V]) Get(k K) (V, bool) {
+	return r.c.Get(k)
+}
+
+// Set stores the value of the key in the local cache and publishes it to the other nodes,
+// see CacheOf.Set for the duration d.
+func (r *ReplicatedOf[K, V]) Set(k K, v V, d time.Duration) error {
+	if d == DefaultExpiration {
+		d = r.c.DefaultExpiration()
+	}
+	msg := ReplicationMessageOf[K, V]{Node: r.node, Op: ReplicateSet, Key: k, Value: v}
+	if d > 0 {
+		msg.Expiration = time.Now().Add(d)
+	}
+	msg.Clock = r.write(k, func() {
+		r.c.Set(k, v, d)
+	})
+	if err := r.t.Publish(msg); err != nil {
+		return replicationError("set", k, err)
+	}
+	return nil
+}
+
+// Delete deletes the key from the local cache and publishes the deletion to the other nodes.
+func (r *ReplicatedOf[K, V]) Delete(k K) error {
+	msg := ReplicationMessageOf[K, V]{Node: r.node, Op: ReplicateDelete, Key: k}
+	msg.Clock = r.write(k, func() {
+		r.c.Delete(k)
+	})
+	if err := r.t.Publish(msg); err != nil {
+		return replicationError("delete", k, err)
+	}
+	return nil
+}
+
+// Cache returns the local cache.
+func (r *ReplicatedOf[K, V]) Cache() CacheOf[K, V] {
+	return r.cache
+}
+
+// NodeID returns the ID of this node.
+func (r *ReplicatedOf[K, V]) NodeID() string {
+	return r.node
+}
+
+// Close unsubscribes from the transport.
+func (r *ReplicatedOf[K, V]) Close() error {
+	r.once.Do(func() {
+		if r.cancel != nil {
+			r.cancel()
+		}
+		close(r.stop)
+	})
+	return nil
+}
+
+// pruneLoop drops the versions older than the tombstone window every window, until Close.
+func (r *ReplicatedOf[K, V]) pruneLoop() {
+	ticker := time.NewTicker(r.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.prune(time.Now().Add(-r.window).UnixNano())
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// prune drops the versions of the keys last written before the time before, in nanoseconds.
+func (r *ReplicatedOf[K, V]) prune(before int64) {
+	r.versions.Range(func(k K, v replicationVersion) bool {
+		if v.at < before {
+			r.versions.ComputeWithOp(k, func(old replicationVersion, loaded bool) (replicationVersion, ComputeOp) {
+				if loaded && old.at < before {
+					return old, DeleteOp
+				}
+				return old, CancelOp
+			})
+		}
+		return true
+	})
+}
+
+// write runs the local write f of the key k under the lock of its version,
+// and returns the clock of the new version, later than all the versions known to the node.
+func (r *ReplicatedOf[K, V]) write(k K, f func()) (clock uint64) {
+	r.versions.ComputeWithOp(k, func(replicationVersion, bool) (replicationVersion, ComputeOp) {
+		clock = atomic.AddUint64(&r.clock, 1)
+		f()
+		return replicationVersion{clock, r.node, time.Now().UnixNano()}, UpdateOp
+	})
+	return
+}
+
+// witness advances the clock of the node to the clock of a write received, if later.
+func (r *ReplicatedOf[K, V]) witness(clock uint64) {
+	for {
+		cur := atomic.LoadUint64(&r.clock)
+		if clock <= cur || atomic.CompareAndSwapUint64(&r.clock, cur, clock) {
+			return
+		}
+	}
+}
+
+// apply applies to the local cache a write received from another node,
+// unless the key was written since by a later version.
+func (r *ReplicatedOf[K, V]) apply(msg ReplicationMessageOf[K, V]) {
+	if msg.Node == r.node {
+		return
+	}
+	r.witness(msg.Clock)
+	v := replicationVersion{msg.Clock, msg.Node, time.Now().UnixNano()}
+	r.versions.ComputeWithOp(msg.Key, func(old replicationVersion, loaded bool) (replicationVersion, ComputeOp) {
+		if loaded && !old.before(v) {
+			return old, CancelOp
+		}
+		switch msg.Op {
+		case ReplicateSet:
+			d, ok := replicatedTTL(msg.Expiration)
+			if !ok {
+				r.c.Delete(msg.Key)
+				break
+			}
+			r.c.Set(msg.Key, msg.Value, d)
+		case ReplicateDelete:
+			r.c.Delete(msg.Key)
+		}
+		return v, UpdateOp
+	})
+}
@@ -0,0 +1,97 @@
+package cache
+
+// DefaultScanCount the default number of keys examined by each Scan call.
+const DefaultScanCount = 10
+
+// matchPattern reports whether s matches the glob-style pattern, with the same rules as Redis:
+//
+//	h?llo matches hello, hallo and hxllo
+//	h*llo matches hllo and heeeello
+//	h[ae]llo matches hello and hallo, but not hillo
+//	h[^e]llo matches hallo, hbllo, ... but not hello
+//	h[a-b]llo matches hallo and hbllo
+//
+// Use \ to escape special characters. Matching is done byte by byte.
+func matchPattern(pattern, s string) bool {
+	// the pattern after the last *, and the position in s it is matched from, to backtrack to
+	// on a mismatch: each other token matches a single byte, so it is enough to retry the last *
+	// one byte further, and the match takes O(len(pattern) * len(s)) at worst.
+	star, next := -1, 0
+	p, i := 0, 0
+	for i < len(s) || p < len(pattern) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				star, next = p, i
+				continue
+			}
+			if i < len(s) {
+				if n, ok := matchToken(pattern[p:], s[i]); ok {
+					p += n
+					i++
+					continue
+				}
+			}
+		}
+		if star < 0 || next == len(s) {
+			return false
+		}
+		next++
+		p, i = star, next
+	}
+	return true
+}
+
+// matchToken reports whether the byte c matches the token at the beginning of pattern,
+// which is not a *, and returns the length of the token.
+func matchToken(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		n := 1
+		not := n < len(pattern) && pattern[n] == '^'
+		if not {
+			n++
+		}
+		match := false
+		for n < len(pattern) && pattern[n] != ']' {
+			switch {
+			case pattern[n] == '\\' && n+1 < len(pattern):
+				n++
+				if pattern[n] == c {
+					match = true
+				}
+			case n+2 < len(pattern) && pattern[n+1] == '-' && pattern[n+2] != ']':
+				lo, hi := pattern[n], pattern[n+2]
+				if lo > hi {
+					lo, hi = hi, lo
+				}
+				if c >= lo && c <= hi {
+					match = true
+				}
+				n += 2
+			default:
+				if pattern[n] == c {
+					match = true
+				}
+			}
+			n++
+		}
+		if n < len(pattern) {
+			n++
+		}
+		// an unterminated class is treated as terminated
+		return n, match != not
+	case '\\':
+		if len(pattern) >= 2 {
+			return 2, pattern[1] == c
+		}
+	}
+	return 1, pattern[0] == c
+}
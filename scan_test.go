@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "heeeell", false},
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h[b-a]llo", "hallo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h[\\]]llo", "h]llo", true},
+		{"session:user:42:*", "session:user:42:token", true},
+		{"session:user:42:*", "session:user:420:token", false},
+		{"session:*:42:*", "session:user:42:token", true},
+		{"a**b", "axxb", true},
+		{"a*b*c", "abxbc", true},
+		{"a*b*c", "abxb", false},
+		{"h[ab", "ha", true},
+		{"h[ab", "hab", false},
+		// exponential with a recursive backtracking
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 100), false},
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 100) + "b", true},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Fatalf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
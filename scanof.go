@@ -0,0 +1,27 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"strings"
+)
+
+// ScanOf incrementally iterates over the keys in a cache with string keys,
+// with the same semantics as Cache.Scan.
+func ScanOf[V any](c CacheOf[string, V], pattern string, cursor uint64, count int) (keys []string, next uint64) {
+	next = c.RangeCursor(cursor, count, func(k string, _ V) {
+		if pattern == "" || matchPattern(pattern, k) {
+			keys = append(keys, k)
+		}
+	})
+	return
+}
+
+// ItemsWithPrefixOf returns the items in a cache with string keys whose keys start with prefix,
+// with the same semantics as Cache.ItemsWithPrefix.
+func ItemsWithPrefixOf[V any](c CacheOf[string, V], prefix string) map[string]V {
+	return c.ItemsWhere(func(k string, _ V) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
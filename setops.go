@@ -0,0 +1,125 @@
+package cache
+
+// KeySet a set of keys, the operand of the set operations such as Union, see CacheKeys and NewKeySet.
+type KeySet interface {
+	// RangeKeys calls f sequentially for each key in the set.
+	// If f returns false, range stops the iteration.
+	RangeKeys(f func(k string) bool)
+
+	// HasKey reports whether the key is in the set.
+	HasKey(k string) bool
+}
+
+// CacheKeys returns the live (unexpired) keys of the cache as a KeySet.
+// The keys are read when the set operation runs, not copied.
+func CacheKeys(c Cache) KeySet {
+	return cacheKeySet{c}
+}
+
+type cacheKeySet struct {
+	c Cache
+}
+
+func (s cacheKeySet) RangeKeys(f func(k string) bool) {
+	s.c.Range(func(k string, _ interface{}) bool {
+		return f(k)
+	})
+}
+
+func (s cacheKeySet) HasKey(k string) bool {
+	// does not record a read in the event history or the shadow trace
+	if p, ok := s.c.(interface{ has(k string) bool }); ok {
+		return p.has(k)
+	}
+	_, ok := s.c.Get(k)
+	return ok
+}
+
+// NewKeySet returns a KeySet of the given keys, e.g. an authoritative key list.
+func NewKeySet(keys ...string) KeySet {
+	s := make(keySet, len(keys))
+	for _, k := range keys {
+		s[k] = struct{}{}
+	}
+	return s
+}
+
+type keySet map[string]struct{}
+
+func (s keySet) RangeKeys(f func(k string) bool) {
+	for k := range s {
+		if !f(k) {
+			return
+		}
+	}
+}
+
+func (s keySet) HasKey(k string) bool {
+	_, ok := s[k]
+	return ok
+}
+
+// RangeUnion calls f sequentially for each key in a or b, each key once.
+// If f returns false, range stops the iteration.
+func RangeUnion(a, b KeySet, f func(k string) bool) {
+	ok := true
+	a.RangeKeys(func(k string) bool {
+		ok = f(k)
+		return ok
+	})
+	if !ok {
+		return
+	}
+	b.RangeKeys(func(k string) bool {
+		if a.HasKey(k) {
+			return true
+		}
+		return f(k)
+	})
+}
+
+// RangeIntersection calls f sequentially for each key in both a and b.
+// If f returns false, range stops the iteration.
+func RangeIntersection(a, b KeySet, f func(k string) bool) {
+	a.RangeKeys(func(k string) bool {
+		if !b.HasKey(k) {
+			return true
+		}
+		return f(k)
+	})
+}
+
+// RangeDifference calls f sequentially for each key in a but not in b.
+// If f returns false, range stops the iteration.
+func RangeDifference(a, b KeySet, f func(k string) bool) {
+	a.RangeKeys(func(k string) bool {
+		if b.HasKey(k) {
+			return true
+		}
+		return f(k)
+	})
+}
+
+// Union returns the keys in a or b.
+func Union(a, b KeySet) []string {
+	return collectKeys(a, b, RangeUnion)
+}
+
+// Intersection returns the keys in both a and b.
+func Intersection(a, b KeySet) []string {
+	return collectKeys(a, b, RangeIntersection)
+}
+
+// Difference returns the keys in a but not in b,
+// e.g. the cached keys missing from the authoritative set.
+func Difference(a, b KeySet) []string {
+	return collectKeys(a, b, RangeDifference)
+}
+
+func collectKeys(a, b KeySet, op func(a, b KeySet, f func(k string) bool)) (keys []string) {
+	op(a, b, func(k string) bool {
+		keys = append(keys, k)
+		return true
+	})
+	return
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSetOperations(t *testing.T) {
+	a := New(WithEventHistory(10))
+	defer a.Close()
+	for _, k := range []string{"a", "b", "c"} {
+		a.SetForever(k, k)
+	}
+	b := NewKeySet("b", "c", "d")
+
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"union", Union(CacheKeys(a), b), []string{"a", "b", "c", "d"}},
+		{"intersection", Intersection(CacheKeys(a), b), []string{"b", "c"}},
+		{"difference", Difference(CacheKeys(a), b), []string{"a"}},
+		{"reverse difference", Difference(b, CacheKeys(a)), []string{"d"}},
+	}
+	for _, tt := range tests {
+		sort.Strings(tt.got)
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Fatalf("%s: expected %v, got: %v", tt.name, tt.want, tt.got)
+		}
+	}
+	if n := len(a.RecentEvents()); n != 3 {
+		t.Fatalf("set operations should not record reads, got %d events", n)
+	}
+
+	n := 0
+	RangeUnion(CacheKeys(a), b, func(string) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Fatalf("expected the iteration to stop, got: %d", n)
+	}
+}
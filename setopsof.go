@@ -0,0 +1,129 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+// KeySetOf a set of keys, the operand of the set operations such as UnionOf,
+// see CacheKeysOf and NewKeySetOf.
+type KeySetOf[K comparable] interface {
+	// RangeKeys calls f sequentially for each key in the set.
+	// If f returns false, range stops the iteration.
+	RangeKeys(f func(k K) bool)
+
+	// HasKey reports whether the key is in the set.
+	HasKey(k K) bool
+}
+
+// CacheKeysOf returns the live (unexpired) keys of the cache as a KeySetOf.
+// The keys are read when the set operation runs, not copied.
+func CacheKeysOf[K comparable, V any](c CacheOf[K, V]) KeySetOf[K] {
+	return cacheKeySetOf[K, V]{c}
+}
+
+type cacheKeySetOf[K comparable, V any] struct {
+	c CacheOf[K, V]
+}
+
+func (s cacheKeySetOf[K, V]) RangeKeys(f func(k K) bool) {
+	s.c.Range(func(k K, _ V) bool {
+		return f(k)
+	})
+}
+
+func (s cacheKeySetOf[K, V]) HasKey(k K) bool {
+	// does not record a read in the event history or the shadow trace
+	if p, ok := s.c.(interface{ has(k K) bool }); ok {
+		return p.has(k)
+	}
+	_, ok := s.c.Get(k)
+	return ok
+}
+
+// NewKeySetOf returns a KeySetOf of the given keys, e.g. an authoritative key list.
+func NewKeySetOf[K comparable](keys ...K) KeySetOf[K] {
+	s := make(keySetOf[K], len(keys))
+	for _, k := range keys {
+		s[k] = struct{}{}
+	}
+	return s
+}
+
+type keySetOf[K comparable] map[K]struct{}
+
+func (s keySetOf[K]) RangeKeys(f func(k K) bool) {
+	for k := range s {
+		if !f(k) {
+			return
+		}
+	}
+}
+
+func (s keySetOf[K]) HasKey(k K) bool {
+	_, ok := s[k]
+	return ok
+}
+
+// RangeUnionOf calls f sequentially for each key in a or b, each key once.
+// If f returns false, range stops the iteration.
+func RangeUnionOf[K comparable](a, b KeySetOf[K], f func(k K) bool) {
+	ok := true
+	a.RangeKeys(func(k K) bool {
+		ok = f(k)
+		return ok
+	})
+	if !ok {
+		return
+	}
+	b.RangeKeys(func(k K) bool {
+		if a.HasKey(k) {
+			return true
+		}
+		return f(k)
+	})
+}
+
+// RangeIntersectionOf calls f sequentially for each key in both a and b.
+// If f returns false, range stops the iteration.
+func RangeIntersectionOf[K comparable](a, b KeySetOf[K], f func(k K) bool) {
+	a.RangeKeys(func(k K) bool {
+		if !b.HasKey(k) {
+			return true
+		}
+		return f(k)
+	})
+}
+
+// RangeDifferenceOf calls f sequentially for each key in a but not in b.
+// If f returns false, range stops the iteration.
+func RangeDifferenceOf[K comparable](a, b KeySetOf[K], f func(k K) bool) {
+	a.RangeKeys(func(k K) bool {
+		if b.HasKey(k) {
+			return true
+		}
+		return f(k)
+	})
+}
+
+// UnionOf returns the keys in a or b.
+func UnionOf[K comparable](a, b KeySetOf[K]) []K {
+	return collectKeysOf(a, b, RangeUnionOf[K])
+}
+
+// IntersectionOf returns the keys in both a and b.
+func IntersectionOf[K comparable](a, b KeySetOf[K]) []K {
+	return collectKeysOf(a, b, RangeIntersectionOf[K])
+}
+
+// DifferenceOf returns the keys in a but not in b,
+// e.g. the cached keys missing from the authoritative set.
+func DifferenceOf[K comparable](a, b KeySetOf[K]) []K {
+	return collectKeysOf(a, b, RangeDifferenceOf[K])
+}
+
+func collectKeysOf[K comparable](a, b KeySetOf[K], op func(a, b KeySetOf[K], f func(k K) bool)) (keys []K) {
+	op(a, b, func(k K) bool {
+		keys = append(keys, k)
+		return true
+	})
+	return
+}
@@ -0,0 +1,276 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// EvictionPolicy decides which items are evicted first when a capacity bound is reached.
+type EvictionPolicy uint8
+
+const (
+	// LRU evicts the least recently used items first.
+	LRU EvictionPolicy = iota
+
+	// LFU evicts the least frequently used items first,
+	// the least recently used among them on ties.
+	LFU
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case LRU:
+		return "LRU"
+	case LFU:
+		return "LFU"
+	default:
+		return "unknown"
+	}
+}
+
+// Shadow an eviction policy and capacity simulated against the access trace of the cache,
+// see WithShadow.
+type Shadow struct {
+	Policy EvictionPolicy
+
+	// Size the maximum number of entries, 0 means unbounded.
+	Size int
+}
+
+// ShadowStats the simulated result of a Shadow.
+type ShadowStats struct {
+	Shadow
+
+	// Hits the number of reads that would have found the key.
+	Hits uint64
+
+	// Misses the number of reads that would have missed the key.
+	Misses uint64
+}
+
+// HitRate returns the ratio of simulated hits to reads, between 0 and 1.
+func (s ShadowStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// String returns a summary, e.g. "LRU@100000 entries would have hit 91.00%".
+func (s ShadowStats) String() string {
+	size := "unbounded"
+	if s.Size > 0 {
+		size = fmt.Sprintf("%d entries", s.Size)
+	}
+	return fmt.Sprintf("%s@%s would have hit %.2f%%", s.Policy, size, s.HitRate()*100)
+}
+
+// shadowSim simulates a bounded cache, keys are identified by their hash.
+type shadowSim interface {
+	// get reports whether the key is present, and marks it as used.
+	get(h uint64) bool
+
+	// set adds the key, or marks it as used, evicting keys over the capacity.
+	set(h uint64)
+
+	del(h uint64)
+	clear()
+}
+
+// shadowTracker replays the access trace of the cache against each simulated policy.
+// Expiration and deletion are replayed too, so only the capacity bound differs from the cache.
+type shadowTracker struct {
+	mu    sync.Mutex
+	sims  []shadowSim
+	stats []ShadowStats
+}
+
+func newShadowTracker(shadows []Shadow) *shadowTracker {
+	if len(shadows) == 0 {
+		return nil
+	}
+	t := &shadowTracker{
+		sims:  make([]shadowSim, len(shadows)),
+		stats: make([]ShadowStats, len(shadows)),
+	}
+	for i, s := range shadows {
+		if s.Policy == LFU {
+			t.sims[i] = newLFUSim(s.Size)
+		} else {
+			t.sims[i] = newLRUSim(s.Size)
+		}
+		t.stats[i].Shadow = s
+	}
+	return t
+}
+
+// trace replays an operation recorded with the given result on the key with hash h.
+func (t *shadowTracker) trace(op EventOp, h uint64, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, sim := range t.sims {
+		switch op {
+		case EventGet, EventRefresh:
+			if sim.get(h) {
+				t.stats[i].Hits++
+			} else {
+				t.stats[i].Misses++
+			}
+		case EventSet, EventLoad:
+			sim.set(h)
+		case EventCompute:
+			if ok {
+				sim.set(h)
+			} else {
+				sim.del(h)
+			}
+		case EventDelete, EventExpire:
+			sim.del(h)
+		case EventClear:
+			sim.clear()
+		}
+	}
+}
+
+func (t *shadowTracker) results() []ShadowStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ShadowStats(nil), t.stats...)
+}
+
+type lruSim struct {
+	size  int
+	order *list.List // front is the most recently used
+	keys  map[uint64]*list.Element
+}
+
+func newLRUSim(size int) *lruSim {
+	return &lruSim{
+		size:  size,
+		order: list.New(),
+		keys:  make(map[uint64]*list.Element),
+	}
+}
+
+func (s *lruSim) get(h uint64) bool {
+	e, ok := s.keys[h]
+	if ok {
+		s.order.MoveToFront(e)
+	}
+	return ok
+}
+
+func (s *lruSim) set(h uint64) {
+	if s.get(h) {
+		return
+	}
+	s.keys[h] = s.order.PushFront(h)
+	if s.size > 0 && s.order.Len() > s.size {
+		s.del(s.order.Back().Value.(uint64))
+	}
+}
+
+func (s *lruSim) del(h uint64) {
+	if e, ok := s.keys[h]; ok {
+		s.order.Remove(e)
+		delete(s.keys, h)
+	}
+}
+
+func (s *lruSim) clear() {
+	s.order.Init()
+	s.keys = make(map[uint64]*list.Element)
+}
+
+type lfuEntry struct {
+	h     uint64
+	freq  uint64
+	tick  uint64
+	index int
+}
+
+// lfuHeap is a min-heap ordered by frequency, then by last use.
+type lfuHeap []*lfuEntry
+
+func (q lfuHeap) Len() int { return len(q) }
+func (q lfuHeap) Less(i, j int) bool {
+	if q[i].freq != q[j].freq {
+		return q[i].freq < q[j].freq
+	}
+	return q[i].tick < q[j].tick
+}
+
+func (q lfuHeap) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *lfuHeap) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
+
+type lfuSim struct {
+	size  int
+	tick  uint64
+	queue lfuHeap
+	keys  map[uint64]*lfuEntry
+}
+
+func newLFUSim(size int) *lfuSim {
+	return &lfuSim{
+		size: size,
+		keys: make(map[uint64]*lfuEntry),
+	}
+}
+
+func (s *lfuSim) get(h uint64) bool {
+	e, ok := s.keys[h]
+	if ok {
+		s.tick++
+		e.freq++
+		e.tick = s.tick
+		heap.Fix(&s.queue, e.index)
+	}
+	return ok
+}
+
+func (s *lfuSim) set(h uint64) {
+	if s.get(h) {
+		return
+	}
+	if s.size > 0 && len(s.queue) >= s.size {
+		e := heap.Pop(&s.queue).(*lfuEntry)
+		delete(s.keys, e.h)
+	}
+	s.tick++
+	e := &lfuEntry{h: h, freq: 1, tick: s.tick}
+	heap.Push(&s.queue, e)
+	s.keys[h] = e
+}
+
+func (s *lfuSim) del(h uint64) {
+	if e, ok := s.keys[h]; ok {
+		heap.Remove(&s.queue, e.index)
+		delete(s.keys, h)
+	}
+}
+
+func (s *lfuSim) clear() {
+	s.queue = nil
+	s.keys = make(map[uint64]*lfuEntry)
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestShadowSim(t *testing.T) {
+	lru := newLRUSim(2)
+	lru.set(1)
+	lru.set(2)
+	lru.get(1)
+	lru.set(3) // evicts 2
+	if !lru.get(1) || lru.get(2) || !lru.get(3) {
+		t.Fatal("LRU should evict the least recently used key")
+	}
+
+	lfu := newLFUSim(2)
+	lfu.set(1)
+	lfu.get(1)
+	lfu.get(1)
+	lfu.set(2)
+	lfu.set(3) // evicts 2
+	if !lfu.get(1) || lfu.get(2) || !lfu.get(3) {
+		t.Fatal("LFU should evict the least frequently used key")
+	}
+	lfu.del(1)
+	if lfu.get(1) || len(lfu.queue) != 1 {
+		t.Fatal("LFU should delete the key")
+	}
+
+	unbounded := newLRUSim(0)
+	for i := uint64(0); i < 1000; i++ {
+		unbounded.set(i)
+	}
+	if unbounded.order.Len() != 1000 {
+		t.Fatalf("expected %d keys, got: %d", 1000, unbounded.order.Len())
+	}
+}
+
+func TestShadowStats_String(t *testing.T) {
+	s := ShadowStats{Shadow: Shadow{Policy: LRU, Size: 100000}, Hits: 91, Misses: 9}
+	if got := s.String(); got != "LRU@100000 entries would have hit 91.00%" {
+		t.Fatalf("unexpected summary: %s", got)
+	}
+	s = ShadowStats{Shadow: Shadow{Policy: LFU}}
+	if got := s.String(); got != "LFU@unbounded would have hit 0.00%" {
+		t.Fatalf("unexpected summary: %s", got)
+	}
+}
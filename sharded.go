@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"runtime"
+	"time"
+)
+
+// Sharded a cache partitioning the keys by hash across independent caches, the shards,
+// for the write heavy workloads where the buckets of a single cache are contended, e.g. by Compute.
+// The operations on a key go to its shard, see Shard for the operations not listed here.
+// Range, Items, Count, DeleteExpired and Clear cover all the shards, one after the other.
+// Sharded is ShardedOf[string, interface{}] whose shards are Cache, see ShardedOf.
+type Sharded struct {
+	*ShardedOf[string, interface{}]
+	shards []Cache
+}
+
+// NewSharded creates a cache of shards caches, rounded up to a power of 2,
+// GOMAXPROCS if shards is less than 1. The options apply to each shard, except that
+// the minimum capacity, the maximum number of items and the maximum cost are divided among
+// the shards, rounded up, and the shards are not registered, see WithRegistry.
+// The memory limit of WithMemoryLimit applies to each shard as is: the memory sampled is
+// the one of the process, over which each shard evicts a part of its items.
+// A single loop cleans the shards in turn, one every cleanup interval divided by the number
+// of shards, instead of all of them at each tick, and the shards run no cleanup loop of their own.
+// Likewise, WithPersistencePath and WithSnapshot persist a single snapshot of all the shards,
+// see SaveTo, which is restored whatever the number of shards.
+func NewSharded(shards int, opts ...Option) *Sharded {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	s := &Sharded{}
+	s.ShardedOf = newShardedOf(shards, configOf(configDefault(cfg)),
+		func(cfg ...ConfigOf[string, interface{}]) CacheOf[string, interface{}] {
+			public, view := newXsyncMapViews(cfg[0])
+			s.shards = append(s.shards, public)
+			return view
+		})
+	return s
+}
+
+// shardCount returns n rounded up to a power of 2, GOMAXPROCS if n is less than 1.
+func shardCount(n int) int {
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	count := 1
+	for count < n {
+		count <<= 1
+	}
+	return count
+}
+
+// shardLimit returns the limit of each of n shards for the limit of the cache, rounded up
+// so that the shards of a bounded cache are bounded, 0 if the cache is unbounded.
+func shardLimit(limit int64, n int) int64 {
+	if limit <= 0 {
+		return limit
+	}
+	return (limit + int64(n) - 1) / int64(n)
+}
+
+// Shard returns the shard of the key.
+func (s *Sharded) Shard(k string) Cache {
+	if s.normalize != nil {
+		k = s.normalize(k)
+	}
+	return s.shards[s.hasher(k, s.seed)&s.mask]
+}
+
+// Shards returns the shards, e.g. to call an operation on all of them.
+func (s *Sharded) Shards() []Cache {
+	return s.shards
+}
+
+// Compute see Cache.Compute.
+func (s *Sharded) Compute(
+	k string,
+	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, delete bool),
+	d time.Duration,
+) (interface{}, bool) {
+	return s.Shard(k).Compute(k, valueFn, d)
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharded(t *testing.T) {
+	s := NewSharded(3, WithCleanupInterval(0))
+	defer s.Close()
+
+	if n := len(s.Shards()); n != 4 {
+		t.Fatalf("expected 4 shards, got %d", n)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Compute("counter", func(old interface{}, loaded bool) (interface{}, bool) {
+					if !loaded {
+						return 1, false
+					}
+					return old.(int) + 1, false
+				}, NoExpiration)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		s.SetForever(strconv.Itoa(i), i)
+	}
+	wg.Wait()
+	if v, ok := s.Get("counter"); !ok || v != 800 {
+		t.Fatalf("expected 800, got %v", v)
+	}
+	if v, ok := s.Shard("42").Get("42"); !ok || v != 42 {
+		t.Fatalf("expected the key in its shard, got %v", v)
+	}
+	if n := s.Count(); n != 101 {
+		t.Fatalf("expected 101 items, got %d", n)
+	}
+	if n := len(s.Items()); n != 101 {
+		t.Fatalf("expected 101 items, got %d", n)
+	}
+
+	n := 0
+	s.Range(func(k string, v interface{}) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("expected the range to stop after 10 items, got %d", n)
+	}
+
+	s.Set("expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	s.DeleteExpired()
+	if n := s.Count(); n != 101 {
+		t.Fatalf("expected the expired item to be deleted, got %d items", n)
+	}
+	s.Clear()
+	if n := s.Count(); n != 0 {
+		t.Fatalf("expected no items, got %d", n)
+	}
+}
+
+func TestSharded_Cleanup(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	s := NewSharded(4, WithClock(clock), WithCleanupInterval(4*time.Second))
+	defer s.Close()
+	for i, c := range s.Shards() {
+		if c.(*xsyncMapWrapper).cleanupStarted {
+			t.Fatalf("expected the shard %d to run no cleanup loop", i)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		s.Set(strconv.Itoa(i), i, time.Second)
+	}
+	counts := make([]int, 4)
+	for i, c := range s.Shards() {
+		counts[i] = c.Count()
+	}
+	clock.Advance(time.Second + 1)
+	for i := 0; s.Shards()[0].Count() != 0; i++ {
+		if i == 100 {
+			t.Fatal("expected the first shard to be cleaned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i, c := range s.Shards()[1:] {
+		if n := c.Count(); n != counts[i+1] {
+			t.Fatalf("expected the shard %d to be cleaned later, got %d items instead of %d", i+1, n, counts[i+1])
+		}
+	}
+}
+
+func TestSharded_Limits(t *testing.T) {
+	s := NewSharded(4, WithCleanupInterval(0), WithMaxEntries(100))
+	defer s.Close()
+	for i := 0; i < 1000; i++ {
+		s.SetForever(strconv.Itoa(i), i)
+	}
+	if n := s.Count(); n == 0 || n > 100 {
+		t.Fatalf("expected at most 100 items across the shards, got %d", n)
+	}
+
+	s = NewSharded(4, WithCleanupInterval(0), WithMaxCost(100))
+	defer s.Close()
+	for i := 0; i < 1000; i++ {
+		k := strconv.Itoa(i)
+		s.Shard(k).SetWithCost(k, i, NoExpiration, 10)
+	}
+	if n := s.Count(); n == 0 || n > 10 {
+		t.Fatalf("expected at most a cost of 100 across the shards, got %d items of cost 10", n)
+	}
+
+	// a bounded cache is never divided into unbounded shards
+	s = NewSharded(4, WithCleanupInterval(0), WithMaxEntries(2))
+	defer s.Close()
+	for i := 0; i < 1000; i++ {
+		s.SetForever(strconv.Itoa(i), i)
+	}
+	if n := s.Count(); n == 0 || n > 4 {
+		t.Fatalf("expected at most 1 item per shard, got %d", n)
+	}
+}
+
+func TestSharded_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	s := NewSharded(4, WithPersistencePath(path))
+	for i := 0; i < 100; i++ {
+		s.SetForever(strconv.Itoa(i), strconv.Itoa(i))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// restored whatever the number of shards, and by a cache
+	s2 := NewSharded(2, WithPersistencePath(path))
+	c := New(WithPersistencePath(path))
+	for i := 0; i < 100; i++ {
+		k := strconv.Itoa(i)
+		if v, ok := s2.Get(k); !ok || v != k {
+			t.Fatalf("expected %s to be restored in its shard, got %v, %v", k, v, ok)
+		}
+		if v, ok := c.Get(k); !ok || v != k {
+			t.Fatalf("expected %s to be restored in the cache, got %v, %v", k, v, ok)
+		}
+	}
+	_ = c.Close()
+	_ = s2.Close()
+
+	// an invalid snapshot is reported, and not saved over
+	if err := os.WriteFile(path, []byte("invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	s5 := NewSharded(2, WithPersistencePath(path), WithPersistenceErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	s5.SetForever("a", "a")
+	if err := s5.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(path); len(errs) != 1 || string(data) != "invalid" {
+		t.Fatalf("expected the invalid snapshot to be reported and kept, got %v, %q", errs, data)
+	}
+
+	w := &testSnapshotWriter{}
+	s3 := NewShardedOf[int, int](4, WithSnapshotOf[int, int](0, func() (io.WriteCloser, error) {
+		if w.Len() > 0 {
+			return nil, errors.New("unexpected snapshot")
+		}
+		return w, nil
+	}))
+	s3.SetForever(1, 1)
+	s3.SetForever(2, 2)
+	if err := s3.Close(); err != nil || !w.closed {
+		t.Fatalf("expected a single snapshot, got %v", err)
+	}
+	s4 := NewShardedOf[int, int](8)
+	defer s4.Close()
+	if err := s4.LoadFrom(&w.Buffer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[int]int{1: 1, 2: 2}; !reflect.DeepEqual(s4.Items(), want) {
+		t.Fatalf("expected %v, got %v", want, s4.Items())
+	}
+}
+
+func TestSharded_Finalizer(t *testing.T) {
+	base := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		NewSharded(4, WithCleanupInterval(time.Millisecond)).Set("a", 1, NoExpiration)
+		NewShardedOf[int, int](4, WithCleanupIntervalOf[int, int](time.Millisecond)).Set(1, 1, NoExpiration)
+	}
+	for i := 0; runtime.NumGoroutine() > base; i++ {
+		if i == 100 {
+			t.Fatalf("expected the unclosed sharded caches to be collected, got %d goroutines instead of %d",
+				runtime.NumGoroutine(), base)
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,394 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/fufuok/cache/internal/xsync"
+)
+
+// ShardedOf a cache partitioning the keys by hash across independent caches, the shards,
+// for the write heavy workloads where the buckets of a single cache are contended, e.g. by Compute.
+// The operations on a key go to its shard, see Shard for the operations not listed here.
+// Range, Items, Count, DeleteExpired and Clear cover all the shards, one after the other.
+type ShardedOf[K comparable, V any] struct {
+	*shardedOf[K, V]
+}
+
+// shardedOf the shards of ShardedOf, referenced by its cleanup loop,
+// so that a ShardedOf never closed is collected, see NewShardedOf.
+type shardedOf[K comparable, V any] struct {
+	shards          []CacheOf[K, V]
+	cores           []*xsyncMapOf[K, V] // the caches of the shards, for the snapshots
+	mask            uint64
+	hasher          func(K, uint64) uint64
+	normalize       func(K) K
+	seed            uint64
+	snapshotFormat  SnapshotFormat
+	codec           Codec
+	persistencePath string
+	persistenceSave bool // see xsyncMapOf
+	snapshotWriter  SnapshotWriterFactory
+	stop            chan struct{}
+	once            sync.Once
+	wg              sync.WaitGroup
+}
+
+// NewShardedOf creates a cache of shards caches, rounded up to a power of 2,
+// GOMAXPROCS if shards is less than 1. The options apply to each shard, except that
+// the minimum capacity, the maximum number of items and the maximum cost are divided among
+// the shards, rounded up, and the shards are not registered, see WithRegistryOf.
+// The memory limit of WithMemoryLimitOf applies to each shard as is: the memory sampled is
+// the one of the process, over which each shard evicts a part of its items.
+// A single loop cleans the shards in turn, one every cleanup interval divided by the number
+// of shards, instead of all of them at each tick, and the shards run no cleanup loop of their own.
+// Likewise, WithPersistencePathOf and WithSnapshotOf persist a single snapshot of all the shards,
+// see SaveTo, which is restored whatever the number of shards.
+func NewShardedOf[K comparable, V any](shards int, opts ...OptionOf[K, V]) *ShardedOf[K, V] {
+	cfg := DefaultConfigOf[K, V]()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return newShardedOf(shards, configDefaultOf[K, V](cfg), newXsyncMapOf[K, V])
+}
+
+// newShardedOf creates the cache of the shards created by newShard, see NewShardedOf and NewSharded.
+// Like the caches, it is closed once collected, unless NoFinalizer is set.
+func newShardedOf[K comparable, V any](
+	shards int,
+	cfg ConfigOf[K, V],
+	newShard func(cfg ...ConfigOf[K, V]) CacheOf[K, V],
+) *ShardedOf[K, V] {
+	n := shardCount(shards)
+	cfg.MinCapacity /= n
+	cfg.MaxEntries = int(shardLimit(int64(cfg.MaxEntries), n))
+	cfg.MaxCost = shardLimit(cfg.MaxCost, n)
+	cfg.Registry = nil
+	// a single loop cleans the shards in turn, see cleanup
+	interval := cfg.CleanupInterval
+	cfg.CleanupInterval = 0
+	// a single snapshot covers the shards, see SaveTo
+	snapshotInterval := cfg.SnapshotInterval
+	cfg.SnapshotInterval = 0
+	s := &shardedOf[K, V]{
+		shards:          make([]CacheOf[K, V], n),
+		cores:           make([]*xsyncMapOf[K, V], n),
+		mask:            uint64(n - 1),
+		hasher:          xsync.DefaultHasher[K](),
+		seed:            xsync.MakeSeed(),
+		snapshotFormat:  cfg.SnapshotFormat,
+		codec:           cfg.Codec,
+		persistencePath: cfg.PersistencePath,
+		snapshotWriter:  cfg.SnapshotWriter,
+		stop:            make(chan struct{}),
+	}
+	persistenceErrorHandler := cfg.PersistenceErrorHandler
+	cfg.PersistencePath = ""
+	cfg.PersistenceErrorHandler = nil
+	cfg.SnapshotWriter = nil
+	if cfg.Hasher != nil {
+		s.hasher = cfg.Hasher
+	}
+	s.normalize = cfg.KeyNormalizer
+	for i := range s.shards {
+		s.shards[i] = newShard(cfg)
+		s.cores[i] = coreOf(s.shards[i])
+	}
+	sharded := &ShardedOf[K, V]{s}
+	if s.persistencePath != "" {
+		s.persistenceSave = restoreFile(s.persistencePath, sharded.LoadFromFile, persistenceErrorHandler)
+	}
+	if interval > 0 {
+		s.cleanup(cfg.Clock, interval)
+	}
+	if snapshotInterval > 0 && s.snapshotWriter != nil {
+		s.snapshot(cfg.Clock, snapshotInterval)
+	}
+	if !cfg.NoFinalizer {
+		runtime.SetFinalizer(sharded, func(s *ShardedOf[K, V]) { _ = s.Close() })
+	}
+	return sharded
+}
+
+// cleanup deletes the expired items of one shard every interval divided by the number of shards,
+// so each shard is cleaned once per interval, and the shards are not all cleaned in one burst.
+func (s *shardedOf[K, V]) cleanup(clock Clock, interval time.Duration) {
+	step := interval / time.Duration(len(s.shards))
+	if step <= 0 {
+		step = 1
+	}
+	ticker := clock.NewTicker(step)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer ticker.Stop()
+		for i := 0; ; i = (i + 1) % len(s.shards) {
+			select {
+			case <-ticker.Chan():
+				s.shards[i].DeleteExpired()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// snapshot writes a snapshot of the shards every interval, see WithSnapshotOf.
+func (s *shardedOf[K, V]) snapshot(clock Clock, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.Chan():
+				// failed snapshots are retried on the next tick
+				_ = saveSnapshot(s.snapshotWriter, s.saveTo)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// coreOf returns the cache of the shard c created by newXsyncMapOf or newXsyncMapViews.
+func coreOf[K comparable, V any](c CacheOf[K, V]) *xsyncMapOf[K, V] {
+	if n, ok := c.(*normalizedOf[K, V]); ok {
+		c = n.CacheOf
+	}
+	return c.(*xsyncMapOfWrapper[K, V]).xsyncMapOf
+}
+
+// Shard returns the shard of the key.
+func (s *ShardedOf[K, V]) Shard(k K) CacheOf[K, V] {
+	if s.normalize != nil {
+		k = s.normalize(k)
+	}
+	return s.shards[s.hasher(k, s.seed)&s.mask]
+}
+
+// Shards returns the shards, e.g. to call an operation on all of them.
+func (s *ShardedOf[K, V]) Shards() []CacheOf[K, V] {
+	return s.shards
+}
+
+// Get see CacheOf.Get.
+func (s *ShardedOf[K, V]) Get(k K) (V, bool) {
+	return s.Shard(k).Get(k)
+}
+
+// GetWithTTL see CacheOf.GetWithTTL.
+func (s *ShardedOf[K, V]) GetWithTTL(k K) (V, time.Duration, bool) {
+	return s.Shard(k).GetWithTTL(k)
+}
+
+// Set see CacheOf.Set.
+func (s *ShardedOf[K, V]) Set(k K, v V, d time.Duration) {
+	s.Shard(k).Set(k, v, d)
+}
+
+// SetDefault see CacheOf.SetDefault.
+func (s *ShardedOf[K, V]) SetDefault(k K, v V) {
+	s.Shard(k).SetDefault(k, v)
+}
+
+// SetForever see CacheOf.SetForever.
+func (s *ShardedOf[K, V]) SetForever(k K, v V) {
+	s.Shard(k).SetForever(k, v)
+}
+
+// GetOrSet see CacheOf.GetOrSet.
+func (s *ShardedOf[K, V]) GetOrSet(k K, v V, d time.Duration) (V, bool) {
+	return s.Shard(k).GetOrSet(k, v, d)
+}
+
+// GetOrCompute see CacheOf.GetOrCompute.
+func (s *ShardedOf[K, V]) GetOrCompute(k K, valueFn func() V, d time.Duration) (V, bool) {
+	return s.Shard(k).GetOrCompute(k, valueFn, d)
+}
+
+// Compute see CacheOf.Compute.
+func (s *ShardedOf[K, V]) Compute(
+	k K,
+	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
+	d time.Duration,
+) (V, bool) {
+	return s.Shard(k).Compute(k, valueFn, d)
+}
+
+// ComputeWithOp see CacheOf.ComputeWithOp.
+func (s *ShardedOf[K, V]) ComputeWithOp(
+	k K,
+	valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
+	d time.Duration,
+) (V, bool) {
+	return s.Shard(k).ComputeWithOp(k, valueFn, d)
+}
+
+// Delete see CacheOf.Delete.
+func (s *ShardedOf[K, V]) Delete(k K) {
+	s.Shard(k).Delete(k)
+}
+
+// GetAndDelete see CacheOf.GetAndDelete.
+func (s *ShardedOf[K, V]) GetAndDelete(k K) (V, bool) {
+	return s.Shard(k).GetAndDelete(k)
+}
+
+// Range calls f sequentially for each key and value present in the shards, one after the other.
+// If f returns false, range stops the iteration.
+func (s *ShardedOf[K, V]) Range(f func(k K, v V) bool) {
+	for _, c := range s.shards {
+		next := true
+		c.Range(func(k K, v V) bool {
+			next = f(k, v)
+			return next
+		})
+		if !next {
+			return
+		}
+	}
+}
+
+// Items return the items in the shards, see CacheOf.Items.
+func (s *ShardedOf[K, V]) Items() map[K]V {
+	items := make(map[K]V, s.Count())
+	s.Range(func(k K, v V) bool {
+		items[k] = v
+		return true
+	})
+	return items
+}
+
+// Count returns the number of items in the shards, see CacheOf.Count.
+func (s *ShardedOf[K, V]) Count() int {
+	n := 0
+	for _, c := range s.shards {
+		n += c.Count()
+	}
+	return n
+}
+
+// DeleteExpired deletes the expired items from the shards.
+func (s *ShardedOf[K, V]) DeleteExpired() {
+	for _, c := range s.shards {
+		c.DeleteExpired()
+	}
+}
+
+// Clear deletes all the items from the shards.
+func (s *ShardedOf[K, V]) Clear() {
+	for _, c := range s.shards {
+		c.Clear()
+	}
+}
+
+// SaveTo writes a snapshot of the unexpired items in the shards to w, one shard after the other,
+// in the format of CacheOf.SaveTo: it can be loaded by a cache, or by a sharded cache
+// of any number of shards.
+func (s *ShardedOf[K, V]) SaveTo(w io.Writer) error {
+	return s.saveTo(w)
+}
+
+func (s *shardedOf[K, V]) saveTo(w io.Writer) error {
+	var (
+		buf   bytes.Buffer
+		count int
+	)
+	enc := newSnapshotEncoder(s.snapshotFormat, s.codec, &buf)
+	for _, c := range s.cores {
+		if err := c.guard.err(); err != nil {
+			return err
+		}
+		n, err := c.encodeItems(enc)
+		if err != nil {
+			return err
+		}
+		count += n
+	}
+	return writeSnapshot(w, s.snapshotFormat, typeName[K](), typeName[V](), count, buf.Bytes())
+}
+
+// LoadFrom reads a snapshot written by SaveTo, or by CacheOf.SaveTo, from r,
+// and stores its unexpired items in their shards, see CacheOf.LoadFrom.
+func (s *ShardedOf[K, V]) LoadFrom(r io.Reader, strategy ...LoadStrategy) error {
+	h, payload, err := readSnapshot(r, typeName[K](), typeName[V]())
+	if err != nil {
+		return err
+	}
+	var items []snapshotItemOf[K, V]
+	err = decodeSnapshot(h, s.codec, payload, func(dec snapshotDecoder) error {
+		var x snapshotItemOf[K, V]
+		if err := dec.Decode(&x); err != nil {
+			return err
+		}
+		items = append(items, x)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ls := loadStrategy(strategy)
+	for _, x := range items {
+		k := x.K
+		if s.normalize != nil {
+			k = s.normalize(k)
+		}
+		c := s.cores[s.hasher(k, s.seed)&s.mask]
+		if err := c.guard.err(); err != nil {
+			return err
+		}
+		c.load(k, itemOf[V]{v: x.V, e: x.E}, ls, c.now())
+	}
+	return nil
+}
+
+// SaveToFile writes a snapshot of the unexpired items in the shards to the file at path, see SaveTo.
+// The snapshot is written to a temporary file renamed to path once complete,
+// so path always holds a complete snapshot.
+func (s *ShardedOf[K, V]) SaveToFile(path string) error {
+	return writeFileAtomic(path, s.saveTo)
+}
+
+// LoadFromFile reads a snapshot written by SaveToFile or SaveTo from the file at path,
+// see LoadFrom.
+func (s *ShardedOf[K, V]) LoadFromFile(path string, strategy ...LoadStrategy) error {
+	return readFile(path, func(r io.Reader) error {
+		return s.LoadFrom(r, strategy...)
+	})
+}
+
+// Close stops the cleanup of the shards, saves their snapshot with WithPersistencePathOf
+// or WithSnapshotOf, closes them, and returns the first error.
+func (s *ShardedOf[K, V]) Close() error {
+	first := false
+	s.once.Do(func() {
+		close(s.stop)
+		first = true
+	})
+	s.wg.Wait()
+	var err error
+	if first {
+		if s.persistenceSave {
+			err = s.SaveToFile(s.persistencePath)
+		}
+		if s.snapshotWriter != nil {
+			if serr := saveSnapshot(s.snapshotWriter, s.saveTo); err == nil {
+				err = serr
+			}
+		}
+	}
+	for _, c := range s.shards {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
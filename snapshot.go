@@ -0,0 +1,292 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// SnapshotVersion the current version of the snapshot format written by SaveTo.
+const SnapshotVersion uint16 = 1
+
+// SnapshotFormat the encoding of the items in a snapshot, see WithSnapshotFormat.
+type SnapshotFormat uint8
+
+const (
+	// SnapshotJSON encodes the items as JSON, numbers in interface{} values are restored as float64.
+	SnapshotJSON SnapshotFormat = iota
+
+	// SnapshotGob encodes the items with encoding/gob, which is more compact and faster
+	// for large caches, and keeps the concrete types of the values.
+	// Custom types stored in interface{} values must be registered with gob.Register.
+	SnapshotGob
+
+	// SnapshotCodec encodes each item with the Codec of the cache, see WithCodec.
+	// The snapshot can only be loaded by a cache with the same codec.
+	SnapshotCodec
+)
+
+func (f SnapshotFormat) String() string {
+	switch f {
+	case SnapshotJSON:
+		return "json"
+	case SnapshotGob:
+		return "gob"
+	case SnapshotCodec:
+		return "codec"
+	default:
+		return "unknown"
+	}
+}
+
+// snapshotMagic identifies a cache snapshot.
+var snapshotMagic = [4]byte{'F', 'C', 'S', 'S'}
+
+var (
+	// ErrSnapshotFormat the data is not a snapshot, or it is truncated or corrupted.
+	ErrSnapshotFormat = errors.New("cache: invalid snapshot format")
+
+	// ErrSnapshotVersion the snapshot was written with an unsupported format version.
+	ErrSnapshotVersion = errors.New("cache: unsupported snapshot version")
+
+	// ErrSnapshotType the key or value type of the snapshot does not match the cache.
+	ErrSnapshotType = errors.New("cache: snapshot type mismatch")
+
+	// ErrSnapshotChecksum the snapshot payload does not match its checksum.
+	ErrSnapshotChecksum = errors.New("cache: snapshot checksum mismatch")
+)
+
+// SnapshotHeader the header written at the beginning of each snapshot.
+type SnapshotHeader struct {
+	// Version the snapshot format version.
+	Version uint16
+
+	// Format the encoding of the items.
+	Format SnapshotFormat
+
+	// KeyType the name of the key type, e.g. "string".
+	KeyType string
+
+	// ValueType the name of the value type, e.g. "interface {}".
+	ValueType string
+
+	// Count the number of items in the snapshot.
+	Count uint64
+
+	// CreatedAt the time the snapshot was created.
+	CreatedAt time.Time
+
+	// Size the size of the payload following the header, in bytes.
+	Size uint64
+
+	// Checksum the CRC-32 (IEEE) checksum of the payload.
+	Checksum uint32
+}
+
+// ReadSnapshotHeader reads and validates the header of a snapshot from r,
+// leaving r positioned at the beginning of the payload.
+func ReadSnapshotHeader(r io.Reader) (SnapshotHeader, error) {
+	var h SnapshotHeader
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || magic != snapshotMagic {
+		return h, ErrSnapshotFormat
+	}
+	if err := binary.Read(r, binary.BigEndian, &h.Version); err != nil {
+		return h, ErrSnapshotFormat
+	}
+	if h.Version != SnapshotVersion {
+		return h, fmt.Errorf("%w: %d", ErrSnapshotVersion, h.Version)
+	}
+	if err := binary.Read(r, binary.BigEndian, &h.Format); err != nil {
+		return h, ErrSnapshotFormat
+	}
+	if h.Format > SnapshotCodec {
+		return h, fmt.Errorf("%w: unknown format %d", ErrSnapshotFormat, h.Format)
+	}
+
+	var err error
+	if h.KeyType, err = readSnapshotString(r); err != nil {
+		return h, err
+	}
+	if h.ValueType, err = readSnapshotString(r); err != nil {
+		return h, err
+	}
+	var fixed struct {
+		Count     uint64
+		CreatedAt int64
+		Size      uint64
+		Checksum  uint32
+	}
+	if err = binary.Read(r, binary.BigEndian, &fixed); err != nil {
+		return h, ErrSnapshotFormat
+	}
+	h.Count = fixed.Count
+	h.CreatedAt = time.Unix(0, fixed.CreatedAt)
+	h.Size = fixed.Size
+	h.Checksum = fixed.Checksum
+	return h, nil
+}
+
+func readSnapshotString(r io.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", ErrSnapshotFormat
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", ErrSnapshotFormat
+	}
+	return string(b), nil
+}
+
+func writeSnapshotString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.BigEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// writeSnapshot writes the header for the payload to w, followed by the payload.
+func writeSnapshot(w io.Writer, f SnapshotFormat, keyType, valueType string, count int, payload []byte) error {
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic[:])
+	_ = binary.Write(&buf, binary.BigEndian, SnapshotVersion)
+	buf.WriteByte(byte(f))
+	_ = writeSnapshotString(&buf, keyType)
+	_ = writeSnapshotString(&buf, valueType)
+	_ = binary.Write(&buf, binary.BigEndian, struct {
+		Count     uint64
+		CreatedAt int64
+		Size      uint64
+		Checksum  uint32
+	}{
+		Count:     uint64(count),
+		CreatedAt: time.Now().UnixNano(),
+		Size:      uint64(len(payload)),
+		Checksum:  crc32.ChecksumIEEE(payload),
+	})
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readSnapshot reads a snapshot from r, checking it against the key and value types of the cache,
+// and returns its header and verified payload.
+func readSnapshot(r io.Reader, keyType, valueType string) (SnapshotHeader, []byte, error) {
+	h, err := ReadSnapshotHeader(r)
+	if err != nil {
+		return h, nil, err
+	}
+	if h.KeyType != keyType || h.ValueType != valueType {
+		return h, nil, fmt.Errorf("%w: snapshot [%s]%s, cache [%s]%s",
+			ErrSnapshotType, h.KeyType, h.ValueType, keyType, valueType)
+	}
+	// grows as data arrives, a corrupted size cannot cause a huge allocation
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(io.LimitReader(r, int64(h.Size))); err != nil {
+		return h, nil, err
+	}
+	if uint64(buf.Len()) != h.Size {
+		return h, nil, ErrSnapshotFormat
+	}
+	payload := buf.Bytes()
+	if crc32.ChecksumIEEE(payload) != h.Checksum {
+		return h, nil, ErrSnapshotChecksum
+	}
+	return h, payload, nil
+}
+
+// snapshotEncoder encodes the items of a snapshot, implemented by json.Encoder and gob.Encoder.
+type snapshotEncoder interface {
+	Encode(v interface{}) error
+}
+
+// snapshotDecoder decodes the items of a snapshot, implemented by json.Decoder and gob.Decoder.
+type snapshotDecoder interface {
+	Decode(v interface{}) error
+}
+
+// newSnapshotEncoder returns the encoder of the format, codec is only used by SnapshotCodec.
+// Its errors wrap ErrSerialization.
+func newSnapshotEncoder(f SnapshotFormat, codec Codec, w io.Writer) snapshotEncoder {
+	switch f {
+	case SnapshotGob:
+		return serializingEncoder{gob.NewEncoder(w)}
+	case SnapshotCodec:
+		return serializingEncoder{codecEncoder{codec: codec, w: w}}
+	default:
+		return serializingEncoder{json.NewEncoder(w)}
+	}
+}
+
+// serializingEncoder wraps the errors of the encoder with ErrSerialization.
+type serializingEncoder struct {
+	enc snapshotEncoder
+}
+
+func (e serializingEncoder) Encode(v interface{}) error {
+	if err := e.enc.Encode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrSerialization, err)
+	}
+	return nil
+}
+
+// newSnapshotDecoder returns the decoder of the format, codec is only used by SnapshotCodec.
+func newSnapshotDecoder(f SnapshotFormat, codec Codec, r io.Reader) snapshotDecoder {
+	switch f {
+	case SnapshotGob:
+		return gob.NewDecoder(r)
+	case SnapshotCodec:
+		return codecDecoder{codec: codec, r: bufio.NewReader(r)}
+	default:
+		return json.NewDecoder(r)
+	}
+}
+
+// decodeSnapshot decodes the items of the payload with decode until the end of the payload,
+// and checks their number against the header.
+func decodeSnapshot(
+	h SnapshotHeader,
+	codec Codec,
+	payload []byte,
+	decode func(dec snapshotDecoder) error,
+) error {
+	dec := newSnapshotDecoder(h.Format, codec, bytes.NewReader(payload))
+	var n uint64
+	for {
+		err := decode(dec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSnapshotFormat, err)
+		}
+		n++
+	}
+	if n != h.Count {
+		return fmt.Errorf("%w: expected %d items, got %d", ErrSnapshotFormat, h.Count, n)
+	}
+	return nil
+}
+
+// snapshotItem an item in the payload of a snapshot written by Cache.
+type snapshotItem struct {
+	K string      `json:"k"`
+	V interface{} `json:"v"`
+	E int64       `json:"e"`
+}
+
+const (
+	snapshotKeyType   = "string"
+	snapshotValueType = "interface {}"
+)
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSnapshot_Corrupted(t *testing.T) {
+	c := New()
+	c.SetForever("a", 1)
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := buf.Bytes()
+
+	corrupt := func(f func(b []byte) []byte) error {
+		b := f(append([]byte(nil), data...))
+		return New().LoadFrom(bytes.NewReader(b))
+	}
+	tests := []struct {
+		name string
+		f    func(b []byte) []byte
+		want error
+	}{
+		{"empty", func(b []byte) []byte { return nil }, ErrSnapshotFormat},
+		{"magic", func(b []byte) []byte { b[0] = 'X'; return b }, ErrSnapshotFormat},
+		{"version", func(b []byte) []byte {
+			binary.BigEndian.PutUint16(b[4:], SnapshotVersion+1)
+			return b
+		}, ErrSnapshotVersion},
+		{"truncated header", func(b []byte) []byte { return b[:12] }, ErrSnapshotFormat},
+		{"truncated payload", func(b []byte) []byte { return b[:len(b)-1] }, ErrSnapshotFormat},
+		{"checksum", func(b []byte) []byte { b[len(b)-2]++; return b }, ErrSnapshotChecksum},
+	}
+	for _, tt := range tests {
+		if err := corrupt(tt.f); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: expected %v, got: %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestSnapshot_Gob(t *testing.T) {
+	c := New(WithSnapshotFormat(SnapshotGob))
+	c.SetForever("a", 1)
+	c.SetForever("b", "2")
+	c.SetForever("c", nil)
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h, err := ReadSnapshotHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil || h.Format != SnapshotGob || h.Version != SnapshotVersion {
+		t.Fatalf("unexpected header: %+v, %v", h, err)
+	}
+
+	// the snapshot format is read from the header
+	c2 := New()
+	if err = c2.LoadFrom(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"a": 1, "b": "2", "c": nil}
+	if got := c2.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+}
+
+// countingCodec a custom codec counting the values it marshals.
+type countingCodec struct {
+	Codec
+	n *int
+}
+
+func (c countingCodec) Marshal(v interface{}) ([]byte, error) {
+	*c.n++
+	return c.Codec.Marshal(v)
+}
+
+func TestSnapshot_Codec(t *testing.T) {
+	c := New(WithCodec(GobCodec))
+	c.SetForever("a", 1)
+	var buf bytes.Buffer
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h, err := ReadSnapshotHeader(&buf); err != nil || h.Format != SnapshotGob {
+		t.Fatalf("expected the gob format, got %+v, %v", h, err)
+	}
+
+	var n int
+	codec := countingCodec{Codec: GobCodec, n: &n}
+	c = New(WithCodec(codec))
+	c.SetForever("a", 1)
+	c.SetForever("b", "2")
+	buf.Reset()
+	if err := c.SaveTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected the codec to encode 2 items, got %d", n)
+	}
+	data := buf.Bytes()
+	if h, err := ReadSnapshotHeader(bytes.NewReader(data)); err != nil || h.Format != SnapshotCodec {
+		t.Fatalf("expected the codec format, got %+v, %v", h, err)
+	}
+
+	c2 := New(WithCodec(codec))
+	if err := c2.LoadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"a": 1, "b": "2"}
+	if got := c2.Items(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got: %v", want, got)
+	}
+	if err := New().LoadFrom(bytes.NewReader(data)); !errors.Is(err, ErrSnapshotFormat) {
+		t.Fatalf("expected ErrSnapshotFormat without the codec, got: %v", err)
+	}
+}
+
+func TestSnapshot_ExportGzip(t *testing.T) {
+	n := 2*ExportChunkSize + 1
+	c := New(WithSnapshotFormat(SnapshotGob))
+	for i := 0; i < n; i++ {
+		c.SetForever(strconv.Itoa(i), i)
+	}
+	c.Set("expired", 1, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	var buf bytes.Buffer
+	if err := c.ExportGzip(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c2 := New()
+	if err := c2.ImportGzip(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := c2.Count(); got != n {
+		t.Fatalf("expected %d items, got %d", n, got)
+	}
+	if v, ok := c2.Get(strconv.Itoa(n - 1)); !ok || v != n-1 {
+		t.Fatalf("expected %d, got %v", n-1, v)
+	}
+
+	// the chunks before the corrupted one are stored
+	data := buf.Bytes()
+	var zbuf bytes.Buffer
+	zr, _ := gzip.NewReader(bytes.NewReader(data))
+	_, _ = zbuf.ReadFrom(zr)
+	raw := append([]byte(nil), zbuf.Bytes()...)
+	raw[len(raw)-2]++
+	zbuf.Reset()
+	zw := gzip.NewWriter(&zbuf)
+	_, _ = zw.Write(raw)
+	_ = zw.Close()
+	c3 := New()
+	if err := c3.ImportGzip(&zbuf); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Fatalf("expected ErrSnapshotChecksum, got: %v", err)
+	}
+	if got := c3.Count(); got != 2*ExportChunkSize {
+		t.Fatalf("expected the first 2 chunks to be stored, got %d items", got)
+	}
+	if err := New().ImportGzip(bytes.NewReader(raw)); !errors.Is(err, ErrSnapshotFormat) {
+		t.Fatalf("expected ErrSnapshotFormat without gzip, got: %v", err)
+	}
+}
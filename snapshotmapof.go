@@ -0,0 +1,54 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync/atomic"
+)
+
+// Snapshot an immutable map replaced as a whole, e.g. a configuration reloaded every minute,
+// whose reads only load an atomic pointer, for a higher read throughput than a cache.
+// Unlike a cache, its items do not expire and are not evicted.
+type Snapshot[K comparable, V any] struct {
+	m atomic.Value // map[K]V, atomic.Pointer needs Go 1.19
+}
+
+// NewSnapshot returns a snapshot of m, which must not be modified afterwards.
+func NewSnapshot[K comparable, V any](m map[K]V) *Snapshot[K, V] {
+	s := &Snapshot[K, V]{}
+	s.ReplaceAll(m)
+	return s
+}
+
+// Get returns the value of the key, and whether it was found.
+func (s *Snapshot[K, V]) Get(k K) (V, bool) {
+	v, ok := s.load()[k]
+	return v, ok
+}
+
+// ReplaceAll replaces all the items by those of m atomically, m must not be modified afterwards.
+// The reads see either all the previous items or all the items of m.
+func (s *Snapshot[K, V]) ReplaceAll(m map[K]V) {
+	s.m.Store(m)
+}
+
+// load returns the current items.
+func (s *Snapshot[K, V]) load() map[K]V {
+	return s.m.Load().(map[K]V)
+}
+
+// Count returns the number of items.
+func (s *Snapshot[K, V]) Count() int {
+	return len(s.load())
+}
+
+// Range calls f sequentially for each key and value of the snapshot, until f returns false.
+// The items replaced meanwhile by ReplaceAll are not seen.
+func (s *Snapshot[K, V]) Range(f func(k K, v V) bool) {
+	for k, v := range s.load() {
+		if !f(k, v) {
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := NewSnapshot(map[string]int{"a": 1, "b": 2})
+	if v, ok := s.Get("a"); !ok || v != 1 {
+		t.Fatalf("unexpected result: %v, %v", v, ok)
+	}
+	if n := s.Count(); n != 2 {
+		t.Fatalf("expected 2 items, got %d", n)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if n := s.Count(); n != 1 && n != 2 {
+				t.Errorf("unexpected count: %d", n)
+			}
+		}
+	}()
+	s.ReplaceAll(map[string]int{"c": 3})
+	wg.Wait()
+	if _, ok := s.Get("a"); ok {
+		t.Fatal("a should be replaced")
+	}
+	n := 0
+	s.Range(func(k string, v int) bool {
+		n++
+		return k == "c" && v == 3
+	})
+	if n != 1 {
+		t.Fatalf("expected 1 item, got %d", n)
+	}
+
+	s = NewSnapshot[string, int](nil)
+	if _, ok := s.Get("a"); ok || s.Count() != 0 {
+		t.Fatal("the empty snapshot should not have items")
+	}
+}
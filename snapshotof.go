@@ -0,0 +1,20 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"reflect"
+)
+
+// snapshotItemOf an item in the payload of a snapshot written by CacheOf.
+type snapshotItemOf[K comparable, V any] struct {
+	K K     `json:"k"`
+	V V     `json:"v"`
+	E int64 `json:"e"`
+}
+
+// typeName returns the name of T recorded in snapshot headers.
+func typeName[T any]() string {
+	return reflect.TypeOf((*T)(nil)).Elem().String()
+}
@@ -0,0 +1,66 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sort"
+)
+
+// Ordered is a constraint for the key types that can be sorted, see RangeSortedOf.
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 |
+		~string
+}
+
+// RangeSortedOf calls f sequentially for each key and value present in the cache, in ascending key order.
+// If f returns false, range stops the iteration.
+// The unexpired items are copied and sorted on each call, in O(n log n) time and O(n) memory.
+func RangeSortedOf[K Ordered, V any](c CacheOf[K, V], f func(k K, v V) bool) {
+	RangeSortedFuncOf(c, func(a, b K) bool { return a < b }, f)
+}
+
+// RangeSortedFuncOf calls f sequentially for each key and value present in the cache,
+// in the key order defined by less. If f returns false, range stops the iteration.
+// The unexpired items are copied and sorted on each call, in O(n log n) time and O(n) memory.
+func RangeSortedFuncOf[K comparable, V any](c CacheOf[K, V], less func(a, b K) bool, f func(k K, v V) bool) {
+	if f == nil {
+		return
+	}
+	for _, x := range sortedItemsOf(c, less) {
+		if !f(x.Key, x.Value) {
+			return
+		}
+	}
+}
+
+// ItemsSortedOf returns the unexpired items in ascending key order.
+// The items are copied and sorted on each call, in O(n log n) time.
+func ItemsSortedOf[K Ordered, V any](c CacheOf[K, V]) []KeyValueOf[K, V] {
+	return sortedItemsOf(c, func(a, b K) bool { return a < b })
+}
+
+// sortedItemsOf returns the items of c in the key order defined by less.
+func sortedItemsOf[K comparable, V any](c CacheOf[K, V], less func(a, b K) bool) []KeyValueOf[K, V] {
+	items := make([]KeyValueOf[K, V], 0, c.Count())
+	c.Range(func(k K, v V) bool {
+		items = append(items, KeyValueOf[K, V]{k, v})
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool { return less(items[i].Key, items[j].Key) })
+	return items
+}
+
+// KeysSortedOf returns the keys of the unexpired items in the cache in ascending order.
+// The keys are sorted on each call, in O(n log n) time.
+func KeysSortedOf[K Ordered, V any](c CacheOf[K, V]) []K {
+	keys := make([]K, 0, c.Count())
+	c.Range(func(k K, _ V) bool {
+		keys = append(keys, k)
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,77 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync"
+)
+
+// tagIndexOf maps the tags to the keys of the items stored with them, see SetWithTags.
+// The index is a hint: the item of a key may have been rewritten without the tag since,
+// InvalidateTag checks the tags of the item before deleting it.
+type tagIndexOf[K comparable] struct {
+	mu   sync.Mutex
+	keys map[string]map[K]struct{}
+}
+
+func newTagIndexOf[K comparable]() *tagIndexOf[K] {
+	return &tagIndexOf[K]{keys: make(map[string]map[K]struct{})}
+}
+
+// add indexes the key k under each of the tags.
+func (x *tagIndexOf[K]) add(k K, tags []string) {
+	x.mu.Lock()
+	for _, tag := range tags {
+		keys, ok := x.keys[tag]
+		if !ok {
+			keys = make(map[K]struct{})
+			x.keys[tag] = keys
+		}
+		keys[k] = struct{}{}
+	}
+	x.mu.Unlock()
+}
+
+// remove removes the key k from the tags, except those for which tagged reports
+// that its current item has been stored with the tag again.
+func (x *tagIndexOf[K]) remove(k K, tags []string, tagged func(tag string) bool) {
+	x.mu.Lock()
+	for _, tag := range tags {
+		keys, ok := x.keys[tag]
+		if !ok || tagged(tag) {
+			continue
+		}
+		delete(keys, k)
+		if len(keys) == 0 {
+			delete(x.keys, tag)
+		}
+	}
+	x.mu.Unlock()
+}
+
+// take removes the tag from the index, and returns its keys.
+func (x *tagIndexOf[K]) take(tag string) map[K]struct{} {
+	x.mu.Lock()
+	keys := x.keys[tag]
+	delete(x.keys, tag)
+	x.mu.Unlock()
+	return keys
+}
+
+// clear removes all the tags from the index.
+func (x *tagIndexOf[K]) clear() {
+	x.mu.Lock()
+	x.keys = make(map[string]map[K]struct{})
+	x.mu.Unlock()
+}
+
+// hasTag reports whether tags contains tag.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
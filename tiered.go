@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Backend a second level store of a tiered cache, e.g. Redis, memcached or disk, see NewTiered.
+// It must be safe for concurrent use.
+type Backend interface {
+	// Get returns the value of the key, its remaining time to live,
+	// less than or equal to 0 if it never expires, and whether the key was found.
+	Get(k string) (v interface{}, ttl time.Duration, ok bool, err error)
+
+	// Set stores the value of the key for the duration d,
+	// all values less than or equal to 0 mean it never expires.
+	Set(k string, v interface{}, d time.Duration) error
+
+	// Delete deletes the key, a missing key is not an error.
+	Delete(k string) error
+}
+
+// TieredMode how the writes of a tiered cache reach its backend.
+type TieredMode int
+
+const (
+	// TieredWriteThrough writes to the backend before returning, the default.
+	TieredWriteThrough TieredMode = iota
+
+	// TieredWriteBehind queues the writes, they are written to the backend in order
+	// by a background worker, and their errors are reported to the error handler.
+	TieredWriteBehind
+)
+
+// DefaultTieredQueueSize the default size of the write-behind queue of a tiered cache.
+const DefaultTieredQueueSize = 1024
+
+type TieredConfig struct {
+	// Mode how the writes reach the backend, TieredWriteThrough by default.
+	Mode TieredMode
+
+	// QueueSize the size of the write-behind queue, the writes wait while it is full.
+	QueueSize int
+
+	// ErrorHandler called with the errors of the backend writes in TieredWriteBehind mode.
+	ErrorHandler func(err error)
+}
+
+type TieredOption func(config *TieredConfig)
+
+func WithTieredMode(mode TieredMode) TieredOption {
+	return func(config *TieredConfig) {
+		config.Mode = mode
+	}
+}
+
+func WithTieredQueueSize(size int) TieredOption {
+	return func(config *TieredConfig) {
+		config.QueueSize = size
+	}
+}
+
+func WithTieredErrorHandler(fn func(err error)) TieredOption {
+	return func(config *TieredConfig) {
+		config.ErrorHandler = fn
+	}
+}
+
+func tieredConfigDefault(opts ...TieredOption) TieredConfig {
+	cfg := TieredConfig{
+		Mode:      TieredWriteThrough,
+		QueueSize: DefaultTieredQueueSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Tiered a two level cache: the misses of the in-memory cache fall through to the backend,
+// and the values found there are promoted to the in-memory cache.
+type Tiered struct {
+	l1           Cache
+	l2           Backend
+	writer       *callbackDispatcher
+	errorHandler func(err error)
+	wg           sync.WaitGroup
+	loads        loadGroupOf[string, interface{}]
+	reads        tieredReadsOf[string]
+}
+
+// NewTiered returns a tiered cache over the in-memory cache l1 and the backend l2.
+// Closing the tiered cache flushes the pending writes, it does not close l1 or l2.
+func NewTiered(l1 Cache, l2 Backend, opts ...TieredOption) *Tiered {
+	cfg := tieredConfigDefault(opts...)
+	t := &Tiered{
+		l1:           l1,
+		l2:           l2,
+		errorHandler: cfg.ErrorHandler,
+	}
+	if cfg.Mode == TieredWriteBehind {
+		t.writer = newCallbackDispatcher(1, cfg.QueueSize, nil, &t.wg)
+	}
+	return t
+}
+
+// Get returns the value of the key from l1, or from l2 on a miss, promoting it to l1
+// for its remaining time to live, at most the default expiration time of l1.
+// The concurrent misses of a key share one read of l2, and the value read is not promoted
+// if the key has been written or deleted since, so that it never overwrites a newer write.
+func (t *Tiered) Get(k string) (interface{}, bool, error) {
+	if v, ok := t.l1.Get(k); ok {
+		return v, true, nil
+	}
+	return t.loads.do(k, func() (interface{}, bool, error) {
+		return t.load(k)
+	})
+}
+
+// load reads the key k missing from l1 in l2, and promotes its value to l1.
+func (t *Tiered) load(k string) (interface{}, bool, error) {
+	t.reads.start(k)
+	defer t.reads.done(k)
+	v, ttl, ok, err := t.l2.Get(k)
+	if err != nil {
+		return nil, false, tieredError("get", k, err)
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	t.l1.ComputeWithOp(k, func(old interface{}, loaded bool) (interface{}, ComputeOp) {
+		if t.reads.done(k) || loaded {
+			// written or deleted since the read
+			return old, CancelOp
+		}
+		return v, UpdateOp
+	}, promotionTTL(ttl, t.l1.DefaultExpiration()))
+	return v, true, nil
+}
+
+// Set stores the value of the key in l1 and l2, see Cache.Set for the duration d.
+// In TieredWriteBehind mode, the write to l2 is queued and the error is always nil.
+func (t *Tiered) Set(k string, v interface{}, d time.Duration) error {
+	if d == DefaultExpiration {
+		d = t.l1.DefaultExpiration()
+	}
+	t.reads.written(k)
+	t.l1.Set(k, v, d)
+	return t.write(func() error {
+		if err := t.l2.Set(k, v, d); err != nil {
+			return tieredError("set", k, err)
+		}
+		return nil
+	})
+}
+
+// Delete deletes the key from l1 and l2.
+// In TieredWriteBehind mode, the deletion from l2 is queued and the error is always nil.
+func (t *Tiered) Delete(k string) error {
+	t.reads.written(k)
+	t.l1.Delete(k)
+	return t.write(func() error {
+		if err := t.l2.Delete(k); err != nil {
+			return tieredError("delete", k, err)
+		}
+		return nil
+	})
+}
+
+// L1 returns the in-memory cache.
+func (t *Tiered) L1() Cache {
+	return t.l1
+}
+
+// Close writes the queued writes to l2 and stops the write-behind worker.
+func (t *Tiered) Close() error {
+	t.writer.close()
+	t.wg.Wait()
+	return nil
+}
+
+// write runs fn now in TieredWriteThrough mode, or queues it in TieredWriteBehind mode.
+func (t *Tiered) write(fn func() error) error {
+	if t.writer == nil {
+		return fn()
+	}
+	t.writer.do(func() {
+		if err := fn(); err != nil && t.errorHandler != nil {
+			t.errorHandler(err)
+		}
+	})
+	return nil
+}
+
+// promotionTTL returns the time to live in l1 of a value found in l2 with the time to live ttl.
+func promotionTTL(ttl, def time.Duration) time.Duration {
+	if ttl <= 0 {
+		ttl = NoExpiration
+	}
+	if def > 0 && (ttl == NoExpiration || ttl > def) {
+		return def
+	}
+	return ttl
+}
+
+func tieredError(op string, k interface{}, err error) error {
+	return fmt.Errorf("cache: tiered %s %v: %w", op, k, err)
+}
@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type mapBackend struct {
+	sync.Mutex
+	items map[string]ItemWithExpiration
+	err   error
+}
+
+func newMapBackend() *mapBackend {
+	return &mapBackend{items: make(map[string]ItemWithExpiration)}
+}
+
+func (b *mapBackend) Get(k string) (interface{}, time.Duration, bool, error) {
+	b.Lock()
+	defer b.Unlock()
+	x, ok := b.items[k]
+	if !ok {
+		return nil, 0, false, b.err
+	}
+	var ttl time.Duration
+	if !x.Expiration.IsZero() {
+		ttl = time.Until(x.Expiration)
+	}
+	return x.Value, ttl, true, b.err
+}
+
+func (b *mapBackend) Set(k string, v interface{}, d time.Duration) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	x := ItemWithExpiration{Value: v}
+	if d > 0 {
+		x.Expiration = time.Now().Add(d)
+	}
+	b.items[k] = x
+	return nil
+}
+
+func (b *mapBackend) Delete(k string) error {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.items, k)
+	return b.err
+}
+
+// gatedBackend counts the reads, which return the value read once the gate is closed.
+type gatedBackend struct {
+	*mapBackend
+	gets    int32
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func newGatedBackend() *gatedBackend {
+	return &gatedBackend{mapBackend: newMapBackend(), started: make(chan struct{}), gate: make(chan struct{})}
+}
+
+func (b *gatedBackend) Get(k string) (interface{}, time.Duration, bool, error) {
+	v, ttl, ok, err := b.mapBackend.Get(k)
+	if atomic.AddInt32(&b.gets, 1) == 1 {
+		close(b.started)
+	}
+	<-b.gate
+	return v, ttl, ok, err
+}
+
+func TestTiered(t *testing.T) {
+	l1 := New(WithDefaultExpiration(time.Hour))
+	defer l1.Close()
+	l2 := newMapBackend()
+	c := NewTiered(l1, l2)
+	defer c.Close()
+
+	if err := c.Set("a", 1, DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l2.items["a"]; !ok {
+		t.Fatal("a should be written through to the backend")
+	}
+
+	// misses fall through to the backend, and are promoted for their remaining time to live
+	_ = l2.Set("b", 2, time.Minute)
+	_ = l2.Set("c", 3, NoExpiration)
+	if v, ok, err := c.Get("b"); err != nil || !ok || v != 2 {
+		t.Fatalf("unexpected result: %v, %v, %v", v, ok, err)
+	}
+	if _, ttl, ok := l1.GetWithTTL("b"); !ok || ttl > time.Minute {
+		t.Fatalf("b should be promoted for at most 1m, got %v", ttl)
+	}
+	if _, ok, _ := c.Get("c"); !ok {
+		t.Fatal("c should be found in the backend")
+	}
+	if _, ttl, ok := l1.GetWithTTL("c"); !ok || ttl <= time.Minute || ttl > time.Hour {
+		t.Fatalf("c should be promoted for the default expiration of l1, got %v", ttl)
+	}
+	if _, ok, err := c.Get("missing"); ok || err != nil {
+		t.Fatalf("unexpected result: %v, %v", ok, err)
+	}
+
+	if err := c.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := c.Get("a"); ok {
+		t.Fatal("a should be deleted from both levels")
+	}
+
+	l2.err = errors.New("unavailable")
+	if err := c.Set("d", 4, NoExpiration); !errors.Is(err, l2.err) {
+		t.Fatalf("expected the backend error, got: %v", err)
+	}
+	if _, _, err := c.Get("e"); !errors.Is(err, l2.err) {
+		t.Fatalf("expected the backend error, got: %v", err)
+	}
+}
+
+func TestTiered_WriteBehind(t *testing.T) {
+	l1 := New()
+	defer l1.Close()
+	l2 := newMapBackend()
+	var errs []error
+	c := NewTiered(l1, l2,
+		WithTieredMode(TieredWriteBehind),
+		WithTieredQueueSize(16),
+		WithTieredErrorHandler(func(err error) { errs = append(errs, err) }),
+	)
+
+	for i := 0; i < 100; i++ {
+		_ = c.Set("k", i, NoExpiration)
+	}
+	_ = c.Set("x", 1, NoExpiration)
+	_ = c.Delete("x")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if x, ok := l2.items["k"]; !ok || x.Value != 99 {
+		t.Fatalf("expected the last write to be flushed, got: %v", x.Value)
+	}
+	if _, ok := l2.items["x"]; ok {
+		t.Fatal("the writes should be flushed in order")
+	}
+
+	l2.err = errors.New("unavailable")
+	_ = c.Set("y", 1, NoExpiration)
+	if len(errs) != 1 || !errors.Is(errs[0], l2.err) {
+		t.Fatalf("expected the backend error to be reported, got: %v", errs)
+	}
+}
+
+func TestTiered_Promotion(t *testing.T) {
+	l1 := New()
+	defer l1.Close()
+
+	// the concurrent misses share one read
+	l2 := newGatedBackend()
+	_ = l2.Set("a", 1, NoExpiration)
+	c := NewTiered(l1, l2)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok, err := c.Get("a"); err != nil || !ok || v != 1 {
+				t.Errorf("unexpected result: %v, %v, %v", v, ok, err)
+			}
+		}()
+	}
+	<-l2.started
+	time.Sleep(10 * time.Millisecond)
+	close(l2.gate)
+	wg.Wait()
+	if n := atomic.LoadInt32(&l2.gets); n != 1 {
+		t.Fatalf("expected one read of the backend, got %d", n)
+	}
+
+	// a value read before a deletion is not promoted
+	l2 = newGatedBackend()
+	_ = l2.Set("b", 1, NoExpiration)
+	c = NewTiered(l1, l2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _ = c.Get("b")
+	}()
+	<-l2.started
+	_ = c.Delete("b")
+	close(l2.gate)
+	<-done
+	if v, ok := l1.Get("b"); ok {
+		t.Fatalf("the deleted key should not be promoted, got %v", v)
+	}
+
+	// nor a value read before a newer write
+	l2 = newGatedBackend()
+	_ = l2.Set("c", 1, NoExpiration)
+	c = NewTiered(l1, l2)
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _ = c.Get("c")
+	}()
+	<-l2.started
+	_ = c.Set("c", 2, NoExpiration)
+	close(l2.gate)
+	<-done
+	if v, ok := l1.Get("c"); !ok || v != 2 {
+		t.Fatalf("expected the newer write, got %v", v)
+	}
+}
@@ -0,0 +1,175 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// BackendOf a second level store of a tiered cache, e.g. Redis, memcached or disk, see NewTieredOf.
+// It must be safe for concurrent use.
+type BackendOf[K comparable, V any] interface {
+	// Get returns the value of the key, its remaining time to live,
+	// less than or equal to 0 if it never expires, and whether the key was found.
+	Get(k K) (v V, ttl time.Duration, ok bool, err error)
+
+	// Set stores the value of the key for the duration d,
+	// all values less than or equal to 0 mean it never expires.
+	Set(k K, v V, d time.Duration) error
+
+	// Delete deletes the key, a missing key is not an error.
+	Delete(k K) error
+}
+
+// TieredOf a two level cache: the misses of the in-memory cache fall through to the backend,
+// and the values found there are promoted to the in-memory cache.
+type TieredOf[K comparable, V any] struct {
+	l1           CacheOf[K, V]
+	l2           BackendOf[K, V]
+	writer       *callbackDispatcher
+	errorHandler func(err error)
+	wg           sync.WaitGroup
+	loads        loadGroupOf[K, V]
+	reads        tieredReadsOf[K]
+}
+
+// NewTieredOf returns a tiered cache over the in-memory cache l1 and the backend l2.
+// Closing the tiered cache flushes the pending writes, it does not close l1 or l2.
+func NewTieredOf[K comparable, V any](l1 CacheOf[K, V], l2 BackendOf[K, V], opts ...TieredOption) *TieredOf[K, V] {
+	cfg := tieredConfigDefault(opts...)
+	t := &TieredOf[K, V]{
+		l1:           l1,
+		l2:           l2,
+		errorHandler: cfg.ErrorHandler,
+	}
+	if cfg.Mode == TieredWriteBehind {
+		t.writer = newCallbackDispatcher(1, cfg.QueueSize, nil, &t.wg)
+	}
+	return t
+}
+
+// Get returns the value of the key from l1, or from l2 on a miss, promoting it to l1
+// for its remaining time to live, at most the default expiration time of l1.
+// The concurrent misses of a key share one read of l2, and the value read is not promoted
+// if the key has been written or deleted since, so that it never overwrites a newer write.
+func (t *TieredOf[K, V]) Get(k K) (V, bool, error) {
+	if v, ok := t.l1.Get(k); ok {
+		return v, true, nil
+	}
+	return t.loads.do(k, func() (V, bool, error) {
+		return t.load(k)
+	})
+}
+
+// load reads the key k missing from l1 in l2, and promotes its value to l1.
+func (t *TieredOf[K, V]) load(k K) (V, bool, error) {
+	var zeroedV V
+	t.reads.start(k)
+	defer t.reads.done(k)
+	v, ttl, ok, err := t.l2.Get(k)
+	if err != nil {
+		return zeroedV, false, tieredError("get", k, err)
+	}
+	if !ok {
+		return zeroedV, false, nil
+	}
+	t.l1.ComputeWithOp(k, func(old V, loaded bool) (V, ComputeOp) {
+		if t.reads.done(k) || loaded {
+			// written or deleted since the read
+			return old, CancelOp
+		}
+		return v, UpdateOp
+	}, promotionTTL(ttl, t.l1.DefaultExpiration()))
+	return v, true, nil
+}
+
+// Set stores the value of the key in l1 and l2, see CacheOf.Set for the duration d.
+// In TieredWriteBehind mode, the write to l2 is queued and the error is always nil.
+func (t *TieredOf[K, V]) Set(k K, v V, d time.Duration) error {
+	if d == DefaultExpiration {
+		d = t.l1.DefaultExpiration()
+	}
+	t.reads.written(k)
+	t.l1.Set(k, v, d)
+	return t.write(func() error {
+		if err := t.l2.Set(k, v, d); err != nil {
+			return tieredError("set", k, err)
+		}
+		return nil
+	})
+}
+
+// Delete deletes the key from l1 and l2.
+// In TieredWriteBehind mode, the deletion from l2 is queued and the error is always nil.
+func (t *TieredOf[K, V]) Delete(k K) error {
+	t.reads.written(k)
+	t.l1.Delete(k)
+	return t.write(func() error {
+		if err := t.l2.Delete(k); err != nil {
+			return tieredError("delete", k, err)
+		}
+		return nil
+	})
+}
+
+// L1 returns the in-memory cache.
+func (t *TieredOf[K, V]) L1() CacheOf[K, V] {
+	return t.l1
+}
+
+// Close writes the queued writes to l2 and stops the write-behind worker.
+func (t *TieredOf[K, V]) Close() error {
+	t.writer.close()
+	t.wg.Wait()
+	return nil
+}
+
+// write runs fn now in TieredWriteThrough mode, or queues it in TieredWriteBehind mode.
+func (t *TieredOf[K, V]) write(fn func() error) error {
+	if t.writer == nil {
+		return fn()
+	}
+	t.writer.do(func() {
+		if err := fn(); err != nil && t.errorHandler != nil {
+			t.errorHandler(err)
+		}
+	})
+	return nil
+}
+
+// tieredReadsOf the keys being read from l2 by a tiered cache, and whether they have been
+// written or deleted since, so that the values read are not promoted over the newer writes.
+type tieredReadsOf[K comparable] struct {
+	mu    sync.Mutex
+	reads map[K]bool
+}
+
+// start records the read of the key k, at most one at a time per key.
+func (r *tieredReadsOf[K]) start(k K) {
+	r.mu.Lock()
+	if r.reads == nil {
+		r.reads = make(map[K]bool)
+	}
+	r.reads[k] = false
+	r.mu.Unlock()
+}
+
+// written marks the read of the key k in progress, if any, as outdated.
+func (r *tieredReadsOf[K]) written(k K) {
+	r.mu.Lock()
+	if _, ok := r.reads[k]; ok {
+		r.reads[k] = true
+	}
+	r.mu.Unlock()
+}
+
+// done ends the read of the key k, and reports whether the key has been written since it started.
+func (r *tieredReadsOf[K]) done(k K) bool {
+	r.mu.Lock()
+	written := r.reads[k]
+	delete(r.reads, k)
+	r.mu.Unlock()
+	return written
+}
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"time"
+)
+
+// Txn buffers the writes of a transaction of Cache.Update, applied once the transaction succeeds.
+// It must not be used once the function given to Update has returned.
+type Txn interface {
+	// Get an item from the cache, or the value written by the transaction.
+	// Returns the item or nil,
+	// and a boolean indicating whether the key was found.
+	Get(k string) (value interface{}, ok bool)
+
+	// Set buffers the write of the item, applied like Cache.Set.
+	Set(k string, v interface{}, d time.Duration)
+
+	// Delete buffers the deletion of the item, applied like Cache.Delete.
+	Delete(k string)
+}
+
+// keyedTxn the Txn of a namespace or a normalized cache, mapping the keys
+// given to the Txn of its parent.
+type keyedTxn struct {
+	tx  Txn
+	key func(k string) string
+}
+
+func (t keyedTxn) Get(k string) (interface{}, bool) {
+	return t.tx.Get(t.key(k))
+}
+
+func (t keyedTxn) Set(k string, v interface{}, d time.Duration) {
+	t.tx.Set(t.key(k), v, d)
+}
+
+func (t keyedTxn) Delete(k string) {
+	t.tx.Delete(t.key(k))
+}
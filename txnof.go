@@ -0,0 +1,95 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"time"
+)
+
+// TxnOf buffers the writes of a transaction of CacheOf.Update, applied once the transaction succeeds.
+// It must not be used once the function given to Update has returned.
+type TxnOf[K comparable, V any] interface {
+	// Get an item from the cache, or the value written by the transaction.
+	// Returns the item or the zero value,
+	// and a boolean indicating whether the key was found.
+	Get(k K) (value V, ok bool)
+
+	// Set buffers the write of the item, applied like CacheOf.Set.
+	Set(k K, v V, d time.Duration)
+
+	// Delete buffers the deletion of the item, applied like CacheOf.Delete.
+	Delete(k K)
+}
+
+type txnOpOf[V any] struct {
+	v   V
+	d   time.Duration
+	del bool
+}
+
+// txnOf the TxnOf of a cache, the last write of each key wins.
+type txnOf[K comparable, V any] struct {
+	get  func(k K) (V, bool)
+	ops  map[K]txnOpOf[V]
+	keys []K // in the order of their first write
+}
+
+func newTxnOf[K comparable, V any](get func(k K) (V, bool)) *txnOf[K, V] {
+	return &txnOf[K, V]{
+		get: get,
+		ops: make(map[K]txnOpOf[V]),
+	}
+}
+
+func (t *txnOf[K, V]) Get(k K) (V, bool) {
+	if op, ok := t.ops[k]; ok {
+		return op.v, !op.del
+	}
+	return t.get(k)
+}
+
+func (t *txnOf[K, V]) Set(k K, v V, d time.Duration) {
+	t.write(k, txnOpOf[V]{v: v, d: d})
+}
+
+func (t *txnOf[K, V]) Delete(k K) {
+	t.write(k, txnOpOf[V]{del: true})
+}
+
+func (t *txnOf[K, V]) write(k K, op txnOpOf[V]) {
+	if _, ok := t.ops[k]; !ok {
+		t.keys = append(t.keys, k)
+	}
+	t.ops[k] = op
+}
+
+// commit applies the writes in the order of the first write of each key.
+func (t *txnOf[K, V]) commit(set func(k K, v V, d time.Duration), del func(k K)) {
+	for _, k := range t.keys {
+		if op := t.ops[k]; op.del {
+			del(k)
+		} else {
+			set(k, op.v, op.d)
+		}
+	}
+}
+
+// keyedTxnOf the TxnOf of a namespace or a normalized cache, mapping the keys
+// given to the TxnOf of its parent.
+type keyedTxnOf[K comparable, V any] struct {
+	tx  TxnOf[K, V]
+	key func(k K) K
+}
+
+func (t keyedTxnOf[K, V]) Get(k K) (V, bool) {
+	return t.tx.Get(t.key(k))
+}
+
+func (t keyedTxnOf[K, V]) Set(k K, v V, d time.Duration) {
+	t.tx.Set(t.key(k), v, d)
+}
+
+func (t keyedTxnOf[K, V]) Delete(k K) {
+	t.tx.Delete(t.key(k))
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WarmupError the keys which failed to load by Warmup, along with their errors.
+type WarmupError struct {
+	// Keys the keys which failed to load, in the order given to Warmup.
+	Keys []string
+
+	// Errs the errors of the keys.
+	Errs []error
+}
+
+func (e *WarmupError) Error() string {
+	return fmt.Sprintf("cache: warmup failed for %d keys, first %q: %v", len(e.Keys), e.Keys[0], e.Errs[0])
+}
+
+// Unwrap returns the errors of the keys.
+func (e *WarmupError) Unwrap() []error {
+	return e.Errs
+}
+
+// Is reports whether the error of one of the keys matches target, for errors.Is before Go 1.20,
+// which does not unwrap the lists of errors.
+func (e *WarmupError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// warmup loads the keys with loader on parallelism workers, and stores their values with set.
+func warmup(
+	ctx context.Context,
+	keys []string,
+	loader Loader,
+	parallelism int,
+	set func(k string, v interface{}, d time.Duration),
+) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > len(keys) {
+		parallelism = len(keys)
+	}
+	load := func(k string) (v interface{}, d time.Duration, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = ErrLoaderPanicked
+			}
+		}()
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		return loader(ctx, k)
+	}
+	errs := make([]error, len(keys))
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(parallelism)
+	for w := 0; w < parallelism; w++ {
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < len(keys); i = int(atomic.AddInt64(&next, 1)) {
+				v, d, err := load(keys[i])
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				set(keys[i], v, d)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var werr *WarmupError
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if werr == nil {
+			werr = &WarmupError{}
+		}
+		werr.Keys = append(werr.Keys, keys[i])
+		werr.Errs = append(werr.Errs, err)
+	}
+	if werr == nil {
+		return nil
+	}
+	return werr
+}
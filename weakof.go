@@ -0,0 +1,73 @@
+//go:build go1.24
+// +build go1.24
+
+package cache
+
+import (
+	"time"
+	"weak"
+)
+
+// WeakOf a cache holding its values behind weak pointers, so the garbage collector can reclaim
+// large values, e.g. images or buffers, as soon as they are no longer used elsewhere:
+// a weak pointer does not keep its value alive until memory runs low, the next collection reclaims it.
+// The values are only cached while referenced elsewhere, e.g. to share the buffers in use.
+// The item of a reclaimed value is dropped when read, or by DeleteCollected.
+// The other operations, e.g. the expiration, are those of the underlying cache, see Cache.
+type WeakOf[K comparable, T any] struct {
+	c CacheOf[K, weak.Pointer[T]]
+}
+
+// NewWeakOf returns a weak cache over c, created with the options of any cache, e.g.
+// NewWeakOf(NewOf[string, weak.Pointer[Image]]()). Closing the weak cache closes c.
+func NewWeakOf[K comparable, T any](c CacheOf[K, weak.Pointer[T]]) *WeakOf[K, T] {
+	return &WeakOf[K, T]{c: c}
+}
+
+// Get returns the value of the key, and false if it is missing, expired or reclaimed.
+func (w *WeakOf[K, T]) Get(k K) (*T, bool) {
+	p, ok := w.c.Get(k)
+	if !ok {
+		return nil, false
+	}
+	v := p.Value()
+	if v == nil {
+		// reclaimed, unless the key has been written again since
+		w.c.CompareAndDelete(k, p)
+		return nil, false
+	}
+	return v, true
+}
+
+// Set stores a weak pointer to v for the key, see CacheOf.Set for the duration d.
+func (w *WeakOf[K, T]) Set(k K, v *T, d time.Duration) {
+	w.c.Set(k, weak.Make(v), d)
+}
+
+// Delete deletes the key.
+func (w *WeakOf[K, T]) Delete(k K) {
+	w.c.Delete(k)
+}
+
+// DeleteCollected deletes the items whose value has been reclaimed, and returns their number.
+func (w *WeakOf[K, T]) DeleteCollected() int {
+	return w.c.DeleteFunc(func(_ K, p weak.Pointer[T]) bool {
+		return p.Value() == nil
+	})
+}
+
+// Count returns the number of items, including those whose value has been reclaimed
+// but not dropped yet, see CacheOf.Count.
+func (w *WeakOf[K, T]) Count() int {
+	return w.c.Count()
+}
+
+// Cache returns the underlying cache.
+func (w *WeakOf[K, T]) Cache() CacheOf[K, weak.Pointer[T]] {
+	return w.c
+}
+
+// Close closes the underlying cache.
+func (w *WeakOf[K, T]) Close() error {
+	return w.c.Close()
+}
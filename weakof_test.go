@@ -0,0 +1,39 @@
+//go:build go1.24
+// +build go1.24
+
+package cache
+
+import (
+	"runtime"
+	"testing"
+	"weak"
+)
+
+type weakBlob struct {
+	b [1 << 10]byte
+}
+
+func TestWeakOf(t *testing.T) {
+	w := NewWeakOf(NewOf[string, weak.Pointer[weakBlob]]())
+	defer w.Close()
+
+	kept := &weakBlob{}
+	w.Set("kept", kept, NoExpiration)
+	w.Set("dropped", &weakBlob{}, NoExpiration)
+	w.Set("collected", &weakBlob{}, NoExpiration)
+	runtime.GC()
+
+	if v, ok := w.Get("kept"); !ok || v != kept {
+		t.Fatal("expected the value still in use to be found")
+	}
+	if _, ok := w.Get("dropped"); ok {
+		t.Fatal("expected the reclaimed value to be missing")
+	}
+	if n := w.Count(); n != 2 {
+		t.Fatalf("expected the reclaimed item to be dropped on read, got %d items", n)
+	}
+	if n := w.DeleteCollected(); n != 1 {
+		t.Fatalf("expected 1 reclaimed item to be deleted, got %d", n)
+	}
+	runtime.KeepAlive(kept)
+}
@@ -0,0 +1,5 @@
+package cache
+
+// WriteFunc writes the value of the key to a persistent store,
+// see WithWriteThrough and WithWriteBehind.
+type WriteFunc func(k string, v interface{}) error
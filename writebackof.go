@@ -0,0 +1,107 @@
+//go:build go1.18
+// +build go1.18
+
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteFuncOf writes the value of the key to a persistent store,
+// see WithWriteThroughOf and WithWriteBehindOf.
+type WriteFuncOf[K comparable, V any] func(k K, v V) error
+
+// storeWriterOf propagates the writes of the cache to a persistent store, synchronously,
+// or batched until the next flush. A nil storeWriterOf does nothing.
+type storeWriterOf[K comparable, V any] struct {
+	fn      WriteFuncOf[K, V]
+	behind  bool
+	window  time.Duration // see WithWriteCoalescingOf
+	clock   Clock
+	mu      sync.Mutex
+	pending map[K]pendingWriteOf[V] // the last value written for each key since the last flush
+}
+
+// pendingWriteOf the last value written to a key, and the time of the first write
+// since the key was last flushed.
+type pendingWriteOf[V any] struct {
+	v     V
+	since time.Time
+}
+
+// newStoreWriterOf returns a write-behind writer if behind is set, a write-through writer
+// if through is set, nil otherwise.
+func newStoreWriterOf[K comparable, V any](
+	through, behind WriteFuncOf[K, V],
+	window time.Duration,
+	clock Clock,
+) *storeWriterOf[K, V] {
+	switch {
+	case behind != nil:
+		return &storeWriterOf[K, V]{
+			fn:      behind,
+			behind:  true,
+			window:  window,
+			clock:   clock,
+			pending: make(map[K]pendingWriteOf[V]),
+		}
+	case through != nil:
+		return &storeWriterOf[K, V]{fn: through}
+	default:
+		return nil
+	}
+}
+
+// write writes the value v of the key k now, and returns the error of the write-through function,
+// or at the next flush.
+func (w *storeWriterOf[K, V]) write(k K, v V) error {
+	if w == nil {
+		return nil
+	}
+	if !w.behind {
+		return w.fn(k, v)
+	}
+	w.mu.Lock()
+	p, ok := w.pending[k]
+	if !ok {
+		p.since = w.clock.Now()
+	}
+	p.v = v
+	w.pending[k] = p
+	w.mu.Unlock()
+	return nil
+}
+
+// flush writes the pending values, all of them or those first written at least the coalescing
+// window ago. Those that failed are retried at the next flush, unless the key has been written
+// again since.
+func (w *storeWriterOf[K, V]) flush(all bool) {
+	if w == nil || !w.behind {
+		return
+	}
+	w.mu.Lock()
+	batch := w.pending
+	if all || w.window <= 0 {
+		w.pending = make(map[K]pendingWriteOf[V], len(batch))
+	} else {
+		batch = make(map[K]pendingWriteOf[V])
+		deadline := w.clock.Now().Add(-w.window)
+		for k, p := range w.pending {
+			if !p.since.After(deadline) {
+				batch[k] = p
+				delete(w.pending, k)
+			}
+		}
+	}
+	w.mu.Unlock()
+	for k, p := range batch {
+		if err := w.fn(k, p.v); err != nil {
+			w.mu.Lock()
+			if _, ok := w.pending[k]; !ok {
+				w.pending[k] = p
+			}
+			w.mu.Unlock()
+		}
+	}
+}
@@ -1,52 +1,46 @@
 package cache
 
 import (
+	"context"
 	"runtime"
-	"sync/atomic"
+	"sort"
 	"time"
 )
 
 var _ Cache = (*xsyncMapWrapper)(nil)
 
+// xsyncMapWrapper implements Cache over the cache of CacheOf[string, interface{}],
+// only the methods whose signatures differ, or which only exist for string keys, are defined here.
 type xsyncMapWrapper struct {
-	*xsyncMap
-}
-
-type xsyncMap struct {
-	defaultExpiration atomic.Value
-	evictedCallback   atomic.Value
-	items             Map
-	stop              chan struct{}
+	*xsyncMapOfWrapper[string, interface{}]
 }
 
 // Create a new cache, optionally specifying configuration items.
 func newXsyncMap(config ...Config) Cache {
-	cfg := configDefault(config...)
-	c := &xsyncMap{
-		items: NewMapPresized(cfg.MinCapacity),
-		stop:  make(chan struct{}),
-	}
-	c.defaultExpiration.Store(cfg.DefaultExpiration)
-	c.evictedCallback.Store(cfg.EvictedCallback)
+	public, _ := newXsyncMapViews(configOf(configDefault(config...)))
+	return public
+}
 
-	if cfg.CleanupInterval > 0 {
-		go func() {
-			ticker := time.NewTicker(cfg.CleanupInterval)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ticker.C:
-					c.DeleteExpired()
-				case <-c.stop:
-					return
-				}
-			}
-		}()
+// newXsyncMapViews creates the cache of the configuration cfg, with its defaults applied,
+// and returns it both as Cache and as CacheOf[string, interface{}], see NewSharded.
+func newXsyncMapViews(cfg ConfigOf[string, interface{}]) (Cache, CacheOf[string, interface{}]) {
+	c := newXsyncMapOfCore(cfg)
+	cache := &xsyncMapWrapper{&xsyncMapOfWrapper[string, interface{}]{c}}
+	if !cfg.NoFinalizer {
+		runtime.SetFinalizer(cache, func(m *xsyncMapWrapper) { m.shutdown() })
 	}
-
-	cache := &xsyncMapWrapper{c}
-	runtime.SetFinalizer(cache, func(m *xsyncMapWrapper) { close(m.stop) })
-	return cache
+	var public Cache = cache
+	var view CacheOf[string, interface{}] = cache.xsyncMapOfWrapper
+	if cfg.KeyNormalizer != nil {
+		public = newNormalized(cache, cfg.KeyNormalizer)
+		view = newNormalizedOf[string, interface{}](cache.xsyncMapOfWrapper, cfg.KeyNormalizer)
+	}
+	if c.registry != nil {
+		// the registry keeps the cache alive until Close
+		c.registered = public
+		c.registry.Register(c.registryName, public)
+	}
+	return public, view
 }
 
 // Creates a new cache with the given default expiration duration and cleanup interval.
@@ -63,367 +57,223 @@ func newXsyncMapDefault(defaultExpiration, cleanupInterval time.Duration, evicte
 	return newXsyncMap(cfg)
 }
 
-// Set add item to the cache, replacing any existing items.
-// (DefaultExpiration), the item uses a cached default expiration time.
-// (NoExpiration), the item never expires.
-// All values less than or equal to 0 are the same except DefaultExpiration,
-// which means never expires.
-func (c *xsyncMap) Set(k string, v interface{}, d time.Duration) {
-	c.items.Store(k, item{
-		v: v,
-		e: c.expiration(d),
-	})
-}
-
-func (c *xsyncMap) expiration(d time.Duration) (e int64) {
-	if d == DefaultExpiration {
-		d = c.DefaultExpiration()
-	}
-	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
-	}
-	return
-}
-
-// SetDefault add item to the cache with the default expiration time,
-// replacing any existing items.
-func (c *xsyncMap) SetDefault(k string, v interface{}) {
-	c.Set(k, v, DefaultExpiration)
-}
-
-// SetForever add item to cache and set to never expire, replacing any existing items.
-func (c *xsyncMap) SetForever(k string, v interface{}) {
-	c.Set(k, v, NoExpiration)
-}
-
-// Get an item from the cache.
-// Returns the item or nil,
-// and a boolean indicating whether the key was found.
-func (c *xsyncMap) Get(k string) (interface{}, bool) {
-	if v, ok := c.get(k); ok {
-		return v.(item).v, true
-	}
-	return nil, false
-}
-
-func (c *xsyncMap) get(k string) (interface{}, bool) {
-	v, ok := c.items.Load(k)
-	if !ok {
-		return nil, false
-	}
-
-	i := v.(item)
-	if !i.expired() {
-		return i, true
-	}
-
-	// double check or delete
-	v, ok = c.items.Compute(
-		k,
-		func(value interface{}, loaded bool) (interface{}, bool) {
-			if loaded {
-				i = value.(item)
-				if !i.expired() {
-					// k has a new value
-					return i, false
-				}
-			}
-			// delete
-			return nil, true
-		},
-	)
-	if ok {
-		return v, true
-	}
-	return nil, false
-}
-
-// GetWithExpiration get an item from the cache.
-// Returns the item or nil,
-// along with the expiration time, and a boolean indicating whether the key was found.
-func (c *xsyncMap) GetWithExpiration(k string) (interface{}, time.Time, bool) {
-	v, ok := c.get(k)
-	if !ok {
-		// not found
-		return nil, time.Time{}, false
-	}
-	i := v.(item)
-	if i.e > 0 {
-		// with expiration
-		return i.v, time.Unix(0, i.e), true
+// configOf returns the configuration of CacheOf[string, interface{}] equivalent to cfg.
+func configOf(cfg Config) ConfigOf[string, interface{}] {
+	return ConfigOf[string, interface{}]{
+		DefaultExpiration:         cfg.DefaultExpiration,
+		CleanupInterval:           cfg.CleanupInterval,
+		EvictedCallback:           EvictedCallbackOf[string, interface{}](cfg.EvictedCallback),
+		MinCapacity:               cfg.MinCapacity,
+		EventHistory:              cfg.EventHistory,
+		Shadows:                   cfg.Shadows,
+		PersistencePath:           cfg.PersistencePath,
+		PersistenceErrorHandler:   cfg.PersistenceErrorHandler,
+		DistributedLocker:         cfg.DistributedLocker,
+		LockLease:                 cfg.LockLease,
+		LockWait:                  cfg.LockWait,
+		LockPrefix:                cfg.LockPrefix,
+		NoFinalizer:               cfg.NoFinalizer,
+		Profiler:                  cfg.Profiler,
+		MaxEntries:                cfg.MaxEntries,
+		EvictionPolicy:            cfg.EvictionPolicy,
+		MaxCost:                   cfg.MaxCost,
+		SnapshotFormat:            cfg.SnapshotFormat,
+		SnapshotInterval:          cfg.SnapshotInterval,
+		SnapshotWriter:            cfg.SnapshotWriter,
+		SlidingExpiration:         cfg.SlidingExpiration,
+		EvictedCallbackWithReason: EvictedCallbackWithReasonOf[string, interface{}](cfg.EvictedCallbackWithReason),
+		CallbackWorkers:           cfg.CallbackWorkers,
+		CallbackQueueSize:         cfg.CallbackQueueSize,
+		NoCleanupLoop:             cfg.NoCleanupLoop,
+		Overflow:                  cfg.Overflow,
+		WriteThrough:              WriteFuncOf[string, interface{}](cfg.WriteThrough),
+		WriteBehind:               WriteFuncOf[string, interface{}](cfg.WriteBehind),
+		WriteBehindInterval:       cfg.WriteBehindInterval,
+		Loader:                    LoaderOf[string, interface{}](cfg.Loader),
+		StaleWhileRevalidate:      cfg.StaleWhileRevalidate,
+		RefreshAhead:              cfg.RefreshAhead,
+		TTLJitter:                 cfg.TTLJitter,
+		Registry:                  cfg.Registry,
+		RegistryName:              cfg.RegistryName,
+		CleanupOnClose:            cfg.CleanupOnClose,
+		ClosedMode:                cfg.ClosedMode,
+		Clock:                     cfg.Clock,
+		MemoryLimit:               cfg.MemoryLimit,
+		MemorySampler:             cfg.MemorySampler,
+		Codec:                     cfg.Codec,
+		ConsistentSnapshots:       cfg.ConsistentSnapshots,
+		HotKeys:                   cfg.HotKeys,
+		AdmissionPolicy:           cfg.AdmissionPolicy,
+		Hasher:                    cfg.Hasher,
+		KeyNormalizer:             cfg.KeyNormalizer,
+		PanicHandler:              cfg.PanicHandler,
+		ValueCopier:               cfg.ValueCopier,
+		WriteCoalesceWindow:       cfg.WriteCoalesceWindow,
+		CleanupHook:               cfg.CleanupHook,
+		ValuePool:                 cfg.ValuePool,
+		GrowOnly:                  cfg.GrowOnly,
+		ShrinkThreshold:           cfg.ShrinkThreshold,
 	}
-	// never expires
-	return i.v, time.Time{}, true
 }
 
-// GetWithTTL get an item from the cache.
-// Returns the item or nil,
-// with the remaining lifetime and a boolean indicating whether the key was found.
-func (c *xsyncMap) GetWithTTL(k string) (interface{}, time.Duration, bool) {
-	v, ok := c.get(k)
-	if !ok {
-		// not found
-		return nil, 0, false
-	}
-	i := v.(item)
-	if i.e > 0 {
-		// with ttl
-		return i.v, time.Until(time.Unix(0, i.e)), true
-	}
-	// never expires
-	return i.v, NoExpiration, true
+// Namespace returns a view of the cache whose keys are prefixed by prefix, e.g. "users:",
+// so that subsystems can share the cache without their keys colliding.
+// Clear, Count and the iterations of the view only cover its keys, but walk the whole cache.
+// The expiration, the callbacks and the statistics are shared with the cache,
+// and Close of the view does nothing. See NamespaceOf for CacheOf.
+func (c *xsyncMapWrapper) Namespace(prefix string) Cache {
+	return newNamespace(c, prefix, c.snapshotFormat, c.codec)
 }
 
-// GetOrSet returns the existing value for the key if present.
-// Otherwise, it stores and returns the given value.
-// The loaded result is true if the value was loaded, false if stored.
-func (c *xsyncMap) GetOrSet(k string, v interface{}, d time.Duration) (interface{}, bool) {
-	var ok bool
-	r, _ := c.items.Compute(
-		k,
-		func(value interface{}, loaded bool) (interface{}, bool) {
-			if loaded {
-				old := value.(item)
-				if !old.expired() {
-					ok = true
-					return old, false
-				}
-			}
-			return item{
-				v: v,
-				e: c.expiration(d),
-			}, false
-		},
-	)
-	return r.(item).v, ok
+// SetWithCallback add item to the cache along with its own callback fn,
+// e.g. closing a connection held in the value, replacing any existing items.
+// fn is called, besides the evicted callback, when the item expires, is deleted or is evicted,
+// but not when it is replaced. It is dropped when the key is written by other methods,
+// except GetAndRefresh, and it is not included in snapshots.
+func (c *xsyncMapWrapper) SetWithCallback(k string, v interface{}, d time.Duration, fn EvictedCallback) {
+	c.xsyncMapOf.SetWithCallback(k, v, d, EvictedCallbackOf[string, interface{}](fn))
 }
 
-// GetAndSet returns the existing value for the key if present,
-// while setting the new value for the key.
-// Otherwise, it stores and returns the given value.
-// The loaded result is true if the value was loaded, false otherwise.
-func (c *xsyncMap) GetAndSet(k string, v interface{}, d time.Duration) (interface{}, bool) {
-	var (
-		ok  bool
-		old item
-	)
-	r, _ := c.items.Compute(
-		k,
-		func(value interface{}, loaded bool) (interface{}, bool) {
-			if loaded {
-				old = value.(item)
-				if !old.expired() {
-					ok = true
-				}
-			}
-			return item{
-				v: v,
-				e: c.expiration(d),
-			}, false
-		},
-	)
-	if ok {
-		return old.v, true
-	}
-	return r.(item).v, false
+// GetBytes returns the value of the key given as bytes, e.g. read from a network buffer,
+// without allocating a string for the key, see Cache.GetBytes.
+func (c *xsyncMapWrapper) GetBytes(k []byte) (interface{}, bool) {
+	return GetBytesOf[interface{}](c.xsyncMapOfWrapper, k)
 }
 
-// GetAndRefresh Get an item from the cache, and refresh the item's expiration time.
-// Returns the item or nil,
-// and a boolean indicating whether the key was found.
-func (c *xsyncMap) GetAndRefresh(k string, d time.Duration) (interface{}, bool) {
-	r, ok := c.items.Compute(
-		k,
-		func(value interface{}, loaded bool) (interface{}, bool) {
-			if loaded {
-				i := value.(item)
-				if !i.expired() {
-					// store new value
-					i.e = c.expiration(d)
-					return i, false
-				}
-			}
-			// delete
-			return nil, true
-		},
-	)
-	if ok {
-		return r.(item).v, true
-	}
-	return nil, false
+// SetBytes stores the value for the key given as bytes, see Cache.SetBytes.
+func (c *xsyncMapWrapper) SetBytes(k []byte, v interface{}, d time.Duration) {
+	c.Set(string(k), v, d)
 }
 
-// GetOrCompute returns the existing value for the key if present.
-// Otherwise, it computes the value using the provided function and
-// returns the computed value. The loaded result is true if the value
-// was loaded, false if stored.
-func (c *xsyncMap) GetOrCompute(k string, valueFn func() interface{}, d time.Duration) (interface{}, bool) {
-	var ok bool
-	v, _ := c.items.Compute(
-		k,
-		func(value interface{}, loaded bool) (interface{}, bool) {
-			if loaded {
-				i := value.(item)
-				if !i.expired() {
-					ok = true
-					return value, false
-				}
-			}
-			return item{
-				v: valueFn(),
-				e: c.expiration(d),
-			}, false
-		},
-	)
-	return v.(item).v, ok
+// Update runs fn with a transaction buffering its writes, and applies them once fn returns nil,
+// none if fn returns an error, which is returned, see CacheOf.Update.
+func (c *xsyncMapWrapper) Update(fn func(tx Txn) error) error {
+	return c.xsyncMapOf.Update(func(tx TxnOf[string, interface{}]) error {
+		return fn(tx)
+	})
 }
 
-// Compute either sets the computed new value for the key or deletes
-// the value for the key. When the delete result of the valueFn function
-// is set to true, the value will be deleted, if it exists. When delete
-// is set to false, the value is updated to the newValue.
-// The ok result indicates whether value was computed and stored, thus, is
-// present in the map. The actual result contains the new value in cases where
-// the value was computed and stored. See the example for a few use cases.
-func (c *xsyncMap) Compute(
-	k string,
-	valueFn func(oldValue interface{}, loaded bool) (newValue interface{}, delete bool),
-	d time.Duration,
-) (interface{}, bool) {
-	var old interface{}
-	v, ok := c.items.Compute(
-		k,
-		func(ov interface{}, lok bool) (nv interface{}, del bool) {
-			var v interface{}
-			if lok {
-				i := ov.(item)
-				if !i.expired() {
-					old = i.v
-				} else {
-					lok = false
-				}
-			}
-			v, del = valueFn(old, lok)
-			if del {
-				return
-			}
-			return item{
-				v: v,
-				e: c.expiration(d),
-			}, false
-		},
-	)
-	if ok {
-		return v.(item).v, true
-	}
-	return old, false
+// Warmup loads the keys with loader, at most parallelism at a time, and stores their values
+// for the durations returned by loader, e.g. to prime the cache before serving traffic.
+// Returns a *WarmupError listing the keys which failed to load: the keys left once ctx is done
+// fail with its error, and those whose loader panicked with ErrLoaderPanicked.
+func (c *xsyncMapWrapper) Warmup(ctx context.Context, keys []string, loader Loader, parallelism int) error {
+	return warmup(ctx, keys, loader, parallelism, c.Set)
 }
 
-// GetAndDelete Get an item from the cache, and delete the key.
-// Returns the item or nil,
-// and a boolean indicating whether the key was found.
-func (c *xsyncMap) GetAndDelete(k string) (interface{}, bool) {
-	v, ok := c.items.LoadAndDelete(k)
-	if !ok {
-		return nil, false
-	}
-	i := v.(item)
-	ec := c.EvictedCallback()
-	if ec != nil {
-		ec(k, i.v)
-	}
-	return i.v, true
+// ScanItems returns the items present in the cache, starting from the cursor,
+// and the cursor to resume the iteration from, like RangeCursor.
+func (c *xsyncMapWrapper) ScanItems(cursor uint64, count int) (items []KeyValue, next uint64) {
+	next = c.RangeCursor(cursor, count, func(k string, v interface{}) {
+		items = append(items, KeyValue{k, v})
+	})
+	return
 }
 
-// Delete an item from the cache.
-// Does nothing if the key is not in the cache.
-func (c *xsyncMap) Delete(k string) {
-	c.GetAndDelete(k)
+// Scan incrementally iterates over the keys in the cache, with Redis SCAN semantics, see Cache.Scan.
+func (c *xsyncMapWrapper) Scan(pattern string, cursor uint64, count int) (keys []string, next uint64) {
+	return ScanOf[interface{}](c.xsyncMapOfWrapper, pattern, cursor, count)
 }
 
-type kv struct {
-	k string
-	v interface{}
+// RangeSorted calls f sequentially for each key and value present in the cache, in ascending key order.
+// If f returns false, range stops the iteration.
+// The unexpired items are copied and sorted on each call, in O(n log n) time and O(n) memory.
+func (c *xsyncMapWrapper) RangeSorted(f func(k string, v interface{}) bool) {
+	RangeSortedOf[string, interface{}](c.xsyncMapOfWrapper, f)
 }
 
-// DeleteExpired delete all expired items from the cache.
-func (c *xsyncMap) DeleteExpired() {
-	var evictedItems []kv
-	ec := c.EvictedCallback()
-	now := time.Now().UnixNano()
-	c.items.Range(func(k string, v interface{}) bool {
-		i := v.(item)
-		if i.expiredWithNow(now) {
-			c.items.Delete(k)
-			if ec != nil {
-				evictedItems = append(evictedItems, kv{k, i.v})
-			}
-		}
-		return true
-	})
-	for _, v := range evictedItems {
-		ec(v.k, v.v)
-	}
+// RangeSortedFunc calls f sequentially for each key and value present in the cache,
+// in the key order defined by less. If f returns false, range stops the iteration.
+// The unexpired items are copied and sorted on each call, in O(n log n) time and O(n) memory.
+func (c *xsyncMapWrapper) RangeSortedFunc(less func(a, b string) bool, f func(k string, v interface{}) bool) {
+	RangeSortedFuncOf[string, interface{}](c.xsyncMapOfWrapper, less, f)
 }
 
-// Range calls f sequentially for each key and value present in the map.
-// If f returns false, range stops the iteration.
-func (c *xsyncMap) Range(f func(k string, v interface{}) bool) {
-	if f == nil {
-		return
-	}
-	now := time.Now().UnixNano()
-	c.items.Range(func(k string, v interface{}) bool {
-		i := v.(item)
-		if i.expiredWithNow(now) {
-			return true
-		}
-		return f(k, i.v)
-	})
+// ItemsSorted returns the unexpired items in ascending key order.
+// The items are copied and sorted on each call, in O(n log n) time.
+func (c *xsyncMapWrapper) ItemsSorted() []KeyValue {
+	return sortedItems(c.Range, c.items.Size(), func(a, b string) bool { return a < b })
 }
 
-// Items return the items in the cache.
-// This is a snapshot, which may include items that are about to expire.
-func (c *xsyncMap) Items() map[string]interface{} {
-	items := make(map[string]interface{}, c.items.Size())
-	c.Range(func(k string, v interface{}) bool {
-		items[k] = v
+// sortedItems returns the items iterated by rangeFn in the key order defined by less,
+// sizeHint is the number of items expected.
+func sortedItems(rangeFn func(f func(k string, v interface{}) bool), sizeHint int, less func(a, b string) bool) []KeyValue {
+	items := make([]KeyValue, 0, sizeHint)
+	rangeFn(func(k string, v interface{}) bool {
+		items = append(items, KeyValue{k, v})
 		return true
 	})
+	sort.Slice(items, func(i, j int) bool { return less(items[i].Key, items[j].Key) })
 	return items
 }
 
-// Clear deletes all keys and values currently stored in the map.
-func (c *xsyncMap) Clear() {
-	c.items.Clear()
+// KeysSorted returns the keys of the unexpired items in ascending order.
+// The keys are sorted on each call, in O(n log n) time.
+func (c *xsyncMapWrapper) KeysSorted() []string {
+	return KeysSortedOf[string, interface{}](c.xsyncMapOfWrapper)
 }
 
-// Count returns the number of items in the cache.
-// This may include items that have expired but have not been cleaned up.
-func (c *xsyncMap) Count() int {
-	return c.items.Size()
+// ItemsWithPrefix returns the items in the cache whose keys start with prefix, like ItemsWhere.
+func (c *xsyncMapWrapper) ItemsWithPrefix(prefix string) map[string]interface{} {
+	return ItemsWithPrefixOf[interface{}](c.xsyncMapOfWrapper, prefix)
 }
 
-// DefaultExpiration returns the default expiration time for the cache.
-func (c *xsyncMap) DefaultExpiration() time.Duration {
-	return c.defaultExpiration.Load().(time.Duration)
+// ItemsWithExpiration return the unexpired items in the cache along with their expiration time.
+// This is a snapshot, which may include items that are about to expire.
+func (c *xsyncMapWrapper) ItemsWithExpiration() map[string]ItemWithExpiration {
+	of := c.xsyncMapOf.ItemsWithExpiration()
+	items := make(map[string]ItemWithExpiration, len(of))
+	for k, x := range of {
+		items[k] = ItemWithExpiration(x)
+	}
+	return items
 }
 
-// SetDefaultExpiration sets the default expiration time for the cache.
-// Atomic safety.
-func (c *xsyncMap) SetDefaultExpiration(defaultExpiration time.Duration) {
-	c.defaultExpiration.Store(defaultExpiration)
+// LoadItemsWithExpiration stores the unexpired items with their expiration time.
+// The optional strategy decides which item is kept when a key is already present,
+// the default is Overwrite.
+func (c *xsyncMapWrapper) LoadItemsWithExpiration(items map[string]ItemWithExpiration, strategy ...LoadStrategy) {
+	of := make(map[string]ItemWithExpirationOf[interface{}], len(items))
+	for k, x := range items {
+		of[k] = ItemWithExpirationOf[interface{}](x)
+	}
+	c.xsyncMapOf.LoadItemsWithExpiration(of, strategy...)
 }
 
 // EvictedCallback returns the callback function to execute
 // when a key-value pair expires and is evicted.
-func (c *xsyncMap) EvictedCallback() EvictedCallback {
-	return c.evictedCallback.Load().(EvictedCallback)
+func (c *xsyncMapWrapper) EvictedCallback() EvictedCallback {
+	return EvictedCallback(c.xsyncMapOf.EvictedCallback())
 }
 
 // SetEvictedCallback Set the callback function to be executed
 // when the key-value pair expires and is evicted.
 // Atomic safety.
-func (c *xsyncMap) SetEvictedCallback(evictedCallback EvictedCallback) {
-	c.evictedCallback.Store(evictedCallback)
+func (c *xsyncMapWrapper) SetEvictedCallback(evictedCallback EvictedCallback) {
+	c.xsyncMapOf.SetEvictedCallback(EvictedCallbackOf[string, interface{}](evictedCallback))
+}
+
+// RecentEvents returns the recent operations on the cache, oldest first.
+// Returns nil if the event history is not enabled, see WithEventHistory.
+func (c *xsyncMapWrapper) RecentEvents() []Event {
+	of := c.xsyncMapOf.RecentEvents()
+	if of == nil {
+		return nil
+	}
+	events := make([]Event, len(of))
+	for i, e := range of {
+		events[i] = Event(e)
+	}
+	return events
+}
+
+// HotKeys returns up to n of the most read keys with their estimated number of reads,
+// the most read first, all the tracked keys if n is less than 1.
+// Returns nil if not enabled.
+func (c *xsyncMapWrapper) HotKeys(n int) []KeyStat {
+	var stats []KeyStat
+	for _, s := range c.xsyncMapOf.HotKeys(n) {
+		stats = append(stats, KeyStat(s))
+	}
+	return stats
 }
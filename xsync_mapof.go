@@ -4,9 +4,16 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/fufuok/cache/internal/xsync"
 )
 
 var (
@@ -19,10 +26,61 @@ type xsyncMapOfWrapper[K comparable, V any] struct {
 }
 
 type xsyncMapOf[K comparable, V any] struct {
+	version           uint64 // the last version of the items, first to be 64-bit aligned, see GetWithVersion
 	defaultExpiration atomic.Value
 	evictedCallback   atomic.Value
 	items             MapOf[K, itemOf[V]]
 	stop              chan struct{}
+	wg                sync.WaitGroup // the background goroutines, waited for by Close
+	hasher            func(K, uint64) uint64
+	seed              uint64
+	events            *eventHistoryOf[K]
+	shadow            *shadowTracker
+	hot               *hotKeys
+	noCopyReads       bool // the reads do not retain their key, see GetBytes
+	plainReads        bool // no feature tracks the reads, see getE
+	persistencePath   string
+	persistenceSave   bool // the snapshot of persistencePath is restored or missing, and saved on Close
+	sliding           bool
+	snapshotWriter    SnapshotWriterFactory
+	closed            uint32
+	loads             loadGroupOf[K, V]
+	lock              *distributedLock
+	profiler          Profiler
+	snapshotFormat    SnapshotFormat
+	codec             Codec
+	evictor           *evictorOf[K]
+	reasonCallback    EvictedCallbackWithReasonOf[K, V]
+	callbacks         *callbackDispatcher
+	noCleanupLoop     bool
+	expiry            *expiryIndexOf[K]
+	tags              *tagIndexOf[K]
+	invalidations     *invalidationsOf[K]
+	overflow          *overflowOf[K, V]
+	writer            *storeWriterOf[K, V]
+	loader            LoaderOf[K, V]
+	reads             loadGroupOf[K, itemOf[V]] // the loads of the loader, see WithLoader
+	revalidating      sync.Map                  // the keys reloaded in the background, see revalidate
+	staleTTL          int64
+	refreshAhead      float64
+	ttlJitter         float64
+	registry          *Registry
+	registryName      string
+	registered        Registered // the handle registered, unregistered on Close if still registered
+	cleanupOnClose    bool
+	cleanupInterval   atomic.Value // time.Duration, see SetCleanupInterval
+	cleanupPaused     uint32
+	cleanupReset      chan struct{} // wakes up the cleanup loop on SetCleanupInterval
+	cleanupMu         sync.Mutex    // guards cleanupStarted and the closing, see startCleanupLoop
+	cleanupStarted    bool
+	clock             Clock
+	panicHandler      PanicHandler
+	cleanupHook       func(report CleanupReport)
+	pool              ValuePool
+	copier            func(v V) V
+	guard             *closedGuardOf[K, itemOf[V]] // the items once closed, nil in ClosedAllow mode
+	txns              sync.Mutex                   // the commits of Update
+	freezer           *freezerOf[K, itemOf[V]]     // the items during ItemsAtomic, nil without WithConsistentSnapshotsOf
 }
 
 // Creates a new MapOf instance with capacity enough to hold sizeHint entries.
@@ -30,21 +88,103 @@ func newXsyncMapOf[K comparable, V any](
 	config ...ConfigOf[K, V],
 ) CacheOf[K, V] {
 	cfg := configDefaultOf(config...)
+	c := newXsyncMapOfCore(cfg)
+	cache := &xsyncMapOfWrapper[K, V]{c}
+	if !cfg.NoFinalizer {
+		runtime.SetFinalizer(cache, func(m *xsyncMapOfWrapper[K, V]) { m.shutdown() })
+	}
+	var public CacheOf[K, V] = cache
+	if cfg.KeyNormalizer != nil {
+		public = newNormalizedOf[K, V](cache, cfg.KeyNormalizer)
+	}
+	if c.registry != nil {
+		// the registry keeps the cache alive until Close
+		c.registered = public
+		c.registry.Register(c.registryName, public)
+	}
+	return public
+}
+
+// newXsyncMapOfCore creates the cache of the configuration cfg, and starts its background goroutines,
+// the caller wraps it in its public handle, see newXsyncMapOf and newXsyncMap.
+func newXsyncMapOfCore[K comparable, V any](cfg ConfigOf[K, V]) *xsyncMapOf[K, V] {
 	c := &xsyncMapOf[K, V]{
-		items: NewMapOfPresized[K, itemOf[V]](cfg.MinCapacity),
-		stop:  make(chan struct{}),
+		items:           xsync.NewMapOf[K, itemOf[V]](itemsMapOptions(cfg.MinCapacity, cfg.GrowOnly, cfg.ShrinkThreshold)...),
+		stop:            make(chan struct{}),
+		hasher:          xsync.DefaultHasher[K](),
+		seed:            xsync.MakeSeed(),
+		events:          newEventHistoryOf[K](cfg.EventHistory),
+		shadow:          newShadowTracker(cfg.Shadows),
+		hot:             newHotKeys(cfg.HotKeys),
+		persistencePath: cfg.PersistencePath,
+		sliding:         cfg.SlidingExpiration,
+		snapshotWriter:  cfg.SnapshotWriter,
+		lock:            newDistributedLock(cfg.DistributedLocker, cfg.LockLease, cfg.LockWait, lockPrefix(cfg.LockPrefix, cfg.RegistryName)),
+		profiler:        cfg.Profiler,
+		snapshotFormat:  codecFormat(cfg.Codec, cfg.SnapshotFormat),
+		codec:           cfg.Codec,
+		evictor:         newEvictorOf[K](cfg.MaxEntries, cfg.MaxCost, cfg.EvictionPolicy, cfg.AdmissionPolicy, cfg.MemoryLimit > 0),
+		reasonCallback:  cfg.EvictedCallbackWithReason,
+		noCleanupLoop:   cfg.NoCleanupLoop,
+		expiry:          newExpiryIndexOf[K](),
+		tags:            newTagIndexOf[K](),
+		invalidations:   newInvalidationsOf[K](),
+		loader:          cfg.Loader,
+		staleTTL:        int64(cfg.StaleWhileRevalidate),
+		refreshAhead:    cfg.RefreshAhead,
+		ttlJitter:       cfg.TTLJitter,
+		writer:          newStoreWriterOf[K, V](cfg.WriteThrough, cfg.WriteBehind, cfg.WriteCoalesceWindow, cfg.Clock),
+		registry:        cfg.Registry,
+		registryName:    cfg.RegistryName,
+		cleanupOnClose:  cfg.CleanupOnClose,
+		cleanupReset:    make(chan struct{}, 1),
+		clock:           cfg.Clock,
+		panicHandler:    cfg.PanicHandler,
+		copier:          cfg.ValueCopier,
+		cleanupHook:     cfg.CleanupHook,
+		pool:            cfg.ValuePool,
+	}
+	c.overflow = newOverflowOf[K, V](cfg.Overflow, &c.wg, c.stop)
+	if cfg.Hasher != nil {
+		c.hasher = cfg.Hasher
+		c.items = xsync.NewMapOfWithHasher[K, itemOf[V]](cfg.Hasher, itemsMapOptions(cfg.MinCapacity, cfg.GrowOnly, cfg.ShrinkThreshold)...)
 	}
+	// the keys given as bytes are only aliased by the reads when no feature may retain them
+	c.noCopyReads = c.events == nil && c.hot == nil && c.evictor == nil && c.refreshAhead == 0
+	c.plainReads = c.noCopyReads && c.shadow == nil && c.profiler == nil
+	if c.guard = newClosedGuardOf[K, itemOf[V]](c.items, cfg.ClosedMode); c.guard != nil {
+		c.items = c.guard
+	}
+	if c.freezer = newFreezerOf[K, itemOf[V]](c.items, cfg.ConsistentSnapshots); c.freezer != nil {
+		c.items = c.freezer
+	}
+	c.callbacks = newCallbackDispatcher(cfg.CallbackWorkers, cfg.CallbackQueueSize, cfg.PanicHandler, &c.wg)
 	c.defaultExpiration.Store(cfg.DefaultExpiration)
+	c.cleanupInterval.Store(cfg.CleanupInterval)
 	c.evictedCallback.Store(cfg.EvictedCallback)
 
-	if cfg.CleanupInterval > 0 {
+	if c.persistencePath != "" {
+		c.persistenceSave = restoreFile(c.persistencePath, c.LoadFromFile, cfg.PersistenceErrorHandler)
+	}
+
+	if !c.noCleanupLoop && cfg.CleanupInterval > 0 {
+		c.startCleanupLoop()
+	}
+
+	if c.writer != nil && c.writer.behind {
+		interval := cfg.WriteBehindInterval
+		if interval <= 0 {
+			interval = DefaultWriteBehindInterval
+		}
+		ticker := c.clock.NewTicker(interval)
+		c.wg.Add(1)
 		go func() {
-			ticker := time.NewTicker(cfg.CleanupInterval)
+			defer c.wg.Done()
 			defer ticker.Stop()
 			for {
 				select {
-				case <-ticker.C:
-					c.DeleteExpired()
+				case <-ticker.Chan():
+					c.writer.flush(false)
 				case <-c.stop:
 					return
 				}
@@ -52,9 +192,48 @@ func newXsyncMapOf[K comparable, V any](
 		}()
 	}
 
-	cache := &xsyncMapOfWrapper[K, V]{c}
-	runtime.SetFinalizer(cache, func(m *xsyncMapOfWrapper[K, V]) { close(m.stop) })
-	return cache
+	if cfg.SnapshotInterval > 0 && c.snapshotWriter != nil {
+		ticker := c.clock.NewTicker(cfg.SnapshotInterval)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.Chan():
+					// failed snapshots are retried on the next tick
+					_ = saveSnapshot(c.snapshotWriter, c.SaveTo)
+				case <-c.stop:
+					return
+				}
+			}
+		}()
+	}
+
+	if cfg.MemoryLimit > 0 {
+		sampler := cfg.MemorySampler
+		if sampler == nil {
+			sampler = HeapSampler
+		}
+		ticker := c.clock.NewTicker(DefaultMemoryCheckInterval)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.Chan():
+					if sampler() > cfg.MemoryLimit {
+						c.shed()
+					}
+				case <-c.stop:
+					return
+				}
+			}
+		}()
+	}
+
+	return c
 }
 
 // Create a new cache with arbitrarily typed keys,
@@ -82,22 +261,165 @@ func newXsyncMapOfDefault[K comparable, V any](
 // All values less than or equal to 0 are the same except DefaultExpiration,
 // which means never expires.
 func (c *xsyncMapOf[K, V]) Set(k K, v V, d time.Duration) {
-	c.items.Store(k, itemOf[V]{
-		v: v,
-		e: c.expiration(d),
-	})
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	c.store(k, c.item(v, c.expiration(d), d))
+	c.record(EventSet, k, true)
+}
+
+// SetE add item to the cache like Set, but returns ErrClosed once closed in ClosedIgnore mode,
+// or ErrCapacityExceeded if the item was evicted as soon as it was stored,
+// e.g. costing more than the maximum cost or rejected by the admission policy.
+// It returns the error of the WithWriteThroughOf function as is, and the item is not stored then.
+func (c *xsyncMapOf[K, V]) SetE(k K, v V, d time.Duration) error {
+	if err := c.guard.err(); err != nil {
+		return err
+	}
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	if err := c.writer.write(k, v); err != nil {
+		return err
+	}
+	c.put(k, c.item(v, c.expiration(d), d))
+	c.record(EventSet, k, true)
+	if c.evictor != nil {
+		if _, ok := c.items.Load(k); !ok {
+			return ErrCapacityExceeded
+		}
+	}
+	return nil
 }
 
+// expiration returns the expiration time of an item stored for d, 0 if it never expires.
+// The item is scheduled for DeleteExpired once stored, see schedule.
 func (c *xsyncMapOf[K, V]) expiration(d time.Duration) (e int64) {
 	if d == DefaultExpiration {
 		d = c.DefaultExpiration()
 	}
 	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
+		e = c.clock.Now().Add(jitter(d, c.ttlJitter)).UnixNano()
 	}
 	return
 }
 
+// expirationAt is expiration with the clock read at now in Unix nanoseconds,
+// for the callers that checked the old item of the key at the same instant.
+func (c *xsyncMapOf[K, V]) expirationAt(d time.Duration, now int64) (e int64) {
+	if d == DefaultExpiration {
+		d = c.DefaultExpiration()
+	}
+	if d > 0 {
+		e = now + int64(jitter(d, c.ttlJitter))
+	}
+	return
+}
+
+// schedule adds the key k expiring at e to the expiry index, if it expires, and removes it
+// from the bucket of prev, the expiration of the item it replaced, if it moves.
+// It is called once the item is stored, so that Clear, which clears the index first,
+// never drops the key of an item stored after it.
+func (c *xsyncMapOf[K, V]) schedule(k K, prev, e int64) {
+	if (e > 0 || prev > 0) && !c.guard.closed() {
+		c.expiry.add(c.hasher(k, c.seed), k, prev, e)
+	}
+}
+
+// slidingTTL returns the sliding lifetime of an item stored for the duration d,
+// 0 if the sliding expiration is disabled or the item never expires.
+func (c *xsyncMapOf[K, V]) slidingTTL(d time.Duration) int64 {
+	if !c.sliding {
+		return 0
+	}
+	if d == DefaultExpiration {
+		d = c.DefaultExpiration()
+	}
+	if d > 0 {
+		return int64(d)
+	}
+	return 0
+}
+
+// Update runs fn with a transaction buffering its writes, and applies them once fn returns nil,
+// none if fn returns an error, which is returned. The writes are applied in the order of the
+// first write of each key, each key atomically, and the transactions one at a time,
+// but the other reads and writes may observe a transaction partially applied.
+func (c *xsyncMapOf[K, V]) Update(fn func(tx TxnOf[K, V]) error) error {
+	if err := c.guard.err(); err != nil {
+		return err
+	}
+	tx := newTxnOf[K, V](c.Get)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	c.txns.Lock()
+	defer c.txns.Unlock()
+	tx.commit(c.Set, c.Delete)
+	return nil
+}
+
+// nextVersion returns the version of an item read by GetWithVersion for the first time since it was written.
+func (c *xsyncMapOf[K, V]) nextVersion() uint64 {
+	return atomic.AddUint64(&c.version, 1)
+}
+
+// panicked passes the value r of a panic recovered from a function computing a value
+// to the panic handler, or raises it again without one, see WithPanicHandlerOf.
+func (c *xsyncMapOf[K, V]) panicked(r any) {
+	if c.panicHandler == nil {
+		panic(r)
+	}
+	c.panicHandler(r)
+}
+
+// recovered recovers a panic of a background goroutine, passed to the panic handler if any.
+func (c *xsyncMapOf[K, V]) recovered() {
+	if r := recover(); r != nil && c.panicHandler != nil {
+		c.panicHandler(r)
+	}
+}
+
+// lifetime returns the lifetime of an item stored for the duration d,
+// 0 if the refresh ahead is disabled or the item never expires.
+func (c *xsyncMapOf[K, V]) lifetime(d time.Duration) int64 {
+	if c.refreshAhead == 0 {
+		return 0
+	}
+	if d == DefaultExpiration {
+		d = c.DefaultExpiration()
+	}
+	if d > 0 {
+		return int64(d)
+	}
+	return 0
+}
+
+// item returns the item of the value v expiring at e, stored for the duration d, see Set for d,
+// along with the metadata of the features enabled.
+func (c *xsyncMapOf[K, V]) item(v V, e int64, d time.Duration) itemOf[V] {
+	return c.itemWith(v, e, d, itemExt{})
+}
+
+// itemWith returns the item like item, along with the metadata x given by the caller.
+func (c *xsyncMapOf[K, V]) itemWith(v V, e int64, d time.Duration, x itemExt) itemOf[V] {
+	x.t, x.d, x.n = c.slidingTTL(d), c.lifetime(d), c.invalidations.stamp()
+	return itemOf[V]{v: v, e: e, x: x.alloc()}
+}
+
+// expireIn returns the item i expiring in d from now, see Set for d,
+// keeping its other metadata.
+func (c *xsyncMapOf[K, V]) expireIn(i itemOf[V], d time.Duration) itemOf[V] {
+	i.e = c.expiration(d)
+	t, l := c.slidingTTL(d), c.lifetime(d)
+	if i.x == nil && t == 0 && l == 0 {
+		return i
+	}
+	return i.withExt(func(x *itemExt) {
+		x.t, x.d = t, l
+	})
+}
+
 // SetDefault add item to the cache with the default expiration time,
 // replacing any existing items.
 func (c *xsyncMapOf[K, V]) SetDefault(k K, v V) {
@@ -109,9 +431,65 @@ func (c *xsyncMapOf[K, V]) SetForever(k K, v V) {
 	c.Set(k, v, NoExpiration)
 }
 
+// SetWithMeta add item to the cache along with the opaque metadata meta,
+// e.g. its source, trace ID or cost, replacing any existing items.
+// The metadata is dropped when the key is written by other methods, except GetAndRefresh,
+// and it is not included in snapshots.
+func (c *xsyncMapOf[K, V]) SetWithMeta(k K, v V, d time.Duration, meta any) {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	c.store(k, c.itemWith(v, c.expiration(d), d, itemExt{m: meta}))
+	c.record(EventSet, k, true)
+}
+
+// SetWithCost add item to the cache with the given cost, e.g. its size in bytes,
+// replacing any existing items. Items stored by other methods cost 1.
+// Items are evicted when the total cost exceeds the maximum cost, see WithMaxCostOf.
+// An item costing more than the maximum cost evicts all the items, itself included.
+func (c *xsyncMapOf[K, V]) SetWithCost(k K, v V, d time.Duration, cost int64) {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	c.store(k, c.item(v, c.expiration(d), d))
+	c.recordCost(EventSet, k, true, cost)
+}
+
+// SetWithCallback add item to the cache along with its own callback fn,
+// e.g. closing a connection held in the value, replacing any existing items.
+// fn is called, besides the evicted callback, when the item expires, is deleted or is evicted,
+// but not when it is replaced. It is dropped when the key is written by other methods,
+// except GetAndRefresh, and it is not included in snapshots.
+func (c *xsyncMapOf[K, V]) SetWithCallback(k K, v V, d time.Duration, fn EvictedCallbackOf[K, V]) {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	var x itemExt
+	if fn != nil {
+		x.f = func() { fn(k, v) }
+	}
+	c.store(k, c.itemWith(v, c.expiration(d), d, x))
+	c.record(EventSet, k, true)
+}
+
+// SetWithTags add item to the cache along with tags, e.g. "user:42", replacing any existing items,
+// so that the items sharing a tag can be deleted at once by InvalidateTag.
+// The tags are dropped when the key is written by other methods, except GetAndRefresh,
+// and they are not included in snapshots.
+func (c *xsyncMapOf[K, V]) SetWithTags(k K, v V, d time.Duration, tags ...string) {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	c.store(k, c.itemWith(v, c.expiration(d), d, itemExt{g: tags}))
+	c.tags.add(k, tags)
+	c.record(EventSet, k, true)
+}
+
 // Get an item from the cache.
 // Returns the item or nil,
 // and a boolean indicating whether the key was found.
+// With the default options, a hit or a miss does not allocate,
+// see the cachetest package to check it with other options.
 func (c *xsyncMapOf[K, V]) Get(k K) (V, bool) {
 	i, ok := c.get(k)
 	if ok {
@@ -120,33 +498,190 @@ func (c *xsyncMapOf[K, V]) Get(k K) (V, bool) {
 	return i.v, false
 }
 
+// GetE get an item from the cache like Get, but returns an error instead of a boolean:
+// ErrNotFound if the key is missing or expired, the error of the loader if it failed
+// to load the key, see WithLoaderOf, or ErrClosed once closed in ClosedIgnore mode.
+func (c *xsyncMapOf[K, V]) GetE(k K) (V, error) {
+	if err := c.guard.err(); err != nil {
+		var zeroedV V
+		return zeroedV, err
+	}
+	i, err := c.getE(k)
+	if err != nil {
+		return i.v, err
+	}
+	return c.copied(i.v), nil
+}
+
 func (c *xsyncMapOf[K, V]) get(k K) (itemOf[V], bool) {
+	i, err := c.getE(k)
+	if err != nil {
+		return i, false
+	}
+	i.v = c.copied(i.v)
+	return i, true
+}
+
+// copied returns the copy of the value v read by the caller, see WithValueCopierOf.
+func (c *xsyncMapOf[K, V]) copied(v V) V {
+	if c.copier == nil {
+		return v
+	}
+	return c.copier(v)
+}
+
+// getE returns the unexpired item of the key k, reloading or loading it if missing,
+// ErrNotFound or the error of the loader otherwise.
+func (c *xsyncMapOf[K, V]) getE(k K) (itemOf[V], error) {
+	if c.plainReads {
+		// the hits are only loaded, unless the item has metadata, e.g. a sliding lifetime
+		if i, ok := c.items.Load(k); ok && i.x == nil && !c.expired(k, i) {
+			return i, nil
+		}
+	}
+	if c.profiler != nil {
+		defer c.profile(ProfileGet, time.Now())
+	}
 	var zeroedV itemOf[V]
+	if c.guard.closed() {
+		// neither reloaded nor loaded through
+		return zeroedV, ErrNotFound
+	}
 	i, ok := c.items.Load(k)
 	if !ok {
-		return zeroedV, false
+		if i, ok := c.reload(k); ok {
+			c.record(EventGet, k, true)
+			return i, nil
+		}
+		c.record(EventGet, k, false)
+		return c.readThrough(k)
 	}
 
-	if !i.expired() {
-		return i, true
+	if !c.expired(k, i) {
+		c.record(EventGet, k, true)
+		c.refresh(k, i)
+		if i.ext().t > 0 {
+			return c.slide(k, i), nil
+		}
+		return i, nil
+	}
+
+	if c.stale(k, i, c.now()) {
+		c.record(EventGet, k, true)
+		c.revalidate(k, i)
+		return i, nil
 	}
 
 	// double check or delete
-	i, ok = c.items.Compute(
+	var (
+		expired bool
+		old     itemOf[V]
+	)
+	i, ok = c.items.ComputeWithOp(
 		k,
-		func(value itemOf[V], loaded bool) (itemOf[V], bool) {
-			if loaded && !value.expired() {
+		func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if loaded && !c.expired(k, value) {
 				// k has a new value
-				return value, false
+				return value, UpdateOp
 			}
 			// delete
-			return zeroedV, true
+			expired, old = loaded, value
+			return zeroedV, DeleteOp
 		},
 	)
+	if expired {
+		c.deletedExpired(k, old)
+	}
+	c.record(EventGet, k, ok)
 	if ok {
-		return i, true
+		return i, nil
 	}
-	return zeroedV, false
+	return c.readThrough(k)
+}
+
+// deletedExpired reports the expired item i of the key k deleted by an operation on the key,
+// like the cleanup does: the evictor forgets the key, its tags are dropped and the callbacks are called.
+func (c *xsyncMapOf[K, V]) deletedExpired(k K, i itemOf[V]) {
+	c.record(EventExpire, k, true)
+	c.untag(k, i)
+	if i.ext().f != nil {
+		c.callbacks.do(i.ext().f)
+	}
+	c.discarded(k, i, ReasonExpired)
+}
+
+// readThrough loads the missing key k with the loader, see WithLoader.
+func (c *xsyncMapOf[K, V]) readThrough(k K) (itemOf[V], error) {
+	if c.loader == nil {
+		var zeroedV itemOf[V]
+		return zeroedV, ErrNotFound
+	}
+	return c.loadThrough(k, -1)
+}
+
+// loadThrough calls the loader once for concurrent callers with the same key k,
+// and stores the value. e is the expiration time of the item reloaded, -1 for a missing key,
+// the loader is not called if the key holds another unexpired item.
+func (c *xsyncMapOf[K, V]) loadThrough(k K, e int64) (itemOf[V], error) {
+	i, _, err := c.reads.do(k, func() (itemOf[V], bool, error) {
+		// stored by a load that completed meanwhile
+		if i, ok := c.items.Load(k); ok && i.e != e && !c.expired(k, i) {
+			return i, true, nil
+		}
+		v, d, err := c.loader(context.Background(), k)
+		if err != nil {
+			var zeroedV itemOf[V]
+			return zeroedV, false, err
+		}
+		i := c.item(v, c.expiration(d), d)
+		c.put(k, i)
+		c.record(EventCompute, k, true)
+		return i, false, nil
+	})
+	return i, err
+}
+
+// revalidate reloads the key k of the item i in the background,
+// see WithStaleWhileRevalidate and WithRefreshAhead.
+// The reads of the key while it is reloaded do not start another reload.
+func (c *xsyncMapOf[K, V]) revalidate(k K, i itemOf[V]) {
+	if _, loaded := c.revalidating.LoadOrStore(k, struct{}{}); loaded {
+		return
+	}
+	go func() {
+		defer c.revalidating.Delete(k)
+		defer c.recovered()
+		_, _ = c.loadThrough(k, i.e)
+	}()
+}
+
+// refresh reloads the key k in the background if its item i is read in the last part
+// of its lifetime, see WithRefreshAhead. The reads during the reload do not start another one.
+func (c *xsyncMapOf[K, V]) refresh(k K, i itemOf[V]) {
+	if i.ext().d > 0 && time.Duration(i.e-c.now()) < time.Duration(float64(i.ext().d)*c.refreshAhead) {
+		c.revalidate(k, i)
+	}
+}
+
+// slide extends the lifetime of the unexpired item read for the key by its sliding lifetime.
+func (c *xsyncMapOf[K, V]) slide(k K, i itemOf[V]) itemOf[V] {
+	v, ok := c.items.ComputeWithOp(
+		k,
+		func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if !loaded {
+				return value, DeleteOp
+			}
+			if value.ext().t > 0 && !c.expired(k, value) {
+				value.e = c.now() + value.ext().t
+			}
+			return value, UpdateOp
+		},
+	)
+	if !ok {
+		// deleted meanwhile
+		return i
+	}
+	return v
 }
 
 // GetWithExpiration get an item from the cache.
@@ -167,6 +702,20 @@ func (c *xsyncMapOf[K, V]) GetWithExpiration(k K) (V, time.Time, bool) {
 	return i.v, time.Time{}, true
 }
 
+// GetWithExpirationNano get an item from the cache.
+// Returns the item or nil, along with the expiration time in Unix nanoseconds, 0 if it never
+// expires, and a boolean indicating whether the key was found.
+// Unlike GetWithExpiration, it does not build a time.Time, for the hot paths.
+func (c *xsyncMapOf[K, V]) GetWithExpirationNano(k K) (V, int64, bool) {
+	i, ok := c.get(k)
+	if !ok {
+		// not found
+		var v V
+		return v, 0, false
+	}
+	return i.v, i.e, true
+}
+
 // GetWithTTL get an item from the cache.
 // Returns the item or nil,
 // with the remaining lifetime and a boolean indicating whether the key was found.
@@ -179,104 +728,601 @@ func (c *xsyncMapOf[K, V]) GetWithTTL(k K) (V, time.Duration, bool) {
 	}
 	if i.e > 0 {
 		// with ttl
-		return i.v, time.Until(time.Unix(0, i.e)), true
+		return i.v, time.Duration(i.e - c.now()), true
 	}
 	// never expires
 	return i.v, NoExpiration, true
 }
 
+// GetWithMeta get an item from the cache.
+// Returns the item or nil,
+// with the metadata set by SetWithMeta and a boolean indicating whether the key was found.
+func (c *xsyncMapOf[K, V]) GetWithMeta(k K) (V, any, bool) {
+	i, ok := c.get(k)
+	return i.v, i.ext().m, ok
+}
+
+// GetWithVersion get an item from the cache.
+// Returns the item or the zero value, with its version and a boolean indicating whether the key was found.
+// The version changes on every write of the value of the key, never on a read or an expiration change,
+// and is never 0 for a key found, see SetIfVersion.
+// The version is assigned by the first GetWithVersion since the write, so that the writes do not pay for it.
+func (c *xsyncMapOf[K, V]) GetWithVersion(k K) (V, uint64, bool) {
+	i, ok := c.get(k)
+	if !ok || i.ext().r != 0 {
+		return i.v, i.ext().r, ok
+	}
+	if i, ok = c.versioned(k); !ok {
+		var zeroedV V
+		return zeroedV, 0, false
+	}
+	return c.copied(i.v), i.ext().r, true
+}
+
+// Peek get an item from the cache like Get, without any side effect: a missing key is not loaded,
+// the expiration is neither slid nor refreshed, and the stats, the events, the hot keys
+// and the eviction policy do not see the read. An expired item is reported missing, but not deleted.
+func (c *xsyncMapOf[K, V]) Peek(k K) (V, bool) {
+	i, ok := c.peek(k)
+	return i.v, ok
+}
+
+// PeekWithExpiration get an item from the cache like GetWithExpiration, without any side effect, see Peek.
+func (c *xsyncMapOf[K, V]) PeekWithExpiration(k K) (V, time.Time, bool) {
+	i, ok := c.peek(k)
+	if !ok || i.e == 0 {
+		return i.v, time.Time{}, ok
+	}
+	return i.v, time.Unix(0, i.e), true
+}
+
+// peek returns the unexpired item of the key k in memory, copied, without any side effect.
+func (c *xsyncMapOf[K, V]) peek(k K) (itemOf[V], bool) {
+	var zeroedV itemOf[V]
+	if c.guard.closed() {
+		return zeroedV, false
+	}
+	i, ok := c.items.Load(k)
+	if !ok || c.expired(k, i) {
+		return zeroedV, false
+	}
+	i.v = c.copied(i.v)
+	return i, true
+}
+
+// versioned returns the unexpired item of the key k, along with its version,
+// assigned if the item was not read by GetWithVersion since it was written.
+func (c *xsyncMapOf[K, V]) versioned(k K) (itemOf[V], bool) {
+	ok := false
+	i, _ := c.items.ComputeWithOp(
+		k,
+		func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if !loaded {
+				return value, DeleteOp
+			}
+			if ok = !c.expired(k, value); ok && value.ext().r == 0 {
+				value = value.withExt(func(x *itemExt) {
+					x.r = c.nextVersion()
+				})
+			}
+			return value, UpdateOp
+		},
+	)
+	return i, ok
+}
+
+// GetMultiple get the items of the keys from the cache, in one call.
+// Returns the items of the keys found, the missing and expired keys are left out.
+// The clock is read at most once for all the keys.
+func (c *xsyncMapOf[K, V]) GetMultiple(keys []K) map[K]V {
+	if c.profiler != nil {
+		defer c.profile(ProfileGet, time.Now())
+	}
+	items := make(map[K]V, len(keys))
+	var now int64 // read by the first item which expires
+	for _, k := range keys {
+		i, ok := c.items.Load(k)
+		if ok && i.e > 0 && now == 0 {
+			now = c.now()
+		}
+		if !ok || c.expiredWithNow(k, i, now) {
+			// deletes the expired item unless written meanwhile,
+			// and reloads or loads the missing key, see WithOverflow and WithLoader
+			if i, ok = c.get(k); ok {
+				items[k] = i.v
+			}
+			continue
+		}
+		c.record(EventGet, k, true)
+		c.refresh(k, i)
+		if i.ext().t > 0 {
+			i = c.slide(k, i)
+		}
+		items[k] = c.copied(i.v)
+	}
+	return items
+}
+
+// has reports whether the unexpired key is in the cache, without recording the read.
+func (c *xsyncMapOf[K, V]) has(k K) bool {
+	i, ok := c.items.Load(k)
+	return ok && !c.expired(k, i)
+}
+
 // GetOrSet returns the existing value for the key if present.
 // Otherwise, it stores and returns the given value.
 // The loaded result is true if the value was loaded, false if stored.
+// The existing item is checked for its expiration at the instant the value would be stored,
+// under the lock of the key, so an item expiring meanwhile is never returned.
 func (c *xsyncMapOf[K, V]) GetOrSet(k K, v V, d time.Duration) (V, bool) {
-	var ok bool
-	i, _ := c.items.Compute(
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	if c.guard.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	i, ok := c.getOrSet(k, v, d)
+	c.record(EventGet, k, ok)
+	if !ok {
+		c.writer.write(k, v)
+		c.record(EventSet, k, true)
+	}
+	return c.copied(i.v), ok
+}
+
+// SetIfAbsent adds the item to the cache only if the key is missing or expired,
+// and reports whether it was stored.
+func (c *xsyncMapOf[K, V]) SetIfAbsent(k K, v V, d time.Duration) bool {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	return c.setIfAbsent(k, v, d)
+}
+
+// setIfAbsent stores v for d only if the key k is missing or expired, and reports whether it was stored.
+func (c *xsyncMapOf[K, V]) setIfAbsent(k K, v V, d time.Duration) bool {
+	if c.guard.closed() {
+		return false
+	}
+	if _, ok := c.getOrSet(k, v, d); ok {
+		return false
+	}
+	c.writer.write(k, v)
+	c.record(EventSet, k, true)
+	return true
+}
+
+// SetIfPresent replaces the value of the key only if it holds an unexpired item,
+// and reports whether it was stored.
+func (c *xsyncMapOf[K, V]) SetIfPresent(k K, v V, d time.Duration) bool {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	return c.compareAndSwap(k, func(itemOf[V]) bool { return true }, v, d, false)
+}
+
+// SetIfVersion replaces the value of the key by v, stored for d, see Set for d,
+// only if its unexpired item is at the version returned by GetWithVersion,
+// and reports whether it was stored.
+// The version 0 stores the value only if the key is missing or expired, like SetIfAbsent.
+func (c *xsyncMapOf[K, V]) SetIfVersion(k K, v V, version uint64, d time.Duration) bool {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	if version == 0 {
+		return c.setIfAbsent(k, v, d)
+	}
+	return c.compareAndSwap(k, func(i itemOf[V]) bool { return i.ext().r == version }, v, d, false)
+}
+
+// getOrSet returns the unexpired item of the key k if present, otherwise it stores v for d.
+// The loaded result is true if the item was loaded, false if stored.
+func (c *xsyncMapOf[K, V]) getOrSet(k K, v V, d time.Duration) (itemOf[V], bool) {
+	var (
+		ok      bool
+		expired bool
+		old     itemOf[V]
+	)
+	i, _ := c.items.ComputeWithOp(
 		k,
-		func(value itemOf[V], loaded bool) (itemOf[V], bool) {
-			if loaded && !value.expired() {
+		func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			// one clock read to check the old item and to store the new one
+			now := c.now()
+			if loaded && !c.expiredWithNow(k, value, now) {
 				ok = true
-				return value, false
+				return value, UpdateOp
 			}
-			return itemOf[V]{
-				v: v,
-				e: c.expiration(d),
-			}, false
+			expired, old = loaded, value
+			return c.item(v, c.expirationAt(d, now), d), UpdateOp
 		},
 	)
-	return i.v, ok
+	if !ok {
+		c.schedule(k, old.e, i.e)
+	}
+	if expired {
+		c.removed(k, old, ReasonExpired)
+	}
+	return i, ok
 }
 
 // GetAndSet returns the existing value for the key if present,
 // while setting the new value for the key.
 // Otherwise, it stores and returns the given value.
 // The loaded result is true if the value was loaded, false otherwise.
+// Like with GetOrSet, an item expiring before the new value is stored is never returned.
 func (c *xsyncMapOf[K, V]) GetAndSet(k K, v V, d time.Duration) (V, bool) {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	if c.guard.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
 	var (
-		ok  bool
-		old itemOf[V]
+		ok      bool
+		expired bool
+		old     itemOf[V]
 	)
-	i, _ := c.items.Compute(
+	i, _ := c.items.ComputeWithOp(
 		k,
-		func(value itemOf[V], loaded bool) (itemOf[V], bool) {
-			if loaded && !value.expired() {
-				ok = true
+		func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			// one clock read to check the old item and to store the new one
+			now := c.now()
+			if loaded {
 				old = value
+				if !c.expiredWithNow(k, value, now) {
+					ok = true
+				} else {
+					expired = true
+				}
 			}
-			return itemOf[V]{
-				v: v,
-				e: c.expiration(d),
-			}, false
+			return c.item(v, c.expirationAt(d, now), d), UpdateOp
 		},
 	)
+	c.schedule(k, old.e, i.e)
+	c.writer.write(k, v)
+	c.record(EventSet, k, true)
 	if ok {
+		c.removed(k, old, ReasonReplaced)
 		return old.v, true
 	}
+	if expired {
+		c.removed(k, old, ReasonExpired)
+	}
 	return i.v, false
 }
 
 // GetAndRefresh Get an item from the cache, and refresh the item's expiration time.
-// Returns the item or nil,
+// Returns the item or the zero value,
 // and a boolean indicating whether the key was found.
 func (c *xsyncMapOf[K, V]) GetAndRefresh(k K, d time.Duration) (V, bool) {
-	var zeroedV itemOf[V]
-	i, ok := c.items.Compute(
-		k,
-		func(value itemOf[V], loaded bool) (itemOf[V], bool) {
-			if loaded && !value.expired() {
-				// store new value
-				value.e = c.expiration(d)
-				return value, false
-			}
-			// delete
-			return zeroedV, true
-		},
-	)
-	if ok {
-		return i.v, true
+	i, ok := c.updateExpiration(k, d)
+	if !ok {
+		return i.v, false
 	}
-	return zeroedV.v, false
+	return c.copied(i.v), true
+}
+
+// RefreshOnly refreshes the expiration time of the key to d from now, like GetAndRefresh,
+// but does not return its value, which saves copying a large value. It is the same as Expire.
+// Returns false if the key was not found.
+func (c *xsyncMapOf[K, V]) RefreshOnly(k K, d time.Duration) bool {
+	return c.Expire(k, d)
+}
+
+// Expire sets the expiration time of the key to d from now, see Set for d,
+// keeping its value, metadata, callback and tags.
+// Returns false if the key was not found.
+func (c *xsyncMapOf[K, V]) Expire(k K, d time.Duration) bool {
+	_, ok := c.updateExpiration(k, d)
+	return ok
+}
+
+// Persist makes the key never expire, keeping its value, metadata, callback and tags.
+// Returns false if the key was not found.
+func (c *xsyncMapOf[K, V]) Persist(k K) bool {
+	return c.Expire(k, NoExpiration)
+}
+
+// Touch resets the expiration time of the key to the default expiration time from now,
+// keeping its value, metadata, callback and tags.
+// Returns false if the key was not found.
+func (c *xsyncMapOf[K, V]) Touch(k K) bool {
+	return c.Expire(k, DefaultExpiration)
+}
+
+// SetExpirationForAll sets the expiration time of all the unexpired items to d from now,
+// see Set for d, and returns the number of items updated.
+func (c *xsyncMapOf[K, V]) SetExpirationForAll(d time.Duration) int {
+	return c.SetExpirationFunc(func(K, V) time.Duration {
+		return d
+	})
+}
+
+// SetExpirationFunc sets the expiration time of each unexpired item to the duration returned
+// by f from now, see Set for the duration, e.g. after LoadItemsWithExpiration to apply
+// the policy of another environment, and returns the number of items updated.
+// f is called for each item while its key is locked. f must not call the cache.
+func (c *xsyncMapOf[K, V]) SetExpirationFunc(f func(k K, v V) time.Duration) int {
+	n := 0
+	now := c.now()
+	c.items.Range(func(k K, _ itemOf[V]) bool {
+		var (
+			updated bool
+			prev    int64
+			p       any // the panic of f
+		)
+		i, _ := c.items.ComputeWithOp(
+			k,
+			unpanickedOf(func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+				if !loaded {
+					return value, DeleteOp
+				}
+				i := value
+				if c.expiredWithNow(k, i, now) {
+					return value, UpdateOp
+				}
+				d := f(k, i.v)
+				prev, i = i.e, c.expireIn(i, d)
+				updated = true
+				return i, UpdateOp
+			}, &p),
+		)
+		if p != nil {
+			c.panicked(p)
+			return false
+		}
+		if updated {
+			n++
+			c.schedule(k, prev, i.e)
+			c.record(EventRefresh, k, true)
+		}
+		return true
+	})
+	return n
+}
+
+// updateExpiration sets the expiration time of the unexpired item of the key k to d from now.
+func (c *xsyncMapOf[K, V]) updateExpiration(k K, d time.Duration) (itemOf[V], bool) {
+	var (
+		zeroedV itemOf[V]
+		expired bool
+		old     itemOf[V]
+		prev    int64
+	)
+	i, ok := c.items.ComputeWithOp(
+		k,
+		func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if loaded && !c.expired(k, value) {
+				// store new value
+				prev, value = value.e, c.expireIn(value, d)
+				return value, UpdateOp
+			}
+			// delete
+			expired, old = loaded, value
+			return zeroedV, DeleteOp
+		},
+	)
+	if expired {
+		c.deletedExpired(k, old)
+	}
+	c.record(EventRefresh, k, ok)
+	if ok {
+		c.schedule(k, prev, i.e)
+		return i, true
+	}
+	return zeroedV, false
+}
+
+// update sets the value of the key k to the value returned by f, keeping its expiration time,
+// or stores it with the default expiration if the key is absent.
+// The item is left unchanged if f returns false.
+func (c *xsyncMapOf[K, V]) update(k K, f func(old V, loaded bool) (V, bool)) (V, bool) {
+	if c.profiler != nil {
+		defer c.profile(ProfileCompute, time.Now())
+	}
+	var (
+		ok      bool
+		created bool
+		expired bool
+		old     itemOf[V]
+	)
+	i, _ := c.items.ComputeWithOp(
+		k,
+		func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if loaded {
+				if !c.expired(k, value) {
+					v, vok := f(value.v, true)
+					if ok = vok; !ok {
+						return value, UpdateOp
+					}
+					value.v = v
+					if value.x != nil {
+						value = value.withExt(func(x *itemExt) {
+							x.r = 0
+						})
+					}
+					return value, UpdateOp
+				}
+				expired, old = true, value
+			}
+			var zeroedV V
+			v, vok := f(zeroedV, false)
+			if ok = vok; !ok {
+				return value, CancelOp
+			}
+			created = true
+			return c.item(v, c.expiration(DefaultExpiration), DefaultExpiration), UpdateOp
+		},
+	)
+	if !ok {
+		var zeroedV V
+		return zeroedV, false
+	}
+	if created {
+		c.schedule(k, old.e, i.e)
+	}
+	if expired {
+		c.removed(k, old, ReasonExpired)
+	}
+	c.writer.write(k, i.v)
+	c.record(EventSet, k, true)
+	return i.v, true
 }
 
 // GetOrCompute returns the existing value for the key if present.
 // Otherwise, it computes the value using the provided function and
 // returns the computed value. The loaded result is true if the value
 // was loaded, false if stored.
+// valueFn runs while the bucket of the key is locked, blocking the keys sharing it,
+// see GetOrComputeUnlocked for the slow computations.
 func (c *xsyncMapOf[K, V]) GetOrCompute(k K, valueFn func() V, d time.Duration) (V, bool) {
-	var ok bool
-	i, _ := c.items.Compute(
+	if c.profiler != nil {
+		defer c.profile(ProfileCompute, time.Now())
+	}
+	if c.guard.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	var (
+		ok      bool
+		p       any // the panic of valueFn
+		expired bool
+		old     itemOf[V]
+	)
+	i, _ := c.items.ComputeWithOp(
 		k,
-		func(value itemOf[V], loaded bool) (itemOf[V], bool) {
-			if loaded && !value.expired() {
+		unpanickedOf(func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if loaded && !c.expired(k, value) {
 				ok = true
-				return value, false
+				return value, UpdateOp
 			}
-			return itemOf[V]{
-				v: valueFn(),
-				e: c.expiration(d),
-			}, false
-		},
+			expired, old = loaded, value
+			return c.item(valueFn(), c.expiration(d), d), UpdateOp
+		}, &p),
 	)
-	return i.v, ok
+	if p != nil {
+		c.panicked(p)
+		var zeroedV V
+		return zeroedV, false
+	}
+	if expired {
+		c.removed(k, old, ReasonExpired)
+	}
+	c.record(EventGet, k, ok)
+	if !ok {
+		c.schedule(k, old.e, i.e)
+		c.writer.write(k, i.v)
+		c.record(EventCompute, k, true)
+	}
+	return c.copied(i.v), ok
+}
+
+// GetOrComputeUnlocked returns the existing value for the key if present, like GetOrCompute,
+// but valueFn runs without locking the bucket of the key, once for concurrent callers
+// with the same key, so that a slow computation does not block the other keys.
+// A value stored meanwhile by another method is kept and returned, loaded is true.
+// If valueFn panics, the waiting callers get the zero value.
+func (c *xsyncMapOf[K, V]) GetOrComputeUnlocked(k K, valueFn func() V, d time.Duration) (V, bool) {
+	if c.profiler != nil {
+		defer c.profile(ProfileCompute, time.Now())
+	}
+	if c.guard.closed() {
+		var zeroedV V
+		return zeroedV, false
+	}
+	if i, ok := c.items.Load(k); ok && !c.expired(k, i) {
+		c.record(EventGet, k, true)
+		return c.copied(i.v), true
+	}
+	c.record(EventGet, k, false)
+	var p any // the panic of valueFn
+	v, loaded, err := c.loads.do(k, func() (V, bool, error) {
+		var v V
+		recovering(func(r any) { p = r }, func() { v = valueFn() })
+		if p != nil {
+			return v, false, ErrLoaderPanicked
+		}
+		if i, ok := c.getOrSet(k, v, d); ok {
+			return i.v, true, nil
+		}
+		c.writer.write(k, v)
+		c.record(EventCompute, k, true)
+		return v, false, nil
+	})
+	if p != nil {
+		c.panicked(p)
+	}
+	if err != nil {
+		var zeroedV V
+		return zeroedV, false
+	}
+	// shared by the concurrent callers and the cache
+	return c.copied(v), loaded
+}
+
+// Warmup loads the keys with loader, at most parallelism at a time, and stores their values
+// for the durations returned by loader, e.g. to prime the cache before serving traffic.
+// Returns a *WarmupErrorOf listing the keys which failed to load: the keys left once ctx is done
+// fail with its error, and those whose loader panicked with ErrLoaderPanicked.
+func (c *xsyncMapOf[K, V]) Warmup(ctx context.Context, keys []K, loader LoaderOf[K, V], parallelism int) error {
+	return warmupOf(ctx, keys, loader, parallelism, func(k K, v V, d time.Duration) {
+		c.put(k, c.item(v, c.expiration(d), d))
+		c.record(EventSet, k, true)
+	})
+}
+
+// GetOrLoad returns the existing value for the key if present.
+// Otherwise, it calls loader once for concurrent callers with the same key,
+// stores the value and returns it to all of them.
+// The loaded result is true if the value was loaded, false if stored.
+// If the loader returns an error, nothing is stored and the error is returned.
+// Unlike GetOrCompute, the loader runs without locking the bucket of the key, so other keys
+// are not blocked while it runs. If the loader panics, the waiting callers get ErrLoaderPanicked.
+// With a distributed locker (see WithDistributedLockerOf), only the process holding the lease
+// runs the loader, the others serve the expired value if it has not been deleted yet
+// (loaded is true), or wait for the lease before running the loader.
+// The leases are only held while loading, a process acquiring one afterwards runs the loader too.
+func (c *xsyncMapOf[K, V]) GetOrLoad(k K, loader func(k K) (V, error), d time.Duration) (V, bool, error) {
+	if err := c.guard.err(); err != nil {
+		var zeroedV V
+		return zeroedV, false, err
+	}
+	if i, ok := c.items.Load(k); ok && !c.expired(k, i) {
+		c.record(EventGet, k, true)
+		return c.copied(i.v), true, nil
+	}
+	c.record(EventGet, k, false)
+	v, loaded, err := c.loads.do(k, func() (V, bool, error) {
+		// stored by a load that completed meanwhile
+		stale, hasStale := c.items.Load(k)
+		if hasStale && !c.expired(k, stale) {
+			return stale.v, true, nil
+		}
+		var name string
+		if c.lock != nil {
+			name = fmt.Sprint(k)
+		}
+		release, serveStale := c.lock.acquire(name, hasStale)
+		defer release()
+		if serveStale {
+			return stale.v, true, nil
+		}
+		v, err := loader(k)
+		if err != nil {
+			var zero V
+			return zero, false, err
+		}
+		c.put(k, c.item(v, c.expiration(d), d))
+		c.record(EventCompute, k, true)
+		return v, false, nil
+	})
+	if err != nil {
+		return v, false, err
+	}
+	// shared by the concurrent callers and the cache
+	return c.copied(v), loaded, nil
 }
 
 // Compute either sets the computed new value for the key or deletes
@@ -291,46 +1337,212 @@ func (c *xsyncMapOf[K, V]) Compute(
 	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
 	d time.Duration,
 ) (V, bool) {
-	var old V
-	i, ok := c.items.Compute(
+	return c.ComputeWithOp(k, computeOpOf(valueFn), d)
+}
+
+// computeOpOf returns the function of ComputeWithOp equivalent to the function valueFn of Compute.
+func computeOpOf[V any](
+	valueFn func(oldValue V, loaded bool) (newValue V, delete bool),
+) func(oldValue V, loaded bool) (V, ComputeOp) {
+	return func(oldValue V, loaded bool) (V, ComputeOp) {
+		newValue, del := valueFn(oldValue, loaded)
+		if del {
+			return newValue, DeleteOp
+		}
+		return newValue, UpdateOp
+	}
+}
+
+// ComputeWithOp either sets the computed new value for the key, deletes
+// the value for the key, or does nothing, depending on the op result
+// of the valueFn function. With UpdateOp, the value is updated to the
+// newValue. With DeleteOp, the value is deleted, if it exists. With
+// CancelOp, the cache is left unchanged: neither the value nor its
+// expiration, and no callback is called.
+// The ok result indicates whether the key is present in the cache after
+// the call. The actual result contains the new value in cases where
+// the value was computed and stored, or the unchanged value when
+// an existing value is kept by CancelOp. See Compute.
+func (c *xsyncMapOf[K, V]) ComputeWithOp(
+	k K,
+	valueFn func(oldValue V, loaded bool) (newValue V, op ComputeOp),
+	d time.Duration,
+) (V, bool) {
+	if c.profiler != nil {
+		defer c.profile(ProfileCompute, time.Now())
+	}
+	var (
+		p         any // the panic of valueFn
+		old       V
+		removed   itemOf[V]
+		reason    EvictionReason
+		cancelled bool
+		kept      bool // the unexpired value is kept by CancelOp
+	)
+	i, ok := c.items.ComputeWithOp(
 		k,
-		func(ov itemOf[V], lok bool) (nv itemOf[V], del bool) {
-			var v V
-			if lok && !ov.expired() {
+		unpanickedOf(func(ov itemOf[V], lok bool) (itemOf[V], ComputeOp) {
+			removed = ov
+			if lok && !c.expired(k, ov) {
 				// current value
 				old = ov.v
 			} else {
+				if lok {
+					reason = ReasonExpired
+				}
 				lok = false
 			}
-			v, del = valueFn(old, lok)
-			if del {
-				return
+			v, op := valueFn(old, lok)
+			switch op {
+			case CancelOp:
+				// an expired item stays until it is deleted as such
+				cancelled, kept = true, lok
+				return ov, CancelOp
+			case DeleteOp:
+				if lok {
+					reason = ReasonDeleted
+				}
+				return ov, DeleteOp
 			}
-			return itemOf[V]{
-				v: v,
-				e: c.expiration(d),
-			}, false
-		},
+			if lok {
+				reason = ReasonReplaced
+			}
+			return c.item(v, c.expiration(d), d), UpdateOp
+		}, &p),
 	)
+	if p != nil {
+		c.panicked(p)
+		var zeroedV V
+		return zeroedV, false
+	}
+	if cancelled {
+		if kept {
+			return c.copied(old), true
+		}
+		return old, false
+	}
+	if reason > 0 {
+		c.removed(k, removed, reason)
+	}
+	c.record(EventCompute, k, ok)
 	if ok {
-		return i.v, true
+		c.schedule(k, removed.e, i.e)
+		c.writer.write(k, i.v)
+		return c.copied(i.v), true
 	}
 	return old, false
 }
 
+// CompareAndSwap replaces the value of the key by new, stored for d, see Set for d,
+// if its current value is equal to old, and reports whether it was swapped.
+// The values must be of a comparable type, like with sync.Map, use CompareAndSwapFunc otherwise.
+func (c *xsyncMapOf[K, V]) CompareAndSwap(k K, old, new V, d time.Duration) bool {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	return c.compareAndSwap(k, func(i itemOf[V]) bool { return any(i.v) == any(old) }, new, d, false)
+}
+
+// CompareAndDelete deletes the item of the key if its current value is equal to old,
+// and reports whether it was deleted.
+// The values must be of a comparable type, like with sync.Map, use CompareAndDeleteFunc otherwise.
+func (c *xsyncMapOf[K, V]) CompareAndDelete(k K, old V) bool {
+	if c.profiler != nil {
+		defer c.profile(ProfileDelete, time.Now())
+	}
+	var zeroedV V
+	return c.compareAndSwap(k, func(i itemOf[V]) bool { return any(i.v) == any(old) }, zeroedV, 0, true)
+}
+
+// CompareAndSwapFunc is CompareAndSwap for the values of any type: equal reports whether
+// the current value of the key is equal to old. equal runs while the key is locked.
+func (c *xsyncMapOf[K, V]) CompareAndSwapFunc(k K, old, new V, equal func(current, old V) bool, d time.Duration) bool {
+	if c.profiler != nil {
+		defer c.profile(ProfileSet, time.Now())
+	}
+	return c.compareAndSwap(k, func(i itemOf[V]) bool { return equal(i.v, old) }, new, d, false)
+}
+
+// CompareAndDeleteFunc is CompareAndDelete for the values of any type, see CompareAndSwapFunc.
+func (c *xsyncMapOf[K, V]) CompareAndDeleteFunc(k K, old V, equal func(current, old V) bool) bool {
+	if c.profiler != nil {
+		defer c.profile(ProfileDelete, time.Now())
+	}
+	var zeroedV V
+	return c.compareAndSwap(k, func(i itemOf[V]) bool { return equal(i.v, old) }, zeroedV, 0, true)
+}
+
+// compareAndSwap replaces the value of the unexpired item of the key k by v stored for d,
+// or deletes the item if del is set, if match returns true for its current item.
+// A panic of match, e.g. comparing uncomparable values, is raised once the key is unlocked.
+func (c *xsyncMapOf[K, V]) compareAndSwap(k K, match func(i itemOf[V]) bool, v V, d time.Duration, del bool) bool {
+	var (
+		swapped bool
+		expired bool
+		old     itemOf[V]
+		p       any // the panic of match
+	)
+	i, _ := c.items.ComputeWithOp(
+		k,
+		unpanickedOf(func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if !loaded {
+				return value, DeleteOp
+			}
+			old = value
+			if c.expired(k, old) {
+				// delete
+				expired = true
+				return value, DeleteOp
+			}
+			if !match(old) {
+				return value, UpdateOp
+			}
+			swapped = true
+			if del {
+				return value, DeleteOp
+			}
+			return c.item(v, c.expiration(d), d), UpdateOp
+		}, &p),
+	)
+	if p != nil {
+		c.panicked(p)
+		return false
+	}
+	switch {
+	case expired:
+		c.deletedExpired(k, old)
+	case swapped && del:
+		c.record(EventDelete, k, true)
+		c.evicted(k, old, ReasonDeleted)
+	case swapped:
+		c.schedule(k, old.e, i.e)
+		c.removed(k, old, ReasonReplaced)
+		c.writer.write(k, v)
+		c.record(EventSet, k, true)
+	}
+	return swapped
+}
+
 // GetAndDelete Get an item from the cache, and delete the key.
 // Returns the item or nil,
 // and a boolean indicating whether the key was found.
 func (c *xsyncMapOf[K, V]) GetAndDelete(k K) (V, bool) {
+	if c.profiler != nil {
+		defer c.profile(ProfileDelete, time.Now())
+	}
 	i, ok := c.items.LoadAndDelete(k)
+	c.record(EventDelete, k, ok)
 	if !ok {
 		var v V
 		return v, false
 	}
-	ec := c.EvictedCallback()
-	if ec != nil {
-		ec(k, i.v)
+	if c.expired(k, i) && !c.stale(k, i, c.now()) {
+		// an expired item is deleted like by Get, and not returned
+		c.deletedExpired(k, i)
+		var v V
+		return v, false
 	}
+	c.evicted(k, i, ReasonDeleted)
 	return i.v, true
 }
 
@@ -340,29 +1552,247 @@ func (c *xsyncMapOf[K, V]) Delete(k K) {
 	c.GetAndDelete(k)
 }
 
+// DeleteMultiple deletes the items of the keys from the cache.
+// The missing keys are ignored.
+func (c *xsyncMapOf[K, V]) DeleteMultiple(keys []K) {
+	for _, k := range keys {
+		c.GetAndDelete(k)
+	}
+}
+
+// DeleteFunc deletes the unexpired items for which f returns true, e.g. all the keys
+// of a tenant, and returns the number of items deleted.
+// f is called for each item while its key is locked, so it is deleted only if f still
+// matches its current value. f must not call the cache.
+func (c *xsyncMapOf[K, V]) DeleteFunc(f func(k K, v V) bool) int {
+	if c.profiler != nil {
+		defer c.profile(ProfileDelete, time.Now())
+	}
+	n := 0
+	now := c.now()
+	c.items.Range(func(k K, _ itemOf[V]) bool {
+		var (
+			p       any // the panic of f
+			i       itemOf[V]
+			deleted bool
+		)
+		c.items.ComputeWithOp(
+			k,
+			unpanickedOf(func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+				if !loaded {
+					return value, DeleteOp
+				}
+				if !c.expiredWithNow(k, value, now) && f(k, value.v) {
+					i, deleted = value, true
+					return value, DeleteOp
+				}
+				return value, UpdateOp
+			}, &p),
+		)
+		if p != nil {
+			c.panicked(p)
+			return false
+		}
+		if deleted {
+			n++
+			c.record(EventDelete, k, true)
+			c.evicted(k, i, ReasonDeleted)
+		}
+		return true
+	})
+	return n
+}
+
+// InvalidateTag deletes the items stored with the tag by SetWithTags,
+// and returns the number of items deleted.
+func (c *xsyncMapOf[K, V]) InvalidateTag(tag string) int {
+	if c.profiler != nil {
+		defer c.profile(ProfileDelete, time.Now())
+	}
+	n := 0
+	for k := range c.tags.take(tag) {
+		var (
+			i       itemOf[V]
+			deleted bool
+		)
+		c.items.ComputeWithOp(
+			k,
+			func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+				if !loaded {
+					return value, DeleteOp
+				}
+				i = value
+				if hasTag(i.ext().g, tag) {
+					deleted = true
+					return value, DeleteOp
+				}
+				return value, UpdateOp
+			},
+		)
+		if deleted {
+			n++
+			c.record(EventDelete, k, true)
+			c.evicted(k, i, ReasonDeleted)
+		}
+	}
+	return n
+}
+
 type kvOf[K comparable, V any] struct {
 	k K
 	v V
 }
 
+// InvalidateIf invalidates the items whose key matches f, in O(1).
+// The invalidated items are handled like expired ones: they are no longer returned,
+// and they are deleted when read or by DeleteExpired, being reported as expired.
+// The items written after the call are not invalidated.
+// f must be safe for concurrent use, it is called while reading until DeleteExpired has run.
+func (c *xsyncMapOf[K, V]) InvalidateIf(f func(k K) bool) {
+	if f == nil {
+		return
+	}
+	c.invalidations.add(f)
+}
+
 // DeleteExpired delete all expired items from the cache.
+// Only the keys expiring by now are visited, so the cost is proportional to the number
+// of expired items rather than to the size of the cache.
+// The expired values spilled by WithOverflowOf are deleted from its store too.
 func (c *xsyncMapOf[K, V]) DeleteExpired() {
+	c.deleteExpired(func(int) bool { return true })
+	c.overflow.sweep(time.Now().UnixNano())
+}
+
+// DeleteExpiredN deletes up to maxItems expired items from the cache, like DeleteExpired,
+// and returns the number of items deleted, e.g. to bound each pass of a manual cleanup.
+// The expired items left are deleted by the next passes.
+func (c *xsyncMapOf[K, V]) DeleteExpiredN(maxItems int) int {
+	return c.deleteExpired(func(deleted int) bool { return deleted < maxItems })
+}
+
+// DeleteExpiredFor deletes expired items from the cache for at most maxDuration, like DeleteExpired,
+// and returns the number of items deleted, e.g. to bound each pass of a manual cleanup.
+// The expired items left are deleted by the next passes.
+func (c *xsyncMapOf[K, V]) DeleteExpiredFor(maxDuration time.Duration) int {
+	deadline := time.Now().Add(maxDuration)
+	return c.deleteExpired(func(int) bool { return time.Now().Before(deadline) })
+}
+
+// deleteExpired deletes the expired items while more returns true for the number of items
+// deleted so far, and returns that number.
+func (c *xsyncMapOf[K, V]) deleteExpired(more func(deleted int) bool) (deleted int) {
+	if c.profiler != nil {
+		defer c.profile(ProfileCleanup, time.Now())
+	}
+	var start time.Time
+	if c.cleanupHook != nil {
+		start = time.Now()
+	}
+	scanned := 0
 	var evictedItems []kvOf[K, V]
+	var callbacks []func()
 	ec := c.EvictedCallback()
-	now := time.Now().UnixNano()
-	c.items.Range(func(k K, v itemOf[V]) bool {
-		i := v
-		if i.expiredWithNow(now) {
-			c.items.Delete(k)
-			if ec != nil {
-				evictedItems = append(evictedItems, kvOf[K, V]{k, i.v})
+	now := c.now()
+	expire := func(k K, reschedule bool) {
+		var (
+			i       itemOf[V]
+			expired bool
+		)
+		c.items.ComputeWithOp(
+			k,
+			func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+				if !loaded {
+					return value, DeleteOp
+				}
+				i = value
+				if c.expiredWithNow(k, i, now) && !c.stale(k, i, now) {
+					expired = true
+					return value, DeleteOp
+				}
+				return value, UpdateOp
+			},
+		)
+		if !expired {
+			if reschedule {
+				// not expired yet, its expiration has changed since it was scheduled,
+				// or it is served stale until the end of its grace period
+				e := i.e
+				if c.stale(k, i, now) {
+					e += c.staleTTL
+				}
+				c.schedule(k, 0, e)
 			}
+			return
+		}
+		c.record(EventExpire, k, true)
+		deleted++
+		c.untag(k, i)
+		if ec != nil || c.reasonCallback != nil || c.pool != nil {
+			evictedItems = append(evictedItems, kvOf[K, V]{k, i.v})
 		}
+		if i.ext().f != nil {
+			callbacks = append(callbacks, i.ext().f)
+		}
+	}
+	c.expiry.due(now, func(k K) bool {
+		if !more(deleted) {
+			return false
+		}
+		scanned++
+		expire(k, true)
 		return true
 	})
+	if gen := c.invalidations.pending(); gen > 0 && more(deleted) {
+		walked := true
+		// the invalidated items are not due, so the whole map is walked
+		c.items.Range(func(k K, value itemOf[V]) bool {
+			if !more(deleted) {
+				walked = false
+				return false
+			}
+			scanned++
+			if c.invalidated(k, value) {
+				expire(k, false)
+			}
+			return true
+		})
+		if walked {
+			c.invalidations.compact(gen)
+		}
+	}
+	var callbacksStart time.Time
+	if c.cleanupHook != nil {
+		callbacksStart = time.Now()
+	}
 	for _, v := range evictedItems {
-		ec(v.k, v.v)
+		v := v
+		c.callbacks.do(func() {
+			if ec != nil {
+				ec(v.k, v.v)
+			}
+			if c.reasonCallback != nil {
+				c.reasonCallback(v.k, v.v, ReasonExpired)
+			}
+			if c.pool != nil {
+				c.pool.Put(v.v)
+			}
+		})
+	}
+	for _, f := range callbacks {
+		c.callbacks.do(f)
 	}
+	if c.cleanupHook != nil {
+		end := time.Now()
+		report := CleanupReport{
+			Scanned:          scanned,
+			Deleted:          deleted,
+			Duration:         end.Sub(start),
+			CallbackDuration: end.Sub(callbacksStart),
+		}
+		recovering(c.panicHandler, func() { c.cleanupHook(report) })
+	}
+	return
 }
 
 // Range calls f sequentially for each key and value present in the map.
@@ -371,38 +1801,364 @@ func (c *xsyncMapOf[K, V]) Range(f func(k K, v V) bool) {
 	if f == nil {
 		return
 	}
-	now := time.Now().UnixNano()
+	if c.copier == nil {
+		c.rangeValues(f)
+		return
+	}
+	c.rangeValues(func(k K, v V) bool {
+		return f(k, c.copier(v))
+	})
+}
+
+// rangeValues calls f for each unexpired item like Range, with the cached values.
+func (c *xsyncMapOf[K, V]) rangeValues(f func(k K, v V) bool) {
+	now := c.now()
 	c.items.Range(func(k K, v itemOf[V]) bool {
 		i := v
-		if i.expiredWithNow(now) {
+		if c.expiredWithNow(k, i, now) {
 			return true
 		}
 		return f(k, i.v)
 	})
 }
 
+// RangeCursor calls f sequentially for the keys and values present in the cache,
+// starting from the cursor, and returns the cursor to resume the iteration from.
+// Start with cursor 0, the iteration is complete when the returned cursor is 0.
+// Like with the Redis SCAN, the cursor is a position in the hash order of the keys, and each
+// call examines up to count keys (DefaultScanCount if count is less than 1), or the keys of
+// one position if more, and calls f for the unexpired ones, whatever the size of the cache.
+// Keys present for the whole iteration are visited exactly once, even if the cache grows or shrinks
+// meanwhile, keys added or deleted during the iteration may or may not be visited.
+// The cursor is a plain value that can be handed out and resumed in a later request,
+// but it is only valid for this cache instance.
+func (c *xsyncMapOf[K, V]) RangeCursor(cursor uint64, count int, f func(k K, v V)) uint64 {
+	if count < 1 {
+		count = DefaultScanCount
+	}
+	now := c.now()
+	return c.items.RangeCursor(cursor, count, func(k K, i itemOf[V]) {
+		if !c.expiredWithNow(k, i, now) {
+			f(k, c.copied(i.v))
+		}
+	})
+}
+
+// ScanItems returns the items present in the cache, starting from the cursor,
+// and the cursor to resume the iteration from, like RangeCursor,
+// e.g. to export a large cache page by page.
+func (c *xsyncMapOf[K, V]) ScanItems(cursor uint64, count int) (items []KeyValueOf[K, V], next uint64) {
+	next = c.RangeCursor(cursor, count, func(k K, v V) {
+		items = append(items, KeyValueOf[K, V]{k, v})
+	})
+	return
+}
+
+// KeyFilter builds a Bloom filter of the unexpired keys in the cache, with the false positive
+// rate p (DefaultFalsePositiveRate if not in (0, 1)), e.g. to check on edge nodes whether
+// the cache probably has a key before making a network hop.
+// The keys are added formatted with fmt.Sprint.
+func (c *xsyncMapOf[K, V]) KeyFilter(p float64) *BloomFilter {
+	f := NewBloomFilter(c.items.Size(), p)
+	c.rangeValues(func(k K, _ V) bool {
+		f.Add(fmt.Sprint(k))
+		return true
+	})
+	return f
+}
+
 // Items return the items in the cache.
 // This is a snapshot, which may include items that are about to expire.
 func (c *xsyncMapOf[K, V]) Items() map[K]V {
 	items := make(map[K]V, c.items.Size())
-	c.Range(func(k K, v V) bool {
-		items[k] = v
+	c.rangeValues(func(k K, v V) bool {
+		items[k] = c.copied(v)
 		return true
 	})
 	return items
 }
 
+// ItemsAtomic return the items in the cache as of a single point in time,
+// the writes being blocked while they are copied, see WithConsistentSnapshotsOf.
+// Without WithConsistentSnapshotsOf, it is equivalent to Items.
+func (c *xsyncMapOf[K, V]) ItemsAtomic() map[K]V {
+	var items map[K]V
+	c.freezer.freeze(func() {
+		items = c.Items()
+	})
+	return items
+}
+
+// ItemsWhere returns the items in the cache for which f returns true, in one pass over
+// the cache, without copying the other items. f is called with the cached values.
+// This is a snapshot, which may include items that are about to expire.
+func (c *xsyncMapOf[K, V]) ItemsWhere(f func(k K, v V) bool) map[K]V {
+	items := make(map[K]V)
+	c.rangeValues(func(k K, v V) bool {
+		if f(k, v) {
+			items[k] = c.copied(v)
+		}
+		return true
+	})
+	return items
+}
+
+// ItemsWithExpiration return the unexpired items in the cache along with their expiration time.
+// This is a snapshot, which may include items that are about to expire.
+func (c *xsyncMapOf[K, V]) ItemsWithExpiration() map[K]ItemWithExpirationOf[V] {
+	items := make(map[K]ItemWithExpirationOf[V], c.items.Size())
+	now := c.now()
+	c.items.Range(func(k K, i itemOf[V]) bool {
+		if !c.expiredWithNow(k, i, now) {
+			items[k] = ItemWithExpirationOf[V]{Value: c.copied(i.v), Expiration: expirationTime(i.e)}
+		}
+		return true
+	})
+	return items
+}
+
+// LoadItemsWithExpiration stores the unexpired items with their expiration time.
+// The optional strategy decides which item is kept when a key is already present,
+// the default is Overwrite.
+func (c *xsyncMapOf[K, V]) LoadItemsWithExpiration(items map[K]ItemWithExpirationOf[V], strategy ...LoadStrategy) {
+	s := loadStrategy(strategy)
+	now := c.now()
+	for k, x := range items {
+		c.load(k, itemOf[V]{v: x.Value, e: expirationNano(x.Expiration)}, s, now)
+	}
+}
+
+// load stores the unexpired item i, keeping the existing item if the strategy prefers it.
+func (c *xsyncMapOf[K, V]) load(k K, i itemOf[V], s LoadStrategy, now int64) {
+	if i.expiredWithNow(now) {
+		return
+	}
+	if i.e > 0 {
+		i.e = now + int64(jitter(time.Duration(i.e-now), c.ttlJitter))
+	}
+	i = i.withExt(func(x *itemExt) {
+		x.n = c.invalidations.stamp()
+	})
+	if s == Overwrite {
+		c.put(k, i)
+		c.record(EventLoad, k, true)
+		return
+	}
+	var (
+		removed itemOf[V]
+		reason  EvictionReason
+		kept    bool
+	)
+	c.items.ComputeWithOp(
+		k,
+		func(old itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if loaded {
+				if !c.expiredWithNow(k, old, now) {
+					if kept = s.keep(old.e, i.e); kept {
+						return old, UpdateOp
+					}
+					reason = ReasonReplaced
+				} else {
+					reason = ReasonExpired
+				}
+				removed = old
+			}
+			return i, UpdateOp
+		},
+	)
+	if !kept {
+		c.schedule(k, removed.e, i.e)
+	}
+	if reason > 0 {
+		c.discarded(k, removed, reason)
+	}
+	c.record(EventLoad, k, true)
+}
+
+// SaveTo writes a snapshot of the unexpired items in the cache to w.
+// The snapshot starts with a versioned header (see SnapshotHeader),
+// followed by the items encoded as JSON, or as set by WithSnapshotFormatOf.
+func (c *xsyncMapOf[K, V]) SaveTo(w io.Writer) error {
+	if err := c.guard.err(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	count, err := c.encodeItems(newSnapshotEncoder(c.snapshotFormat, c.codec, &buf))
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, c.snapshotFormat, typeName[K](), typeName[V](), count, buf.Bytes())
+}
+
+// encodeItems encodes the unexpired items in the cache with enc, and returns their number, see SaveTo.
+func (c *xsyncMapOf[K, V]) encodeItems(enc snapshotEncoder) (count int, err error) {
+	c.freezer.freeze(func() {
+		now := c.now()
+		c.items.Range(func(k K, i itemOf[V]) bool {
+			if c.expiredWithNow(k, i, now) {
+				return true
+			}
+			if err = enc.Encode(snapshotItemOf[K, V]{K: k, V: i.v, E: i.e}); err != nil {
+				return false
+			}
+			count++
+			return true
+		})
+	})
+	return count, err
+}
+
+// LoadFrom reads a snapshot written by SaveTo from r, and stores its unexpired items
+// in the cache with their original expiration time.
+// The snapshot is fully verified before any item is stored, an unsupported version,
+// mismatched key/value types or a bad checksum returns an error wrapping
+// ErrSnapshotVersion, ErrSnapshotType or ErrSnapshotChecksum.
+// The optional strategy decides which item is kept when a key is already present,
+// the default is Overwrite.
+func (c *xsyncMapOf[K, V]) LoadFrom(r io.Reader, strategy ...LoadStrategy) error {
+	if err := c.guard.err(); err != nil {
+		return err
+	}
+	h, payload, err := readSnapshot(r, typeName[K](), typeName[V]())
+	if err != nil {
+		return err
+	}
+	return c.loadSnapshot(h, payload, loadStrategy(strategy))
+}
+
+// loadSnapshot stores the items of the verified payload of a snapshot, once all decoded.
+func (c *xsyncMapOf[K, V]) loadSnapshot(h SnapshotHeader, payload []byte, s LoadStrategy) error {
+	var items []snapshotItemOf[K, V]
+	err := decodeSnapshot(h, c.codec, payload, func(dec snapshotDecoder) error {
+		var x snapshotItemOf[K, V]
+		if err := dec.Decode(&x); err != nil {
+			return err
+		}
+		items = append(items, x)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	now := c.now()
+	for _, x := range items {
+		c.load(x.K, itemOf[V]{v: x.V, e: x.E}, s, now)
+	}
+	return nil
+}
+
+// ExportGzip writes the unexpired items in the cache to w, compressed with gzip, in chunks
+// of ExportChunkSize items encoded like the snapshots of SaveTo, so that large caches
+// are streamed instead of being buffered in memory.
+func (c *xsyncMapOf[K, V]) ExportGzip(w io.Writer) error {
+	if err := c.guard.err(); err != nil {
+		return err
+	}
+	return exportGzip(w, c.snapshotFormat, c.codec, typeName[K](), typeName[V](),
+		func(add func(x interface{}) error) error {
+			var err error
+			now := c.now()
+			c.items.Range(func(k K, i itemOf[V]) bool {
+				if c.expiredWithNow(k, i, now) {
+					return true
+				}
+				err = add(snapshotItemOf[K, V]{K: k, V: i.v, E: i.e})
+				return err == nil
+			})
+			return err
+		})
+}
+
+// ImportGzip reads the items written by ExportGzip from r, chunk by chunk, and stores the
+// unexpired items like LoadFrom. Each chunk is verified before its items are stored,
+// so on error the items of the previous chunks have been stored.
+func (c *xsyncMapOf[K, V]) ImportGzip(r io.Reader, strategy ...LoadStrategy) error {
+	if err := c.guard.err(); err != nil {
+		return err
+	}
+	s := loadStrategy(strategy)
+	return importGzip(r, typeName[K](), typeName[V](), func(h SnapshotHeader, payload []byte) error {
+		return c.loadSnapshot(h, payload, s)
+	})
+}
+
+// SaveToFile writes a snapshot of the unexpired items in the cache to the file at path, see SaveTo.
+// The snapshot is written to a temporary file renamed to path once complete,
+// so path always holds a complete snapshot.
+func (c *xsyncMapOf[K, V]) SaveToFile(path string) error {
+	return writeFileAtomic(path, c.SaveTo)
+}
+
+// LoadFromFile reads a snapshot written by SaveToFile or SaveTo from the file at path,
+// see LoadFrom.
+func (c *xsyncMapOf[K, V]) LoadFromFile(path string, strategy ...LoadStrategy) error {
+	return readFile(path, func(r io.Reader) error {
+		return c.LoadFrom(r, strategy...)
+	})
+}
+
 // Clear deletes all keys and values currently stored in the map.
+// With an evicted callback with reason, the keys are deleted one by one to report them.
 func (c *xsyncMapOf[K, V]) Clear() {
-	c.items.Clear()
+	// the index is cleared first, the items stored meanwhile are scheduled once stored
+	c.expiry.clear()
+	c.tags.clear()
+	if c.reasonCallback == nil && c.pool == nil {
+		c.items.Clear()
+	} else {
+		c.items.Range(func(k K, _ itemOf[V]) bool {
+			if i, ok := c.items.LoadAndDelete(k); ok {
+				if c.expired(k, i) {
+					c.discarded(k, i, ReasonExpired)
+				} else {
+					c.discarded(k, i, ReasonCleared)
+				}
+			}
+			return true
+		})
+	}
+	var k K
+	c.record(EventClear, k, true)
 }
 
 // Count returns the number of items in the cache.
-// This may include items that have expired but have not been cleaned up.
+// This may include items that have expired but have not been cleaned up,
+// unless the cleanup loop is disabled by WithNoCleanupLoopOf.
 func (c *xsyncMapOf[K, V]) Count() int {
+	if c.noCleanupLoop {
+		c.DeleteExpired()
+	}
 	return c.items.Size()
 }
 
+// CountWhere returns the number of unexpired items in the cache for which f returns true.
+// f is called with the cached values.
+func (c *xsyncMapOf[K, V]) CountWhere(f func(k K, v V) bool) int {
+	count := 0
+	c.rangeValues(func(k K, v V) bool {
+		if f(k, v) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// ExpiredCount returns the number of items in the cache that have expired but have not been
+// cleaned up yet, in one pass over the cache without copying the items, e.g. to tell the live
+// items from those awaiting the cleanup in Count.
+func (c *xsyncMapOf[K, V]) ExpiredCount() int {
+	count := 0
+	now := c.now()
+	c.items.Range(func(k K, v itemOf[V]) bool {
+		if c.expiredWithNow(k, v, now) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
 // DefaultExpiration returns the default expiration time of the cache.
 func (c *xsyncMapOf[K, V]) DefaultExpiration() time.Duration {
 	return c.defaultExpiration.Load().(time.Duration)
@@ -414,6 +2170,86 @@ func (c *xsyncMapOf[K, V]) SetDefaultExpiration(defaultExpiration time.Duration)
 	c.defaultExpiration.Store(defaultExpiration)
 }
 
+// PauseCleanup pauses the cleanup loop until ResumeCleanup, e.g. during a latency-critical phase.
+// The expired items are still deleted when read, and by DeleteExpired.
+func (c *xsyncMapOf[K, V]) PauseCleanup() {
+	atomic.StoreUint32(&c.cleanupPaused, 1)
+}
+
+// ResumeCleanup resumes the cleanup loop paused by PauseCleanup.
+func (c *xsyncMapOf[K, V]) ResumeCleanup() {
+	atomic.StoreUint32(&c.cleanupPaused, 0)
+}
+
+// SetCleanupInterval sets the interval of the cleanup loop, a non-positive interval stops it
+// until the next call. The loop applies it asynchronously. It has no effect if the cleanup loop is disabled by WithNoCleanupLoopOf.
+func (c *xsyncMapOf[K, V]) SetCleanupInterval(interval time.Duration) {
+	if interval < 0 {
+		interval = 0
+	}
+	c.cleanupInterval.Store(interval)
+	if interval > 0 && !c.noCleanupLoop {
+		c.startCleanupLoop()
+	}
+	select {
+	case c.cleanupReset <- struct{}{}:
+	default:
+		// the loop has yet to apply the previous interval, it reads the last one
+	}
+}
+
+// startCleanupLoop starts the cleanup loop once, unless the cache is closed.
+// It is started by the first positive cleanup interval, so that a cache cleaned by other means,
+// e.g. the shards of a sharded cache, does not run an idle loop.
+func (c *xsyncMapOf[K, V]) startCleanupLoop() {
+	c.cleanupMu.Lock()
+	defer c.cleanupMu.Unlock()
+	if c.cleanupStarted || atomic.LoadUint32(&c.closed) == 1 {
+		return
+	}
+	c.cleanupStarted = true
+	c.wg.Add(1)
+	go c.cleanupLoop(c.newCleanupTicker())
+}
+
+// newCleanupTicker returns a ticker of the cleanup interval, nil if the interval is not positive.
+func (c *xsyncMapOf[K, V]) newCleanupTicker() Ticker {
+	if d := c.cleanupInterval.Load().(time.Duration); d > 0 {
+		return c.clock.NewTicker(d)
+	}
+	return nil
+}
+
+// cleanupLoop deletes the expired items on each tick of ticker, unless paused,
+// until the cache is closed. The ticker is replaced on SetCleanupInterval.
+func (c *xsyncMapOf[K, V]) cleanupLoop(ticker Ticker) {
+	defer c.wg.Done()
+	var tick <-chan time.Time
+	if ticker != nil {
+		tick = ticker.Chan()
+	}
+	for {
+		select {
+		case <-tick:
+			if atomic.LoadUint32(&c.cleanupPaused) == 0 {
+				c.DeleteExpired()
+			}
+		case <-c.cleanupReset:
+			if ticker != nil {
+				ticker.Stop()
+			}
+			if ticker, tick = c.newCleanupTicker(), nil; ticker != nil {
+				tick = ticker.Chan()
+			}
+		case <-c.stop:
+			if ticker != nil {
+				ticker.Stop()
+			}
+			return
+		}
+	}
+}
+
 // EvictedCallback returns the callback function to execute
 // when a key-value pair expires and is evicted.
 func (c *xsyncMapOf[K, V]) EvictedCallback() EvictedCallbackOf[K, V] {
@@ -426,3 +2262,340 @@ func (c *xsyncMapOf[K, V]) EvictedCallback() EvictedCallbackOf[K, V] {
 func (c *xsyncMapOf[K, V]) SetEvictedCallback(evictedCallback EvictedCallbackOf[K, V]) {
 	c.evictedCallback.Store(evictedCallback)
 }
+
+// RecentEvents returns the recent operations on the cache, oldest first.
+// Returns nil if the event history is not enabled, see WithEventHistoryOf.
+func (c *xsyncMapOf[K, V]) RecentEvents() []EventOf[K] {
+	if c.events == nil {
+		return nil
+	}
+	return c.events.recent()
+}
+
+// Diagnostics returns a histogram of the remaining TTLs of the items, and a forecast
+// of the number of items expiring in each of the next intervals cleanup intervals.
+// It walks the whole cache.
+func (c *xsyncMapOf[K, V]) Diagnostics(intervals int) Diagnostics {
+	d := newDiagnostics(c.cleanupInterval.Load().(time.Duration), intervals)
+	now := c.now()
+	c.items.Range(func(k K, i itemOf[V]) bool {
+		d.add(i.e, now, c.expiredWithNow(k, i, now))
+		return true
+	})
+	return d
+}
+
+// HotKeys returns up to n of the most read keys with their estimated number of reads,
+// the most read first, all the tracked keys if n is less than 1.
+// Returns nil if not enabled.
+func (c *xsyncMapOf[K, V]) HotKeys(n int) []KeyStatOf[K] {
+	if c.hot == nil {
+		return nil
+	}
+	var stats []KeyStatOf[K]
+	c.hot.hottest(n, func(key interface{}, count uint64) {
+		stats = append(stats, KeyStatOf[K]{Key: key.(K), Count: count})
+	})
+	return stats
+}
+
+// ShadowStats returns the simulated hit rate of each shadow policy, e.g.
+// "LRU@100000 entries would have hit 91.00%".
+// Returns nil if no shadow is configured.
+func (c *xsyncMapOf[K, V]) ShadowStats() []ShadowStats {
+	if c.shadow == nil {
+		return nil
+	}
+	return c.shadow.results()
+}
+
+// profile reports the time elapsed since start to the profiler.
+func (c *xsyncMapOf[K, V]) profile(op ProfileOp, start time.Time) {
+	c.profiler.Observe(op, time.Since(start))
+}
+
+// record adds the operation to the event history, the shadow tracker and the evictor, if enabled.
+func (c *xsyncMapOf[K, V]) record(op EventOp, k K, ok bool) {
+	c.recordCost(op, k, ok, 1)
+}
+
+// recordCost records the operation, cost is the cost of the key when it is written.
+func (c *xsyncMapOf[K, V]) recordCost(op EventOp, k K, ok bool, cost int64) {
+	if c.overflow != nil {
+		switch op {
+		case EventGet, EventExpire, EventEvict:
+		case EventClear:
+			c.overflow.clear()
+		default:
+			// the spilled value is stale once the key is written or deleted
+			c.overflow.forget(k)
+		}
+	}
+	if c.events != nil {
+		c.events.add(op, k, ok)
+	}
+	if c.shadow != nil {
+		c.shadow.trace(op, c.hasher(k, c.seed), ok)
+	}
+	if c.hot != nil && op == EventGet {
+		c.hot.add(k, c.hasher(k, c.seed))
+	}
+	if c.evictor != nil {
+		for _, victim := range c.evictor.trace(op, k, ok, cost) {
+			c.evict(victim)
+		}
+	}
+}
+
+// evict deletes the key to keep the cache within its capacity, and calls the evicted callback.
+// The key is kept if it is back in the eviction order, i.e. written again since it was chosen.
+func (c *xsyncMapOf[K, V]) evict(k K) {
+	var (
+		i  itemOf[V]
+		ok bool
+	)
+	c.items.ComputeWithOp(
+		k,
+		func(value itemOf[V], loaded bool) (itemOf[V], ComputeOp) {
+			if !loaded {
+				return value, DeleteOp
+			}
+			if c.evictor.queued(k) {
+				return value, UpdateOp
+			}
+			i, ok = value, true
+			return value, DeleteOp
+		},
+	)
+	if !ok {
+		return
+	}
+	if !c.expired(k, i) {
+		c.overflow.spill(k, i.v, i.e)
+	}
+	c.record(EventEvict, k, true)
+	c.evicted(k, i, ReasonCapacityEvicted)
+}
+
+// shed evicts a part of the items, in the order of the eviction policy, while the memory
+// is over the limit, see WithMemoryLimitOf.
+func (c *xsyncMapOf[K, V]) shed() {
+	for _, k := range c.evictor.shrink(memoryShedFraction) {
+		c.evict(k)
+	}
+}
+
+// reload moves the value of the key k spilled by WithOverflow back to the cache, after a miss.
+func (c *xsyncMapOf[K, V]) reload(k K) (itemOf[V], bool) {
+	var i itemOf[V]
+	v, ttl, ok := c.overflow.reload(k)
+	if !ok {
+		return i, false
+	}
+	i = itemOf[V]{v: v, e: c.expiration(ttl)}.withExt(func(x *itemExt) {
+		x.n = c.invalidations.stamp()
+	})
+	if old, loaded := c.items.LoadOrStore(k, i); loaded {
+		// written meanwhile
+		return old, !c.expired(k, old)
+	}
+	c.schedule(k, 0, i.e)
+	c.record(EventLoad, k, true)
+	return i, true
+}
+
+// evicted calls the evicted callbacks and the callback of the item that left the cache.
+func (c *xsyncMapOf[K, V]) evicted(k K, i itemOf[V], reason EvictionReason) {
+	c.untag(k, i)
+	ec := c.EvictedCallback()
+	// the values evicted for the capacity are pooled, unless spilled to the overflow store
+	pooled := c.pool != nil && reason == ReasonCapacityEvicted && c.overflow == nil
+	// the closure captures copies, so that i is not moved to the heap when it returns early
+	v, f := i.v, i.ext().f
+	if ec == nil && f == nil && c.reasonCallback == nil && !pooled {
+		return
+	}
+	c.callbacks.do(func() {
+		if ec != nil {
+			ec(k, v)
+		}
+		if f != nil {
+			f()
+		}
+		if c.reasonCallback != nil {
+			c.reasonCallback(k, v, reason)
+		}
+		if pooled {
+			c.pool.Put(v)
+		}
+	})
+}
+
+// expired reports whether the item i of the key k has expired, or has been invalidated.
+// The clock is only read for the items which expire.
+func (c *xsyncMapOf[K, V]) expired(k K, i itemOf[V]) bool {
+	return (i.e > 0 && c.now() > i.e) || c.invalidated(k, i)
+}
+
+// now returns the current time of the clock of the cache in Unix nanoseconds.
+func (c *xsyncMapOf[K, V]) now() int64 {
+	return c.clock.Now().UnixNano()
+}
+
+// expiredWithNow reports whether the item i of the key k has expired at now, or has been invalidated.
+func (c *xsyncMapOf[K, V]) expiredWithNow(k K, i itemOf[V], now int64) bool {
+	return i.expiredWithNow(now) || c.invalidated(k, i)
+}
+
+// stale reports whether the item i of the key k has expired at now, but is still served
+// while it is revalidated, see WithStaleWhileRevalidate.
+func (c *xsyncMapOf[K, V]) stale(k K, i itemOf[V], now int64) bool {
+	return c.staleTTL > 0 && i.e > 0 && now >= i.e && now < i.e+c.staleTTL && !c.invalidated(k, i)
+}
+
+// invalidated reports whether the item i of the key k has been invalidated by InvalidateIf.
+// Only the current generation is loaded for the items written since the last InvalidateIf.
+func (c *xsyncMapOf[K, V]) invalidated(k K, i itemOf[V]) bool {
+	n := i.ext().n
+	return n != c.invalidations.generation() && c.invalidations.match(k, n)
+}
+
+// untag removes the key of the item i that left the cache from the tag index,
+// except from the tags it has been stored with again since.
+func (c *xsyncMapOf[K, V]) untag(k K, i itemOf[V]) {
+	if len(i.ext().g) == 0 {
+		return
+	}
+	c.tags.remove(k, i.ext().g, func(tag string) bool {
+		v, ok := c.items.Load(k)
+		return ok && hasTag(v.ext().g, tag)
+	})
+}
+
+// removed calls the evicted callback with reason, if set, for the item that left the cache.
+func (c *xsyncMapOf[K, V]) removed(k K, i itemOf[V], reason EvictionReason) {
+	if c.reasonCallback != nil {
+		c.callbacks.do(func() { c.reasonCallback(k, i.v, reason) })
+	}
+}
+
+// discarded reports the item that left the cache like removed, then returns its value
+// to the value pool, if set, for the items no caller may hold, see WithValuePool.
+func (c *xsyncMapOf[K, V]) discarded(k K, i itemOf[V], reason EvictionReason) {
+	if c.pool == nil {
+		c.removed(k, i, reason)
+		return
+	}
+	c.callbacks.do(func() {
+		if c.reasonCallback != nil {
+			c.reasonCallback(k, i.v, reason)
+		}
+		c.pool.Put(i.v)
+	})
+}
+
+// store stores the item for the key written by the caller, writes it through, see WithWriteThroughOf,
+// and reports the item it replaced to the evicted callback with reason, if set.
+func (c *xsyncMapOf[K, V]) store(k K, i itemOf[V]) {
+	if c.guard.closed() {
+		// not written through nor scheduled, like the items are not stored
+		return
+	}
+	c.writer.write(k, i.v)
+	c.put(k, i)
+}
+
+// put stores the item for the key like store, but does not write it through,
+// for the values loaded by a loader or from a snapshot, which come from the persistent store.
+func (c *xsyncMapOf[K, V]) put(k K, i itemOf[V]) {
+	if c.guard.closed() {
+		return
+	}
+	old, loaded := c.items.LoadAndStore(k, i)
+	c.schedule(k, old.e, i.e)
+	if c.reasonCallback == nil && c.pool == nil {
+		return
+	}
+	if !loaded {
+		return
+	}
+	reason := ReasonReplaced
+	if c.expired(k, old) {
+		reason = ReasonExpired
+	}
+	if sameRef(old.v, i.v) {
+		// stored again, e.g. after an update in place
+		c.removed(k, old, reason)
+	} else {
+		c.discarded(k, old, reason)
+	}
+}
+
+// Close stops the background goroutines, waits for the queued evicted callbacks,
+// flushes the write-behind writes, and saves a snapshot if a persistence path
+// or a snapshot writer is configured, after deleting the expired items if WithCleanupOnCloseOf is set.
+// The cache can still be used after Close, but expired items are no longer deleted automatically.
+// Returns ErrClosed if the cache is already closed.
+func (c *xsyncMapOf[K, V]) Close() error {
+	return c.CloseContext(context.Background())
+}
+
+// CloseContext closes the cache like Close, but returns ctx.Err() if ctx is done first,
+// e.g. on a shutdown deadline, while the closing goes on in the background.
+func (c *xsyncMapOf[K, V]) CloseContext(ctx context.Context) error {
+	if !c.shutdown() {
+		return ErrClosed
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.finish()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finish waits for the background goroutines and the queued callbacks of a closed cache,
+// then flushes its writes and saves its snapshot.
+func (c *xsyncMapOf[K, V]) finish() error {
+	if c.cleanupOnClose {
+		c.DeleteExpired()
+	}
+	c.wg.Wait()
+	c.writer.flush(true)
+	var err error
+	if c.persistenceSave {
+		err = c.SaveToFile(c.persistencePath)
+	}
+	if c.snapshotWriter != nil {
+		if serr := saveSnapshot(c.snapshotWriter, c.SaveTo); err == nil {
+			err = serr
+		}
+	}
+	c.overflow.close()
+	if c.registry != nil {
+		c.registry.unregister(c.registryName, func(r Registered) bool {
+			return r == c.registered
+		})
+		c.registered = nil
+	}
+	c.guard.seal()
+	return err
+}
+
+// shutdown stops the cleanup goroutine, reports whether the cache was running.
+func (c *xsyncMapOf[K, V]) shutdown() bool {
+	// the cleanup loop is not started once closed, see startCleanupLoop
+	c.cleanupMu.Lock()
+	swapped := atomic.CompareAndSwapUint32(&c.closed, 0, 1)
+	c.cleanupMu.Unlock()
+	if !swapped {
+		return false
+	}
+	close(c.stop)
+	c.callbacks.close()
+	return true
+}
@@ -5,7 +5,10 @@ package cache
 
 import (
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -566,3 +569,236 @@ func TestXsyncMapOf_Range(t *testing.T) {
 		t.Fatalf("incorrect number of items in cache, expected %d, got %d", 10, c.Count())
 	}
 }
+
+func TestXsyncMapOf_ItemExt(t *testing.T) {
+	cache := newXsyncMapOf[string, int](ConfigOf[string, int]{CleanupInterval: 0})
+	defer cache.Close()
+	c := cache.(*xsyncMapOfWrapper[string, int]).xsyncMapOf
+	ext := func(k string) *itemExt {
+		i, _ := c.items.Load(k)
+		return i.x
+	}
+
+	c.Set("a", 1, time.Minute)
+	if x := ext("a"); x != nil {
+		t.Fatalf("expected no metadata, got %+v", x)
+	}
+	c.SetWithMeta("a", 1, time.Minute, "meta")
+	if x := ext("a"); x == nil || x.m != "meta" {
+		t.Fatalf("unexpected metadata: %+v", x)
+	}
+	c.Expire("a", NoExpiration)
+	if _, meta, _ := c.GetWithMeta("a"); meta != "meta" {
+		t.Fatalf("the metadata should be kept on Expire, got %v", meta)
+	}
+	if _, version, _ := c.GetWithVersion("a"); version == 0 || ext("a").r != version {
+		t.Fatalf("unexpected version: %v", version)
+	}
+	IncrementOf[string, int](c, "a", 1)
+	if x := ext("a"); x == nil || x.m != "meta" || x.r != 0 {
+		t.Fatalf("unexpected metadata: %+v", x)
+	}
+	c.Set("a", 1, time.Minute)
+	if x := ext("a"); x != nil {
+		t.Fatalf("expected no metadata, got %+v", x)
+	}
+
+	set := func() { c.Set("a", 1, time.Minute) }
+	allocs := testing.AllocsPerRun(100, set)
+	c.InvalidateIf(func(string) bool { return false })
+	c.Set("a", 1, time.Minute)
+	if x := ext("a"); x == nil || x.n == 0 {
+		t.Fatalf("expected the generation, got %+v", x)
+	}
+	c.DeleteExpired()
+	c.Set("a", 1, time.Minute)
+	if x := ext("a"); x != nil {
+		t.Fatalf("expected no metadata once no invalidation is pending, got %+v", x)
+	}
+	if n := testing.AllocsPerRun(100, set); n != allocs {
+		t.Fatalf("expected %v allocations per Set after InvalidateIf, got %v", allocs, n)
+	}
+}
+
+func TestXsyncMapOf_ClearExpiryIndex(t *testing.T) {
+	cache := newXsyncMapOf[int, int](ConfigOf[int, int]{CleanupInterval: 0})
+	defer cache.Close()
+	c := cache.(*xsyncMapOfWrapper[int, int]).xsyncMapOf
+
+	for i := 0; i < 100; i++ {
+		c.SetForever(i, i)
+	}
+	if n := atomic.LoadInt64(&c.expiry.size); n != 0 {
+		t.Fatalf("expected no key indexed for the items which never expire, got %d", n)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.Clear()
+				runtime.Gosched()
+			}
+		}
+	}()
+	for i := 0; i < 10000; i++ {
+		c.Set(i, i, time.Millisecond)
+		if i%100 == 0 {
+			runtime.Gosched()
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// the items stored during a Clear are still deleted once expired
+	time.Sleep(2 * time.Millisecond)
+	c.DeleteExpired()
+	if n := c.items.Size(); n != 0 {
+		t.Fatalf("expected the expired items to be deleted, %d left", n)
+	}
+	if n := atomic.LoadInt64(&c.expiry.size); n != 0 {
+		t.Fatalf("expected no key indexed, got %d", n)
+	}
+}
+
+func TestXsyncMapOf_ClosedExpiryIndex(t *testing.T) {
+	cache := newXsyncMapOf[int, int](ConfigOf[int, int]{CleanupInterval: 0, ClosedMode: ClosedIgnore})
+	c := cache.(*xsyncMapOfWrapper[int, int]).xsyncMapOf
+	_ = cache.Close()
+
+	for i := 0; i < 10; i++ {
+		c.Set(i, i, time.Minute)
+		c.GetOrSet(i, i, time.Minute)
+		c.GetAndSet(i, i, time.Minute)
+	}
+	if n := atomic.LoadInt64(&c.expiry.size); n != 0 {
+		t.Fatalf("expected no key scheduled after Close, got %d", n)
+	}
+}
+
+func TestXsyncMapOf_MovedExpiryIndex(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	cache := newXsyncMapOf[int, int](ConfigOf[int, int]{CleanupInterval: 0, Clock: clock})
+	defer cache.Close()
+	c := cache.(*xsyncMapOfWrapper[int, int]).xsyncMapOf
+
+	for i := 0; i < 100; i++ {
+		switch i % 4 {
+		case 0:
+			c.Set(0, i, time.Minute)
+		case 1:
+			c.GetAndSet(0, i, time.Minute)
+		case 2:
+			c.GetAndRefresh(0, time.Minute)
+		default:
+			c.Compute(0, func(int, bool) (int, bool) { return i, false }, time.Minute)
+		}
+		clock.Advance(time.Second)
+	}
+	if n := atomic.LoadInt64(&c.expiry.size); n != 1 {
+		t.Fatalf("expected the key indexed once, got %d", n)
+	}
+	c.SetForever(0, 0)
+	if n := atomic.LoadInt64(&c.expiry.size); n != 0 {
+		t.Fatalf("expected no key indexed once it never expires, got %d", n)
+	}
+
+	// the emptied buckets are dropped once due
+	clock.Advance(2 * time.Minute)
+	c.DeleteExpired()
+	if n := atomic.LoadInt64(&c.expiry.buckets); n != 0 {
+		t.Fatalf("expected no bucket left, got %d", n)
+	}
+}
+
+func TestXsyncMapOf_PlainReads(t *testing.T) {
+	cache := newXsyncMapOf[int, int](ConfigOf[int, int]{CleanupInterval: 0})
+	defer cache.Close()
+	c := cache.(*xsyncMapOfWrapper[int, int]).xsyncMapOf
+	if !c.plainReads {
+		t.Fatal("expected the reads of a cache without features to be plain")
+	}
+	c.SetForever(1, 1)
+	c.Set(2, 2, time.Minute)
+	if n := testing.AllocsPerRun(100, func() {
+		c.Get(1)
+		c.Get(2)
+	}); n != 0 {
+		t.Fatalf("expected no allocation per Get, got %v", n)
+	}
+
+	// the reads of a bounded cache touch the eviction order
+	bounded := newXsyncMapOf[int, int](ConfigOf[int, int]{CleanupInterval: 0, MaxEntries: 10})
+	defer bounded.Close()
+	if bounded.(*xsyncMapOfWrapper[int, int]).plainReads {
+		t.Fatal("expected the reads of a bounded cache not to be plain")
+	}
+}
+
+func TestExpiryIndexOf_Due(t *testing.T) {
+	x := newExpiryIndexOf[int]()
+	for i := 0; i < 1000; i++ {
+		x.add(uint64(i), i, 0, int64(i+1)*expiryResolution)
+	}
+	var due []int
+	x.due(10*expiryResolution, func(k int) bool {
+		due = append(due, k)
+		return true
+	})
+	sort.Ints(due)
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(due, want) {
+		t.Fatalf("expected %v, got %v", want, due)
+	}
+	if n := atomic.LoadInt64(&x.size); n != 990 {
+		t.Fatalf("expected 990 keys left, got %d", n)
+	}
+	for i := range x.shards {
+		if s := &x.shards[i]; len(s.order) != len(s.buckets) {
+			t.Fatalf("expected a bucket id per bucket, got %d ids for %d buckets", len(s.order), len(s.buckets))
+		}
+	}
+}
+
+func TestXsyncMapOf_EvictionReads(t *testing.T) {
+	cache := newXsyncMapOf[int, int](ConfigOf[int, int]{CleanupInterval: 0, MaxEntries: 10})
+	defer cache.Close()
+	c := cache.(*xsyncMapOfWrapper[int, int]).xsyncMapOf
+	for i := 0; i < 10; i++ {
+		c.SetForever(i, i)
+	}
+
+	// the reads do not wait for the mutex of the evictor
+	c.evictor.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10*readBufferSize; i++ {
+			c.Get(i % 10)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the reads not to wait for the evictor")
+	}
+	c.evictor.mu.Unlock()
+
+	// a victim written again since it was chosen is kept
+	c.evict(0)
+	if _, ok := c.Get(0); !ok {
+		t.Fatal("expected the key in the eviction order to be kept")
+	}
+	c.evictor.mu.Lock()
+	c.evictor.remove(0)
+	c.evictor.mu.Unlock()
+	c.evict(0)
+	if _, ok := c.Get(0); ok {
+		t.Fatal("expected the victim to be evicted")
+	}
+}